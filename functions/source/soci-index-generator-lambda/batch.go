@@ -0,0 +1,122 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+)
+
+const (
+	defaultBatchConcurrency = 4
+	defaultBatchMaxJitter   = 500 * time.Millisecond
+)
+
+// A reference to an image to build a SOCI index for
+type ImageRef struct {
+	RegistryURL    string
+	RepositoryName string
+	Digest         string
+	Tag            string
+}
+
+func (ref ImageRef) String() string {
+	return ref.RegistryURL + "/" + ref.RepositoryName + "@" + ref.Digest
+}
+
+type BatchOptions struct {
+	// Maximum number of images processed at the same time. Defaults to 4.
+	Concurrency int
+	// Upper bound of the random delay before each worker starts, so that workers don't hit ECR auth
+	// at the same instant. Defaults to 500ms; set a negative value to disable jitter.
+	MaxJitter time.Duration
+	// Options applied to every image of the batch
+	ProcessOptions
+
+	// The per-image pipeline, overridden in tests
+	process func(ctx context.Context, ref ImageRef, opts ProcessOptions) (string, error)
+}
+
+// The outcome of processing one image of a batch
+type BatchResult struct {
+	Ref     ImageRef
+	Message string
+	Err     error
+	// The cause of Err with a remediation hint, nil without error
+	Failure *FailureReport
+}
+
+// Pull, index and push multiple images concurrently using a bounded pool of workers.
+// A failure of one image doesn't abort the batch: every ref gets its own result, in the order of refs.
+func ProcessBatch(ctx context.Context, refs []ImageRef, opts BatchOptions) []BatchResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	if concurrency > len(refs) {
+		concurrency = len(refs)
+	}
+	maxJitter := opts.MaxJitter
+	if maxJitter == 0 {
+		maxJitter = defaultBatchMaxJitter
+	}
+	process := opts.process
+	if process == nil {
+		process = processImage
+	}
+
+	log.Info(ctx, fmt.Sprintf("Processing a batch of %d images with %d workers", len(refs), concurrency))
+
+	results := make([]BatchResult, len(refs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if maxJitter > 0 {
+				select {
+				case <-time.After(time.Duration(rand.Int63n(int64(maxJitter)))):
+				case <-ctx.Done():
+				}
+			}
+			for i := range indexes {
+				results[i] = processBatchItem(ctx, refs[i], opts.ProcessOptions, process)
+			}
+		}()
+	}
+
+	for i := range refs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// Process a single image of a batch, turning a panic into an error so that it doesn't take down the other images
+func processBatchItem(ctx context.Context, ref ImageRef, opts ProcessOptions, process func(context.Context, ImageRef, ProcessOptions) (string, error)) (result BatchResult) {
+	result.Ref = ref
+	defer func() {
+		if r := recover(); r != nil {
+			result.Err = fmt.Errorf("panic while processing %s: %v", ref, r)
+			result.Failure = NewFailureReport(result.Err)
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		result.Failure = NewFailureReport(err)
+		return result
+	}
+	result.Message, result.Err = process(ctx, ref, opts)
+	result.Failure = NewFailureReport(result.Err)
+	return result
+}
@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestProcessBatch(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-batch"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	var refs []ImageRef
+	for i := 0; i < 10; i++ {
+		refs = append(refs, ImageRef{
+			RegistryURL:    "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+			RepositoryName: fmt.Sprintf("repo-%d", i),
+			Digest:         fmt.Sprintf("sha256:%064d", i),
+		})
+	}
+
+	var running, maxRunning int32
+	opts := BatchOptions{
+		Concurrency:    3,
+		MaxJitter:      time.Millisecond,
+		ProcessOptions: ProcessOptions{SociIndexVersion: "V1"},
+		process: func(ctx context.Context, ref ImageRef, opts ProcessOptions) (string, error) {
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				observed := atomic.LoadInt32(&maxRunning)
+				if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			switch ref.RepositoryName {
+			case "repo-3":
+				return "failed", errors.New("pull error")
+			case "repo-7":
+				panic("unexpected")
+			}
+			return BuildAndPushSuccessMessage, nil
+		},
+	}
+
+	results := ProcessBatch(ctx, refs, opts)
+	if len(results) != len(refs) {
+		t.Fatalf("Expected %d results but got %d", len(refs), len(results))
+	}
+	for i, result := range results {
+		if result.Ref != refs[i] {
+			t.Fatalf("Result %d is for %s, expected %s", i, result.Ref, refs[i])
+		}
+		switch result.Ref.RepositoryName {
+		case "repo-3", "repo-7":
+			if result.Err == nil || result.Failure == nil || result.Failure.Code != FailureUnknown {
+				t.Fatalf("Expected an error with its failure report for %s but got %v, %+v", result.Ref, result.Err, result.Failure)
+			}
+		default:
+			if result.Err != nil || result.Failure != nil || result.Message != BuildAndPushSuccessMessage {
+				t.Fatalf("Unexpected result for %s: %s, %v", result.Ref, result.Message, result.Err)
+			}
+		}
+	}
+	if maxRunning > 3 {
+		t.Fatalf("Expected at most 3 images processed concurrently, got %d", maxRunning)
+	}
+}
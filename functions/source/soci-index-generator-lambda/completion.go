@@ -0,0 +1,179 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+const (
+	CompletionSnsTopicArn  = "completion_sns_topic_arn"
+	CompletionEventBusName = "completion_event_bus_name"
+
+	// Source and detail type of the EventBridge completion events, for event rules to match
+	CompletionEventSource     = "soci-index-builder"
+	CompletionEventDetailType = "SOCI Index Pushed"
+)
+
+// Published once the SOCI index of an image is pushed
+type CompletionEvent struct {
+	Repository string `json:"repository"`
+	// Digest of the indexed image
+	SubjectDigest string `json:"subjectDigest"`
+	// Digest of the pushed SOCI index, or of the image index for V2 SOCI indexes
+	IndexDigest string `json:"indexDigest"`
+	// Tag of the pushed index, empty when it's only reachable by digest
+	Tag string `json:"tag,omitempty"`
+}
+
+// Notifies downstream systems of pushed SOCI indexes
+type CompletionPublisher interface {
+	Publish(ctx context.Context, event CompletionEvent) error
+}
+
+// The SNS API calls made by the SNS publisher
+type snsAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// The EventBridge API calls made by the EventBridge publisher
+type eventBridgeAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// Publish completion events as JSON messages to an SNS topic
+type snsPublisher struct {
+	client   snsAPI
+	topicArn string
+}
+
+func (publisher *snsPublisher) Publish(ctx context.Context, event CompletionEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = publisher.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(publisher.topicArn),
+		Message:  aws.String(string(message)),
+	})
+	return err
+}
+
+// Publish completion events to an EventBridge bus, with the event as detail
+type eventBridgePublisher struct {
+	client  eventBridgeAPI
+	busName string
+}
+
+func (publisher *eventBridgePublisher) Publish(ctx context.Context, event CompletionEvent) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	output, err := publisher.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []eventbridgetypes.PutEventsRequestEntry{{
+			EventBusName: aws.String(publisher.busName),
+			Source:       aws.String(CompletionEventSource),
+			DetailType:   aws.String(CompletionEventDetailType),
+			Detail:       aws.String(string(detail)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// PutEvents succeeds even when it fails to put the entries
+	if output.FailedEntryCount > 0 {
+		for _, entry := range output.Entries {
+			if entry.ErrorCode != nil {
+				return fmt.Errorf("failed to put the event: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+			}
+		}
+		return errors.New("failed to put the event")
+	}
+	return nil
+}
+
+// Publish to each of the publishers, even when some fail
+type completionPublishers []CompletionPublisher
+
+func (publishers completionPublishers) Publish(ctx context.Context, event CompletionEvent) error {
+	var errs []error
+	for _, publisher := range publishers {
+		errs = append(errs, publisher.Publish(ctx, event))
+	}
+	return errors.Join(errs...)
+}
+
+// Create the SNS and EventBridge clients of the publishers, overridden in tests
+var newCompletionClients = func(ctx context.Context) (snsAPI, eventBridgeAPI, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sns.NewFromConfig(cfg), eventbridge.NewFromConfig(cfg), nil
+}
+
+// Create the publisher of the completion events to the given SNS topic and EventBridge bus, either of which may
+// be empty. Returns nil when both are empty.
+func newCompletionPublisher(ctx context.Context, topicArn string, busName string) (CompletionPublisher, error) {
+	if topicArn == "" && busName == "" {
+		return nil, nil
+	}
+	snsClient, eventBridgeClient, err := newCompletionClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var publishers completionPublishers
+	if topicArn != "" {
+		publishers = append(publishers, &snsPublisher{client: snsClient, topicArn: topicArn})
+	}
+	if busName != "" {
+		publishers = append(publishers, &eventBridgePublisher{client: eventBridgeClient, busName: busName})
+	}
+	return publishers, nil
+}
+
+var (
+	completionPublisher     CompletionPublisher
+	completionPublisherOnce sync.Once
+)
+
+// Return the publisher configured by the Lambda's environment variables, created once so that its clients are
+// kept across warm invocations. Completion events are only published when completion_sns_topic_arn or
+// completion_event_bus_name is set.
+func completionPublisherFromEnv(ctx context.Context) CompletionPublisher {
+	completionPublisherOnce.Do(func() {
+		publisher, err := newCompletionPublisher(ctx, os.Getenv(CompletionSnsTopicArn), os.Getenv(CompletionEventBusName))
+		if err != nil {
+			log.Warn(ctx, fmt.Sprintf("Completion events are disabled, failed to create the publisher: %v", err))
+			return
+		}
+		completionPublisher = publisher
+	})
+	return completionPublisher
+}
+
+// Publish the completion event of a pushed index. The index is pushed already, so failures are only logged.
+func notifyCompletion(ctx context.Context, publisher CompletionPublisher, event CompletionEvent) {
+	if publisher == nil {
+		return
+	}
+	if err := publisher.Publish(ctx, event); err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to publish the completion event: %v", err))
+		return
+	}
+	log.Info(ctx, "Published the completion event")
+}
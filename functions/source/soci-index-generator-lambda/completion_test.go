@@ -0,0 +1,153 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+var testCompletionEvent = CompletionEvent{
+	Repository:    "repo",
+	SubjectDigest: "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	IndexDigest:   "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	Tag:           "latest-soci",
+}
+
+type fakeSnsClient struct {
+	inputs []*sns.PublishInput
+	err    error
+}
+
+func (c *fakeSnsClient) Publish(ctx context.Context, input *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &sns.PublishOutput{}, c.err
+}
+
+type fakeEventBridgeClient struct {
+	inputs []*eventbridge.PutEventsInput
+	output *eventbridge.PutEventsOutput
+}
+
+func (c *fakeEventBridgeClient) PutEvents(ctx context.Context, input *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	c.inputs = append(c.inputs, input)
+	if c.output == nil {
+		return &eventbridge.PutEventsOutput{}, nil
+	}
+	return c.output, nil
+}
+
+// A publisher recording the events it's given
+type fakePublisher struct {
+	events []CompletionEvent
+	err    error
+}
+
+func (publisher *fakePublisher) Publish(ctx context.Context, event CompletionEvent) error {
+	publisher.events = append(publisher.events, event)
+	return publisher.err
+}
+
+func stubCompletionClients(t *testing.T, snsClient snsAPI, eventBridgeClient eventBridgeAPI) {
+	original := newCompletionClients
+	newCompletionClients = func(ctx context.Context) (snsAPI, eventBridgeAPI, error) {
+		return snsClient, eventBridgeClient, nil
+	}
+	t.Cleanup(func() { newCompletionClients = original })
+}
+
+func TestCompletionPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("disabled without a target", func(t *testing.T) {
+		publisher, err := newCompletionPublisher(ctx, "", "")
+		if err != nil || publisher != nil {
+			t.Fatalf("Expected no publisher but got %v, %v", publisher, err)
+		}
+	})
+
+	t.Run("publishes to the SNS topic and the EventBridge bus", func(t *testing.T) {
+		snsClient := &fakeSnsClient{}
+		eventBridgeClient := &fakeEventBridgeClient{}
+		stubCompletionClients(t, snsClient, eventBridgeClient)
+		publisher, err := newCompletionPublisher(ctx, "arn:aws:sns:us-west-2:123456789012:soci", "soci-bus")
+		if err != nil {
+			t.Fatalf("newCompletionPublisher failed: %v", err)
+		}
+		if err := publisher.Publish(ctx, testCompletionEvent); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+
+		if len(snsClient.inputs) != 1 || aws.ToString(snsClient.inputs[0].TopicArn) != "arn:aws:sns:us-west-2:123456789012:soci" {
+			t.Fatalf("Expected a single message to the topic but got %v", snsClient.inputs)
+		}
+		var published CompletionEvent
+		if err := json.Unmarshal([]byte(aws.ToString(snsClient.inputs[0].Message)), &published); err != nil || published != testCompletionEvent {
+			t.Fatalf("Expected message %+v but got %+v (%v)", testCompletionEvent, published, err)
+		}
+
+		if len(eventBridgeClient.inputs) != 1 || len(eventBridgeClient.inputs[0].Entries) != 1 {
+			t.Fatalf("Expected a single event to the bus but got %v", eventBridgeClient.inputs)
+		}
+		entry := eventBridgeClient.inputs[0].Entries[0]
+		if aws.ToString(entry.EventBusName) != "soci-bus" || aws.ToString(entry.Source) != CompletionEventSource || aws.ToString(entry.DetailType) != CompletionEventDetailType {
+			t.Fatalf("Unexpected event entry %+v", entry)
+		}
+		if err := json.Unmarshal([]byte(aws.ToString(entry.Detail)), &published); err != nil || published != testCompletionEvent {
+			t.Fatalf("Expected event detail %+v but got %+v (%v)", testCompletionEvent, published, err)
+		}
+	})
+
+	t.Run("failed entries are errors", func(t *testing.T) {
+		snsClient := &fakeSnsClient{err: errors.New("throttled")}
+		eventBridgeClient := &fakeEventBridgeClient{output: &eventbridge.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries:          []eventbridgetypes.PutEventsResultEntry{{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("try again")}},
+		}}
+		stubCompletionClients(t, snsClient, eventBridgeClient)
+		publisher, err := newCompletionPublisher(ctx, "arn:aws:sns:us-west-2:123456789012:soci", "soci-bus")
+		if err != nil {
+			t.Fatalf("newCompletionPublisher failed: %v", err)
+		}
+		err = publisher.Publish(ctx, testCompletionEvent)
+		if err == nil || !strings.Contains(err.Error(), "throttled") || !strings.Contains(err.Error(), "InternalFailure") {
+			t.Fatalf("Expected both failures to be reported but got %v", err)
+		}
+		// a failing target doesn't keep the event from the others
+		if len(eventBridgeClient.inputs) != 1 {
+			t.Fatalf("Expected the event to be put to the bus despite the SNS failure")
+		}
+	})
+}
+
+func TestNotifyCompletion(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-notify-completion"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	// nothing to notify without a publisher
+	notifyCompletion(ctx, nil, testCompletionEvent)
+
+	publisher := &fakePublisher{}
+	notifyCompletion(ctx, publisher, testCompletionEvent)
+	if len(publisher.events) != 1 || publisher.events[0] != testCompletionEvent {
+		t.Fatalf("Expected the event to be published but got %v", publisher.events)
+	}
+
+	// failures are only logged
+	failing := &fakePublisher{err: errors.New("unreachable")}
+	notifyCompletion(ctx, failing, testCompletionEvent)
+	if len(failing.events) != 1 {
+		t.Fatalf("Expected a publish attempt but got %v", failing.events)
+	}
+}
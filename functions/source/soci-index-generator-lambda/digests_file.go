@@ -0,0 +1,136 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Status of a line of a digests file that isn't a valid entry
+const DigestsFileInvalid = "INVALID"
+
+// The outcome of a line of a digests file, written as a line of JSON to the report of ProcessDigestsFile
+type DigestsFileLineResult struct {
+	// Line number in the file, starting at 1
+	Line  int    `json:"line"`
+	Entry string `json:"entry"`
+	// One of the Result* statuses, or DigestsFileInvalid
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// One of the codes of skipReasons when skipped
+	SkipReason string `json:"skipReason,omitempty"`
+	// The cause of the failure when failed
+	Failure *FailureReport `json:"failure,omitempty"`
+	// Why the line isn't a valid entry when invalid
+	Invalid string `json:"invalid,omitempty"`
+}
+
+// Parse an entry of a digests file, a repository name and an image digest separated by "@", e.g. "org/app@sha256:..."
+func parseDigestsFileEntry(entry string) (string, string, error) {
+	repositoryName, digest, found := strings.Cut(entry, "@")
+	if !found {
+		return "", "", fmt.Errorf("expected repository@digest")
+	}
+	if err := registryutils.ValidateRepositoryName(repositoryName); err != nil {
+		return "", "", err
+	}
+	if _, err := registryutils.ParseDigest(digest); err != nil {
+		return "", "", err
+	}
+	return repositoryName, digest, nil
+}
+
+// Build and push the SOCI indexes of the images listed by a newline-delimited file of repository@digest entries
+// of the registry, e.g. to backfill the images of a migration, with the batch processing flow of ProcessBatch.
+// Blank lines and lines starting with "#" are ignored. Malformed lines are skipped and reported as invalid.
+// The result of each line is written to report as a line of JSON, in the order of the file, and returned.
+// Failures of images are reported rather than returned; the error is that of reading the file or writing the report.
+func ProcessDigestsFile(ctx context.Context, file io.Reader, registryURL string, opts BatchOptions, report io.Writer) ([]DigestsFileLineResult, error) {
+	var results []DigestsFileLineResult
+	var refs []ImageRef
+	// the index in results of each ref
+	var positions []int
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		result := DigestsFileLineResult{Line: line, Entry: entry}
+		repositoryName, digest, err := parseDigestsFileEntry(entry)
+		if err != nil {
+			log.Warn(ctx, fmt.Sprintf("Skipping invalid line %d of the digests file %q: %v", line, entry, err))
+			result.Status = DigestsFileInvalid
+			result.Invalid = err.Error()
+		} else {
+			refs = append(refs, ImageRef{RegistryURL: registryURL, RepositoryName: repositoryName, Digest: digest})
+			positions = append(positions, len(results))
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the digests file: %w", err)
+	}
+
+	for i, batchResult := range ProcessBatch(ctx, refs, opts) {
+		result := &results[positions[i]]
+		result.Message = batchResult.Message
+		result.Status, result.SkipReason = resultStatus(batchResult.Message, batchResult.Err)
+		result.Failure = batchResult.Failure
+	}
+
+	encoder := json.NewEncoder(report)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return results, fmt.Errorf("failed to write the report: %w", err)
+		}
+	}
+	return results, nil
+}
+
+// Run ProcessDigestsFile on a file of images of the registry outside of Lambda, with the options of the environment
+// variables of the Lambda, writing the report to stdout. Returns the exit code of the process, 1 if any line is
+// invalid or failed.
+func runDigestsFile(path string, registryURL string) int {
+	lc := lambdacontext.LambdaContext{AwsRequestID: fmt.Sprintf("digests-file-%d", time.Now().Unix())}
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+	if registryURL == "" {
+		log.Error(ctx, "Digests file error", fmt.Errorf("the registry of the images must be given with -registry-url"))
+		return 1
+	}
+	opts, err := processOptionsFromEnv(ctx)
+	if err != nil {
+		log.Error(ctx, "Platform allowlist parsing error", err)
+		return 1
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Error(ctx, "Digests file error", err)
+		return 1
+	}
+	defer file.Close()
+
+	results, err := ProcessDigestsFile(ctx, file, registryURL, BatchOptions{ProcessOptions: opts}, os.Stdout)
+	if err != nil {
+		log.Error(ctx, "Digests file error", err)
+		return 1
+	}
+	for _, result := range results {
+		if result.Status == DigestsFileInvalid || result.Status == ResultFailed {
+			return 1
+		}
+	}
+	return 0
+}
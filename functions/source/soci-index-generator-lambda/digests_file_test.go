@@ -0,0 +1,126 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestProcessDigestsFile(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-digests-file"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+	registryURL := "123456789012.dkr.ecr.us-west-2.amazonaws.com"
+
+	digestOf := func(i int) string { return fmt.Sprintf("sha256:%064d", i) }
+	file := strings.Join([]string{
+		"# images of the migration",
+		"app@" + digestOf(1),
+		"",
+		"org/team/api@" + digestOf(2),
+		"no-digest",
+		"app@sha256:1234",
+		"Invalid Repo@" + digestOf(3),
+		"  failing@" + digestOf(4) + "  ",
+		"indexed@" + digestOf(5),
+	}, "\n")
+
+	var mu sync.Mutex
+	var processed []ImageRef
+	opts := BatchOptions{
+		MaxJitter:      time.Millisecond,
+		ProcessOptions: ProcessOptions{SociIndexVersion: "V2"},
+		process: func(ctx context.Context, ref ImageRef, opts ProcessOptions) (string, error) {
+			mu.Lock()
+			processed = append(processed, ref)
+			mu.Unlock()
+			switch ref.RepositoryName {
+			case "failing":
+				return "", errors.New("pull error")
+			case "indexed":
+				return SkipExistingIndexMessage, nil
+			}
+			return BuildAndPushSuccessMessage, nil
+		},
+	}
+
+	var report bytes.Buffer
+	results, err := ProcessDigestsFile(ctx, strings.NewReader(file), registryURL, opts, &report)
+	if err != nil {
+		t.Fatalf("ProcessDigestsFile failed: %v", err)
+	}
+
+	// only the valid entries are processed, as images of the registry
+	if len(processed) != 4 {
+		t.Fatalf("Expected 4 images to be processed but got %v", processed)
+	}
+	for _, ref := range processed {
+		if ref.RegistryURL != registryURL {
+			t.Fatalf("Expected the images to be in %s but got %v", registryURL, ref)
+		}
+	}
+
+	expected := []struct {
+		line       int
+		entry      string
+		status     string
+		skipReason string
+	}{
+		{2, "app@" + digestOf(1), ResultSucceeded, ""},
+		{4, "org/team/api@" + digestOf(2), ResultSucceeded, ""},
+		{5, "no-digest", DigestsFileInvalid, ""},
+		{6, "app@sha256:1234", DigestsFileInvalid, ""},
+		{7, "Invalid Repo@" + digestOf(3), DigestsFileInvalid, ""},
+		{8, "failing@" + digestOf(4), ResultFailed, ""},
+		{9, "indexed@" + digestOf(5), ResultSkipped, "ALREADY_INDEXED"},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results but got %+v", len(expected), results)
+	}
+	for i, e := range expected {
+		result := results[i]
+		if result.Line != e.line || result.Entry != e.entry || result.Status != e.status || result.SkipReason != e.skipReason {
+			t.Fatalf("Expected line %d %q to be %s %s but got %+v", e.line, e.entry, e.status, e.skipReason, result)
+		}
+		if (result.Status == DigestsFileInvalid) != (result.Invalid != "") {
+			t.Fatalf("Expected only invalid lines to say why but got %+v", result)
+		}
+		if (result.Status == ResultFailed) != (result.Failure != nil) {
+			t.Fatalf("Expected only failed lines to have a failure but got %+v", result)
+		}
+	}
+
+	// the report has a line of JSON per result, in the order of the file
+	lines := strings.Split(strings.TrimSuffix(report.String(), "\n"), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("Expected %d lines in the report but got %q", len(results), report.String())
+	}
+	for i, line := range lines {
+		var reported DigestsFileLineResult
+		if err := json.Unmarshal([]byte(line), &reported); err != nil {
+			t.Fatalf("Failed to parse line %d of the report %q: %v", i, line, err)
+		}
+		if reported.Line != results[i].Line || reported.Status != results[i].Status {
+			t.Fatalf("Expected line %d of the report to be %+v but got %+v", i, results[i], reported)
+		}
+	}
+
+	// a file without valid entries processes nothing
+	processed = nil
+	report.Reset()
+	results, err = ProcessDigestsFile(ctx, strings.NewReader("# nothing\nnot an entry\n"), registryURL, opts, &report)
+	if err != nil || len(results) != 1 || results[0].Status != DigestsFileInvalid || len(processed) != 0 {
+		t.Fatalf("Expected a single invalid line and nothing processed but got %+v, %v, %v", results, processed, err)
+	}
+}
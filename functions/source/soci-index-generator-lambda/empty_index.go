@@ -0,0 +1,98 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+)
+
+const PushEmptyIndex = "push_empty_index"
+
+// ErrNoIndexableLayers is returned when the media type filters and the minimum layer size leave no layer of an
+// image to span, unless ProcessOptions.PushEmptyIndex is set
+var ErrNoIndexableLayers = errors.New("no layer of the image qualifies for a ztoc")
+
+// Check that the image manifest of a platform has a layer the SOCI index would span,
+// returning ErrNoIndexableLayers otherwise
+func checkIndexableLayers(ctx context.Context, contentStore content.Store, image images.Image, platform ocispec.Platform, opts ProcessOptions) error {
+	manifest, err := images.Manifest(ctx, contentStore, image.Target, platforms.OnlyStrict(platform))
+	if err != nil {
+		return err
+	}
+	plan := PlanIndex(ctx, image.Name, manifest, opts)
+	if len(plan.Selected) == 0 {
+		return fmt.Errorf("%w: all %d layers of platform %s are excluded", ErrNoIndexableLayers, len(plan.Excluded), platforms.Format(platform))
+	}
+	return nil
+}
+
+// Check that at least one of the platforms of an image has a layer the SOCI index would span,
+// returning ErrNoIndexableLayers otherwise. The SOCI library leaves out the other platforms.
+func checkAnyIndexableLayers(ctx context.Context, contentStore content.Store, image images.Image, selected []ocispec.Platform, opts ProcessOptions) error {
+	if len(selected) == 0 {
+		var err error
+		if selected, err = images.Platforms(ctx, contentStore, image.Target); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, platform := range selected {
+		err := checkIndexableLayers(ctx, contentStore, image, platform, opts)
+		if err == nil || !errors.Is(err, ErrNoIndexableLayers) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Write a V1 SOCI index spanning no layers for the image manifest of a platform, for tooling expecting every image
+// to have a SOCI index. The snapshotter lazily loads no layer of such an image.
+func writeEmptyIndex(ctx context.Context, contentStore content.Store, sociStore *store.SociStore, image images.Image, platform ocispec.Platform) (*ocispec.Descriptor, error) {
+	manifestDesc, err := soci.GetImageManifestDescriptor(ctx, contentStore, image.Target, platforms.OnlyStrict(platform))
+	if err != nil {
+		return nil, err
+	}
+	subject := &ocispec.Descriptor{MediaType: manifestDesc.MediaType, Digest: manifestDesc.Digest, Size: manifestDesc.Size}
+	return writeIndex(ctx, sociStore, []ocispec.Descriptor{}, subject)
+}
+
+// Write a V1 SOCI index of the given ztocs to the SOCI store, along with its config
+func writeIndex(ctx context.Context, sociStore *store.SociStore, ztocs []ocispec.Descriptor, subject *ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	index := soci.NewIndex(soci.V1, ztocs, subject, map[string]string{
+		soci.IndexAnnotationBuildToolIdentifier: buildToolIdentifier,
+	})
+
+	// the empty JSON object the config descriptor of SOCI indexes refers to
+	config := []byte("{}")
+	if err := sociStore.Push(ctx, index.Config, bytes.NewReader(config)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return nil, fmt.Errorf("failed to write the config of the SOCI index: %w", err)
+	}
+	manifest, err := soci.MarshalIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: index.ArtifactType,
+		Digest:       digest.FromBytes(manifest),
+		Size:         int64(len(manifest)),
+	}
+	if err := sociStore.Push(ctx, desc, bytes.NewReader(manifest)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return nil, fmt.Errorf("failed to write the SOCI index: %w", err)
+	}
+	return &desc, nil
+}
@@ -0,0 +1,93 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	orascontent "oras.land/oras-go/v2/content"
+)
+
+func TestBuildIndexWithNoIndexableLayers(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-build-index-with-no-indexable-layers"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	// an image whose only layer is below the minimum layer size
+	setup := func(t *testing.T) (string, images.Image) {
+		dataDir := t.TempDir()
+		contentStore, err := local.NewStore(path.Join(dataDir, artifactsStoreName))
+		if err != nil {
+			t.Fatalf("Failed to create content store: %v", err)
+		}
+		config, err := json.Marshal(ocispec.Image{Platform: platforms.DefaultSpec(), RootFS: ocispec.RootFS{Type: "layers"}})
+		if err != nil {
+			t.Fatalf("Failed to marshal config: %v", err)
+		}
+		manifest := ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageConfig, config),
+			Layers:    []ocispec.Descriptor{writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayer(t))},
+		}
+		manifest.SchemaVersion = 2
+		manifestBytes, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatalf("Failed to marshal manifest: %v", err)
+		}
+		target := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageManifest, manifestBytes)
+		return dataDir, images.Image{Name: "repo@" + target.Digest.String(), Target: target}
+	}
+
+	doTest := func(opts ProcessOptions, expectEmptyIndex bool) {
+		t.Helper()
+		dataDir, image := setup(t)
+		sociStore, err := initSociStore(ctx, dataDir)
+		if err != nil {
+			t.Fatalf("Failed to create SOCI store: %v", err)
+		}
+		indexDesc, _, err := buildIndex(ctx, dataDir, sociStore, image, opts)
+		if !expectEmptyIndex {
+			if !errors.Is(err, ErrNoIndexableLayers) {
+				t.Fatalf("Expected ErrNoIndexableLayers but got %v", err)
+			}
+			if report := NewFailureReport(err); report.Code != FailureImageTooSmall {
+				t.Fatalf("Expected failure code %s but got %s", FailureImageTooSmall, report.Code)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Expected an empty SOCI index but got %v", err)
+		}
+		raw, err := orascontent.FetchAll(ctx, sociStore, *indexDesc)
+		if err != nil {
+			t.Fatalf("Failed to read the SOCI index: %v", err)
+		}
+		var index ocispec.Manifest
+		if err := json.Unmarshal(raw, &index); err != nil {
+			t.Fatalf("Failed to decode the SOCI index: %v", err)
+		}
+		if len(index.Layers) != 0 || index.Subject == nil || index.Subject.Digest != image.Target.Digest {
+			t.Fatalf("Expected an index spanning no layers of the image but got %s", raw)
+		}
+		if exists, err := sociStore.Exists(ctx, index.Config); err != nil || !exists {
+			t.Fatalf("Expected the config of the SOCI index to be stored but got %v, %v", exists, err)
+		}
+	}
+
+	// skipped by default
+	doTest(ProcessOptions{}, false)
+	doTest(ProcessOptions{SociIndexVersion: "V2"}, false)
+	doTest(ProcessOptions{PushEmptyIndex: true}, true)
+	// V2 images can't have an empty SOCI index
+	doTest(ProcessOptions{SociIndexVersion: "V2", PushEmptyIndex: true}, false)
+}
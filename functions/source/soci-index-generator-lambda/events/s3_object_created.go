@@ -0,0 +1,64 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/opencontainers/go-digest"
+)
+
+var imageTagRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// The image an S3 object created event points at.
+// The object key is the image reference within the registry, i.e. "<repository-name>[:<tag>]@<digest>",
+// optionally followed by a ".json" extension, e.g. "team/app:v1@sha256:<hex>.json".
+type S3ImageLocation struct {
+	Region         string
+	RepositoryName string
+	ImageDigest    string
+	ImageTag       string
+}
+
+// Extract and validate the image location carried by an S3 object created event record
+func ParseS3EventRecord(record awsevents.S3EventRecord) (S3ImageLocation, error) {
+	if record.EventSource != "aws:s3" {
+		return S3ImageLocation{}, fmt.Errorf("the record's 'eventSource' must be 'aws:s3'")
+	}
+	if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+		return S3ImageLocation{}, fmt.Errorf("the record's 'eventName' must be an ObjectCreated event")
+	}
+	if record.AWSRegion == "" {
+		return S3ImageLocation{}, fmt.Errorf("the record's 'awsRegion' must not be empty")
+	}
+
+	key := strings.TrimSuffix(record.S3.Object.URLDecodedKey, ".json")
+	if key == "" {
+		return S3ImageLocation{}, fmt.Errorf("the record's 's3.object.key' must not be empty")
+	}
+	name, imageDigest, found := strings.Cut(key, "@")
+	if !found {
+		return S3ImageLocation{}, fmt.Errorf("the record's 's3.object.key' must be of the form <repository-name>[:<tag>]@<digest>")
+	}
+
+	location := S3ImageLocation{Region: record.AWSRegion, RepositoryName: name, ImageDigest: imageDigest}
+	// a colon after the last slash separates the tag, as in image references
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		location.RepositoryName, location.ImageTag = name[:i], name[i+1:]
+		if !imageTagRegex.MatchString(location.ImageTag) {
+			return S3ImageLocation{}, fmt.Errorf("the record's 's3.object.key' must contain a valid image tag")
+		}
+	}
+	if err := registryutils.ValidateRepositoryName(location.RepositoryName); err != nil {
+		return S3ImageLocation{}, fmt.Errorf("the record's 's3.object.key' must contain a valid repository name: %w", err)
+	}
+	if _, err := digest.Parse(location.ImageDigest); err != nil {
+		return S3ImageLocation{}, fmt.Errorf("the record's 's3.object.key' must contain a valid image digest")
+	}
+	return location, nil
+}
@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+)
+
+const testDigest = "sha256:8a1ab2f6f0e7f0e3bf6b3b0bd3b7f7c0b4e1c6b7b0d1e6e2a1c9f1d6b1e0f7a3"
+
+// s3Event returns a representative S3 object created event for an object key, URL encoded as S3 does
+func s3Event(eventName string, key string) string {
+	return `{
+  "Records": [
+    {
+      "eventVersion": "2.1",
+      "eventSource": "aws:s3",
+      "awsRegion": "us-west-2",
+      "eventTime": "2024-05-01T12:00:00.000Z",
+      "eventName": "` + eventName + `",
+      "userIdentity": {"principalId": "AWS:AIDAEXAMPLE"},
+      "requestParameters": {"sourceIPAddress": "10.0.0.1"},
+      "responseElements": {"x-amz-request-id": "EXAMPLE123456789", "x-amz-id-2": "EXAMPLE123/5678abcdefghijklambdaisawesome/mnopqrstuvwxyzABCDEFGH"},
+      "s3": {
+        "s3SchemaVersion": "1.0",
+        "configurationId": "soci-index-builder",
+        "bucket": {"name": "image-manifests", "ownerIdentity": {"principalId": "EXAMPLE"}, "arn": "arn:aws:s3:::image-manifests"},
+        "object": {"key": "` + key + `", "size": 1024, "eTag": "0123456789abcdef0123456789abcdef", "sequencer": "0A1B2C3D4E5F678901"}
+      }
+    }
+  ]
+}`
+}
+
+func TestParseS3EventRecord(t *testing.T) {
+	doTest := func(eventName string, key string, expected S3ImageLocation, expectError bool) {
+		var event awsevents.S3Event
+		if err := json.Unmarshal([]byte(s3Event(eventName, key)), &event); err != nil {
+			t.Fatalf("Failed to unmarshal S3 event: %v", err)
+		}
+		location, err := ParseS3EventRecord(event.Records[0])
+		if expectError {
+			if err == nil {
+				t.Fatalf("Expected an error for key %s, got %+v", key, location)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error for key %s: %v", key, err)
+		}
+		if location != expected {
+			t.Fatalf("Expected %+v but got %+v", expected, location)
+		}
+	}
+
+	encodedDigest := strings.Replace(testDigest, ":", "%3A", 1)
+	doTest("ObjectCreated:Put", "app%40"+encodedDigest,
+		S3ImageLocation{Region: "us-west-2", RepositoryName: "app", ImageDigest: testDigest}, false)
+	doTest("ObjectCreated:CompleteMultipartUpload", "team/app%3Av1.2%40"+encodedDigest+".json",
+		S3ImageLocation{Region: "us-west-2", RepositoryName: "team/app", ImageDigest: testDigest, ImageTag: "v1.2"}, false)
+	doTest("ObjectCreated:Put", "org/team__a/app--web%3Av1%40"+encodedDigest,
+		S3ImageLocation{Region: "us-west-2", RepositoryName: "org/team__a/app--web", ImageDigest: testDigest, ImageTag: "v1"}, false)
+
+	doTest("ObjectRemoved:Delete", "app%40"+encodedDigest, S3ImageLocation{}, true)
+	doTest("ObjectCreated:Put", "", S3ImageLocation{}, true)
+	doTest("ObjectCreated:Put", "app", S3ImageLocation{}, true)
+	doTest("ObjectCreated:Put", "App%40"+encodedDigest, S3ImageLocation{}, true)
+	doTest("ObjectCreated:Put", "org//app%40"+encodedDigest, S3ImageLocation{}, true)
+	doTest("ObjectCreated:Put", "app%40sha256%3Aabc", S3ImageLocation{}, true)
+	doTest("ObjectCreated:Put", "app%3A-bad%40"+encodedDigest, S3ImageLocation{}, true)
+}
@@ -0,0 +1,48 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/awslabs/soci-snapshotter/soci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const SkipExistingIndex = "skip_existing_index"
+
+// Find a SOCI index of the version being built that already refers to the image, e.g. one pushed by other tooling
+// or by a previous invocation, among the referrers of the image. Returns nil without such an index.
+func findExistingIndex(ctx context.Context, registry registryutils.RegistryClient, repo string, imageDigest string, version registryutils.SociIndexVersion) (*ocispec.Descriptor, error) {
+	artifactType := soci.SociIndexArtifactTypeV1
+	if version == registryutils.V2 {
+		artifactType = soci.SociIndexArtifactTypeV2
+	}
+	referrers, err := registry.ListReferrers(ctx, repo, imageDigest, artifactType)
+	if err != nil || len(referrers) == 0 {
+		return nil, err
+	}
+	return &referrers[0], nil
+}
+
+// Check if the image already has a SOCI index and is skipped, when SkipExistingIndex is set. A failure to list the
+// referrers of the image is logged and the image indexed anyway.
+func hasExistingIndex(ctx context.Context, registry registryutils.RegistryClient, repo string, imageDigest string, opts ProcessOptions) bool {
+	if !opts.SkipExistingIndex {
+		return false
+	}
+	existing, err := findExistingIndex(ctx, registry, repo, imageDigest, opts.indexVersion())
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to look for an existing SOCI index, indexing the image: %v", err))
+		return false
+	}
+	if existing == nil {
+		return false
+	}
+	log.Info(ctx, fmt.Sprintf("Found existing SOCI index %s", existing.Digest))
+	return true
+}
@@ -0,0 +1,93 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type fakeReferrersRegistryClient struct {
+	registryutils.RegistryClient
+	// Referrers of the image keyed by artifact type
+	referrers    map[string][]ocispec.Descriptor
+	referrersErr error
+	listed       []string
+	pulled       int
+}
+
+func (c *fakeReferrersRegistryClient) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion registryutils.SociIndexVersion) error {
+	return nil
+}
+
+func (c *fakeReferrersRegistryClient) ListReferrers(ctx context.Context, repositoryName string, subjectDigest string, artifactType string) ([]ocispec.Descriptor, error) {
+	c.listed = append(c.listed, artifactType)
+	return c.referrers[artifactType], c.referrersErr
+}
+
+func (c *fakeReferrersRegistryClient) Pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string, opts ...registryutils.PullOption) (*registryutils.PullResult, error) {
+	c.pulled++
+	return &registryutils.PullResult{Descriptor: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.Digest(imageReference)}}, nil
+}
+
+func (c *fakeReferrersRegistryClient) Push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string, opts ...registryutils.PushOption) (*registryutils.PushResult, error) {
+	return &registryutils.PushResult{Descriptor: indexDesc}, nil
+}
+
+func TestProcessImageSkipsExistingIndex(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-skips-existing-index"
+	ctx, cancel := context.WithTimeout(lambdacontext.NewContext(context.Background(), &lc), time.Minute)
+	defer cancel()
+
+	var client *fakeReferrersRegistryClient
+	originalClient := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return client, nil
+	}
+	defer func() { newRegistryClient = originalClient }()
+	originalBuild := buildImageIndex
+	buildImageIndex = func(ctx context.Context, dataDir string, sociStore *store.SociStore, image images.Image, opts ProcessOptions) (*ocispec.Descriptor, []PlatformFailure, error) {
+		return &ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("index")}, nil, nil
+	}
+	defer func() { buildImageIndex = originalBuild }()
+
+	existing := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("existing"), ArtifactType: soci.SociIndexArtifactTypeV1}
+	ref := ImageRef{RegistryURL: "123456789012.dkr.ecr.us-west-2.amazonaws.com", RepositoryName: "repo", Digest: testImageDigest, Tag: "1.0"}
+	doTest := func(referrers map[string][]ocispec.Descriptor, referrersErr error, opts ProcessOptions, expected string, expectedListed int) {
+		client = &fakeReferrersRegistryClient{referrers: referrers, referrersErr: referrersErr}
+		resp, err := processImage(ctx, ref, opts)
+		if err != nil || resp != expected {
+			t.Fatalf("Expected %q but got %q, %v", expected, resp, err)
+		}
+		if len(client.listed) != expectedListed {
+			t.Fatalf("Expected the referrers to be listed %d times but got %v", expectedListed, client.listed)
+		}
+		if skipped := expected == SkipExistingIndexMessage; skipped != (client.pulled == 0) {
+			t.Fatalf("Expected the image to be pulled %v but got %d pulls", !skipped, client.pulled)
+		}
+	}
+	skipExisting := ProcessOptions{SociIndexVersion: "V1", SkipExistingIndex: true}
+
+	// already indexed
+	doTest(map[string][]ocispec.Descriptor{soci.SociIndexArtifactTypeV1: {existing}}, nil, skipExisting, SkipExistingIndexMessage, 1)
+	// needs indexing
+	doTest(nil, nil, skipExisting, BuildAndPushSuccessMessage, 1)
+	// an index of the other version doesn't count
+	doTest(map[string][]ocispec.Descriptor{soci.SociIndexArtifactTypeV1: {existing}}, nil, ProcessOptions{SociIndexVersion: "V2", SkipExistingIndex: true}, BuildAndPushSuccessMessage, 1)
+	// the image is indexed when the referrers can't be listed
+	doTest(nil, errors.New("referrers unavailable"), skipExisting, BuildAndPushSuccessMessage, 1)
+	// the referrers aren't listed by default
+	doTest(map[string][]ocispec.Descriptor{soci.SociIndexArtifactTypeV1: {existing}}, nil, ProcessOptions{SociIndexVersion: "V1"}, BuildAndPushSuccessMessage, 0)
+}
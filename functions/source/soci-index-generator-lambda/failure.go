@@ -0,0 +1,130 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Stable codes of the causes of a failure to index an image, for status reports and dashboards
+const (
+	FailureAuthDenied         = "AUTH_DENIED"
+	FailureReplicationTimeout = "REPLICATION_TIMEOUT"
+	FailureRepositoryNotFound = "REPOSITORY_NOT_FOUND"
+	FailureImageNotFound      = "IMAGE_NOT_FOUND"
+	FailureOCIUnsupported     = "OCI_UNSUPPORTED"
+	FailureImageTooSmall      = "IMAGE_TOO_SMALL"
+	FailureTooManyLayers      = "TOO_MANY_LAYERS"
+	FailureUnknown            = "UNKNOWN"
+)
+
+// The cause of a failure to index an image, with a hint at how to remediate it
+type FailureReport struct {
+	// One of the Failure* codes
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint"`
+}
+
+// The known failure causes, checked in order
+var failureCauses = []struct {
+	code    string
+	hint    string
+	matches func(err error) bool
+}{
+	{
+		code:    FailureAuthDenied,
+		hint:    "Grant the Lambda role ecr:GetAuthorizationToken and pull and push permissions on the repository, or check the registry credentials",
+		matches: isAuthDenied,
+	},
+	{
+		// before the not found causes, which the timeout wraps
+		code: FailureReplicationTimeout,
+		hint: "Check the replication rules of the source registry, or raise replication_wait_timeout",
+		matches: func(err error) bool {
+			return errors.Is(err, registryutils.ErrReplicationTimeout)
+		},
+	},
+	{
+		code:    FailureRepositoryNotFound,
+		hint:    "Check that the repository exists in the registry and region the image was pushed to",
+		matches: isRepositoryNotFound,
+	},
+	{
+		code: FailureImageNotFound,
+		hint: "Check that the image tag or digest exists in the repository, it may have been deleted or overwritten",
+		matches: func(err error) bool {
+			return errors.Is(err, registryutils.ErrImageNotFound)
+		},
+	},
+	{
+		code: FailureOCIUnsupported,
+		hint: "Use a registry supporting OCI artifacts and image indexes, or build V1 SOCI indexes",
+		matches: func(err error) bool {
+			return errors.Is(err, registryutils.RegistryNotSupportingOciArtifacts)
+		},
+	},
+	{
+		code: FailureImageTooSmall,
+		hint: "Every layer is below the minimum layer size, lazy loading brings no benefit to this image",
+		matches: func(err error) bool {
+			return errors.Is(err, ErrEmptyIndex) || errors.Is(err, soci.ErrEmptyIndex) || errors.Is(err, ErrNoIndexableLayers)
+		},
+	},
+	{
+		code: FailureTooManyLayers,
+		hint: "Squash the layers of the image or raise the maximum number of layers",
+		matches: func(err error) bool {
+			return errors.Is(err, registryutils.ErrTooManyLayers)
+		},
+	},
+}
+
+// Map the error of indexing an image to a failure report. Returns nil for a nil error.
+func NewFailureReport(err error) *FailureReport {
+	if err == nil {
+		return nil
+	}
+	for _, cause := range failureCauses {
+		if cause.matches(err) {
+			return &FailureReport{Code: cause.code, Message: err.Error(), Hint: cause.hint}
+		}
+	}
+	return &FailureReport{Code: FailureUnknown, Message: err.Error(), Hint: "Check the Lambda logs for details and retry"}
+}
+
+func isAuthDenied(err error) bool {
+	if errors.Is(err, registryutils.ErrRegistryUnauthorized) {
+		return true
+	}
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) && (errResp.StatusCode == http.StatusUnauthorized || errResp.StatusCode == http.StatusForbidden) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException"
+}
+
+func isRepositoryNotFound(err error) bool {
+	if errors.Is(err, registryutils.ErrRepositoryNotFound) {
+		return true
+	}
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) {
+		for _, e := range errResp.Errors {
+			if e.Code == errcode.ErrorCodeNameUnknown {
+				return true
+			}
+		}
+	}
+	var notFound *types.RepositoryNotFoundException
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,73 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+func TestNewFailureReport(t *testing.T) {
+	doTest := func(err error, expectedCode string, expectedHint string) {
+		report := NewFailureReport(err)
+		if report == nil {
+			t.Fatalf("Expected a failure report for %v", err)
+		}
+		if report.Code != expectedCode {
+			t.Fatalf("Expected code %s for %v but got %s", expectedCode, err, report.Code)
+		}
+		if !strings.Contains(report.Hint, expectedHint) {
+			t.Fatalf("Expected the hint for %v to mention %q but got %q", err, expectedHint, report.Hint)
+		}
+		if report.Message != err.Error() {
+			t.Fatalf("Expected message %q but got %q", err.Error(), report.Message)
+		}
+	}
+
+	unauthorized := &errcode.ErrorResponse{Method: http.MethodGet, StatusCode: http.StatusUnauthorized}
+	doTest(fmt.Errorf("pull repo@sha256:abcd: %w", unauthorized), FailureAuthDenied, "ecr:GetAuthorizationToken")
+	doTest(fmt.Errorf("%w: %w", registryutils.ErrRegistryUnauthorized, errors.New("denied")), FailureAuthDenied, "credentials")
+	doTest(&smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}, FailureAuthDenied, "ecr:GetAuthorizationToken")
+
+	nameUnknown := &errcode.ErrorResponse{
+		Method:     http.MethodGet,
+		StatusCode: http.StatusNotFound,
+		Errors:     errcode.Errors{{Code: errcode.ErrorCodeNameUnknown, Message: "repository name not known to registry"}},
+	}
+	doTest(fmt.Errorf("pull repo@sha256:abcd: %w", nameUnknown), FailureRepositoryNotFound, "repository exists")
+	doTest(&types.RepositoryNotFoundException{Message: aws.String("not found")}, FailureRepositoryNotFound, "repository exists")
+	doTest(fmt.Errorf("head manifest repo:latest: %w", registryutils.ErrRepositoryNotFound), FailureRepositoryNotFound, "repository exists")
+	doTest(fmt.Errorf("pull repo:latest: %w", registryutils.ErrImageNotFound), FailureImageNotFound, "tag or digest")
+	// the timeout wraps the not found error of the last poll
+	doTest(fmt.Errorf("%w after 1m0s: %w", registryutils.ErrReplicationTimeout, &types.RepositoryNotFoundException{Message: aws.String("not found")}), FailureReplicationTimeout, "replication")
+
+	doTest(fmt.Errorf("push: %w", registryutils.RegistryNotSupportingOciArtifacts), FailureOCIUnsupported, "V1")
+	doTest(fmt.Errorf("failed to convert OCI index: %w", soci.ErrEmptyIndex), FailureImageTooSmall, "minimum layer size")
+	doTest(ErrEmptyIndex, FailureImageTooSmall, "minimum layer size")
+	doTest(fmt.Errorf("validate: %w", registryutils.ErrTooManyLayers), FailureTooManyLayers, "Squash")
+	doTest(errors.New("disk full"), FailureUnknown, "logs")
+
+	if report := NewFailureReport(nil); report != nil {
+		t.Fatalf("Expected no failure report without error but got %+v", report)
+	}
+
+	encoded, err := json.Marshal(NewFailureReport(registryutils.ErrTooManyLayers))
+	if err != nil {
+		t.Fatalf("Failed to encode the failure report: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"code":"TOO_MANY_LAYERS"`) {
+		t.Fatalf("Expected the encoded report to carry its code but got %s", encoded)
+	}
+}
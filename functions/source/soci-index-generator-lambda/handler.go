@@ -5,6 +5,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"regexp"
@@ -19,10 +21,10 @@ import (
 	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/fs"
 	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
 	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/containerd/containerd/images"
-	"oras.land/oras-go/v2/content/oci"
 
 	"github.com/awslabs/soci-snapshotter/soci"
 	"github.com/awslabs/soci-snapshotter/soci/store"
@@ -30,6 +32,7 @@ import (
 	"github.com/containerd/containerd/content/local"
 	"github.com/containerd/containerd/platforms"
 
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -39,75 +42,301 @@ var (
 )
 
 const (
-	BuildFailedMessage          = "SOCI index build error"
-	TagFailedMessage            = "SOCI V2 OCI Image tag error"
-	PushFailedMessage           = "SOCI index push error"
-	SkipPushOnEmptyIndexMessage = "Skipping pushing SOCI index as it does not contain any zTOCs"
-	BuildAndPushSuccessMessage  = "Successfully built and pushed SOCI index"
-
-	artifactsStoreName = "store"
-	artifactsDbName    = "artifacts.db"
+	BuildFailedMessage             = "SOCI index build error"
+	TagFailedMessage               = "SOCI V2 OCI Image tag error"
+	PushFailedMessage              = "SOCI index push error"
+	SkipPushOnEmptyIndexMessage    = "Skipping pushing SOCI index as it does not contain any zTOCs"
+	TagImmutableMessage            = "Pushed SOCI index without tag as the tag already exists in an immutable repository"
+	SkipNoMatchingPlatformsMessage = "Skipping SOCI index generation as no platform of the image is allowed"
+	SkipAlreadyProcessedMessage    = "Skipping SOCI index generation as the image was recently indexed"
+	SkipScanNotPassedMessage       = "Skipping SOCI index generation as the image did not pass the ECR image scan"
+	SkipSubjectIsSociIndexMessage  = "Skipping SOCI index generation as the image is itself a SOCI index"
+	SkipNoIndexableLayersMessage   = "Skipping SOCI index generation as no layer of the image qualifies for a ztoc"
+	SkipInvalidManifestMessage     = "Exited early due to manifest validation error"
+	SkipNoTagMessage               = "Skipped SOCI index generation for V2 as image has no tag"
+	SkipExistingIndexMessage       = "Skipping SOCI index generation as the image already has a SOCI index"
+	BuildAndPushSuccessMessage     = "Successfully built and pushed SOCI index"
+	PartialBuildAndPushMessage     = "Successfully built and pushed SOCI index, skipping the platforms that failed"
+
+	buildToolIdentifier = "AWS SOCI Index Builder Cfn v0.2"
+	artifactsStoreName  = "store"
+	artifactsDbName     = "artifacts.db"
 )
 
 // Define custom context key types to avoid collisions
 type contextKey string
 
 const (
-	RegistryURLKey     contextKey = "RegistryURL"
-	RepositoryNameKey  contextKey = "RepositoryName"
-	ImageDigestKey     contextKey = "ImageDigest"
-	ImageTagKey        contextKey = "ImageTag"
-	SOCIIndexDigestKey contextKey = "SOCIIndexDigest"
-	SociIndexVersion   string     = "soci_index_version"
+	RegistryURLKey          contextKey = "RegistryURL"
+	RepositoryNameKey       contextKey = "RepositoryName"
+	ImageDigestKey          contextKey = "ImageDigest"
+	ImageTagKey             contextKey = "ImageTag"
+	SOCIIndexDigestKey      contextKey = "SOCIIndexDigest"
+	SociIndexVersion        string     = "soci_index_version"
+	PlatformAllowlist       string     = "platform_allowlist"
+	ScanSeverityThreshold   string     = "scan_severity_threshold"
+	LayerMediaTypeAllowlist string     = "layer_media_type_allowlist"
+	LayerMediaTypeDenylist  string     = "layer_media_type_denylist"
+	ContinueOnPlatformError string     = "continue_on_platform_error"
+	WorkDirRoot             string     = "work_dir_root"
+	ResultFormat            string     = "result_format"
+	ValidatePushedIndex     string     = "validate_pushed_index"
 )
 
+// Options of the pull, index and push pipeline of an image
+type ProcessOptions struct {
+	// The SOCI index version to build, i.e. "V1" or "V2"
+	SociIndexVersion string
+	// When indexing an image index, only index the platforms matching one of these.
+	// An empty allowlist indexes every platform.
+	PlatformAllowlist []ocispec.Platform
+	// Images recently indexed, which are skipped. A nil cache skips nothing.
+	ProcessedCache *ProcessedCache
+	// When set, only index images whose ECR scan is complete without findings at or above this severity,
+	// e.g. "HIGH"
+	ScanSeverityThreshold string
+	// Only layers of these media types are spanned by the SOCI index.
+	// An empty allowlist allows the standard tar, gzip and zstd layer media types.
+	LayerMediaTypeAllowlist []string
+	// Layers of these media types are never spanned by the SOCI index
+	LayerMediaTypeDenylist []string
+	// When indexing an image index, push the SOCI indexes of the platforms that succeed and report the
+	// platforms that fail rather than failing the whole image
+	ContinueOnPlatformError bool
+	// Template of the tag of the pushed index, derived from the source tag of the image, e.g. "{tag}-soci".
+	// See renderIndexTag for the placeholders. Empty tags V2 indexes with "{tag}-soci" and leaves V1 indexes untagged.
+	IndexTagTemplate string
+	// Notified of each pushed SOCI index. A nil publisher publishes nothing.
+	CompletionPublisher CompletionPublisher
+	// Layers smaller than this many bytes get no ztoc. Non positive values keep the builder's default of 10MiB.
+	MinLayerSize int64
+	// When no layer of a V1 image qualifies for a ztoc, push a SOCI index spanning no layers for tooling expecting
+	// its presence, rather than skipping the image with ErrNoIndexableLayers. V2 images are always skipped.
+	PushEmptyIndex bool
+	// Extend the existing V1 SOCI index of the image, or else the one at the index tag, reusing the ztocs of the
+	// layers it spans and only spanning the others. V2 images are always indexed from scratch.
+	IncrementalIndexing bool
+	// Root of the working directory of each image, the Lambda's ephemeral storage of /tmp when empty
+	WorkDirRoot string
+	// Check that the pushed index parses the way the SOCI snapshotter parses it before tagging it
+	ValidatePushedIndex bool
+	// Skip images that already have a SOCI index of the version being built, e.g. pushed by other tooling
+	SkipExistingIndex bool
+	// When positive, wait up to this long for the image to be replicated to the region of the registry by ECR
+	// cross-region replication before pulling it
+	ReplicationWaitTimeout time.Duration
+	// The snapshotter versions the pushed index targets, the current release when nil
+	SnapshotterCompatibility *registryutils.SnapshotterCompatibility
+}
+
+// Lambda entry point, accepting either an ECR image action event or an S3 event
+func HandleEvent(ctx context.Context, payload json.RawMessage) (string, error) {
+	var s3Event awsevents.S3Event
+	if err := json.Unmarshal(payload, &s3Event); err == nil && len(s3Event.Records) > 0 {
+		return HandleS3Event(ctx, s3Event)
+	}
+
+	var ecrEvent events.ECRImageActionEvent
+	if err := json.Unmarshal(payload, &ecrEvent); err != nil {
+		return lambdaError(ctx, "Event parsing error", err)
+	}
+	return HandleRequest(ctx, ecrEvent)
+}
+
 func HandleRequest(ctx context.Context, event events.ECRImageActionEvent) (string, error) {
 	ctx, err := validateEvent(ctx, event)
 	if err != nil {
 		return lambdaError(ctx, "ECRImageActionEvent validation error", err)
 	}
 
+	opts, err := processOptionsFromEnv(ctx)
+	if err != nil {
+		return lambdaError(ctx, "Platform allowlist parsing error", err)
+	}
+
+	ref := ImageRef{
+		RegistryURL:    buildEcrRegistryUrl(event),
+		RepositoryName: event.Detail.RepositoryName,
+		Digest:         event.Detail.ImageDigest,
+		Tag:            event.Detail.ImageTag,
+	}
+	return processImage(ctx, ref, opts)
+}
+
+// Handle S3 object created events whose object keys locate images in the Lambda's account, see events.S3ImageLocation
+func HandleS3Event(ctx context.Context, event awsevents.S3Event) (string, error) {
+	lambdaContext, _ := lambdacontext.FromContext(ctx)
+	account := accountFromArn(lambdaContext.InvokedFunctionArn)
+	if account == "" {
+		return lambdaError(ctx, "S3 event validation error", fmt.Errorf("couldn't determine the account id from the function arn"))
+	}
+
+	var refs []ImageRef
+	for _, record := range event.Records {
+		location, err := events.ParseS3EventRecord(record)
+		if err != nil {
+			return lambdaError(ctx, "S3 event validation error", err)
+		}
+		refs = append(refs, ImageRef{
+			RegistryURL:    ecrRegistryUrl(account, location.Region),
+			RepositoryName: location.RepositoryName,
+			Digest:         location.ImageDigest,
+			Tag:            location.ImageTag,
+		})
+	}
+
+	opts, err := processOptionsFromEnv(ctx)
+	if err != nil {
+		return lambdaError(ctx, "Platform allowlist parsing error", err)
+	}
+
+	if len(refs) == 1 {
+		return processImage(ctx, refs[0], opts)
+	}
+	var errs []error
+	for _, result := range ProcessBatch(ctx, refs, BatchOptions{ProcessOptions: opts}) {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Ref, result.Err))
+		}
+	}
+	if len(errs) > 0 {
+		return lambdaError(ctx, "Batch processing error", errors.Join(errs...))
+	}
+	return fmt.Sprintf("Processed %d images", len(refs)), nil
+}
+
+// The version of the SOCI index built for an image: buildIndex builds a V1 index for any version other than V2
+func (opts ProcessOptions) indexVersion() registryutils.SociIndexVersion {
+	if opts.SociIndexVersion == string(registryutils.V2) {
+		return registryutils.V2
+	}
+	return registryutils.V1
+}
+
+// Read the processing options from the Lambda's environment variables
+func processOptionsFromEnv(ctx context.Context) (ProcessOptions, error) {
 	// Get the SOCI index version from environment variable
 	sociIndexVersion := os.Getenv(SociIndexVersion)
 	log.Info(ctx, fmt.Sprintf("Using SOCI index version: %s", sociIndexVersion))
+	if _, err := registryutils.ParseSociIndexVersion(sociIndexVersion); err != nil {
+		log.Warn(ctx, fmt.Sprintf("Building V1 SOCI indexes: %v", err))
+	}
+
+	// Get the optional platform allowlist from environment variable, e.g. "linux/amd64,linux/arm64"
+	platformAllowlist, err := parsePlatforms(os.Getenv(PlatformAllowlist))
+	if err != nil {
+		return ProcessOptions{}, err
+	}
+
+	return ProcessOptions{
+		SociIndexVersion:      sociIndexVersion,
+		PlatformAllowlist:     platformAllowlist,
+		ProcessedCache:        processedCacheFromEnv(ctx),
+		ScanSeverityThreshold: os.Getenv(ScanSeverityThreshold),
+		// Optional comma separated lists of layer media types
+		LayerMediaTypeAllowlist:  parseMediaTypes(os.Getenv(LayerMediaTypeAllowlist)),
+		LayerMediaTypeDenylist:   parseMediaTypes(os.Getenv(LayerMediaTypeDenylist)),
+		ContinueOnPlatformError:  os.Getenv(ContinueOnPlatformError) == "true",
+		IndexTagTemplate:         os.Getenv(IndexTagTemplate),
+		CompletionPublisher:      completionPublisherFromEnv(ctx),
+		MinLayerSize:             minLayerSizeFromEnv(ctx),
+		PushEmptyIndex:           os.Getenv(PushEmptyIndex) == "true",
+		IncrementalIndexing:      os.Getenv(IncrementalIndexing) == "true",
+		WorkDirRoot:              os.Getenv(WorkDirRoot),
+		ValidatePushedIndex:      os.Getenv(ValidatePushedIndex) == "true",
+		SkipExistingIndex:        os.Getenv(SkipExistingIndex) == "true",
+		ReplicationWaitTimeout:   replicationWaitTimeoutFromEnv(ctx),
+		SnapshotterCompatibility: snapshotterCompatibilityFromEnv(ctx),
+	}, nil
+}
+
+// Create the client of the registry an image is pulled from and its SOCI index pushed to, overridden in tests
+var newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+	return registryutils.Init(ctx, registryUrl)
+}
+
+// Pull an image, build its SOCI index and push the index back to the image's repository
+func processImage(ctx context.Context, ref ImageRef, opts ProcessOptions) (string, error) {
+	return runImage(ctx, ref, opts, newResult(ref, opts))
+}
 
-	repo := event.Detail.RepositoryName
-	digest := event.Detail.ImageDigest
-	registryUrl := buildEcrRegistryUrl(event)
-	ctx = context.WithValue(ctx, RegistryURLKey, registryUrl)
+// The pipeline of processImage, recording what it transferred and how long it took in result
+func runImage(ctx context.Context, ref ImageRef, opts ProcessOptions, result *Result) (string, error) {
+	sociIndexVersion := opts.SociIndexVersion
+	repo := ref.RepositoryName
+	digest := ref.Digest
+	ctx = context.WithValue(ctx, RegistryURLKey, ref.RegistryURL)
+	ctx = context.WithValue(ctx, RepositoryNameKey, repo)
+	ctx = context.WithValue(ctx, ImageDigestKey, digest)
+	if ref.Tag != "" {
+		ctx = context.WithValue(ctx, ImageTagKey, ref.Tag)
+	}
+	if opts.ProcessedCache.Contains(processedKey(ref, opts)) {
+		log.Info(ctx, SkipAlreadyProcessedMessage)
+		return SkipAlreadyProcessedMessage, nil
+	}
 
-	registry, err := registryutils.Init(ctx, registryUrl)
+	registry, err := newRegistryClient(ctx, ref.RegistryURL)
 	if err != nil {
 		return lambdaError(ctx, "Remote registry initialization error", err)
 	}
 
-	err = registry.ValidateImageDigest(ctx, repo, digest, sociIndexVersion)
+	if opts.ReplicationWaitTimeout > 0 {
+		if err := registry.WaitForReplication(ctx, repo, digest, opts.ReplicationWaitTimeout); err != nil {
+			return lambdaError(ctx, "Replication wait error", err)
+		}
+	}
+
+	err = registry.ValidateImageDigest(ctx, repo, digest, opts.indexVersion())
+	if errors.Is(err, registryutils.ErrSubjectIsSociIndex) {
+		log.Warn(ctx, fmt.Sprintf("%s: %v", SkipSubjectIsSociIndexMessage, err))
+		// Returning a non error to skip retries
+		return SkipSubjectIsSociIndexMessage, nil
+	}
 	if err != nil {
 		log.Warn(ctx, fmt.Sprintf("Image manifest validation error: %v", err))
 		// Returning a non error to skip retries
-		return "Exited early due to manifest validation error", nil
+		return SkipInvalidManifestMessage, nil
 	}
 
-	// For V2, only convert images that have a tag and tag the newly generated image index
-	var tag string
-	if sociIndexVersion == "V2" {
-		// Get the original image tag if available
-		originalTag, ok := ctx.Value(ImageTagKey).(string)
-		if !ok || originalTag == "" {
-			log.Info(ctx, "Skipping SOCI index generation for V2 as image has no tag")
-			return "Skipped SOCI index generation for V2 as image has no tag", nil
+	if hasExistingIndex(ctx, registry, repo, digest, opts) {
+		log.Info(ctx, SkipExistingIndexMessage)
+		opts.ProcessedCache.Add(processedKey(ref, opts))
+		return SkipExistingIndexMessage, nil
+	}
+
+	if opts.ScanSeverityThreshold != "" {
+		err = registry.CheckImageScan(ctx, repo, digest, opts.ScanSeverityThreshold)
+		if errors.Is(err, registryutils.ErrScanNotPassed) {
+			log.Warn(ctx, fmt.Sprintf("%s: %v", SkipScanNotPassedMessage, err))
+			// Returning a non error to skip retries
+			return SkipScanNotPassedMessage, nil
+		}
+		if err != nil {
+			return lambdaError(ctx, "Image scan check error", err)
 		}
+	}
 
-		tag = originalTag + "-soci"
-		log.Info(ctx, fmt.Sprintf("Using original image tag with suffix: %s", tag))
+	// The image is pulled by digest, while the index is tagged after the source tag.
+	// For V2, only convert images that have a tag and tag the newly generated image index
+	tag, err := indexTag(ref, opts)
+	if err != nil {
+		return lambdaError(ctx, "Index tag error", err)
+	}
+	if sociIndexVersion == "V2" && tag == "" {
+		log.Info(ctx, "Skipping SOCI index generation for V2 as image has no tag")
+		return SkipNoTagMessage, nil
+	}
+	if tag != "" {
+		log.Info(ctx, fmt.Sprintf("Tagging the SOCI index of the image with %s", tag))
 	}
 
-	// Directory in lambda storage to store images and SOCI artifacts
-	dataDir, err := createTempDir(ctx)
+	// Directory in lambda storage to store images and SOCI artifacts, removed even on panic
+	dataDir, cleanup, err := createTempDir(ctx, opts.WorkDirRoot)
 	if err != nil {
 		return lambdaError(ctx, "Directory create error", err)
 	}
-	defer cleanUp(ctx, dataDir)
+	defer cleanup()
 
 	// The channel to signal the deadline monitor goroutine to exit early
 	quitChannel := make(chan int)
@@ -115,39 +344,88 @@ func HandleRequest(ctx context.Context, event events.ECRImageActionEvent) (strin
 		quitChannel <- 1
 	}()
 
-	setDeadline(ctx, quitChannel, dataDir)
+	setDeadline(ctx, quitChannel, cleanup)
 
 	sociStore, err := initSociStore(ctx, dataDir)
 	if err != nil {
 		return lambdaError(ctx, "OCI storage initialization error", err)
 	}
 
-	desc, err := registry.Pull(ctx, repo, sociStore, digest)
+	pulled, err := registry.Pull(ctx, repo, sociStore, digest)
 	if err != nil {
 		return lambdaError(ctx, "Image pull error", err)
 	}
+	log.Info(ctx, fmt.Sprintf("Pulled %d bytes (resolved in %s, copied in %s)", pulled.BytesCopied, pulled.ResolveDuration, pulled.CopyDuration))
+	result.recordPull(pulled)
 
 	image := images.Image{
 		Name:   repo + "@" + digest,
-		Target: *desc,
+		Target: pulled.Descriptor,
 	}
 
-	indexDescriptor, err := buildIndex(ctx, dataDir, sociStore, image, sociIndexVersion)
+	var indexDescriptor *ocispec.Descriptor
+	var platformFailures []PlatformFailure
+	if base := pullBaseIndex(ctx, registry, repo, digest, tag, sociStore, opts); base != nil {
+		indexDescriptor, err = buildIncrementalIndex(ctx, dataDir, sociStore, image, *base, opts)
+	} else {
+		indexDescriptor, platformFailures, err = buildImageIndex(ctx, dataDir, sociStore, image, opts)
+	}
+	for _, failure := range platformFailures {
+		log.Warn(ctx, fmt.Sprintf("Skipped platform %s: %v", platforms.Format(failure.Platform), failure.Err))
+	}
 	if err != nil {
 		if err.Error() == ErrEmptyIndex.Error() {
 			log.Warn(ctx, SkipPushOnEmptyIndexMessage)
 			return SkipPushOnEmptyIndexMessage, nil
 		}
+		if errors.Is(err, ErrNoIndexableLayers) {
+			log.Warn(ctx, fmt.Sprintf("%s: %v", SkipNoIndexableLayersMessage, err))
+			return SkipNoIndexableLayersMessage, nil
+		}
+		if errors.Is(err, ErrNoMatchingPlatforms) {
+			log.Warn(ctx, fmt.Sprintf("%s: %v", SkipNoMatchingPlatformsMessage, err))
+			return SkipNoMatchingPlatformsMessage, nil
+		}
 		return lambdaError(ctx, BuildFailedMessage, err)
 	}
 
 	ctx = context.WithValue(ctx, SOCIIndexDigestKey, indexDescriptor.Digest.String())
+	logStoreUsage(ctx, dataDir)
+	logIndexCoverage(ctx, sociStore, *indexDescriptor)
 
-	err = registry.Push(ctx, sociStore, *indexDescriptor, repo, tag)
+	pushOpts := []registryutils.PushOption{registryutils.WithSociIndexVersion(opts.indexVersion())}
+	if opts.ValidatePushedIndex {
+		pushOpts = append(pushOpts, registryutils.WithSnapshotterValidation())
+	}
+	if opts.SnapshotterCompatibility != nil {
+		pushOpts = append(pushOpts, registryutils.WithSnapshotterCompatibility(*opts.SnapshotterCompatibility))
+	}
+	pushed, err := registry.Push(ctx, sociStore, *indexDescriptor, repo, tag, pushOpts...)
+	if pushed != nil {
+		log.Info(ctx, fmt.Sprintf("Pushed %d bytes (reconciled in %s, copied in %s, tagged in %s)",
+			pushed.BytesUploaded, pushed.ReconcileDuration, pushed.CopyDuration, pushed.TagDuration))
+		result.recordPush(pushed)
+	}
 	if err != nil {
+		if errors.Is(err, registryutils.ErrTagImmutable) {
+			// the index itself was pushed and stays reachable by digest, retrying wouldn't help
+			log.Warn(ctx, fmt.Sprintf("%s: %v", TagImmutableMessage, err))
+			return TagImmutableMessage, nil
+		}
 		return lambdaError(ctx, PushFailedMessage, err)
 	}
+	notifyCompletion(ctx, opts.CompletionPublisher, CompletionEvent{
+		Repository:    repo,
+		SubjectDigest: digest,
+		IndexDigest:   pushed.Descriptor.Digest.String(),
+		Tag:           tag,
+	})
 
+	opts.ProcessedCache.Add(processedKey(ref, opts))
+	if len(platformFailures) > 0 {
+		log.Info(ctx, PartialBuildAndPushMessage)
+		return PartialBuildAndPushMessage, nil
+	}
 	log.Info(ctx, BuildAndPushSuccessMessage)
 	return BuildAndPushSuccessMessage, nil
 }
@@ -171,9 +449,6 @@ func validateEvent(ctx context.Context, event events.ECRImageActionEvent) (conte
 	if event.Detail.Result != "SUCCESS" {
 		errors = append(errors, fmt.Errorf("the event's 'detail.result' must be 'SUCCESS'"))
 	}
-	if event.Detail.RepositoryName == "" {
-		errors = append(errors, fmt.Errorf("the event's 'detail.repository-name' must not be empty"))
-	}
 	if event.Detail.ImageDigest == "" {
 		errors = append(errors, fmt.Errorf("the event's 'detail.image-digest' must not be empty"))
 	}
@@ -186,21 +461,17 @@ func validateEvent(ctx context.Context, event events.ECRImageActionEvent) (conte
 		errors = append(errors, fmt.Errorf("the event's 'account' must be a valid AWS account ID"))
 	}
 
-	validRepositoryName, err := regexp.MatchString(`(?:[a-z0-9]+(?:[._-][a-z0-9]+)*/)*[a-z0-9]+(?:[._-][a-z0-9]+)*`, event.Detail.RepositoryName)
-	if err != nil {
-		errors = append(errors, err)
-	}
-	if validRepositoryName {
+	// repository names may span several path components, e.g. "org/team/app"
+	err = registryutils.ValidateRepositoryName(event.Detail.RepositoryName)
+	if err == nil {
 		ctx = context.WithValue(ctx, RepositoryNameKey, event.Detail.RepositoryName)
 	} else {
-		errors = append(errors, fmt.Errorf("the event's 'detail.repository-name' must be a valid repository name"))
+		errors = append(errors, fmt.Errorf("the event's 'detail.repository-name' must be a valid repository name: %w", err))
 	}
 
-	validImageDigest, err := regexp.MatchString(`[[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*[:][A-Fa-f0-9]{32,}`, event.Detail.ImageDigest)
-	if err != nil {
-		errors = append(errors, err)
-	}
-	if validImageDigest {
+	// any digest algorithm supported by go-digest is accepted, e.g. sha256 and sha512
+	_, err = registryutils.ParseDigest(event.Detail.ImageDigest)
+	if err == nil {
 		ctx = context.WithValue(ctx, ImageDigestKey, event.Detail.ImageDigest)
 	} else {
 		errors = append(errors, fmt.Errorf("the event's 'detail.image-digest' must be a valid image digest"))
@@ -228,34 +499,57 @@ func validateEvent(ctx context.Context, event events.ECRImageActionEvent) (conte
 
 // Returns ecr registry url from an image action event
 func buildEcrRegistryUrl(event events.ECRImageActionEvent) string {
+	return ecrRegistryUrl(event.Account, event.Region)
+}
+
+// Returns the url of the ecr registry of an account in a region
+func ecrRegistryUrl(account string, region string) string {
 	var awsDomain = ".amazonaws.com"
-	if strings.HasPrefix(event.Region, "cn") {
+	if strings.HasPrefix(region, "cn") {
 		awsDomain = ".amazonaws.com.cn"
 	}
-	return event.Account + ".dkr.ecr." + event.Region + awsDomain
+	return account + ".dkr.ecr." + region + awsDomain
+}
+
+// Returns the account id of an arn, e.g. 123456789012 from arn:aws:lambda:us-west-2:123456789012:function:name
+func accountFromArn(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
 }
 
-// Create a temp directory in /tmp
+// Create a temp directory under root, /tmp when empty, along with the function cleaning it up
 // The directory is prefixed by the Lambda's request id
-func createTempDir(ctx context.Context) (string, error) {
+func createTempDir(ctx context.Context, root string) (string, func(), error) {
+	if root == "" {
+		root = fs.DefaultWorkDirRoot
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", nil, err
+	}
 	// free space in bytes
-	freeSpace := fs.CalculateFreeSpace("/tmp")
-	log.Info(ctx, fmt.Sprintf("There are %d bytes of free space in /tmp directory", freeSpace))
+	freeSpace := fs.CalculateFreeSpace(root)
+	log.Info(ctx, fmt.Sprintf("There are %d bytes of free space in %s directory", freeSpace, root))
 	if freeSpace < 6_000_000_000 {
 		// this is problematic because we support images as big as 6GB
-		log.Warn(ctx, fmt.Sprintf("Free space in /tmp is only %d bytes, which is less than 6GB", freeSpace))
+		log.Warn(ctx, fmt.Sprintf("Free space in %s is only %d bytes, which is less than 6GB", root, freeSpace))
 	}
 
 	log.Info(ctx, "Creating a directory to store images and SOCI artifacts")
 	lambdaContext, _ := lambdacontext.FromContext(ctx)
-	tempDir, err := os.MkdirTemp("/tmp", lambdaContext.AwsRequestID) // The temp dir name is prefixed by the request id
-	return tempDir, err
+	tempDir, remove, err := fs.CreateWorkDir(root, lambdaContext.AwsRequestID) // The temp dir name is prefixed by the request id
+	if err != nil {
+		return "", nil, err
+	}
+	return tempDir, func() { cleanUp(ctx, tempDir, remove) }, nil
 }
 
 // Clean up the data written by the Lambda
-func cleanUp(ctx context.Context, dataDir string) {
+func cleanUp(ctx context.Context, dataDir string, remove func() error) {
 	log.Info(ctx, fmt.Sprintf("Removing all files in %s", dataDir))
-	if err := os.RemoveAll(dataDir); err != nil {
+	if err := remove(); err != nil {
 		log.Error(ctx, "Clean up error", err)
 	}
 }
@@ -264,7 +558,7 @@ func cleanUp(ctx context.Context, dataDir string) {
 // want to keep data in storage when the Lambda reaches its invocation timeout.
 // This function creates a goroutine that will do cleanup when the invocation timeout is near.
 // quitChannel is used for signaling that goroutine when the invocation ends naturally.
-func setDeadline(ctx context.Context, quitChannel chan int, dataDir string) {
+func setDeadline(ctx context.Context, quitChannel chan int, cleanup func()) {
 	// setting deadline as 10 seconds before lambda timeout.
 	// reference: https://docs.aws.amazon.com/lambda/latest/dg/golang-context.html
 	deadline, _ := ctx.Deadline()
@@ -274,7 +568,7 @@ func setDeadline(ctx context.Context, quitChannel chan int, dataDir string) {
 		for {
 			select {
 			case <-timeoutChannel:
-				cleanUp(ctx, dataDir)
+				cleanup()
 				log.Error(ctx, "Invocation timeout error", fmt.Errorf("invocation timeout after 14 minutes and 50 seconds"))
 				return
 			case <-quitChannel:
@@ -284,6 +578,28 @@ func setDeadline(ctx context.Context, quitChannel chan int, dataDir string) {
 	}()
 }
 
+// Log how much of the data directory the OCI store takes, which only affects logs if it can't be read
+func logStoreUsage(ctx context.Context, dataDir string) {
+	usage, err := registryutils.StoreStats(path.Join(dataDir, artifactsStoreName))
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to read the OCI store usage: %v", err))
+		return
+	}
+	log.Info(ctx, fmt.Sprintf("OCI store holds %d blobs (%d bytes)", usage.Blobs, usage.Bytes))
+}
+
+// Log how much of the image's layers the SOCI index covers, which helps tuning the minimum layer size.
+// Like logStoreUsage, a failure only affects logs.
+func logIndexCoverage(ctx context.Context, sociStore *store.SociStore, indexDescriptor ocispec.Descriptor) {
+	coverage, err := registryutils.StoreIndexCoverage(ctx, sociStore, indexDescriptor)
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to compute the SOCI index coverage: %v", err))
+		return
+	}
+	log.Info(ctx, fmt.Sprintf("SOCI index covers %d of %d layers (%d of %d bytes, %.1f%%)",
+		coverage.CoveredLayers, coverage.TotalLayers, coverage.CoveredBytes, coverage.TotalBytes, coverage.Ratio()*100))
+}
+
 // Init containerd store
 func initContainerdStore(dataDir string) (content.Store, error) {
 	containerdStore, err := local.NewStore(path.Join(dataDir, artifactsStoreName))
@@ -292,13 +608,7 @@ func initContainerdStore(dataDir string) (content.Store, error) {
 
 // Init SOCI artifact store
 func initSociStore(ctx context.Context, dataDir string) (*store.SociStore, error) {
-	// Note: We are wrapping an *oci.Store in a store.SociStore because soci.WriteSociIndex
-	// expects a store.Store, an interface that extends the oci.Store to provide support
-	// for garbage collection.
-	ociStore, err := oci.NewWithContext(ctx, path.Join(dataDir, artifactsStoreName))
-	return &store.SociStore{
-		ociStore,
-	}, err
+	return registryutils.NewSociStore(ctx, path.Join(dataDir, artifactsStoreName))
 }
 
 // Init a new instance of SOCI artifacts DB
@@ -311,68 +621,128 @@ func initSociArtifactsDb(dataDir string) (*soci.ArtifactsDb, error) {
 	return artifactsDb, nil
 }
 
-// Build soci index for an image and returns its ocispec.Descriptor
-func buildIndex(ctx context.Context, dataDir string, sociStore *store.SociStore, image images.Image, sociIndexVersion string) (*ocispec.Descriptor, error) {
+// Build the SOCI index of a pulled image, overridden in tests
+var buildImageIndex = buildIndex
+
+// Build soci index for an image and returns its ocispec.Descriptor, along with the platforms skipped with ContinueOnPlatformError
+func buildIndex(ctx context.Context, dataDir string, sociStore *store.SociStore, image images.Image, opts ProcessOptions) (*ocispec.Descriptor, []PlatformFailure, error) {
 	log.Info(ctx, "Building SOCI index")
 	platform := platforms.DefaultSpec()
 
-	artifactsDb, err := initSociArtifactsDb(dataDir)
-	if err != nil {
-		return nil, err
-	}
-
 	containerdStore, err := initContainerdStore(dataDir)
 	if err != nil {
-		return nil, err
-	}
-	builderOpts := []soci.BuilderOption{
-		soci.WithBuildToolIdentifier("AWS SOCI Index Builder Cfn v0.2"),
-		soci.WithArtifactsDb(artifactsDb),
+		return nil, nil, err
 	}
-
-	builder, err := soci.NewIndexBuilder(containerdStore, sociStore, builderOpts...)
+	builder, artifactsDb, err := newIndexBuilder(dataDir, containerdStore, sociStore, opts, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Build the SOCI index based on the specified version
-	if sociIndexVersion == "V2" {
+	if opts.SociIndexVersion == "V2" {
+		var selectedPlatforms []ocispec.Platform
+		if len(opts.PlatformAllowlist) > 0 {
+			selectedPlatforms, err = selectPlatforms(ctx, containerdStore, image.Target, opts.PlatformAllowlist)
+			if err != nil {
+				return nil, nil, err
+			}
+			log.Info(ctx, fmt.Sprintf("Indexing platforms %s", formatPlatforms(selectedPlatforms)))
+		}
+		if err := checkAnyIndexableLayers(ctx, containerdStore, image, selectedPlatforms, opts); err != nil {
+			if opts.PushEmptyIndex && errors.Is(err, ErrNoIndexableLayers) {
+				log.Warn(ctx, "Empty SOCI indexes are only pushed for V1, skipping the image")
+			}
+			return nil, nil, err
+		}
+
 		// Use Convert() for V2 index generation
-		convertedOCIIndex, err := builder.Convert(ctx, image)
+		convertedOCIIndex, platformFailures, err := convertPlatforms(ctx, builder, containerdStore, image, selectedPlatforms, opts.ContinueOnPlatformError)
+		if err != nil {
+			return nil, platformFailures, fmt.Errorf("failed to convert OCI index: %w", err)
+		}
+		convertedOCIIndex, err = restoreConvertedManifests(ctx, containerdStore, sociStore, image, *convertedOCIIndex)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert OCI index: %w", err)
+			return nil, platformFailures, fmt.Errorf("failed to restore the image manifests of the OCI index: %w", err)
 		}
 		fmt.Printf("Generated OCI Index Digest: %s\n", convertedOCIIndex.Digest.String())
-		return convertedOCIIndex, nil
+		return convertedOCIIndex, platformFailures, nil
 	} else {
+		if err := checkIndexableLayers(ctx, containerdStore, image, platform, opts); err != nil {
+			if !opts.PushEmptyIndex || !errors.Is(err, ErrNoIndexableLayers) {
+				return nil, nil, err
+			}
+			log.Warn(ctx, fmt.Sprintf("Building an empty SOCI index: %v", err))
+			emptyIndex, err := writeEmptyIndex(ctx, containerdStore, sociStore, image, platform)
+			return emptyIndex, nil, err
+		}
 		// Default to Build() for V1 index generation
 		generatedSOCIIndex, err := builder.Build(ctx, image, soci.WithPlatform(platform))
 		if err != nil {
-			return nil, fmt.Errorf("failed to build SOCI index: %w", err)
+			return nil, nil, fmt.Errorf("failed to build SOCI index: %w", err)
 		}
 		fmt.Printf("Generated SOCI Index Digest: %s\n", generatedSOCIIndex.ImageDesc.Digest.String())
 		// Get SOCI indices for the image from the OCI store
 		indexDescriptorInfos, _, err := soci.GetIndexDescriptorCollection(ctx, containerdStore, artifactsDb, image, []ocispec.Platform{platform})
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if len(indexDescriptorInfos) == 0 {
-			return nil, errors.New("no SOCI indices found in OCI store")
+			return nil, nil, errors.New("no SOCI indices found in OCI store")
 		}
 		sort.Slice(indexDescriptorInfos, func(i, j int) bool {
 			return indexDescriptorInfos[i].CreatedAt.Before(indexDescriptorInfos[j].CreatedAt)
 		})
 
-		return &indexDescriptorInfos[len(indexDescriptorInfos)-1].Descriptor, nil
+		return &indexDescriptorInfos[len(indexDescriptorInfos)-1].Descriptor, nil, nil
+	}
+}
+
+// Create the SOCI index builder of the images of dataDir, hiding the layers excluded by the media type filters
+// and the skipped layers from it
+func newIndexBuilder(dataDir string, containerdStore content.Store, sociStore *store.SociStore, opts ProcessOptions, skip map[digest.Digest]bool) (*soci.IndexBuilder, *soci.ArtifactsDb, error) {
+	artifactsDb, err := initSociArtifactsDb(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	builderOpts := []soci.BuilderOption{
+		soci.WithBuildToolIdentifier(buildToolIdentifier),
+		soci.WithArtifactsDb(artifactsDb),
+		soci.WithMinLayerSize(opts.minLayerSize()),
+	}
+	filteredStore := &filteredContentStore{
+		Store:  containerdStore,
+		filter: layerFilter{allowlist: opts.LayerMediaTypeAllowlist, denylist: opts.LayerMediaTypeDenylist},
+		skip:   skip,
+	}
+	builder, err := soci.NewIndexBuilder(filteredStore, sociStore, builderOpts...)
+	if err != nil {
+		return nil, nil, err
 	}
+	return builder, artifactsDb, nil
 }
 
 // Log and return the lambda handler error
 func lambdaError(ctx context.Context, msg string, err error) (string, error) {
 	log.Error(ctx, msg, err)
+	if report := NewFailureReport(err); report != nil && report.Code != FailureUnknown {
+		log.Warn(ctx, fmt.Sprintf("Failure %s: %s", report.Code, report.Hint))
+	}
 	return msg, err
 }
 
 func main() {
-	lambda.Start(HandleRequest)
+	// one-off backfills run the binary outside of Lambda, which starts it without arguments
+	digestsFile := flag.String("digests-file", "", "newline-delimited file of repository@digest entries to index, instead of serving Lambda invocations")
+	registryURL := flag.String("registry-url", "", "registry of the images of -digests-file, e.g. 123456789012.dkr.ecr.us-west-2.amazonaws.com")
+	flag.Parse()
+	if *digestsFile != "" {
+		os.Exit(runDigestsFile(*digestsFile, *registryURL))
+	}
+
+	// Step Functions state machines get the Result document rather than a message
+	if os.Getenv(ResultFormat) == ResultFormatJSON {
+		lambda.Start(HandleEventResult)
+		return
+	}
+	lambda.Start(HandleEvent)
 }
@@ -5,12 +5,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/events"
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/opencontainers/go-digest"
 )
 
 // This test ensures that the handler can pull Docker and OCI images, build, and push the SOCI index back to the repository.
@@ -124,3 +131,234 @@ func TestHandlerInvalidDigestMediaType(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEventImageDigest(t *testing.T) {
+	doTest := func(imageDigest string, expectValid bool) {
+		event := events.ECRImageActionEvent{
+			Version:    "1",
+			Id:         "id",
+			DetailType: "ECR Image Action",
+			Source:     "aws.ecr",
+			Account:    "123456789012",
+			Time:       "time",
+			Region:     "us-west-2",
+			Detail: events.ECRImageActionEventDetail{
+				ActionType:     "PUSH",
+				Result:         "SUCCESS",
+				RepositoryName: "repo",
+				ImageDigest:    imageDigest,
+			},
+		}
+
+		_, err := validateEvent(context.Background(), event)
+		if expectValid && err != nil {
+			t.Fatalf("Expected digest %s to be valid, got: %v", imageDigest, err)
+		}
+		if !expectValid && err == nil {
+			t.Fatalf("Expected digest %s to be invalid", imageDigest)
+		}
+	}
+
+	doTest(digest.SHA256.FromString("image").String(), true)
+	doTest(digest.SHA512.FromString("image").String(), true)
+	doTest("sha256:1234", false)
+	doTest("md5:"+digest.SHA256.FromString("image").Encoded(), false)
+}
+
+func TestValidateEventRepositoryName(t *testing.T) {
+	doTest := func(repositoryName string, expectValid bool) {
+		event := events.ECRImageActionEvent{
+			Version:    "1",
+			Id:         "id",
+			DetailType: "ECR Image Action",
+			Source:     "aws.ecr",
+			Account:    "123456789012",
+			Time:       "time",
+			Region:     "us-west-2",
+			Detail: events.ECRImageActionEventDetail{
+				ActionType:     "PUSH",
+				Result:         "SUCCESS",
+				RepositoryName: repositoryName,
+				ImageDigest:    digest.FromString("image").String(),
+			},
+		}
+
+		ctx, err := validateEvent(context.Background(), event)
+		if expectValid && (err != nil || ctx.Value(RepositoryNameKey) != repositoryName) {
+			t.Fatalf("Expected repository name %q to be valid, got: %v", repositoryName, err)
+		}
+		if !expectValid && err == nil {
+			t.Fatalf("Expected repository name %q to be invalid", repositoryName)
+		}
+	}
+
+	doTest("repo", true)
+	doTest("org/team/app", true)
+	doTest("org/team__a/app--web", true)
+	doTest("", false)
+	doTest("Org/App", false)
+	doTest("org//app", false)
+	doTest("org/app/", false)
+}
+
+func TestHandleEventS3Validation(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-handle-s3-event"
+	lc.InvokedFunctionArn = "arn:aws:lambda:us-west-2:123456789012:function:soci-index-generator"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	// not an ObjectCreated event: rejected before reaching the registry
+	payload := `{"Records": [{"eventSource": "aws:s3", "eventName": "ObjectRemoved:Delete", "awsRegion": "us-west-2",
+		"s3": {"object": {"key": "app%40sha256%3A8a1ab2f6f0e7f0e3bf6b3b0bd3b7f7c0b4e1c6b7b0d1e6e2a1c9f1d6b1e0f7a3"}}}]}`
+	resp, err := HandleEvent(ctx, json.RawMessage(payload))
+	if err == nil || resp != "S3 event validation error" {
+		t.Fatalf("Expected an S3 event validation error but got %s, %v", resp, err)
+	}
+
+	// an ECR event is still validated as such
+	resp, err = HandleEvent(ctx, json.RawMessage(`{"source": "aws.s3", "detail-type": "ECR Image Action"}`))
+	if err == nil || resp != "ECRImageActionEvent validation error" {
+		t.Fatalf("Expected an ECRImageActionEvent validation error but got %s, %v", resp, err)
+	}
+}
+
+func TestEcrRegistryUrl(t *testing.T) {
+	doTest := func(arn string, region string, expected string) {
+		url := ecrRegistryUrl(accountFromArn(arn), region)
+		if url != expected {
+			t.Fatalf("Expected %s but got %s", expected, url)
+		}
+	}
+
+	doTest("arn:aws:lambda:us-west-2:123456789012:function:name", "us-west-2", "123456789012.dkr.ecr.us-west-2.amazonaws.com")
+	doTest("arn:aws-cn:lambda:cn-north-1:123456789012:function:name", "cn-north-1", "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn")
+}
+
+// fakeRegistryClient rejects every image at validation
+type fakeRegistryClient struct {
+	registryutils.RegistryClient
+}
+
+func (c *fakeRegistryClient) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion registryutils.SociIndexVersion) error {
+	return errors.New("not an image")
+}
+
+func TestCreateTempDir(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-create-temp-dir"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+	root := filepath.Join(t.TempDir(), "work")
+
+	dataDir, cleanup, err := createTempDir(ctx, root)
+	if err != nil {
+		t.Fatalf("Failed to create the temp dir: %v", err)
+	}
+	if filepath.Dir(dataDir) != root || !strings.HasPrefix(filepath.Base(dataDir), lc.AwsRequestID) {
+		t.Fatalf("Expected a directory prefixed by the request id under %s but got %s", root, dataDir)
+	}
+	if _, err := initSociStore(ctx, dataDir); err != nil {
+		t.Fatalf("Failed to create the SOCI store in the temp dir: %v", err)
+	}
+	cleanup()
+	// also called by the deadline monitor
+	cleanup()
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed but got %v", dataDir, err)
+	}
+}
+
+func TestProcessImageWithFakeRegistry(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-fake-registry"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	original := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return &fakeRegistryClient{}, nil
+	}
+	defer func() { newRegistryClient = original }()
+
+	ref := ImageRef{
+		RegistryURL:    "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		RepositoryName: "repo",
+		Digest:         "sha256:" + strings.Repeat("a", 64),
+	}
+	resp, err := processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V1"})
+	if err != nil {
+		t.Fatalf("Expected no error for an invalid image but got %v", err)
+	}
+	if resp != "Exited early due to manifest validation error" {
+		t.Fatalf("Unexpected response: %s", resp)
+	}
+}
+
+type fakeScannedRegistryClient struct {
+	registryutils.RegistryClient
+	validateErr error
+	scanErr     error
+}
+
+func (c *fakeScannedRegistryClient) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion registryutils.SociIndexVersion) error {
+	return c.validateErr
+}
+
+func (c *fakeScannedRegistryClient) CheckImageScan(ctx context.Context, repositoryName string, digest string, severityThreshold string) error {
+	return c.scanErr
+}
+
+func TestProcessImageScanGate(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-scan-gate"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	doTest := func(scanErr error, expectedResp string, expectErr bool) {
+		original := newRegistryClient
+		newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+			return &fakeScannedRegistryClient{scanErr: scanErr}, nil
+		}
+		defer func() { newRegistryClient = original }()
+
+		ref := ImageRef{
+			RegistryURL:    "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+			RepositoryName: "repo",
+			Digest:         "sha256:" + strings.Repeat("a", 64),
+		}
+		// V2 images without a tag are skipped right after the scan gate
+		resp, err := processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V2", ScanSeverityThreshold: "HIGH"})
+		if expectErr != (err != nil) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp != expectedResp {
+			t.Fatalf("Expected response %q but got %q", expectedResp, resp)
+		}
+	}
+
+	doTest(fmt.Errorf("%w: the scan found 1 CRITICAL vulnerabilities", registryutils.ErrScanNotPassed), SkipScanNotPassedMessage, false)
+	doTest(errors.New("access denied"), "Image scan check error", true)
+	doTest(nil, "Skipped SOCI index generation for V2 as image has no tag", false)
+}
+
+func TestProcessImageSubjectIsSociIndex(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-subject-is-soci-index"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	original := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return &fakeScannedRegistryClient{validateErr: registryutils.ErrSubjectIsSociIndex}, nil
+	}
+	defer func() { newRegistryClient = original }()
+
+	ref := ImageRef{
+		RegistryURL:    "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		RepositoryName: "repo",
+		Digest:         "sha256:" + strings.Repeat("a", 64),
+	}
+	resp, err := processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V2"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp != SkipSubjectIsSociIndexMessage {
+		t.Fatalf("Expected response %q but got %q", SkipSubjectIsSociIndexMessage, resp)
+	}
+}
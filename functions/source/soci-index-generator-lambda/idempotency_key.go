@@ -0,0 +1,69 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// The span size of the ztocs, the default of the SOCI index builder which the Lambda doesn't change
+	indexSpanSize int64 = 4 << 20
+	// Length of the idempotency keys, kept apart from the {short_digest} placeholder so that the keys, and the
+	// processed images cache they key, don't change with it
+	idempotencyKeyLength = 12
+)
+
+// The inputs of an index build the idempotency key is derived from, in a stable order
+type idempotencyInputs struct {
+	SubjectDigest           string   `json:"subjectDigest"`
+	SociIndexVersion        string   `json:"sociIndexVersion"`
+	SpanSize                int64    `json:"spanSize"`
+	MinLayerSize            int64    `json:"minLayerSize"`
+	LayerMediaTypeAllowlist []string `json:"layerMediaTypeAllowlist"`
+	LayerMediaTypeDenylist  []string `json:"layerMediaTypeDenylist"`
+	PlatformAllowlist       []string `json:"platformAllowlist"`
+	BuildTool               string   `json:"buildTool"`
+	// Left out for the default, so that the keys of the default stay the same
+	SnapshotterCompatibility string `json:"snapshotterCompatibility,omitempty"`
+}
+
+// Return a short key of the subject digest and the effective indexing config of an image. Runs with the same inputs
+// get the same key, whatever the order of the filters.
+func IdempotencyKey(ref ImageRef, opts ProcessOptions) string {
+	var platformAllowlist []string
+	for _, platform := range opts.PlatformAllowlist {
+		platformAllowlist = append(platformAllowlist, platforms.Format(platform))
+	}
+	inputs := idempotencyInputs{
+		SubjectDigest:           ref.Digest,
+		SociIndexVersion:        opts.SociIndexVersion,
+		SpanSize:                indexSpanSize,
+		MinLayerSize:            opts.minLayerSize(),
+		LayerMediaTypeAllowlist: sortedCopy(opts.LayerMediaTypeAllowlist),
+		LayerMediaTypeDenylist:  sortedCopy(opts.LayerMediaTypeDenylist),
+		PlatformAllowlist:       sortedCopy(platformAllowlist),
+		BuildTool:               buildToolIdentifier,
+	}
+	if opts.SnapshotterCompatibility != nil {
+		inputs.SnapshotterCompatibility = opts.SnapshotterCompatibility.Versions
+	}
+	// marshaling a struct of strings and integers can't fail
+	encoded, _ := json.Marshal(inputs)
+	return digest.FromBytes(encoded).Encoded()[:idempotencyKeyLength]
+}
+
+// Return a sorted copy of values, nil when empty so that nil and empty lists get the same key
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted
+}
@@ -0,0 +1,85 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	ref := ImageRef{RegistryURL: "123456789012.dkr.ecr.us-west-2.amazonaws.com", RepositoryName: "repo", Digest: testImageDigest, Tag: "1.0"}
+	base := ProcessOptions{
+		SociIndexVersion:        "V2",
+		MinLayerSize:            1 << 20,
+		LayerMediaTypeAllowlist: []string{ocispec.MediaTypeImageLayerGzip, ocispec.MediaTypeImageLayerZstd},
+		PlatformAllowlist:       []ocispec.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}},
+	}
+	key := IdempotencyKey(ref, base)
+	if len(key) != idempotencyKeyLength {
+		t.Fatalf("Expected a key of %d characters but got %q", idempotencyKeyLength, key)
+	}
+
+	doTest := func(ref ImageRef, opts ProcessOptions, expectSame bool) {
+		if same := IdempotencyKey(ref, opts) == key; same != expectSame {
+			t.Fatalf("Expected the key of %+v to be the same: %v but got %v", opts, expectSame, same)
+		}
+	}
+
+	// identical inputs get the same key
+	doTest(ref, base, true)
+	// the tag, the repository and the options not affecting the index don't change the key
+	other := ref
+	other.Tag = "latest"
+	other.RepositoryName = "other"
+	doTest(other, base, true)
+	opts := base
+	opts.SkipExistingIndex = true
+	opts.IndexTagTemplate = "{tag}-soci"
+	doTest(ref, opts, true)
+	// neither does the order of the filters and of the platforms
+	opts = base
+	opts.LayerMediaTypeAllowlist = []string{ocispec.MediaTypeImageLayerZstd, ocispec.MediaTypeImageLayerGzip}
+	opts.PlatformAllowlist = []ocispec.Platform{{OS: "linux", Architecture: "arm64"}, {OS: "linux", Architecture: "amd64"}}
+	doTest(ref, opts, true)
+
+	// any change of the subject or of the indexing config changes the key
+	other = ref
+	other.Digest = "sha256:" + strings.Repeat("cd", 32)
+	doTest(other, base, false)
+	opts = base
+	opts.SociIndexVersion = "V1"
+	doTest(ref, opts, false)
+	opts = base
+	opts.MinLayerSize = 2 << 20
+	doTest(ref, opts, false)
+	opts = base
+	opts.LayerMediaTypeAllowlist = []string{ocispec.MediaTypeImageLayerGzip}
+	doTest(ref, opts, false)
+	opts = base
+	opts.LayerMediaTypeDenylist = []string{ocispec.MediaTypeImageLayerZstd}
+	doTest(ref, opts, false)
+	opts = base
+	opts.PlatformAllowlist = nil
+	doTest(ref, opts, false)
+
+	// the key is usable as a tag suffix
+	tag, err := renderIndexTag("{tag}-soci-{idempotency_key}", ref, base)
+	if err != nil {
+		t.Fatalf("Failed to render the index tag: %v", err)
+	}
+	if expected := "1.0-soci-" + key; tag != expected {
+		t.Fatalf("Expected tag %q but got %q", expected, tag)
+	}
+	// and keys the processed images cache
+	cache := NewProcessedCache(10, defaultProcessedCacheTTL)
+	cache.Add(processedKey(ref, base))
+	opts = base
+	opts.MinLayerSize = 2 << 20
+	if !cache.Contains(processedKey(ref, base)) || cache.Contains(processedKey(ref, opts)) {
+		t.Fatalf("Expected the processed images cache to be keyed by the indexing config")
+	}
+}
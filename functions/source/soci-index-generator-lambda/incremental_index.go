@@ -0,0 +1,205 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	orascontent "oras.land/oras-go/v2/content"
+)
+
+const IncrementalIndexing = "incremental_indexing"
+
+// Whether a manifest is a V1 SOCI index, which older builders mark by the media type of the config only
+func isSociIndexV1(manifest ocispec.Manifest) bool {
+	return manifest.ArtifactType == soci.SociIndexArtifactTypeV1 || manifest.Config.MediaType == soci.SociIndexArtifactTypeV1
+}
+
+// Find the V1 SOCI index an image is incrementally indexed from: the index of the image spanning the most layers,
+// e.g. one built with a larger minimum layer size, or else the index at the tag of the index, i.e. the index of the
+// previous image of the tag. Returns nil without such an index.
+func findBaseIndex(ctx context.Context, registry registryutils.RegistryClient, repo string, imageDigest string, tag string) (*ocispec.Descriptor, error) {
+	referrers, err := registry.ListReferrers(ctx, repo, imageDigest, soci.SociIndexArtifactTypeV1)
+	if err != nil {
+		return nil, err
+	}
+	var base *ocispec.Descriptor
+	mostLayers := -1
+	for i, referrer := range referrers {
+		manifest, err := registry.GetManifest(ctx, repo, referrer.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		if len(manifest.Layers) > mostLayers {
+			base, mostLayers = &referrers[i], len(manifest.Layers)
+		}
+	}
+	if base != nil || tag == "" {
+		return base, nil
+	}
+
+	desc, err := registry.HeadManifest(ctx, repo, tag)
+	if errors.Is(err, registryutils.ErrImageNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := registry.GetManifest(ctx, repo, desc.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	if !isSociIndexV1(manifest) {
+		log.Debug(ctx, fmt.Sprintf("Tag %s is not a V1 SOCI index, indexing every layer", tag))
+		return nil, nil
+	}
+	return &desc, nil
+}
+
+// Pull the SOCI index an image is incrementally indexed from, along with its ztocs, but without its subject, which
+// is another image when the index is the previous index of the tag. Returns nil when incremental indexing is off or
+// there is no index to start from; failures are logged and fall back to indexing every layer.
+func pullBaseIndex(ctx context.Context, registry registryutils.RegistryClient, repo string, imageDigest string, tag string, sociStore *store.SociStore, opts ProcessOptions) *ocispec.Manifest {
+	if !opts.IncrementalIndexing || opts.indexVersion() == registryutils.V2 {
+		return nil
+	}
+	baseDesc, err := findBaseIndex(ctx, registry, repo, imageDigest, tag)
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to find the SOCI index to extend, indexing every layer: %v", err))
+		return nil
+	}
+	if baseDesc == nil {
+		log.Info(ctx, "No SOCI index to extend, indexing every layer")
+		return nil
+	}
+
+	pulled, err := registry.Pull(ctx, repo, sociStore, baseDesc.Digest.String(), registryutils.WithCopyOptions(func(copyOpts *oras.CopyOptions) {
+		copyOpts.FindSuccessors = withoutSubject
+	}))
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to pull the SOCI index %s to extend, indexing every layer: %v", baseDesc.Digest, err))
+		return nil
+	}
+	raw, err := orascontent.FetchAll(ctx, sociStore, pulled.Descriptor)
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to read the SOCI index %s to extend, indexing every layer: %v", baseDesc.Digest, err))
+		return nil
+	}
+	var base ocispec.Manifest
+	if err := json.Unmarshal(raw, &base); err != nil || !isSociIndexV1(base) {
+		log.Warn(ctx, fmt.Sprintf("%s is not a V1 SOCI index, indexing every layer", baseDesc.Digest))
+		return nil
+	}
+	log.Info(ctx, fmt.Sprintf("Extending the SOCI index %s spanning %d layers", baseDesc.Digest, len(base.Layers)))
+	return &base
+}
+
+// Find the successors of a manifest but its subject
+func withoutSubject(ctx context.Context, fetcher orascontent.Fetcher, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	successors, err := orascontent.Successors(ctx, fetcher, desc)
+	if err != nil || desc.MediaType != ocispec.MediaTypeImageManifest {
+		return successors, err
+	}
+	raw, err := orascontent.FetchAll(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Subject == nil {
+		return successors, nil
+	}
+	filtered := make([]ocispec.Descriptor, 0, len(successors))
+	for _, successor := range successors {
+		if successor.Digest != manifest.Subject.Digest {
+			filtered = append(filtered, successor)
+		}
+	}
+	return filtered, nil
+}
+
+// Build the V1 SOCI index of an image from a base index, reusing the ztocs of the layers the base already spans and
+// only spanning the others, e.g. the layers an image gained since its previous index. The ztocs of the base index
+// must be in the SOCI store. Layers of the base the image no longer has are dropped, as are those the layer filter
+// and minimum layer size of opts exclude.
+func buildIncrementalIndex(ctx context.Context, dataDir string, sociStore *store.SociStore, image images.Image, base ocispec.Manifest, opts ProcessOptions) (*ocispec.Descriptor, error) {
+	platform := platforms.DefaultSpec()
+	containerdStore, err := initContainerdStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	manifestDesc, err := soci.GetImageManifestDescriptor(ctx, containerdStore, image.Target, platforms.OnlyStrict(platform))
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := readJSON(ctx, containerdStore, *manifestDesc, &manifest); err != nil {
+		return nil, err
+	}
+
+	baseZtocs := make(map[string]ocispec.Descriptor, len(base.Layers))
+	for _, ztoc := range base.Layers {
+		if layer, ok := ztoc.Annotations[soci.IndexAnnotationImageLayerDigest]; ok {
+			baseZtocs[layer] = ztoc
+		}
+	}
+	// the base may have been built with another configuration, e.g. a smaller minimum layer size
+	filter := layerFilter{allowlist: opts.LayerMediaTypeAllowlist, denylist: opts.LayerMediaTypeDenylist}
+	minLayerSize := opts.minLayerSize()
+	covered := map[digest.Digest]bool{}
+	for _, layer := range manifest.Layers {
+		if _, ok := baseZtocs[layer.Digest.String()]; ok && filter.includes(layer.MediaType) && layer.Size >= minLayerSize {
+			covered[layer.Digest] = true
+		}
+	}
+	log.Info(ctx, fmt.Sprintf("Reusing the ztocs of %d of %d layers", len(covered), len(manifest.Layers)))
+
+	// the layers left to span, if any qualify
+	newZtocs := map[string]ocispec.Descriptor{}
+	if len(covered) < len(manifest.Layers) {
+		builder, _, err := newIndexBuilder(dataDir, containerdStore, sociStore, opts, covered)
+		if err != nil {
+			return nil, err
+		}
+		built, err := builder.Build(ctx, image, soci.WithPlatform(platform))
+		if err != nil && !errors.Is(err, ErrEmptyIndex) && !errors.Is(err, soci.ErrEmptyIndex) {
+			return nil, fmt.Errorf("failed to build SOCI index: %w", err)
+		}
+		if built != nil {
+			for _, ztoc := range built.Index.Blobs {
+				newZtocs[ztoc.Annotations[soci.IndexAnnotationImageLayerDigest]] = ztoc
+			}
+		}
+	}
+
+	// in the order of the layers, as the builder does
+	ztocs := []ocispec.Descriptor{}
+	for _, layer := range manifest.Layers {
+		if covered[layer.Digest] {
+			ztocs = append(ztocs, baseZtocs[layer.Digest.String()])
+		} else if ztoc, ok := newZtocs[layer.Digest.String()]; ok {
+			ztocs = append(ztocs, ztoc)
+		}
+	}
+	if len(ztocs) == 0 {
+		return nil, ErrEmptyIndex
+	}
+	log.Info(ctx, fmt.Sprintf("Spanned %d new layers", len(newZtocs)))
+	subject := &ocispec.Descriptor{MediaType: manifestDesc.MediaType, Digest: manifestDesc.Digest, Size: manifestDesc.Size}
+	return writeIndex(ctx, sociStore, ztocs, subject)
+}
@@ -0,0 +1,193 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	orascontent "oras.land/oras-go/v2/content"
+)
+
+func TestBuildIncrementalIndex(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-build-incremental-index"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	dataDir := t.TempDir()
+	contentStore, err := local.NewStore(path.Join(dataDir, artifactsStoreName))
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+	sociStore, err := initSociStore(ctx, dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create SOCI store: %v", err)
+	}
+
+	// the image gained a layer since its previous index, which spans its first layer and a layer it no longer has
+	config, err := json.Marshal(ocispec.Image{Platform: platforms.DefaultSpec(), RootFS: ocispec.RootFS{Type: "layers"}})
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	oldLayer := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayer(t))
+	newLayer := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayerOf(t, bytes.Repeat([]byte("new layer"), 1024)))
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageConfig, config),
+		Layers:    []ocispec.Descriptor{oldLayer, newLayer},
+	}
+	manifest.SchemaVersion = 2
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	target := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageManifest, manifestBytes)
+	image := images.Image{Name: "repo@" + target.Digest.String(), Target: target}
+
+	// ztocs that aren't actual ztocs, which the builder would never produce for these layers
+	baseZtoc := func(data []byte, layer digest.Digest) ocispec.Descriptor {
+		desc := ocispec.Descriptor{
+			MediaType:   soci.SociLayerMediaType,
+			Digest:      digest.FromBytes(data),
+			Size:        int64(len(data)),
+			Annotations: map[string]string{soci.IndexAnnotationImageLayerDigest: layer.String()},
+		}
+		if err := sociStore.Push(ctx, desc, bytes.NewReader(data)); err != nil {
+			t.Fatalf("Failed to store ztoc: %v", err)
+		}
+		return desc
+	}
+	reused := baseZtoc([]byte("reused ztoc"), oldLayer.Digest)
+	dropped := baseZtoc([]byte("dropped ztoc"), digest.FromString("removed layer"))
+	base := ocispec.Manifest{ArtifactType: soci.SociIndexArtifactTypeV1, Layers: []ocispec.Descriptor{dropped, reused}}
+
+	indexDesc, err := buildIncrementalIndex(ctx, dataDir, sociStore, image, base, ProcessOptions{MinLayerSize: 1})
+	if err != nil {
+		t.Fatalf("Failed to build the incremental index: %v", err)
+	}
+	raw, err := orascontent.FetchAll(ctx, sociStore, *indexDesc)
+	if err != nil {
+		t.Fatalf("Failed to read the SOCI index: %v", err)
+	}
+	var index ocispec.Manifest
+	if err := json.Unmarshal(raw, &index); err != nil {
+		t.Fatalf("Failed to decode the SOCI index: %v", err)
+	}
+	if index.Subject == nil || index.Subject.Digest != target.Digest {
+		t.Fatalf("Expected the index to refer to the image but got %s", raw)
+	}
+	if len(index.Layers) != 2 {
+		t.Fatalf("Expected the index to span both layers but got %s", raw)
+	}
+	if index.Layers[0].Digest != reused.Digest {
+		t.Fatalf("Expected the ztoc of the first layer to be reused but got %s", index.Layers[0].Digest)
+	}
+	spanned := index.Layers[1]
+	if spanned.Annotations[soci.IndexAnnotationImageLayerDigest] != newLayer.Digest.String() || spanned.Digest == reused.Digest {
+		t.Fatalf("Expected a new ztoc for the new layer but got %+v", spanned)
+	}
+	if exists, err := sociStore.Exists(ctx, spanned); err != nil || !exists {
+		t.Fatalf("Expected the ztoc of the new layer to be stored but got %v, %v", exists, err)
+	}
+
+	// without a new layer the base is reused as is
+	indexDesc, err = buildIncrementalIndex(ctx, dataDir, sociStore, image, index, ProcessOptions{MinLayerSize: 1})
+	if err != nil {
+		t.Fatalf("Failed to build the incremental index: %v", err)
+	}
+	if indexDesc.Digest.String() != digest.FromBytes(raw).String() {
+		t.Fatalf("Expected the same index when every layer is spanned")
+	}
+
+	// the ztocs of layers the configuration excludes are not reused
+	for _, opts := range []ProcessOptions{
+		{MinLayerSize: max(oldLayer.Size, newLayer.Size) + 1},
+		{MinLayerSize: 1, LayerMediaTypeDenylist: []string{ocispec.MediaTypeImageLayerGzip}},
+	} {
+		if _, err := buildIncrementalIndex(ctx, dataDir, sociStore, image, index, opts); !errors.Is(err, ErrEmptyIndex) {
+			t.Fatalf("Expected ErrEmptyIndex with every layer excluded by %+v but got %v", opts, err)
+		}
+	}
+}
+
+func TestBuildIncrementalIndexBelowMinLayerSize(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-build-incremental-index-below-min-layer-size"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	dataDir := t.TempDir()
+	contentStore, err := local.NewStore(path.Join(dataDir, artifactsStoreName))
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+	sociStore, err := initSociStore(ctx, dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create SOCI store: %v", err)
+	}
+
+	// the base spans the large layer, the layer the image gained is below the minimum layer size
+	config, err := json.Marshal(ocispec.Image{Platform: platforms.DefaultSpec(), RootFS: ocispec.RootFS{Type: "layers"}})
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	incompressible := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(incompressible)
+	largeLayer := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayerOf(t, incompressible))
+	smallLayer := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayer(t))
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageConfig, config),
+		Layers:    []ocispec.Descriptor{largeLayer, smallLayer},
+	}
+	manifest.SchemaVersion = 2
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	target := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageManifest, manifestBytes)
+	image := images.Image{Name: "repo@" + target.Digest.String(), Target: target}
+
+	data := []byte("reused ztoc")
+	reused := ocispec.Descriptor{
+		MediaType:   soci.SociLayerMediaType,
+		Digest:      digest.FromBytes(data),
+		Size:        int64(len(data)),
+		Annotations: map[string]string{soci.IndexAnnotationImageLayerDigest: largeLayer.Digest.String()},
+	}
+	if err := sociStore.Push(ctx, reused, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Failed to store ztoc: %v", err)
+	}
+	base := ocispec.Manifest{ArtifactType: soci.SociIndexArtifactTypeV1, Layers: []ocispec.Descriptor{reused}}
+
+	if smallLayer.Size >= largeLayer.Size {
+		t.Fatalf("Expected the small layer (%d bytes) to be smaller than the large layer (%d bytes)", smallLayer.Size, largeLayer.Size)
+	}
+	indexDesc, err := buildIncrementalIndex(ctx, dataDir, sociStore, image, base, ProcessOptions{MinLayerSize: smallLayer.Size + 1})
+	if err != nil {
+		t.Fatalf("Expected the index of the base alone but got: %v", err)
+	}
+	var index ocispec.Manifest
+	raw, err := orascontent.FetchAll(ctx, sociStore, *indexDesc)
+	if err != nil {
+		t.Fatalf("Failed to read the SOCI index: %v", err)
+	}
+	if err := json.Unmarshal(raw, &index); err != nil {
+		t.Fatalf("Failed to decode the SOCI index: %v", err)
+	}
+	if len(index.Layers) != 1 || index.Layers[0].Digest != reused.Digest {
+		t.Fatalf("Expected the index to only span the large layer with the reused ztoc but got %s", raw)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// Which layers of an image get spanned by the SOCI index, by media type
+type layerFilter struct {
+	// An empty allowlist allows the standard tar, gzip and zstd layer media types
+	allowlist []string
+	denylist  []string
+}
+
+// Whether a layer of the given media type gets indexed
+func (f layerFilter) includes(mediaType string) bool {
+	_, excluded := f.exclusionReason(mediaType)
+	return !excluded
+}
+
+// Why the filter excludes a layer of the given media type, if it does
+func (f layerFilter) exclusionReason(mediaType string) (LayerExclusionReason, bool) {
+	if slices.Contains(f.denylist, mediaType) {
+		return ExcludedByDenylist, true
+	}
+	if len(f.allowlist) == 0 {
+		if !registryutils.IsImageLayerMediaType(mediaType) {
+			return ExcludedNotImageLayer, true
+		}
+		return "", false
+	}
+	if !slices.Contains(f.allowlist, mediaType) {
+		return ExcludedByAllowlist, true
+	}
+	return "", false
+}
+
+// Parse a comma separated list of media types, ignoring empty entries
+func parseMediaTypes(mediaTypes string) []string {
+	var parsed []string
+	for _, mediaType := range strings.Split(mediaTypes, ",") {
+		if mediaType = strings.TrimSpace(mediaType); mediaType != "" {
+			parsed = append(parsed, mediaType)
+		}
+	}
+	return parsed
+}
+
+// A content store serving image manifests without the layers excluded by the filter, so that the SOCI
+// index builder only spans the included layers
+type filteredContentStore struct {
+	content.Store
+	filter layerFilter
+	// Layers hidden regardless of their media type, e.g. those already spanned by a base index
+	skip map[digest.Digest]bool
+}
+
+func (s *filteredContentStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	if !images.IsManifestType(desc.MediaType) {
+		return s.Store.ReaderAt(ctx, desc)
+	}
+	blob, err := content.ReadBlob(ctx, s.Store, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		return nil, err
+	}
+	layers := make([]ocispec.Descriptor, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		if s.filter.includes(layer.MediaType) && !s.skip[layer.Digest] {
+			layers = append(layers, layer)
+		}
+	}
+	if len(layers) != len(manifest.Layers) {
+		manifest.Layers = layers
+		if blob, err = json.Marshal(manifest); err != nil {
+			return nil, err
+		}
+	}
+	return bytesReaderAt{bytes.NewReader(blob)}, nil
+}
+
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (bytesReaderAt) Close() error {
+	return nil
+}
+
+// Restore the layers of the image manifests of an OCI index converted from a filtered content store.
+// Convert annotates and pushes the image manifests it reads, which without restoring would miss the
+// layers excluded from indexing. Returns the descriptor of the restored OCI index.
+func restoreConvertedManifests(ctx context.Context, contentStore content.Store, sociStore *store.SociStore, image images.Image, converted ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	original := []ocispec.Descriptor{image.Target}
+	if images.IsIndexType(image.Target.MediaType) {
+		var index ocispec.Index
+		if err := readJSON(ctx, contentStore, image.Target, &index); err != nil {
+			return nil, err
+		}
+		original = index.Manifests
+	}
+
+	var ociIndex ocispec.Index
+	if err := readJSON(ctx, contentStore, converted, &ociIndex); err != nil {
+		return nil, err
+	}
+	if len(ociIndex.Manifests) < len(original) {
+		return nil, errors.New("converted OCI index is missing image manifests")
+	}
+
+	restored := false
+	for i, desc := range original {
+		if !images.IsManifestType(desc.MediaType) {
+			continue
+		}
+		var manifest ocispec.Manifest
+		if err := readJSON(ctx, contentStore, desc, &manifest); err != nil {
+			return nil, err
+		}
+		// Same changes as Convert, on the unfiltered manifest
+		entry := &ociIndex.Manifests[i]
+		manifest.MediaType = ocispec.MediaTypeImageManifest
+		manifest.Config.MediaType = ocispec.MediaTypeImageConfig
+		manifest.Annotations = entry.Annotations
+		manifestDesc, err := pushJSON(ctx, sociStore, ocispec.MediaTypeImageManifest, manifest)
+		if err != nil {
+			return nil, err
+		}
+		if manifestDesc.Digest == entry.Digest {
+			continue
+		}
+
+		for j := range ociIndex.Manifests {
+			annotations := ociIndex.Manifests[j].Annotations
+			if annotations[soci.IndexAnnotationImageManifestDigest] == entry.Digest.String() {
+				annotations[soci.IndexAnnotationImageManifestDigest] = manifestDesc.Digest.String()
+			}
+		}
+		entry.Digest = manifestDesc.Digest
+		entry.Size = manifestDesc.Size
+		restored = true
+	}
+	if !restored {
+		return &converted, nil
+	}
+	return pushJSON(ctx, sociStore, ocispec.MediaTypeImageIndex, ociIndex)
+}
+
+// Read and decode a JSON blob of a content store
+func readJSON(ctx context.Context, provider content.Provider, desc ocispec.Descriptor, v any) error {
+	blob, err := content.ReadBlob(ctx, provider, desc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(blob, v)
+}
+
+// Encode and push a JSON blob to the SOCI store
+func pushJSON(ctx context.Context, sociStore *store.SociStore, mediaType string, v any) (*ocispec.Descriptor, error) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	if err := sociStore.Push(ctx, desc, bytes.NewReader(blob)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return nil, err
+	}
+	return &desc, nil
+}
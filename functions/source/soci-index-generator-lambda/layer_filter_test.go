@@ -0,0 +1,170 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const inTotoMediaType = "application/vnd.in-toto+json"
+
+// Write a blob to a content store and return its descriptor
+func writeTestBlob(t *testing.T, ctx context.Context, contentStore content.Store, mediaType string, blob []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	if err := content.WriteBlob(ctx, contentStore, desc.Digest.String(), bytes.NewReader(blob), desc); err != nil {
+		t.Fatalf("Failed to write blob: %v", err)
+	}
+	return desc
+}
+
+// A gzip compressed tar layer containing a single file
+func gzipLayer(t *testing.T) []byte {
+	return gzipLayerOf(t, bytes.Repeat([]byte("soci"), 1024))
+}
+
+// A gzip compressed tar layer containing a single file of the given data
+func gzipLayerOf(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(data))}); err != nil {
+		t.Fatalf("Failed to write tar header: %v", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatalf("Failed to write tar content: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestLayerFilter(t *testing.T) {
+	doTest := func(filter layerFilter, mediaType string, expected bool) {
+		if filter.includes(mediaType) != expected {
+			t.Fatalf("Expected includes(%s) to be %v with %+v", mediaType, expected, filter)
+		}
+	}
+
+	doTest(layerFilter{}, registryutils.MediaTypeOCIImageLayerGzip, true)
+	doTest(layerFilter{}, registryutils.MediaTypeDockerImageLayerZstd, true)
+	doTest(layerFilter{}, registryutils.MediaTypeDockerForeignLayer, false)
+	doTest(layerFilter{}, inTotoMediaType, false)
+	doTest(layerFilter{denylist: []string{registryutils.MediaTypeOCIImageLayerZstd}}, registryutils.MediaTypeOCIImageLayerZstd, false)
+	doTest(layerFilter{allowlist: []string{registryutils.MediaTypeOCIImageLayerGzip}}, registryutils.MediaTypeOCIImageLayerGzip, true)
+	doTest(layerFilter{allowlist: []string{registryutils.MediaTypeOCIImageLayerGzip}}, registryutils.MediaTypeOCIImageLayer, false)
+
+	parsed := parseMediaTypes(" application/vnd.oci.image.layer.v1.tar+gzip,, application/vnd.oci.image.layer.v1.tar ")
+	if len(parsed) != 2 || parsed[0] != registryutils.MediaTypeOCIImageLayerGzip || parsed[1] != registryutils.MediaTypeOCIImageLayer {
+		t.Fatalf("Unexpected parsed media types: %v", parsed)
+	}
+}
+
+func TestBuildIndexExcludesArtifactLayers(t *testing.T) {
+	ctx := context.Background()
+	storeDir := t.TempDir()
+	contentStore, err := local.NewStore(storeDir)
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+	sociStore, err := registryutils.NewSociStore(ctx, storeDir)
+	if err != nil {
+		t.Fatalf("Failed to create SOCI store: %v", err)
+	}
+	artifactsDb, err := soci.NewDB(path.Join(t.TempDir(), artifactsDbName))
+	if err != nil {
+		t.Fatalf("Failed to create artifacts db: %v", err)
+	}
+
+	platform := platforms.DefaultSpec()
+	config, err := json.Marshal(ocispec.Image{Platform: platform, RootFS: ocispec.RootFS{Type: "layers"}})
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageConfig, config),
+		Layers: []ocispec.Descriptor{
+			writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayer(t)),
+			writeTestBlob(t, ctx, contentStore, inTotoMediaType, []byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`)),
+		},
+	}
+	manifest.SchemaVersion = 2
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	image := images.Image{Target: writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageManifest, manifestBytes)}
+
+	filteredStore := &filteredContentStore{Store: contentStore}
+	filtered, err := images.Manifest(ctx, filteredStore, image.Target, nil)
+	if err != nil {
+		t.Fatalf("Failed to read the filtered manifest: %v", err)
+	}
+	if len(filtered.Layers) != 1 || filtered.Layers[0].MediaType != ocispec.MediaTypeImageLayerGzip {
+		t.Fatalf("Expected only the gzip layer in the filtered manifest but got %v", filtered.Layers)
+	}
+
+	builder, err := soci.NewIndexBuilder(filteredStore, sociStore, soci.WithArtifactsDb(artifactsDb), soci.WithMinLayerSize(0))
+	if err != nil {
+		t.Fatalf("Failed to create index builder: %v", err)
+	}
+
+	// V1
+	built, err := builder.Build(ctx, image, soci.WithPlatform(platform))
+	if err != nil {
+		t.Fatalf("Expected the artifact layer to be excluded from the build but got %v", err)
+	}
+	if len(built.Index.Blobs) != 1 || built.Index.Subject.Digest != image.Target.Digest {
+		t.Fatalf("Expected a single ztoc for the image but got %+v", built.Index)
+	}
+
+	// V2
+	converted, err := builder.Convert(ctx, image)
+	if err != nil {
+		t.Fatalf("Expected the artifact layer to be excluded from the conversion but got %v", err)
+	}
+	converted, err = restoreConvertedManifests(ctx, contentStore, sociStore, image, *converted)
+	if err != nil {
+		t.Fatalf("Failed to restore the converted manifests: %v", err)
+	}
+	var ociIndex ocispec.Index
+	if err := readJSON(ctx, contentStore, *converted, &ociIndex); err != nil {
+		t.Fatalf("Failed to read the converted index: %v", err)
+	}
+	if len(ociIndex.Manifests) != 2 {
+		t.Fatalf("Expected the image and its SOCI index in the converted index but got %v", ociIndex.Manifests)
+	}
+	var restored ocispec.Manifest
+	if err := readJSON(ctx, contentStore, ociIndex.Manifests[0], &restored); err != nil {
+		t.Fatalf("Failed to read the converted image manifest: %v", err)
+	}
+	if len(restored.Layers) != 2 || restored.Layers[1].MediaType != inTotoMediaType {
+		t.Fatalf("Expected the converted image to keep the artifact layer but got %v", restored.Layers)
+	}
+	if restored.Annotations[soci.ImageAnnotationSociIndexDigest] != ociIndex.Manifests[1].Digest.String() {
+		t.Fatalf("Expected the converted image to reference its SOCI index but got %v", restored.Annotations)
+	}
+	if ociIndex.Manifests[1].Annotations[soci.IndexAnnotationImageManifestDigest] != ociIndex.Manifests[0].Digest.String() {
+		t.Fatalf("Expected the SOCI index to reference the converted image but got %v", ociIndex.Manifests[1].Annotations)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	MinLayerSize = "min_layer_size"
+
+	// The minimum layer size of the SOCI index builder, used without MinLayerSize
+	defaultMinLayerSize int64 = 10 << 20
+)
+
+// Why a layer of an image gets no ztoc in its SOCI index
+type LayerExclusionReason string
+
+const (
+	ExcludedByDenylist        LayerExclusionReason = "media type is in the denylist"
+	ExcludedByAllowlist       LayerExclusionReason = "media type is not in the allowlist"
+	ExcludedNotImageLayer     LayerExclusionReason = "media type is not an image layer media type"
+	ExcludedBelowMinLayerSize LayerExclusionReason = "layer is smaller than the minimum layer size"
+)
+
+// A layer the SOCI index won't span, and why
+type ExcludedLayer struct {
+	Layer  ocispec.Descriptor
+	Reason LayerExclusionReason
+}
+
+// The layers of an image manifest a SOCI index would span with the given options
+type IndexPlan struct {
+	Selected []ocispec.Descriptor
+	Excluded []ExcludedLayer
+}
+
+// Plan which layers of an image manifest the SOCI index will span given the media type filters and the minimum
+// layer size of the options, and why the others are excluded, without pulling or indexing anything. Helps tuning
+// the options to the images of a repository.
+func PlanIndex(ctx context.Context, repositoryName string, manifest ocispec.Manifest, opts ProcessOptions) IndexPlan {
+	filter := layerFilter{allowlist: opts.LayerMediaTypeAllowlist, denylist: opts.LayerMediaTypeDenylist}
+	minLayerSize := opts.minLayerSize()
+	var plan IndexPlan
+	for _, layer := range manifest.Layers {
+		reason, excluded := filter.exclusionReason(layer.MediaType)
+		if !excluded && layer.Size < minLayerSize {
+			reason, excluded = ExcludedBelowMinLayerSize, true
+		}
+		if excluded {
+			plan.Excluded = append(plan.Excluded, ExcludedLayer{Layer: layer, Reason: reason})
+		} else {
+			plan.Selected = append(plan.Selected, layer)
+		}
+	}
+	log.Info(ctx, fmt.Sprintf("SOCI index of %s will span %d of %d layers", repositoryName, len(plan.Selected), len(manifest.Layers)))
+	for _, excluded := range plan.Excluded {
+		log.Debug(ctx, fmt.Sprintf("Layer %s (%s, %d bytes) excluded: %s", excluded.Layer.Digest, excluded.Layer.MediaType, excluded.Layer.Size, excluded.Reason))
+	}
+	return plan
+}
+
+// The minimum size of the layers spanned by the SOCI index
+func (opts ProcessOptions) minLayerSize() int64 {
+	if opts.MinLayerSize <= 0 {
+		return defaultMinLayerSize
+	}
+	return opts.MinLayerSize
+}
+
+// Parse the minimum layer size of the environment, 0 for the default when unset or invalid
+func minLayerSizeFromEnv(ctx context.Context) int64 {
+	value := os.Getenv(MinLayerSize)
+	if value == "" {
+		return 0
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		log.Warn(ctx, fmt.Sprintf("Ignoring invalid %s %q, using the default of %d bytes", MinLayerSize, value, defaultMinLayerSize))
+		return 0
+	}
+	return size
+}
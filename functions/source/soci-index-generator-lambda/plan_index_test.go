@@ -0,0 +1,90 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPlanIndex(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-plan-index"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	layer := func(name string, mediaType string, size int64) ocispec.Descriptor {
+		return ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromString(name), Size: size}
+	}
+	large := layer("large", ocispec.MediaTypeImageLayerGzip, 20<<20)
+	small := layer("small", ocispec.MediaTypeImageLayerGzip, 1<<20)
+	zstd := layer("zstd", ocispec.MediaTypeImageLayerZstd, 20<<20)
+	wasm := layer("wasm", "application/wasm", 20<<20)
+	manifest := ocispec.Manifest{Layers: []ocispec.Descriptor{large, small, zstd, wasm}}
+
+	doTest := func(opts ProcessOptions, expectedSelected []ocispec.Descriptor, expectedExcluded map[digest.Digest]LayerExclusionReason) {
+		t.Helper()
+		plan := PlanIndex(ctx, "repo", manifest, opts)
+		if len(plan.Selected) != len(expectedSelected) {
+			t.Fatalf("Expected %d selected layers but got %v", len(expectedSelected), plan.Selected)
+		}
+		for i, selected := range plan.Selected {
+			if selected.Digest != expectedSelected[i].Digest {
+				t.Fatalf("Expected layer %s to be selected but got %s", expectedSelected[i].Digest, selected.Digest)
+			}
+		}
+		if len(plan.Excluded) != len(expectedExcluded) {
+			t.Fatalf("Expected %d excluded layers but got %v", len(expectedExcluded), plan.Excluded)
+		}
+		for _, excluded := range plan.Excluded {
+			if reason := expectedExcluded[excluded.Layer.Digest]; excluded.Reason != reason {
+				t.Fatalf("Expected layer %s to be excluded because %q but got %q", excluded.Layer.Digest, reason, excluded.Reason)
+			}
+		}
+	}
+
+	// the builder's defaults
+	doTest(ProcessOptions{}, []ocispec.Descriptor{large, zstd}, map[digest.Digest]LayerExclusionReason{
+		small.Digest: ExcludedBelowMinLayerSize,
+		wasm.Digest:  ExcludedNotImageLayer,
+	})
+	doTest(ProcessOptions{MinLayerSize: 1}, []ocispec.Descriptor{large, small, zstd}, map[digest.Digest]LayerExclusionReason{
+		wasm.Digest: ExcludedNotImageLayer,
+	})
+	doTest(ProcessOptions{LayerMediaTypeDenylist: []string{ocispec.MediaTypeImageLayerZstd}}, []ocispec.Descriptor{large}, map[digest.Digest]LayerExclusionReason{
+		small.Digest: ExcludedBelowMinLayerSize,
+		zstd.Digest:  ExcludedByDenylist,
+		wasm.Digest:  ExcludedNotImageLayer,
+	})
+	// the media type filter takes precedence over the size
+	doTest(ProcessOptions{LayerMediaTypeAllowlist: []string{"application/wasm"}}, []ocispec.Descriptor{wasm}, map[digest.Digest]LayerExclusionReason{
+		large.Digest: ExcludedByAllowlist,
+		small.Digest: ExcludedByAllowlist,
+		zstd.Digest:  ExcludedByAllowlist,
+	})
+}
+
+func TestMinLayerSizeFromEnv(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-min-layer-size-from-env"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	doTest := func(value string, expected int64) {
+		t.Setenv(MinLayerSize, value)
+		if size := minLayerSizeFromEnv(ctx); size != expected {
+			t.Fatalf("Expected %s %q to parse to %d but got %d", MinLayerSize, value, expected, size)
+		}
+	}
+
+	doTest("", 0)
+	doTest("1048576", 1<<20)
+	doTest("-1", 0)
+	doTest("ten", 0)
+	if size := (ProcessOptions{}).minLayerSize(); size != defaultMinLayerSize {
+		t.Fatalf("Expected the default minimum layer size but got %d", size)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ErrNoMatchingPlatforms is returned when none of an image's platforms is in the platform allowlist
+var ErrNoMatchingPlatforms = errors.New("no platform of the image matches the platform allowlist")
+
+// Select the platforms of an image to index, keeping only those matching an entry of the allowlist.
+// An entry without an exact match in the image selects the nearest compatible platform instead, the way containerd
+// does, e.g. linux/arm/v6 for linux/arm/v7. An empty allowlist selects every platform of the image.
+func selectPlatforms(ctx context.Context, contentStore content.Store, target ocispec.Descriptor, allowlist []ocispec.Platform) ([]ocispec.Platform, error) {
+	available, err := images.Platforms(ctx, contentStore, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowlist) == 0 {
+		return available, nil
+	}
+
+	matched := make([]bool, len(available))
+	for _, allowed := range allowlist {
+		exact := false
+		for i, platform := range available {
+			if platforms.NewMatcher(allowed).Match(platform) {
+				matched[i], exact = true, true
+			}
+		}
+		if exact {
+			continue
+		}
+		nearest := -1
+		compatible := platforms.Only(allowed)
+		for i, platform := range available {
+			if compatible.Match(platform) && (nearest < 0 || compatible.Less(platform, available[nearest])) {
+				nearest = i
+			}
+		}
+		if nearest >= 0 {
+			log.Info(ctx, fmt.Sprintf("Platform %s is not in the image, indexing the compatible platform %s", platforms.Format(allowed), platforms.Format(available[nearest])))
+			matched[nearest] = true
+		}
+	}
+	var selected []ocispec.Platform
+	for i, platform := range available {
+		if matched[i] {
+			selected = append(selected, platform)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: available platforms are %s", ErrNoMatchingPlatforms, formatPlatforms(available))
+	}
+	return selected, nil
+}
+
+// A platform of an image whose SOCI index failed to build
+type PlatformFailure struct {
+	Platform ocispec.Platform
+	Err      error
+}
+
+// Convert an image into a SOCI enabled image for the given platforms, or every platform of the image if none is given.
+// With continueOnError, a conversion failing because of some platforms is retried without them: the platforms whose
+// SOCI index fails to build on its own are left out and reported as failures.
+func convertPlatforms(ctx context.Context, builder *soci.IndexBuilder, contentStore content.Store, image images.Image, selected []ocispec.Platform, continueOnError bool) (*ocispec.Descriptor, []PlatformFailure, error) {
+	var convertOpts []soci.ConvertOption
+	if len(selected) > 0 {
+		convertOpts = append(convertOpts, soci.ConvertWithPlatforms(selected...))
+	}
+	converted, convertErr := builder.Convert(ctx, image, convertOpts...)
+	if convertErr == nil || !continueOnError || errors.Is(convertErr, soci.ErrEmptyIndex) {
+		return converted, nil, convertErr
+	}
+
+	if len(selected) == 0 {
+		var err error
+		if selected, err = images.Platforms(ctx, contentStore, image.Target); err != nil {
+			return nil, nil, convertErr
+		}
+	}
+	var succeeded []ocispec.Platform
+	var failures []PlatformFailure
+	for _, platform := range selected {
+		_, err := builder.Build(ctx, image, soci.WithPlatform(platform), soci.WithNoGarbageCollectionLabel())
+		if err != nil && !errors.Is(err, soci.ErrEmptyIndex) {
+			failures = append(failures, PlatformFailure{Platform: platform, Err: err})
+			continue
+		}
+		succeeded = append(succeeded, platform)
+	}
+	if len(failures) == 0 {
+		// no platform fails on its own, the conversion itself failed
+		return nil, nil, convertErr
+	}
+	if len(succeeded) == 0 {
+		return nil, failures, fmt.Errorf("every platform failed: %w", convertErr)
+	}
+
+	converted, err := builder.Convert(ctx, image, soci.ConvertWithPlatforms(succeeded...))
+	return converted, failures, err
+}
+
+// Parse a comma separated list of platforms, e.g. "linux/amd64,linux/arm64"
+func parsePlatforms(specifiers string) ([]ocispec.Platform, error) {
+	var parsed []ocispec.Platform
+	for _, specifier := range strings.Split(specifiers, ",") {
+		specifier = strings.TrimSpace(specifier)
+		if specifier == "" {
+			continue
+		}
+		platform, err := platforms.Parse(specifier)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, platform)
+	}
+	return parsed, nil
+}
+
+func formatPlatforms(list []ocispec.Platform) string {
+	formatted := make([]string, len(list))
+	for i, platform := range list {
+		formatted[i] = platforms.Format(platform)
+	}
+	return strings.Join(formatted, ", ")
+}
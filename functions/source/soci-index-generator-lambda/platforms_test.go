@@ -0,0 +1,174 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSelectPlatforms(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-select-platforms"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+	contentStore, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex}
+	index.SchemaVersion = 2
+	for i, platform := range []string{"linux/amd64", "linux/arm64/v8", "linux/386", "windows/amd64"} {
+		p := platforms.MustParse(platform)
+		index.Manifests = append(index.Manifests, ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString(platform),
+			Size:      int64(i + 1),
+			Platform:  &p,
+		})
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	target := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromBytes(indexBytes), Size: int64(len(indexBytes))}
+	if err := content.WriteBlob(ctx, contentStore, target.Digest.String(), bytes.NewReader(indexBytes), target); err != nil {
+		t.Fatalf("Failed to write index: %v", err)
+	}
+
+	doTest := func(allowlist string, expected []string) {
+		parsed, err := parsePlatforms(allowlist)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", allowlist, err)
+		}
+		selected, err := selectPlatforms(ctx, contentStore, target, parsed)
+		if err != nil {
+			t.Fatalf("Unexpected error for allowlist %q: %v", allowlist, err)
+		}
+		if formatPlatforms(selected) != strings.Join(expected, ", ") {
+			t.Fatalf("Expected %v for allowlist %q but got %s", expected, allowlist, formatPlatforms(selected))
+		}
+	}
+
+	doTest("", []string{"linux/amd64", "linux/arm64/v8", "linux/386", "windows/amd64"})
+	doTest("linux/amd64,linux/arm64", []string{"linux/amd64", "linux/arm64/v8"})
+	doTest(" linux/arm64 ", []string{"linux/arm64/v8"})
+	// without an exact match, the nearest compatible platform is indexed rather than every compatible one
+	doTest("linux/amd64/v3", []string{"linux/amd64"})
+	doTest("linux/amd64/v3,linux/386", []string{"linux/amd64", "linux/386"})
+
+	parsed, err := parsePlatforms("linux/s390x")
+	if err != nil {
+		t.Fatalf("Failed to parse allowlist: %v", err)
+	}
+	_, err = selectPlatforms(ctx, contentStore, target, parsed)
+	if !errors.Is(err, ErrNoMatchingPlatforms) {
+		t.Fatalf("Expected ErrNoMatchingPlatforms but got %v", err)
+	}
+	if !strings.Contains(err.Error(), "windows/amd64") {
+		t.Fatalf("Expected the error to list the available platforms, got: %v", err)
+	}
+}
+
+func TestConvertPlatformsContinueOnError(t *testing.T) {
+	ctx := context.Background()
+	storeDir := t.TempDir()
+	contentStore, err := local.NewStore(storeDir)
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+	sociStore, err := registryutils.NewSociStore(ctx, storeDir)
+	if err != nil {
+		t.Fatalf("Failed to create SOCI store: %v", err)
+	}
+	artifactsDb, err := soci.NewDB(path.Join(t.TempDir(), artifactsDbName))
+	if err != nil {
+		t.Fatalf("Failed to create artifacts db: %v", err)
+	}
+
+	good := platforms.MustParse("linux/amd64")
+	bad := platforms.MustParse("linux/arm64")
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex}
+	index.SchemaVersion = 2
+	for _, platform := range []ocispec.Platform{good, bad} {
+		config, err := json.Marshal(ocispec.Image{Platform: platform, RootFS: ocispec.RootFS{Type: "layers"}})
+		if err != nil {
+			t.Fatalf("Failed to marshal config: %v", err)
+		}
+		// the layer of the bad platform is missing from the store
+		layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("missing"), Size: 1024}
+		if platform.Architecture == good.Architecture {
+			layerDesc = writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageLayerGzip, gzipLayer(t))
+		}
+		manifest := ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageConfig, config),
+			Layers:    []ocispec.Descriptor{layerDesc},
+		}
+		manifest.SchemaVersion = 2
+		manifestBytes, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatalf("Failed to marshal manifest: %v", err)
+		}
+		manifestDesc := writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageManifest, manifestBytes)
+		manifestDesc.Platform = &platform
+		index.Manifests = append(index.Manifests, manifestDesc)
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	image := images.Image{Target: writeTestBlob(t, ctx, contentStore, ocispec.MediaTypeImageIndex, indexBytes)}
+
+	builder, err := soci.NewIndexBuilder(contentStore, sociStore, soci.WithArtifactsDb(artifactsDb), soci.WithMinLayerSize(0))
+	if err != nil {
+		t.Fatalf("Failed to create index builder: %v", err)
+	}
+
+	if _, _, err := convertPlatforms(ctx, builder, contentStore, image, nil, false); err == nil {
+		t.Fatalf("Expected the bad platform to fail the conversion")
+	}
+
+	converted, failures, err := convertPlatforms(ctx, builder, contentStore, image, nil, true)
+	if err != nil {
+		t.Fatalf("Expected the good platform to be converted but got %v", err)
+	}
+	if len(failures) != 1 || platforms.Format(failures[0].Platform) != platforms.Format(bad) || failures[0].Err == nil {
+		t.Fatalf("Expected a failure of %s but got %v", platforms.Format(bad), failures)
+	}
+	var ociIndex ocispec.Index
+	if err := readJSON(ctx, contentStore, *converted, &ociIndex); err != nil {
+		t.Fatalf("Failed to read the converted index: %v", err)
+	}
+	var indexedPlatforms []ocispec.Platform
+	for _, desc := range ociIndex.Manifests {
+		if desc.ArtifactType == soci.SociIndexArtifactTypeV2 {
+			indexedPlatforms = append(indexedPlatforms, *desc.Platform)
+		}
+	}
+	if formatPlatforms(indexedPlatforms) != platforms.Format(good) {
+		t.Fatalf("Expected only %s to be indexed but got %s", platforms.Format(good), formatPlatforms(indexedPlatforms))
+	}
+
+	// a platform selected explicitly is the only one probed
+	_, failures, err = convertPlatforms(ctx, builder, contentStore, image, []ocispec.Platform{bad}, true)
+	if err == nil || len(failures) != 1 {
+		t.Fatalf("Expected the only selected platform to fail but got %v, %v", failures, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+)
+
+const (
+	ProcessedCacheSize = "processed_cache_size"
+	ProcessedCacheTTL  = "processed_cache_ttl"
+
+	defaultProcessedCacheTTL = 10 * time.Minute
+)
+
+// An LRU cache of the images whose SOCI index was recently built and pushed, so that duplicate deliveries of
+// the same event are skipped. Entries expire after the TTL. A nil cache caches nothing.
+// The cache is safe for concurrent use.
+type ProcessedCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	// Current time, overridden in tests
+	now func() time.Time
+}
+
+type processedEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// Create a cache holding at most size entries for ttl each. A non positive size returns a nil cache.
+func NewProcessedCache(size int, ttl time.Duration) *ProcessedCache {
+	if size <= 0 {
+		return nil
+	}
+	return &ProcessedCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+// Check if the key was added less than the TTL ago, dropping it if it expired
+func (cache *ProcessedCache) Contains(key string) bool {
+	if cache == nil {
+		return false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return false
+	}
+	if !cache.now().Before(element.Value.(*processedEntry).expiresAt) {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+		return false
+	}
+	cache.order.MoveToFront(element)
+	return true
+}
+
+// Add a key, or refresh its TTL, evicting the least recently used key when the cache is full
+func (cache *ProcessedCache) Add(key string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	expiresAt := cache.now().Add(cache.ttl)
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*processedEntry).expiresAt = expiresAt
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&processedEntry{key: key, expiresAt: expiresAt})
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*processedEntry).key)
+	}
+}
+
+// The key of an image in the cache. The same image is indexed again for another SOCI index version or another
+// indexing config, as the IdempotencyKey of the image changes with it.
+func processedKey(ref ImageRef, opts ProcessOptions) string {
+	return ref.String() + " " + IdempotencyKey(ref, opts)
+}
+
+var (
+	processedCache     *ProcessedCache
+	processedCacheOnce sync.Once
+)
+
+// Return the cache configured by the Lambda's environment variables, created once so that it's kept
+// across warm invocations. The cache is disabled unless processed_cache_size is set.
+func processedCacheFromEnv(ctx context.Context) *ProcessedCache {
+	processedCacheOnce.Do(func() {
+		sizeValue := os.Getenv(ProcessedCacheSize)
+		if sizeValue == "" {
+			return
+		}
+		size, err := strconv.Atoi(sizeValue)
+		if err != nil {
+			log.Warn(ctx, fmt.Sprintf("Ignoring invalid %s %q, the processed images cache is disabled: %v", ProcessedCacheSize, sizeValue, err))
+			return
+		}
+		ttl := defaultProcessedCacheTTL
+		if ttlValue := os.Getenv(ProcessedCacheTTL); ttlValue != "" {
+			ttl, err = time.ParseDuration(ttlValue)
+			if err != nil {
+				log.Warn(ctx, fmt.Sprintf("Ignoring invalid %s %q, the processed images cache is disabled: %v", ProcessedCacheTTL, ttlValue, err))
+				return
+			}
+		}
+		processedCache = NewProcessedCache(size, ttl)
+	})
+	return processedCache
+}
@@ -0,0 +1,97 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestProcessedCache(t *testing.T) {
+	now := time.Now()
+	cache := NewProcessedCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	if cache.Contains("a") {
+		t.Fatalf("Expected a miss on an empty cache")
+	}
+	cache.Add("a")
+	cache.Add("b")
+	if !cache.Contains("a") || !cache.Contains("b") {
+		t.Fatalf("Expected hits on added keys")
+	}
+
+	// a was used after b, so b is the least recently used key
+	cache.Contains("a")
+	cache.Add("c")
+	if cache.Contains("b") {
+		t.Fatalf("Expected the least recently used key to be evicted")
+	}
+	if !cache.Contains("a") || !cache.Contains("c") {
+		t.Fatalf("Expected hits on the most recently used keys")
+	}
+
+	// adding a again refreshes its TTL
+	now = now.Add(30 * time.Second)
+	cache.Add("a")
+	now = now.Add(30 * time.Second)
+	if cache.Contains("c") {
+		t.Fatalf("Expected a miss on an expired key")
+	}
+	if !cache.Contains("a") {
+		t.Fatalf("Expected a hit on a refreshed key")
+	}
+	now = now.Add(30 * time.Second)
+	if cache.Contains("a") {
+		t.Fatalf("Expected a miss once the refreshed key expired")
+	}
+
+	disabled := NewProcessedCache(0, time.Minute)
+	disabled.Add("a")
+	if disabled.Contains("a") {
+		t.Fatalf("Expected a disabled cache to cache nothing")
+	}
+}
+
+func TestProcessImageSkipsRecentlyProcessed(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-skips-recently-processed"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	original := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		t.Fatalf("Expected a recently processed image not to reach the registry")
+		return nil, nil
+	}
+	defer func() { newRegistryClient = original }()
+
+	ref := ImageRef{
+		RegistryURL:    "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		RepositoryName: "repo",
+		Digest:         "sha256:" + strings.Repeat("a", 64),
+	}
+	cache := NewProcessedCache(10, time.Minute)
+	cache.Add(processedKey(ref, ProcessOptions{SociIndexVersion: "V1"}))
+	resp, err := processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V1", ProcessedCache: cache})
+	if err != nil {
+		t.Fatalf("Expected no error for a recently processed image but got %v", err)
+	}
+	if resp != SkipAlreadyProcessedMessage {
+		t.Fatalf("Unexpected response: %s", resp)
+	}
+
+	// the image is indexed again for another SOCI index version
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return &fakeRegistryClient{}, nil
+	}
+	resp, _ = processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V2", ProcessedCache: cache})
+	if resp == SkipAlreadyProcessedMessage {
+		t.Fatalf("Expected the image to be processed for another SOCI index version")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+)
+
+const ReplicationWaitTimeout = "replication_wait_timeout"
+
+// Return the timeout of the wait for the replication of images set by replication_wait_timeout, e.g. "5m",
+// zero to not wait when it's unset or invalid
+func replicationWaitTimeoutFromEnv(ctx context.Context) time.Duration {
+	value := os.Getenv(ReplicationWaitTimeout)
+	if value == "" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Warn(ctx, fmt.Sprintf("Ignoring invalid %s %q, images are indexed without waiting for their replication", ReplicationWaitTimeout, value))
+		return 0
+	}
+	return timeout
+}
@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// A registry client whose image is replicated after a number of polls, rejecting the image once it is
+type fakeReplicatingRegistryClient struct {
+	registryutils.RegistryClient
+	// Number of polls before the image is replicated
+	replicatedAfter int
+	polls           int
+	timeout         time.Duration
+	validated       bool
+}
+
+func (c *fakeReplicatingRegistryClient) WaitForReplication(ctx context.Context, repositoryName string, digest string, timeout time.Duration) error {
+	c.timeout = timeout
+	for c.polls = 1; c.polls <= c.replicatedAfter; c.polls++ {
+		if time.Duration(c.polls)*time.Second >= timeout {
+			return fmt.Errorf("%w after %s", registryutils.ErrReplicationTimeout, timeout)
+		}
+	}
+	return nil
+}
+
+func (c *fakeReplicatingRegistryClient) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion registryutils.SociIndexVersion) error {
+	c.validated = true
+	return errors.New("invalid manifest")
+}
+
+func TestProcessImageWaitsForReplication(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-waits-for-replication"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	var client *fakeReplicatingRegistryClient
+	original := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return client, nil
+	}
+	defer func() { newRegistryClient = original }()
+
+	ref := ImageRef{RegistryURL: "123456789012.dkr.ecr.eu-west-1.amazonaws.com", RepositoryName: "repo", Digest: testImageDigest}
+	doTest := func(timeout time.Duration, replicatedAfter int, expectTimeout bool) {
+		client = &fakeReplicatingRegistryClient{replicatedAfter: replicatedAfter}
+		resp, err := processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V1", ReplicationWaitTimeout: timeout})
+		if expectTimeout {
+			if !errors.Is(err, registryutils.ErrReplicationTimeout) {
+				t.Fatalf("Expected %v but got %q, %v", registryutils.ErrReplicationTimeout, resp, err)
+			}
+			if client.validated {
+				t.Fatalf("Expected the image not to be pulled after the replication timed out")
+			}
+			return
+		}
+		// the pipeline carries on with the replicated image
+		if err != nil || resp != SkipInvalidManifestMessage || !client.validated {
+			t.Fatalf("Expected the pipeline to carry on after the replication but got %q, %v", resp, err)
+		}
+		if client.timeout != timeout {
+			t.Fatalf("Expected a wait of %s but got %s", timeout, client.timeout)
+		}
+	}
+
+	// replicated after 3 polls of a second
+	doTest(time.Minute, 3, false)
+	doTest(2*time.Second, 3, true)
+	// no wait without a timeout
+	doTest(0, 1<<30, false)
+}
+
+func TestReplicationWaitTimeoutFromEnv(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "abcd-1234-test-replication-wait-timeout-from-env"})
+	doTest := func(value string, expected time.Duration) {
+		t.Setenv(ReplicationWaitTimeout, value)
+		if actual := replicationWaitTimeoutFromEnv(ctx); actual != expected {
+			t.Fatalf("Expected a timeout of %s for %q but got %s", expected, value, actual)
+		}
+	}
+	doTest("", 0)
+	doTest("5m", 5*time.Minute)
+	doTest("soon", 0)
+	doTest("-1m", 0)
+}
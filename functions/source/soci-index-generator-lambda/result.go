@@ -0,0 +1,170 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/events"
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+)
+
+// Version of the schema of Result, bumped on any change that isn't the addition of an optional field
+const ResultSchemaVersion = "1"
+
+// Value of the result_format environment variable making the Lambda respond with the Result document
+const ResultFormatJSON = "json"
+
+// Statuses of a Result
+const (
+	ResultSucceeded = "SUCCEEDED"
+	// Pushed the SOCI indexes of some platforms of the image, skipping those that failed
+	ResultPartial = "PARTIAL"
+	// Exited without pushing a SOCI index, see SkipReason
+	ResultSkipped = "SKIPPED"
+	ResultFailed  = "FAILED"
+)
+
+// Stable codes of the reasons to skip an image, keyed by the message processImage returns
+var skipReasons = map[string]string{
+	SkipAlreadyProcessedMessage:    "ALREADY_PROCESSED",
+	SkipSubjectIsSociIndexMessage:  "SUBJECT_IS_SOCI_INDEX",
+	SkipInvalidManifestMessage:     "INVALID_MANIFEST",
+	SkipScanNotPassedMessage:       "SCAN_NOT_PASSED",
+	SkipNoTagMessage:               "NO_TAG",
+	SkipPushOnEmptyIndexMessage:    "EMPTY_INDEX",
+	SkipNoIndexableLayersMessage:   "NO_INDEXABLE_LAYERS",
+	SkipNoMatchingPlatformsMessage: "NO_MATCHING_PLATFORMS",
+	SkipExistingIndexMessage:       "ALREADY_INDEXED",
+}
+
+// The machine-readable outcome of indexing an image, e.g. the response of the Lambda to a Step Functions state
+// machine. Fields are only ever added to a schema version; empty optional fields are omitted.
+type Result struct {
+	SchemaVersion string `json:"schemaVersion"`
+	// One of the Result* statuses
+	Status string `json:"status"`
+	// The message processImage returned, for humans
+	Message          string `json:"message"`
+	Repository       string `json:"repository"`
+	SubjectDigest    string `json:"subjectDigest"`
+	SociIndexVersion string `json:"sociIndexVersion"`
+	// Digest of the pushed SOCI index, or of the image index for V2 SOCI indexes
+	IndexDigest string `json:"indexDigest,omitempty"`
+	// Tags applied to the pushed index
+	Tags        []string        `json:"tags"`
+	BytesPulled int64           `json:"bytesPulled"`
+	BytesPushed int64           `json:"bytesPushed"`
+	Durations   ResultDurations `json:"durations"`
+	// One of the codes of skipReasons when skipped
+	SkipReason string `json:"skipReason,omitempty"`
+	// The cause of the failure when failed
+	Failure *FailureReport `json:"failure,omitempty"`
+}
+
+// Durations of the steps of indexing an image in milliseconds, zero for the steps that didn't run
+type ResultDurations struct {
+	ResolveMs   int64 `json:"resolveMs"`
+	PullMs      int64 `json:"pullMs"`
+	ReconcileMs int64 `json:"reconcileMs"`
+	PushMs      int64 `json:"pushMs"`
+	TagMs       int64 `json:"tagMs"`
+	// From the start of processImage to its end, building the index included
+	TotalMs int64 `json:"totalMs"`
+}
+
+func newResult(ref ImageRef, opts ProcessOptions) *Result {
+	return &Result{
+		SchemaVersion:    ResultSchemaVersion,
+		Repository:       ref.RepositoryName,
+		SubjectDigest:    ref.Digest,
+		SociIndexVersion: string(opts.indexVersion()),
+		Tags:             []string{},
+	}
+}
+
+func (result *Result) recordPull(pulled *registryutils.PullResult) {
+	result.BytesPulled = pulled.BytesCopied
+	result.Durations.ResolveMs = pulled.ResolveDuration.Milliseconds()
+	result.Durations.PullMs = pulled.CopyDuration.Milliseconds()
+}
+
+func (result *Result) recordPush(pushed *registryutils.PushResult) {
+	result.IndexDigest = pushed.Descriptor.Digest.String()
+	result.Tags = append([]string{}, pushed.AppliedTags...)
+	result.BytesPushed = pushed.BytesUploaded
+	result.Durations.ReconcileMs = pushed.ReconcileDuration.Milliseconds()
+	result.Durations.PushMs = pushed.CopyDuration.Milliseconds()
+	result.Durations.TagMs = pushed.TagDuration.Milliseconds()
+}
+
+// Set the status of the result from the outcome of processImage
+func (result *Result) finish(message string, err error, elapsed time.Duration) {
+	result.Message = message
+	result.Durations.TotalMs = elapsed.Milliseconds()
+	result.Status, result.SkipReason = resultStatus(message, err)
+	result.Failure = NewFailureReport(err)
+}
+
+// Return the status of the outcome of processImage, along with the code of its skip reason when skipped
+func resultStatus(message string, err error) (string, string) {
+	switch {
+	case err != nil:
+		return ResultFailed, ""
+	case skipReasons[message] != "":
+		return ResultSkipped, skipReasons[message]
+	case message == PartialBuildAndPushMessage:
+		return ResultPartial, ""
+	default:
+		return ResultSucceeded, ""
+	}
+}
+
+// Serialize the result as the JSON document of its schema version
+func (result *Result) JSON() ([]byte, error) {
+	return json.Marshal(result)
+}
+
+// Pull an image, build its SOCI index and push the index like processImage, returning the Result of the image.
+// The result of a failure is returned along with the error.
+func processImageResult(ctx context.Context, ref ImageRef, opts ProcessOptions) (*Result, error) {
+	start := time.Now()
+	result := newResult(ref, opts)
+	message, err := runImage(ctx, ref, opts, result)
+	result.finish(message, err, time.Since(start))
+	return result, err
+}
+
+// Lambda entry point responding with the Result document, e.g. for a Step Functions task, selected by setting the
+// result_format environment variable to "json". Failures are returned as Lambda errors so that the Retry and Catch
+// of the state machine apply; their Result is logged.
+func HandleEventResult(ctx context.Context, event events.ECRImageActionEvent) (*Result, error) {
+	ctx, err := validateEvent(ctx, event)
+	if err != nil {
+		_, err = lambdaError(ctx, "ECRImageActionEvent validation error", err)
+		return nil, err
+	}
+	opts, err := processOptionsFromEnv(ctx)
+	if err != nil {
+		_, err = lambdaError(ctx, "Platform allowlist parsing error", err)
+		return nil, err
+	}
+
+	result, err := processImageResult(ctx, ImageRef{
+		RegistryURL:    buildEcrRegistryUrl(event),
+		RepositoryName: event.Detail.RepositoryName,
+		Digest:         event.Detail.ImageDigest,
+		Tag:            event.Detail.ImageTag,
+	}, opts)
+	if err != nil {
+		if document, jsonErr := result.JSON(); jsonErr == nil {
+			log.Warn(ctx, "Result: "+string(document))
+		}
+		return nil, err
+	}
+	return result, nil
+}
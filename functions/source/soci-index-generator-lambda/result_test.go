@@ -0,0 +1,169 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The fields of a Result document that are always present
+var requiredResultFields = []string{"schemaVersion", "status", "message", "repository", "subjectDigest", "sociIndexVersion", "tags", "bytesPulled", "bytesPushed", "durations"}
+
+func TestResultJSON(t *testing.T) {
+	ref := ImageRef{RepositoryName: "repo", Digest: testImageDigest}
+	doTest := func(result *Result, expectedStatus string, expectedOptional ...string) map[string]interface{} {
+		content, err := result.JSON()
+		if err != nil {
+			t.Fatalf("Failed to serialize result: %v", err)
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal(content, &document); err != nil {
+			t.Fatalf("Failed to parse result: %v", err)
+		}
+		expectedFields := append(append([]string{}, requiredResultFields...), expectedOptional...)
+		var actualFields []string
+		for field := range document {
+			actualFields = append(actualFields, field)
+		}
+		sort.Strings(expectedFields)
+		sort.Strings(actualFields)
+		if strings.Join(actualFields, ",") != strings.Join(expectedFields, ",") {
+			t.Fatalf("Expected fields %v but got %v in %s", expectedFields, actualFields, content)
+		}
+		if document["schemaVersion"] != ResultSchemaVersion || document["status"] != expectedStatus {
+			t.Fatalf("Expected a %s result of schema %s but got %s", expectedStatus, ResultSchemaVersion, content)
+		}
+		durations, ok := document["durations"].(map[string]interface{})
+		if !ok || len(durations) != 6 {
+			t.Fatalf("Expected the 6 durations but got %s", content)
+		}
+		return document
+	}
+
+	succeeded := newResult(ref, ProcessOptions{SociIndexVersion: "V2"})
+	succeeded.recordPull(&registryutils.PullResult{BytesCopied: 2048, ResolveDuration: 1500 * time.Millisecond, CopyDuration: 3 * time.Second})
+	succeeded.recordPush(&registryutils.PushResult{
+		Descriptor:        ocispec.Descriptor{Digest: digest.FromString("index")},
+		BytesUploaded:     512,
+		ReconcileDuration: 10 * time.Millisecond,
+		CopyDuration:      time.Second,
+		TagDuration:       20 * time.Millisecond,
+		AppliedTags:       []string{"1.0-soci"},
+	})
+	succeeded.finish(BuildAndPushSuccessMessage, nil, 5*time.Second)
+	document := doTest(succeeded, ResultSucceeded, "indexDigest")
+	durations := document["durations"].(map[string]interface{})
+	if document["indexDigest"] != digest.FromString("index").String() || document["bytesPulled"] != 2048.0 || document["bytesPushed"] != 512.0 ||
+		document["sociIndexVersion"] != "V2" || durations["resolveMs"] != 1500.0 || durations["totalMs"] != 5000.0 || durations["tagMs"] != 20.0 {
+		t.Fatalf("Unexpected values in %+v", document)
+	}
+	if tags := document["tags"].([]interface{}); len(tags) != 1 || tags[0] != "1.0-soci" {
+		t.Fatalf("Expected the applied tags but got %v", tags)
+	}
+
+	partial := newResult(ref, ProcessOptions{})
+	partial.finish(PartialBuildAndPushMessage, nil, time.Second)
+	doTest(partial, ResultPartial)
+
+	skipped := newResult(ref, ProcessOptions{})
+	skipped.finish(SkipNoIndexableLayersMessage, nil, time.Second)
+	if document := doTest(skipped, ResultSkipped, "skipReason"); document["skipReason"] != "NO_INDEXABLE_LAYERS" {
+		t.Fatalf("Expected skip reason NO_INDEXABLE_LAYERS but got %v", document["skipReason"])
+	}
+	// no tag is an empty list rather than null
+	if document := doTest(skipped, ResultSkipped, "skipReason"); document["tags"] == nil {
+		t.Fatalf("Expected an empty list of tags")
+	}
+
+	failed := newResult(ref, ProcessOptions{})
+	failed.finish(PushFailedMessage, registryutils.ErrImageNotFound, time.Second)
+	document = doTest(failed, ResultFailed, "failure")
+	if failure := document["failure"].(map[string]interface{}); failure["code"] != FailureImageNotFound {
+		t.Fatalf("Expected failure %s but got %v", FailureImageNotFound, failure)
+	}
+}
+
+func TestSkipReasonsAreDistinct(t *testing.T) {
+	seen := map[string]string{}
+	for message, reason := range skipReasons {
+		if other, ok := seen[reason]; ok {
+			t.Fatalf("Expected distinct skip reasons but %q and %q are both %s", message, other, reason)
+		}
+		seen[reason] = message
+	}
+}
+
+type fakeResultRegistryClient struct {
+	registryutils.RegistryClient
+	pushErr error
+}
+
+func (c *fakeResultRegistryClient) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion registryutils.SociIndexVersion) error {
+	return nil
+}
+
+func (c *fakeResultRegistryClient) Pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string, opts ...registryutils.PullOption) (*registryutils.PullResult, error) {
+	return &registryutils.PullResult{Descriptor: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.Digest(imageReference)}, BytesCopied: 4096}, nil
+}
+
+func (c *fakeResultRegistryClient) Push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string, opts ...registryutils.PushOption) (*registryutils.PushResult, error) {
+	if c.pushErr != nil {
+		return nil, c.pushErr
+	}
+	return &registryutils.PushResult{Descriptor: indexDesc, BytesUploaded: 1024, AppliedTags: []string{tag}}, nil
+}
+
+func TestProcessImageResult(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-result"
+	ctx, cancel := context.WithTimeout(lambdacontext.NewContext(context.Background(), &lc), time.Minute)
+	defer cancel()
+
+	client := &fakeResultRegistryClient{}
+	originalClient := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return client, nil
+	}
+	defer func() { newRegistryClient = originalClient }()
+	originalBuild := buildImageIndex
+	buildImageIndex = func(ctx context.Context, dataDir string, sociStore *store.SociStore, image images.Image, opts ProcessOptions) (*ocispec.Descriptor, []PlatformFailure, error) {
+		return &ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromString("index")}, nil, nil
+	}
+	defer func() { buildImageIndex = originalBuild }()
+
+	ref := ImageRef{RegistryURL: "123456789012.dkr.ecr.us-west-2.amazonaws.com", RepositoryName: "repo", Digest: testImageDigest, Tag: "1.0"}
+	result, err := processImageResult(ctx, ref, ProcessOptions{SociIndexVersion: "V2"})
+	if err != nil {
+		t.Fatalf("processImageResult failed: %v", err)
+	}
+	if result.Status != ResultSucceeded || result.Message != BuildAndPushSuccessMessage || result.IndexDigest != digest.FromString("index").String() ||
+		result.SubjectDigest != testImageDigest || result.BytesPulled != 4096 || result.BytesPushed != 1024 ||
+		len(result.Tags) != 1 || result.Tags[0] != "1.0-soci" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	result, err = processImageResult(ctx, ImageRef{RegistryURL: ref.RegistryURL, RepositoryName: "repo", Digest: testImageDigest}, ProcessOptions{SociIndexVersion: "V2"})
+	if err != nil || result.Status != ResultSkipped || result.SkipReason != "NO_TAG" {
+		t.Fatalf("Expected the untagged V2 image to be skipped but got %+v, %v", result, err)
+	}
+
+	client.pushErr = errors.New("push failed")
+	result, err = processImageResult(ctx, ref, ProcessOptions{SociIndexVersion: "V2"})
+	if err == nil || result.Status != ResultFailed || result.Failure == nil || result.Failure.Code != FailureUnknown || result.IndexDigest != "" {
+		t.Fatalf("Expected a failed result but got %+v, %v", result, err)
+	}
+}
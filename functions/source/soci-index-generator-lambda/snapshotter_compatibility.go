@@ -0,0 +1,30 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+)
+
+const SnapshotterCompatibility = "snapshotter_compatibility"
+
+// Return the snapshotter compatibility set by snapshotter_compatibility, e.g. "pre-v0.8", nil to push indexes as
+// built when it's unset or invalid
+func snapshotterCompatibilityFromEnv(ctx context.Context) *registryutils.SnapshotterCompatibility {
+	value := os.Getenv(SnapshotterCompatibility)
+	if value == "" {
+		return nil
+	}
+	compatibility, err := registryutils.ParseSnapshotterCompatibility(value)
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Ignoring invalid %s, targeting the current snapshotter release: %v", SnapshotterCompatibility, err))
+		return nil
+	}
+	return &compatibility
+}
@@ -0,0 +1,36 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestSnapshotterCompatibilityFromEnv(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "abcd-1234-test-snapshotter-compatibility-from-env"})
+	doTest := func(value string, expected *registryutils.SnapshotterCompatibility) {
+		t.Setenv(SnapshotterCompatibility, value)
+		actual := snapshotterCompatibilityFromEnv(ctx)
+		if (actual == nil) != (expected == nil) || (actual != nil && actual.Versions != expected.Versions) {
+			t.Fatalf("Expected %+v for %q but got %+v", expected, value, actual)
+		}
+	}
+	doTest("", nil)
+	doTest("pre-v0.8", &registryutils.SnapshotterCompatibilityPreV2)
+	doTest("current", &registryutils.SnapshotterCompatibilityCurrent)
+	doTest("v0.1", nil)
+
+	// the index built for other snapshotters is another build of the image
+	ref := ImageRef{RepositoryName: "repo", Digest: testImageDigest}
+	opts := ProcessOptions{SociIndexVersion: "V1"}
+	preV2 := opts
+	preV2.SnapshotterCompatibility = &registryutils.SnapshotterCompatibilityPreV2
+	if IdempotencyKey(ref, opts) == IdempotencyKey(ref, preV2) {
+		t.Fatalf("Expected the snapshotter compatibility to change the idempotency key")
+	}
+}
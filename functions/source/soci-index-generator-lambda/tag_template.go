@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	IndexTagTemplate = "index_tag_template"
+
+	// The tag of V2 SOCI indexes without a template, the source tag with a suffix
+	defaultIndexTagTemplate = "{tag}-soci"
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Render the tag of the SOCI index of an image from a template. The image is pulled by its digest, which pins its
+// content, while the tag of the index is derived from the source tag, which labels it. Placeholders:
+//   - {tag}: the source tag of the image
+//   - {digest}: the encoded portion of the image digest, without the algorithm
+//   - {short_digest}: the ShortDigest of the image digest, as in logs
+//   - {version}: the SOCI index version in lower case, e.g. "v2"
+//   - {idempotency_key}: the IdempotencyKey of the image and the options, a tag suffix that changes with the
+//     indexing config
+//
+// An empty tag is returned when the template uses {tag} and the image has no source tag.
+func renderIndexTag(template string, ref ImageRef, opts ProcessOptions) (string, error) {
+	shortDigest, err := registryutils.ShortDigest(ref.Digest)
+	if err != nil {
+		return "", err
+	}
+	values := map[string]string{
+		"{tag}":             ref.Tag,
+		"{digest}":          digest.Digest(ref.Digest).Encoded(),
+		"{short_digest}":    shortDigest,
+		"{version}":         strings.ToLower(opts.SociIndexVersion),
+		"{idempotency_key}": IdempotencyKey(ref, opts),
+	}
+	var unknown []string
+	tag := placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok {
+			unknown = append(unknown, placeholder)
+		}
+		return value
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholders %s in the index tag template %q", strings.Join(unknown, ", "), template)
+	}
+	if ref.Tag == "" && strings.Contains(template, "{tag}") {
+		return "", nil
+	}
+	normalized, isDigest, err := registryutils.NormalizeReference(tag)
+	if err != nil || isDigest || normalized != tag {
+		return "", fmt.Errorf("the index tag template %q renders the invalid tag %q", template, tag)
+	}
+	return tag, nil
+}
+
+// Return the tag of the SOCI index of an image, empty to push the index by digest only. V2 indexes are tagged
+// with the template, or the source tag suffixed with -soci without one, while V1 indexes are only tagged when a
+// template is set.
+func indexTag(ref ImageRef, opts ProcessOptions) (string, error) {
+	template := opts.IndexTagTemplate
+	if template == "" {
+		if opts.SociIndexVersion != "V2" {
+			return "", nil
+		}
+		template = defaultIndexTagTemplate
+	}
+	return renderIndexTag(template, ref, opts)
+}
@@ -0,0 +1,129 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	registryutils "github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var testImageDigest = "sha256:" + strings.Repeat("ab", 32)
+
+func TestRenderIndexTag(t *testing.T) {
+	doTest := func(template string, sourceTag string, expected string, expectErr bool) {
+		ref := ImageRef{RepositoryName: "repo", Digest: testImageDigest, Tag: sourceTag}
+		tag, err := renderIndexTag(template, ref, ProcessOptions{SociIndexVersion: "V2"})
+		if expectErr != (err != nil) {
+			t.Fatalf("Unexpected error rendering %q: %v", template, err)
+		}
+		if tag != expected {
+			t.Fatalf("Expected %q to render %q but got %q", template, expected, tag)
+		}
+	}
+
+	doTest("{tag}-soci", "1.0", "1.0-soci", false)
+	doTest("{tag}-soci-{version}", "latest", "latest-soci-v2", false)
+	doTest("soci-{short_digest}", "", "soci-abababababab", false)
+	doTest("{tag}-{digest}", "v1", "v1-"+strings.Repeat("ab", 32), false)
+	// no source tag to derive the index tag from
+	doTest("{tag}-soci", "", "", false)
+	doTest("{tag}-{unknown}", "1.0", "", true)
+	doTest("{tag}:soci", "1.0", "", true)
+	doTest("{tag}-soci-"+strings.Repeat("x", 128), "1.0", "", true)
+	// digests of other algorithms are shortened as in logs
+	sha512Ref := ImageRef{RepositoryName: "repo", Digest: "sha512:" + strings.Repeat("cd", 64)}
+	if tag, err := renderIndexTag("soci-{short_digest}", sha512Ref, ProcessOptions{SociIndexVersion: "V2"}); err != nil || tag != "soci-cdcdcdcdcdcd" {
+		t.Fatalf("Expected the short sha512 digest to render soci-cdcdcdcdcdcd but got %q, %v", tag, err)
+	}
+
+	doTestIndexTag := func(opts ProcessOptions, sourceTag string, expected string) {
+		tag, err := indexTag(ImageRef{Digest: testImageDigest, Tag: sourceTag}, opts)
+		if err != nil {
+			t.Fatalf("indexTag failed: %v", err)
+		}
+		if tag != expected {
+			t.Fatalf("Expected index tag %q with %+v but got %q", expected, opts, tag)
+		}
+	}
+	doTestIndexTag(ProcessOptions{SociIndexVersion: "V2"}, "1.0", "1.0-soci")
+	doTestIndexTag(ProcessOptions{SociIndexVersion: "V1"}, "1.0", "")
+	doTestIndexTag(ProcessOptions{SociIndexVersion: "V1", IndexTagTemplate: "{tag}-soci-{version}"}, "1.0", "1.0-soci-v1")
+}
+
+// A registry client recording the references images are pulled by and the tags indexes are pushed with
+type fakeTaggingRegistryClient struct {
+	registryutils.RegistryClient
+	pulled []string
+	pushed []string
+}
+
+func (c *fakeTaggingRegistryClient) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion registryutils.SociIndexVersion) error {
+	return nil
+}
+
+func (c *fakeTaggingRegistryClient) Pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string, opts ...registryutils.PullOption) (*registryutils.PullResult, error) {
+	c.pulled = append(c.pulled, imageReference)
+	return &registryutils.PullResult{Descriptor: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.Digest(imageReference)}}, nil
+}
+
+func (c *fakeTaggingRegistryClient) Push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string, opts ...registryutils.PushOption) (*registryutils.PushResult, error) {
+	c.pushed = append(c.pushed, tag)
+	return &registryutils.PushResult{Descriptor: indexDesc}, nil
+}
+
+func TestProcessImagePullsByDigestAndTagsBySourceTag(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-process-image-digest-pull-tag-push"
+	ctx, cancel := context.WithTimeout(lambdacontext.NewContext(context.Background(), &lc), time.Minute)
+	defer cancel()
+
+	client := &fakeTaggingRegistryClient{}
+	originalClient := newRegistryClient
+	newRegistryClient = func(ctx context.Context, registryUrl string) (registryutils.RegistryClient, error) {
+		return client, nil
+	}
+	defer func() { newRegistryClient = originalClient }()
+	var built []images.Image
+	originalBuild := buildImageIndex
+	buildImageIndex = func(ctx context.Context, dataDir string, sociStore *store.SociStore, image images.Image, opts ProcessOptions) (*ocispec.Descriptor, []PlatformFailure, error) {
+		built = append(built, image)
+		return &ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromString("index")}, nil, nil
+	}
+	defer func() { buildImageIndex = originalBuild }()
+
+	ref := ImageRef{
+		RegistryURL:    "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		RepositoryName: "repo",
+		Digest:         testImageDigest,
+		Tag:            "1.0",
+	}
+	publisher := &fakePublisher{}
+	resp, err := processImage(ctx, ref, ProcessOptions{SociIndexVersion: "V2", IndexTagTemplate: "{tag}-soci-{short_digest}", CompletionPublisher: publisher})
+	if err != nil || resp != BuildAndPushSuccessMessage {
+		t.Fatalf("Expected success but got %q, %v", resp, err)
+	}
+	if len(client.pulled) != 1 || client.pulled[0] != testImageDigest {
+		t.Fatalf("Expected the image to be pulled by digest but got %v", client.pulled)
+	}
+	if len(built) != 1 || built[0].Target.Digest.String() != testImageDigest {
+		t.Fatalf("Expected the pulled image to be indexed but got %v", built)
+	}
+	expectedTag := "1.0-soci-" + strings.Repeat("ab", registryutils.ShortDigestLength/2)
+	if len(client.pushed) != 1 || client.pushed[0] != expectedTag {
+		t.Fatalf("Expected the index to be tagged %q but got %v", expectedTag, client.pushed)
+	}
+	// both the pin and the label are reported
+	if len(publisher.events) != 1 || publisher.events[0].SubjectDigest != testImageDigest || publisher.events[0].Tag != expectedTag {
+		t.Fatalf("Expected a completion event for %s tagged %s but got %+v", testImageDigest, expectedTag, publisher.events)
+	}
+}
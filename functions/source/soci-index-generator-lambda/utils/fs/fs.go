@@ -1,10 +1,18 @@
 // Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
-// Package fs contains utilities for checking free space in a directory
+// Package fs contains utilities for checking free space in a directory and managing working directories
 package fs
 
-import "golang.org/x/sys/unix"
+import (
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// The root of working directories when none is configured, i.e. the Lambda's ephemeral storage
+const DefaultWorkDirRoot = "/tmp"
 
 // Calculate free splace in bytes of a directory
 func CalculateFreeSpace(path string) uint64 {
@@ -16,3 +24,42 @@ func CalculateFreeSpace(path string) uint64 {
 	// Available blocks * size per block = available space in bytes
 	return stat.Bavail * uint64(stat.Bsize)
 }
+
+// Create a uniquely named working directory under root, or DefaultWorkDirRoot when root is empty, whose name starts
+// with prefix. The returned cleanup removes the directory along with everything in it. It can be called several times
+// and concurrently, e.g. deferred, which also runs on panic, and from a timeout handler; only the first call removes.
+func CreateWorkDir(root string, prefix string) (string, func() error, error) {
+	if root == "" {
+		root = DefaultWorkDirRoot
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp(root, prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	var once sync.Once
+	var removeErr error
+	cleanup := func() error {
+		once.Do(func() {
+			removeErr = os.RemoveAll(dir)
+		})
+		return removeErr
+	}
+	return dir, cleanup, nil
+}
+
+// Run fn in a working directory created by CreateWorkDir, which is removed once fn returns or panics
+func WithWorkDir(root string, prefix string, fn func(dir string) error) (err error) {
+	dir, cleanup, err := CreateWorkDir(root, prefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cleanupErr := cleanup(); err == nil {
+			err = cleanupErr
+		}
+	}()
+	return fn(dir)
+}
@@ -3,10 +3,94 @@
 
 package fs
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestGetFreeSpace(t *testing.T) {
 	if CalculateFreeSpace("/tmp") <= 0 {
 		t.Fatalf("Expected free space of /tmp to be greater than 0")
 	}
 }
+
+func TestCreateWorkDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "work")
+	dir, cleanup, err := CreateWorkDir(root, "request-id")
+	if err != nil {
+		t.Fatalf("Failed to create working directory: %v", err)
+	}
+	if filepath.Dir(dir) != root || !strings.HasPrefix(filepath.Base(dir), "request-id") {
+		t.Fatalf("Expected a directory prefixed by request-id under %s but got %s", root, dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "blob"), []byte("data"), 0o644); err != nil {
+		t.Fatalf("Failed to write to working directory: %v", err)
+	}
+	other, otherCleanup, err := CreateWorkDir(root, "request-id")
+	if err != nil {
+		t.Fatalf("Failed to create working directory: %v", err)
+	}
+	defer otherCleanup()
+	if other == dir {
+		t.Fatalf("Expected uniquely named working directories but got %s twice", dir)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := cleanup(); err != nil {
+			t.Fatalf("Cleanup failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed but got %v", dir, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("Expected %s to be left alone but got %v", other, err)
+	}
+
+	// the default root
+	dir, cleanup, err = CreateWorkDir("", "fs-test")
+	if err != nil {
+		t.Fatalf("Failed to create working directory: %v", err)
+	}
+	defer cleanup()
+	if filepath.Dir(dir) != DefaultWorkDirRoot {
+		t.Fatalf("Expected a directory under %s but got %s", DefaultWorkDirRoot, dir)
+	}
+}
+
+func TestWithWorkDirPanic(t *testing.T) {
+	root := t.TempDir()
+	var workDir string
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Expected the panic to propagate")
+			}
+		}()
+		WithWorkDir(root, "panic", func(dir string) error {
+			workDir = dir
+			panic("indexing failed")
+		})
+	}()
+	if workDir == "" {
+		t.Fatalf("Expected fn to be called")
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed on panic but got %v", workDir, err)
+	}
+
+	expected := errors.New("indexing failed")
+	err := WithWorkDir(root, "error", func(dir string) error {
+		workDir = dir
+		return expected
+	})
+	if !errors.Is(err, expected) {
+		t.Fatalf("Expected the error of fn but got %v", err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed but got %v", workDir, err)
+	}
+}
@@ -6,32 +6,81 @@ package log
 
 import (
 	"context"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 )
 
+// The logger every function logs to. Its level is read once from the LOG_LEVEL environment variable,
+// one of debug, info, warn or error, and defaults to info.
+var logger = newLogger(os.Stderr, os.Getenv("LOG_LEVEL"))
+
+// Create a logger writing JSON lines to w, dropping the events below the named level
+func newLogger(w io.Writer, levelName string) zerolog.Logger {
+	return zerolog.New(w).With().Timestamp().Logger().Level(parseLevel(levelName))
+}
+
+// Redirect the logs to w at the named level, e.g. for tests capturing the logs of an operation.
+// Returns a function restoring the previous logger.
+func Redirect(w io.Writer, levelName string) func() {
+	original := logger
+	logger = newLogger(w, levelName)
+	return func() { logger = original }
+}
+
+// Whether debug events are emitted, so that callers can skip preparing debug details otherwise
+func DebugEnabled() bool {
+	return logger.GetLevel() <= zerolog.DebugLevel
+}
+
+// Parse a level name, case insensitively. Unknown and empty names are the info level.
+func parseLevel(levelName string) zerolog.Level {
+	switch strings.ToLower(strings.TrimSpace(levelName)) {
+	case "debug":
+		return zerolog.DebugLevel
+	case "warn", "warning":
+		return zerolog.WarnLevel
+	case "error":
+		return zerolog.ErrorLevel
+	default:
+		return zerolog.InfoLevel
+	}
+}
+
 func Error(ctx context.Context, msg string, err error) {
-	logEvent := log.Error().Err(err)
+	logEvent := logger.Error().Err(err)
 	addContext(ctx, logEvent)
 	logEvent.Msg(msg)
 }
 
 func Warn(ctx context.Context, msg string) {
-	logEvent := log.Warn()
+	logEvent := logger.Warn()
 	addContext(ctx, logEvent)
 	logEvent.Msg(msg)
 }
 
 func Info(ctx context.Context, msg string) {
-	logEvent := log.Info()
+	logEvent := logger.Info()
+	addContext(ctx, logEvent)
+	logEvent.Msg(msg)
+}
+
+// Log details of individual operations, only emitted with LOG_LEVEL=debug
+func Debug(ctx context.Context, msg string) {
+	logEvent := logger.Debug()
 	addContext(ctx, logEvent)
 	logEvent.Msg(msg)
 }
 
 // Add more context to the log event
 func addContext(ctx context.Context, logEvent *zerolog.Event) {
+	// events below the level are nil and discarded
+	if logEvent == nil {
+		return
+	}
 	contextKeys := []string{
 		"RegistryURL",
 		"RepositoryName",
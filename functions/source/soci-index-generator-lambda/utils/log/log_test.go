@@ -0,0 +1,75 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestLogLevel(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-log-level"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	doTest := func(levelName string, expectedMessages []string) {
+		var buf bytes.Buffer
+		original := logger
+		logger = newLogger(&buf, levelName)
+		defer func() { logger = original }()
+
+		Debug(ctx, "debug message")
+		Info(ctx, "info message")
+		Warn(ctx, "warn message")
+		Error(ctx, "error message", errors.New("error"))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if buf.Len() == 0 {
+			lines = nil
+		}
+		if len(lines) != len(expectedMessages) {
+			t.Fatalf("Expected %d lines at level %q but got %d: %s", len(expectedMessages), levelName, len(lines), buf.String())
+		}
+		for i, message := range expectedMessages {
+			if !strings.Contains(lines[i], `"message":"`+message+`"`) || !strings.Contains(lines[i], lc.AwsRequestID) {
+				t.Fatalf("Expected line %d to be %q with the request id but got %s", i, message, lines[i])
+			}
+		}
+	}
+
+	doTest("", []string{"info message", "warn message", "error message"})
+	doTest("info", []string{"info message", "warn message", "error message"})
+	doTest("DEBUG", []string{"debug message", "info message", "warn message", "error message"})
+	doTest("warn", []string{"warn message", "error message"})
+	doTest("error", []string{"error message"})
+	doTest("verbose", []string{"info message", "warn message", "error message"})
+}
+
+func TestRedirect(t *testing.T) {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = "abcd-1234-test-redirect"
+	ctx := lambdacontext.NewContext(context.Background(), &lc)
+
+	var buf bytes.Buffer
+	restore := Redirect(&buf, "debug")
+	if !DebugEnabled() {
+		t.Fatalf("Expected debug events to be enabled")
+	}
+	Debug(ctx, "redirected message")
+	restore()
+	if !strings.Contains(buf.String(), "redirected message") {
+		t.Fatalf("Expected the message to be redirected but got %q", buf.String())
+	}
+
+	restore = Redirect(&buf, "info")
+	defer restore()
+	if DebugEnabled() {
+		t.Fatalf("Expected debug events to be disabled at the info level")
+	}
+}
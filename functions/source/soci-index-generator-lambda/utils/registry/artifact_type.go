@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrInvalidArtifactType = errors.New("invalid artifact type")
+
+// A media type as defined by RFC 6838, i.e. type/subtype without parameters
+var mediaTypeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+// Check that an artifact type is a media type, e.g. application/vnd.amazon.soci.index.v2+json
+func validateArtifactType(artifactType string) error {
+	if !mediaTypeRegexp.MatchString(artifactType) {
+		return fmt.Errorf("%w: %q is not a media type", ErrInvalidArtifactType, artifactType)
+	}
+	return nil
+}
+
+// Rewrite the manifest of the local store described by desc to carry the given artifactType, store it and return
+// its descriptor. The other fields of the manifest are kept as is. A manifest that already carries the artifact type
+// is returned unchanged.
+func setArtifactType(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor, artifactType string) (ocispec.Descriptor, error) {
+	rc, err := sociStore.Fetch(ctx, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var current string
+	if raw, ok := fields["artifactType"]; ok {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	if current == artifactType {
+		return desc, nil
+	}
+
+	fields["artifactType"], err = json.Marshal(artifactType)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	content, err = json.Marshal(fields)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	mappedDesc := desc
+	mappedDesc.ArtifactType = artifactType
+	mappedDesc.Digest = digest.FromBytes(content)
+	mappedDesc.Size = int64(len(content))
+	if err := pushBytes(ctx, sociStore, mappedDesc, content); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to store the index with artifact type %s: %w", artifactType, err)
+	}
+	return mappedDesc, nil
+}
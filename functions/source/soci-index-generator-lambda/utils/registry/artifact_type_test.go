@@ -0,0 +1,53 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+)
+
+func TestPushArtifactType(t *testing.T) {
+	doTest := func(artifactType string, expectedErr error) {
+		ctx := newTestContext("abcd-1234-test-push-artifact-type")
+		fake := newFakeRegistry(t)
+		sociStore := newTestSociStore(t, ctx)
+		indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+		result, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithArtifactType(artifactType))
+		if expectedErr != nil {
+			if !errors.Is(err, expectedErr) {
+				t.Fatalf("Expected %v but got %v", expectedErr, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+		if result.Descriptor.ArtifactType != artifactType {
+			t.Fatalf("Expected the pushed descriptor to have artifact type %s but got %s", artifactType, result.Descriptor.ArtifactType)
+		}
+		if fake.tagged("repo", "latest-soci") != result.Descriptor.Digest.String() {
+			t.Fatalf("Expected latest-soci to point to %s but got %q", result.Descriptor.Digest, fake.tagged("repo", "latest-soci"))
+		}
+		manifest, err := fake.registry(t).GetManifest(ctx, "repo", "latest-soci")
+		if err != nil {
+			t.Fatalf("Failed to get the pushed index: %v", err)
+		}
+		if manifest.ArtifactType != artifactType {
+			t.Fatalf("Expected the pushed index to have artifact type %s but got %s", artifactType, manifest.ArtifactType)
+		}
+		if len(manifest.Layers) != 1 {
+			t.Fatalf("Expected the pushed index to keep its layer but got %v", manifest.Layers)
+		}
+	}
+
+	doTest(soci.SociIndexArtifactTypeV2, nil)
+	doTest("application/vnd.amazon.soci.index.v1+json", nil)
+	doTest("not a media type", ErrInvalidArtifactType)
+	doTest("application/", ErrInvalidArtifactType)
+	doTest("application/json; charset=utf-8", ErrInvalidArtifactType)
+}
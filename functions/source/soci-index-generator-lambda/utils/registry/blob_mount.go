@@ -0,0 +1,43 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry"
+)
+
+// A repository uploading a blob when mounting it from another repository fails outright, e.g. when the credentials
+// can't pull from the source repository, rather than failing the push. Registries declining a mount reply 202, and
+// oras uploads the blob then already.
+type mountFallbackRepository struct {
+	registry.Repository
+}
+
+func (repo *mountFallbackRepository) Mount(ctx context.Context, desc ocispec.Descriptor, fromRepo string, getContent func() (io.ReadCloser, error)) error {
+	mounter, ok := repo.Repository.(registry.Mounter)
+	if ok {
+		fetched := false
+		err := mounter.Mount(ctx, desc, fromRepo, func() (io.ReadCloser, error) {
+			fetched = true
+			return getContent()
+		})
+		// once the content is fetched, the mount was declined and the error is the upload's
+		if err == nil || fetched {
+			return err
+		}
+		log.Debug(ctx, fmt.Sprintf("Failed to mount blob %s from repository %s, uploading it: %v", desc.Digest, fromRepo, err))
+	}
+	rc, err := getContent()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return repo.Push(ctx, desc, rc)
+}
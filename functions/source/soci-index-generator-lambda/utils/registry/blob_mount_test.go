@@ -0,0 +1,78 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestPushWithBlobMountFrom(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-blob-mount-from")
+	layer := []byte("shared ztoc")
+	layerDigest := digest.FromBytes(layer)
+
+	doTest := func(name string, denyMounts bool) {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeRegistry(t)
+			fake.putBlob("source", "application/octet-stream", layer)
+			var mounts []string
+			fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method == http.MethodPost && r.URL.Query().Get("mount") != "" {
+					fake.mu.Lock()
+					mounts = append(mounts, r.URL.Query().Get("mount"))
+					fake.mu.Unlock()
+					if denyMounts {
+						writeRegistryError(w, http.StatusForbidden, "DENIED", "not authorized to pull from source")
+						return true
+					}
+				}
+				return false
+			}
+			sociStore := newTestSociStore(t, ctx)
+			indexDesc := storeTestIndex(t, ctx, sociStore, layer)
+
+			result, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "", WithBlobMountFrom("source"))
+			if err != nil {
+				t.Fatalf("Expected the push to succeed, got: %v", err)
+			}
+			if !fake.hasBlob("repo", layerDigest) || !fake.hasManifest("repo", indexDesc.Digest) {
+				t.Fatalf("Expected the index and its blobs to be pushed")
+			}
+			fake.mu.Lock()
+			mounted := false
+			for _, mount := range mounts {
+				mounted = mounted || mount == layerDigest.String()
+			}
+			fake.mu.Unlock()
+			if !mounted {
+				t.Fatalf("Expected a mount of %s to be attempted but got %v", layerDigest, mounts)
+			}
+
+			// the config isn't in the source repository, so it's uploaded either way
+			expectedUploads, expectedMounted := 1, 1
+			if denyMounts {
+				expectedUploads, expectedMounted = 2, 0
+			}
+			if uploads := fake.requestCount(http.MethodPut, "/blobs/uploads/"); uploads != expectedUploads {
+				t.Fatalf("Expected %d blob uploads but got %d", expectedUploads, uploads)
+			}
+			if result.BlobsMounted != expectedMounted {
+				t.Fatalf("Expected %d mounted blobs but got %d", expectedMounted, result.BlobsMounted)
+			}
+			if expectedMounted > 0 && result.BytesMounted != int64(len(layer)) {
+				t.Fatalf("Expected %d mounted bytes but got %d", len(layer), result.BytesMounted)
+			}
+			if result.BlobsUploaded+result.BlobsMounted != 2 {
+				t.Fatalf("Expected every blob to be accounted for but got %+v", result)
+			}
+		})
+	}
+
+	doTest("mount succeeds", false)
+	// the push doesn't fail when the mount is refused outright, the blob is uploaded
+	doTest("mount denied", true)
+}
@@ -0,0 +1,237 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Artifact type of the manifests pushed to probe the capabilities of a registry
+const capabilityProbeArtifactType = "application/vnd.soci-index-builder.capability-probe"
+
+// The OCI features a registry supports, which decide how SOCI indexes can be pushed to it
+type Capabilities struct {
+	// The registry stores OCI image indexes, which V2 SOCI indexes are pushed as
+	OCIImageIndex bool
+	// The registry serves the referrers API, rather than only the referrers tag schema
+	ReferrersAPI bool
+	// The registry stores manifests with a subject field, which V1 SOCI indexes refer to their image with
+	Subject bool
+	// The registry honors If-Match on manifest PUTs, so that WithExpectedTagDigest moves tags atomically
+	ConditionalRequests bool
+}
+
+// Probe the OCI features supported by a repository, so that the push path can pick its behavior up front rather
+// than react to a rejected push. The referrers API is probed with a read; image indexes and subjects by pushing
+// a tiny manifest, an index and a referrer of the manifest, by digest. The probes are deleted afterwards on a
+// best effort basis; their digests are the same on every call, so that probes left behind don't pile up.
+func (registry *Registry) DetectCapabilities(ctx context.Context, repositoryName string) (Capabilities, error) {
+	capabilities, err := registry.detectCapabilities(ctx, repositoryName)
+	return capabilities, registry.wrapError("detect capabilities", repositoryName, "", err)
+}
+
+func (registry *Registry) detectCapabilities(ctx context.Context, repositoryName string) (Capabilities, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return Capabilities{}, err
+	}
+	var capabilities Capabilities
+	err := registry.withReauthorization(ctx, func() error {
+		target, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		repo, ok := target.(*remote.Repository)
+		if !ok {
+			return fmt.Errorf("repository %s does not support probing capabilities", repositoryName)
+		}
+		capabilities, err = registry.probeCapabilities(ctx, repo)
+		return err
+	})
+	if err != nil {
+		return Capabilities{}, err
+	}
+	log.Info(ctx, fmt.Sprintf("Registry capabilities: OCI image index %v, referrers API %v, subject %v, conditional requests %v",
+		capabilities.OCIImageIndex, capabilities.ReferrersAPI, capabilities.Subject, capabilities.ConditionalRequests))
+	return capabilities, nil
+}
+
+func (registry *Registry) probeCapabilities(ctx context.Context, repo *remote.Repository) (Capabilities, error) {
+	var capabilities Capabilities
+	emptyBlob, err := oras.PushBytes(ctx, repo, ocispec.MediaTypeEmptyJSON, ocispec.DescriptorEmptyJSON.Data)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to push the probe blob: %w", err)
+	}
+	probeManifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    emptyBlob,
+		Layers:    []ocispec.Descriptor{emptyBlob},
+	}
+	probeManifest.SchemaVersion = 2
+	manifest, err := pushProbe(ctx, repo, ocispec.MediaTypeImageManifest, probeManifest)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to push the probe manifest: %w", err)
+	}
+	// probes are deleted in the reverse order of their push, referrers and indexes before the manifest
+	var probes []ocispec.Descriptor
+	defer func() {
+		probes = append(probes, manifest)
+		for i := len(probes) - 1; i >= 0; i-- {
+			if err := repo.Delete(ctx, probes[i]); err != nil {
+				log.Debug(ctx, fmt.Sprintf("Failed to delete the capability probe %s: %v", probes[i].Digest, err))
+			}
+		}
+	}()
+
+	capabilities.ReferrersAPI, err = probeReferrersAPI(ctx, repo, manifest)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to probe the referrers API: %w", err)
+	}
+	// the referrer probe is deleted right away, so there's no need for oras to maintain the referrers tag schema
+	if err := repo.SetReferrersCapability(true); err != nil {
+		return capabilities, err
+	}
+
+	probeIndex := ocispec.Index{
+		MediaType:    ocispec.MediaTypeImageIndex,
+		ArtifactType: capabilityProbeArtifactType,
+		Manifests:    []ocispec.Descriptor{manifest},
+	}
+	probeIndex.SchemaVersion = 2
+	index, err := pushProbe(ctx, repo, ocispec.MediaTypeImageIndex, probeIndex)
+	capabilities.OCIImageIndex, err = registry.probeAccepted(err)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to push the probe index: %w", err)
+	}
+	if capabilities.OCIImageIndex {
+		probes = append(probes, index)
+	}
+
+	probeReferrer := probeManifest
+	probeReferrer.ArtifactType = capabilityProbeArtifactType
+	probeReferrer.Subject = &manifest
+	referrer, err := pushProbe(ctx, repo, ocispec.MediaTypeImageManifest, probeReferrer)
+	capabilities.Subject, err = registry.probeAccepted(err)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to push the probe referrer: %w", err)
+	}
+	if capabilities.Subject {
+		probes = append(probes, referrer)
+	}
+
+	capabilities.ConditionalRequests, err = probeConditionalRequests(ctx, repo, manifest, probeManifest)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to probe conditional requests: %w", err)
+	}
+	return capabilities, nil
+}
+
+// Push a probe manifest or index by digest
+func pushProbe(ctx context.Context, repo *remote.Repository, mediaType string, v interface{}) (ocispec.Descriptor, error) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return oras.PushBytes(ctx, repo, mediaType, blob)
+}
+
+// Map the error of pushing a probe to whether the registry accepted it. Rejections of registries that don't
+// support OCI artifacts mean the feature is unsupported, other errors are returned.
+func (registry *Registry) probeAccepted(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if isUnsupportedArtifactError(err, registry.config.unsupportedArtifactMatchers) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Check if the registry serves the referrers API for a subject. Registries without it reply 404, as the
+// distribution spec prescribes for clients to fall back to the referrers tag schema.
+func probeReferrersAPI(ctx context.Context, repo *remote.Repository, subject ocispec.Descriptor) (bool, error) {
+	url := repositoryURL(repo, "referrers/"+subject.Digest.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", ocispec.MediaTypeImageIndex)
+	client := repo.Client
+	if client == nil {
+		client = auth.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, DefaultMaxManifestSize))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		return mediaType == ocispec.MediaTypeImageIndex, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, &errcode.ErrorResponse{Method: req.Method, URL: req.URL, StatusCode: resp.StatusCode}
+	}
+}
+
+// Check if the registry honors conditional requests by pushing the probe manifest again, by digest, on the condition
+// that its current ETag matches a digest it can't have. Registries honoring the condition reply 412; others accept
+// the PUT, which is idempotent.
+func probeConditionalRequests(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, manifest ocispec.Manifest) (bool, error) {
+	blob, err := json.Marshal(manifest)
+	if err != nil {
+		return false, err
+	}
+	header := http.Header{}
+	header.Set("If-Match", `"`+digest.FromString(capabilityProbeArtifactType).String()+`"`)
+	err = putManifest(ctx, repo, desc, blob, desc.Digest.String(), header)
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) && errResp.StatusCode == http.StatusPreconditionFailed {
+		return true, nil
+	}
+	return false, err
+}
+
+// Check up front if the registry is known not to support the root of an artifact to push, either an OCI image
+// index or a manifest with a subject
+func (capabilities Capabilities) rejects(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor) (bool, error) {
+	switch desc.MediaType {
+	case MediaTypeOCIImageIndex:
+		return !capabilities.OCIImageIndex, nil
+	case MediaTypeOCIManifest:
+		if capabilities.Subject {
+			return false, nil
+		}
+		blob, err := content.FetchAll(ctx, sociStore, desc)
+		if err != nil {
+			return false, err
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(blob, &manifest); err != nil {
+			return false, fmt.Errorf("%w: %w", ErrNotImageManifest, err)
+		}
+		return manifest.Subject != nil, nil
+	default:
+		return false, nil
+	}
+}
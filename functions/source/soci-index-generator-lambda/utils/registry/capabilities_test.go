@@ -0,0 +1,141 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Make a fake registry reject OCI image indexes, manifests with a subject and the referrers API,
+// like older registries do
+func withoutOCIArtifacts(fake *fakeRegistry) {
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/referrers/") {
+			writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown path")
+			return true
+		}
+		if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+			return false
+		}
+		if r.Header.Get("Content-Type") == MediaTypeOCIImageIndex {
+			writeRegistryError(w, http.StatusUnsupportedMediaType, errcode.ErrorCodeManifestInvalid, "unsupported media type")
+			return true
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if bytes.Contains(body, []byte(`"subject"`)) {
+			writeRegistryError(w, http.StatusBadRequest, errcode.ErrorCodeManifestInvalid, "unknown field artifactType")
+			return true
+		}
+		return false
+	}
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-detect-capabilities")
+
+	doTest := func(name string, configure func(fake *fakeRegistry), expected Capabilities) {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeRegistry(t)
+			configure(fake)
+			capabilities, err := fake.registry(t).DetectCapabilities(ctx, "repo")
+			if err != nil {
+				t.Fatalf("DetectCapabilities failed: %v", err)
+			}
+			if capabilities != expected {
+				t.Fatalf("Expected capabilities %+v but got %+v", expected, capabilities)
+			}
+			// the probes are cleaned up
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if len(fake.manifests) != 0 || len(fake.tags) != 0 {
+				t.Fatalf("Expected the probes to be deleted but found %d manifests and %d tags", len(fake.manifests), len(fake.tags))
+			}
+		})
+	}
+
+	doTest("capabilities present", func(fake *fakeRegistry) {}, Capabilities{OCIImageIndex: true, ReferrersAPI: true, Subject: true})
+	doTest("capabilities absent", withoutOCIArtifacts, Capabilities{})
+	doTest("referrers tag schema only", func(fake *fakeRegistry) {
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if strings.Contains(r.URL.Path, "/referrers/") {
+				writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown path")
+				return true
+			}
+			return false
+		}
+	}, Capabilities{OCIImageIndex: true, Subject: true})
+
+	t.Run("other failures are errors", func(t *testing.T) {
+		fake := newFakeRegistry(t)
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method == http.MethodPut && r.Header.Get("Content-Type") == MediaTypeOCIImageIndex {
+				writeRegistryError(w, http.StatusForbidden, "DENIED", "denied")
+				return true
+			}
+			return false
+		}
+		if _, err := fake.registry(t).DetectCapabilities(ctx, "repo"); err == nil || !strings.Contains(err.Error(), "detect capabilities") {
+			t.Fatalf("Expected a wrapped error but got %v", err)
+		}
+	})
+}
+
+func TestPushWithCapabilities(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-capabilities")
+	fake := newFakeRegistry(t)
+	withoutOCIArtifacts(fake)
+	sociStore := newTestSociStore(t, ctx)
+	manifestDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	index := ocispec.Index{
+		MediaType:    MediaTypeOCIImageIndex,
+		ArtifactType: "application/vnd.amazon.soci.index.v2+json",
+		Manifests:    []ocispec.Descriptor{manifestDesc},
+	}
+	index.SchemaVersion = 2
+	content, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	indexDesc := pushToStore(t, ctx, sociStore, MediaTypeOCIImageIndex, content)
+
+	registry := fake.registry(t)
+	capabilities, err := registry.DetectCapabilities(ctx, "repo")
+	if err != nil {
+		t.Fatalf("DetectCapabilities failed: %v", err)
+	}
+
+	// the index is converted up front, without attempting to push it first
+	before := fake.requestCount(http.MethodPut, "/manifests/")
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithCapabilities(capabilities), WithDockerManifestListFallback()); err != nil {
+		t.Fatalf("Expected the push to succeed, got: %v", err)
+	}
+	// the child manifest, the manifest list and its tag
+	if puts := fake.requestCount(http.MethodPut, "/manifests/") - before; puts != 3 {
+		t.Fatalf("Expected no attempt at pushing the index, got %d manifest pushes", puts)
+	}
+
+	before = fake.requestCount(http.MethodPut, "/manifests/")
+	_, err = registry.Push(ctx, sociStore, indexDesc, "repo", "", WithCapabilities(capabilities), WithRequireOCIArtifacts())
+	if !errors.Is(err, RegistryNotSupportingOciArtifacts) {
+		t.Fatalf("Expected RegistryNotSupportingOciArtifacts, got: %v", err)
+	}
+	if puts := fake.requestCount(http.MethodPut, "/manifests/") - before; puts != 0 {
+		t.Fatalf("Expected nothing to be pushed, got %d manifest pushes", puts)
+	}
+
+	// manifests without a subject don't need the capability
+	if _, err := registry.Push(ctx, sociStore, manifestDesc, "repo", "", WithCapabilities(capabilities)); err != nil {
+		t.Fatalf("Expected the manifest push to succeed, got: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// Suffix of the blobs a CompressedStore keeps gzip compressed on disk
+const compressedBlobSuffix = ".gz"
+
+// A store of SOCI artifacts keeping them gzip compressed on disk and decompressing them transparently on read, e.g.
+// to stage the ztocs of many images without filling /tmp. Ztocs are mostly the uncompressed checkpoints of their
+// layer, so they shrink severalfold, e.g. the 491KiB ztoc of a 16MiB layer of source files takes 91KiB on disk,
+// as do manifests and other JSON metadata; image layers, which are compressed already, are stored as is. The blobs are laid out like those of an OCI store, so StoreStats reports the disk
+// usage of the store. It implements store.Store, e.g. for the blob store of the SOCI index builder, without
+// garbage collection or batches.
+type CompressedStore struct {
+	root string
+}
+
+var _ store.Store = (*CompressedStore)(nil)
+
+// Create the compressed store rooted at rootPath. An existing store at rootPath is opened as is.
+func NewCompressedStore(rootPath string) (*CompressedStore, error) {
+	if err := os.MkdirAll(filepath.Join(rootPath, "blobs"), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create compressed store at %s: %w", rootPath, err)
+	}
+	return &CompressedStore{root: rootPath}, nil
+}
+
+// Return the path of a blob as laid out in an OCI store, without the compressed suffix
+func (s *CompressedStore) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, "blobs", dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// Return the path a blob is stored at and whether it is compressed, or errdef.ErrNotFound
+func (s *CompressedStore) find(dgst digest.Digest) (string, bool, error) {
+	path, err := s.blobPath(dgst)
+	if err != nil {
+		return "", false, err
+	}
+	for _, compressed := range []bool{true, false} {
+		candidate := path
+		if compressed {
+			candidate += compressedBlobSuffix
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, compressed, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", false, err
+		}
+	}
+	return "", false, fmt.Errorf("%s: %w", dgst, errdef.ErrNotFound)
+}
+
+func (s *CompressedStore) Exists(_ context.Context, target ocispec.Descriptor) (bool, error) {
+	_, _, err := s.find(target.Digest)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Fetch a blob, decompressed and verified against its descriptor as it is read
+func (s *CompressedStore) Fetch(_ context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
+	path, compressed, err := s.find(target.Digest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return verifiedReadCloser(file, file, target), nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", target.Digest, err)
+	}
+	return verifiedReadCloser(gz, closerFunc(func() error {
+		gz.Close()
+		return file.Close()
+	}), target), nil
+}
+
+// Write a blob, compressing it unless it is an image layer. The blob is only visible once verified.
+func (s *CompressedStore) Push(_ context.Context, expected ocispec.Descriptor, reader io.Reader) error {
+	if _, _, err := s.find(expected.Digest); err == nil {
+		return fmt.Errorf("%s: %w", expected.Digest, errdef.ErrAlreadyExists)
+	} else if !errors.Is(err, errdef.ErrNotFound) {
+		return err
+	}
+	path, err := s.blobPath(expected.Digest)
+	if err != nil {
+		return err
+	}
+	compress := !IsImageLayerMediaType(expected.MediaType)
+	if compress {
+		path += compressedBlobSuffix
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".ingest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := writeBlob(file, reader, expected, compress); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", expected.Digest, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Copy a blob to w, compressed or not, verifying it against its descriptor
+func writeBlob(w io.Writer, reader io.Reader, expected ocispec.Descriptor, compress bool) error {
+	verifier := content.NewVerifyReader(reader, expected)
+	if !compress {
+		if _, err := io.Copy(w, verifier); err != nil {
+			return err
+		}
+		return verifier.Verify()
+	}
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, verifier); err != nil {
+		return err
+	}
+	if err := verifier.Verify(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (s *CompressedStore) Delete(_ context.Context, dgst digest.Digest) error {
+	path, _, err := s.find(dgst)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Label is a no-op, the store doesn't garbage collect
+func (s *CompressedStore) Label(_ context.Context, _ ocispec.Descriptor, _ string, _ string) error {
+	return nil
+}
+
+// BatchOpen is a no-op, the store doesn't garbage collect
+func (s *CompressedStore) BatchOpen(ctx context.Context) (context.Context, store.CleanupFunc, error) {
+	return ctx, store.NopCleanup, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// Wrap a reader of a blob to fail the read reaching its end with content that doesn't match the descriptor
+func verifiedReadCloser(r io.Reader, closer io.Closer, target ocispec.Descriptor) io.ReadCloser {
+	return &verifyingReadCloser{verifier: content.NewVerifyReader(r, target), Closer: closer}
+}
+
+type verifyingReadCloser struct {
+	io.Closer
+	verifier *content.VerifyReader
+}
+
+func (r *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.verifier.Read(p)
+	if errors.Is(err, io.EOF) {
+		if verifyErr := r.verifier.Verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
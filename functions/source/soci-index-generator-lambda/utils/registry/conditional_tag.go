@@ -0,0 +1,123 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+var ErrConcurrentModification = errors.New("tag was moved concurrently")
+
+// Move a tag to an index only if the tag still points at the expected digest, or doesn't exist yet when the
+// expected digest is empty, so that concurrent builders don't clobber each other's tag. Registries known from
+// their capabilities to honor conditional requests get the manifest PUT with If-Match or If-None-Match; the tag is
+// resolved before and after tagging otherwise, which narrows the race without closing it.
+func (registry *Registry) tagConditionally(ctx context.Context, repo registry.Repository, repositoryName string, indexDesc ocispec.Descriptor, tag string, config *pushConfig) error {
+	if remoteRepo, ok := repo.(*remote.Repository); ok && config.capabilities != nil && config.capabilities.ConditionalRequests {
+		manifest, err := content.FetchAll(ctx, remoteRepo, indexDesc)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the index to tag: %w", err)
+		}
+		header := http.Header{}
+		if config.expectedTagDigest == "" {
+			header.Set("If-None-Match", "*")
+		} else {
+			header.Set("If-Match", `"`+config.expectedTagDigest+`"`)
+		}
+		err = putManifest(ctx, remoteRepo, indexDesc, manifest, tag, header)
+		var errResp *errcode.ErrorResponse
+		if errors.As(err, &errResp) && errResp.StatusCode == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: tag %s no longer points at %s", ErrConcurrentModification, tag, expectedTagName(config.expectedTagDigest))
+		}
+		return tagError(err, repositoryName, tag)
+	}
+
+	log.Warn(ctx, fmt.Sprintf("Registry not known to support conditional requests, checking tag %s before and after tagging instead", tag))
+	current, err := repo.Resolve(ctx, tag)
+	if err != nil && !errors.Is(err, errdef.ErrNotFound) {
+		return fmt.Errorf("failed to resolve tag %s before tagging: %w", tag, err)
+	}
+	if current.Digest.String() != config.expectedTagDigest {
+		return fmt.Errorf("%w: tag %s points at %s, expected %s", ErrConcurrentModification, tag, current.Digest, expectedTagName(config.expectedTagDigest))
+	}
+	if err := tagError(repo.Tag(ctx, indexDesc, tag), repositoryName, tag); err != nil {
+		return err
+	}
+	tagged, err := repo.Resolve(ctx, tag)
+	if err != nil {
+		return fmt.Errorf("failed to verify tag %s: %w", tag, err)
+	}
+	if tagged.Digest != indexDesc.Digest {
+		return fmt.Errorf("%w: tag %s was moved to %s while tagging", ErrConcurrentModification, tag, tagged.Digest)
+	}
+	return nil
+}
+
+func expectedTagName(expectedDigest string) string {
+	if expectedDigest == "" {
+		return "no manifest"
+	}
+	return expectedDigest
+}
+
+// PUT a manifest with extra request headers, which oras-go doesn't support, returning an *errcode.ErrorResponse
+// for non 201 responses
+func putManifest(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, manifest []byte, reference string, header http.Header) error {
+	url := repositoryURL(repo, "manifests/"+reference)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(manifest))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = desc.Size
+	req.Header.Set("Content-Type", desc.MediaType)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	client := repo.Client
+	if client == nil {
+		client = auth.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, DefaultMaxManifestSize))
+		return nil
+	}
+	errResp := &errcode.ErrorResponse{Method: req.Method, URL: req.URL, StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, DefaultMaxManifestSize))
+	var parsed struct {
+		Errors errcode.Errors `json:"errors"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		errResp.Errors = parsed.Errors
+	}
+	return errResp
+}
+
+// Return the URL of a path of the registry API under a repository, e.g. "manifests/latest"
+func repositoryURL(repo *remote.Repository, path string) string {
+	scheme := "https"
+	if repo.PlainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, repo.Reference.Host(), repo.Reference.Repository, path)
+}
@@ -0,0 +1,123 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Make a fake registry honor If-Match and If-None-Match on manifest PUTs, with the digest of a manifest as its ETag
+func withConditionalRequests(fake *fakeRegistry) {
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		i := strings.LastIndex(r.URL.Path, "/manifests/")
+		if r.Method != http.MethodPut || i < 0 {
+			return false
+		}
+		repo := strings.TrimPrefix(r.URL.Path[:i], "/v2/")
+		reference := r.URL.Path[i+len("/manifests/"):]
+		current := reference
+		if _, err := digest.Parse(reference); err != nil {
+			current = fake.tagged(repo, reference)
+		} else if !fake.hasManifest(repo, digest.Digest(reference)) {
+			current = ""
+		}
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if (ifMatch != "" && ifMatch != `"`+current+`"`) || (ifNoneMatch == "*" && current != "") {
+			writeRegistryError(w, http.StatusPreconditionFailed, "PRECONDITION_FAILED", "precondition failed")
+			return true
+		}
+		return false
+	}
+}
+
+func TestConditionalTag(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-conditional-tag")
+
+	// Push an index and a manifest, the latter tagged latest-soci, returning them along with the store of the index
+	setup := func(t *testing.T, fake *fakeRegistry, registry *Registry) (*store.SociStore, ocispec.Descriptor, ocispec.Descriptor) {
+		sociStore := newTestSociStore(t, ctx)
+		indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+		if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", ""); err != nil {
+			t.Fatalf("Failed to push index: %v", err)
+		}
+		other := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest-soci")
+		return sociStore, indexDesc, other
+	}
+
+	doTest := func(name string, conditional bool) {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeRegistry(t)
+			registry := fake.registry(t)
+			var opts []PushOption
+			if conditional {
+				withConditionalRequests(fake)
+				capabilities, err := registry.DetectCapabilities(ctx, "repo")
+				if err != nil {
+					t.Fatalf("DetectCapabilities failed: %v", err)
+				}
+				if !capabilities.ConditionalRequests {
+					t.Fatalf("Expected conditional requests to be detected")
+				}
+				opts = append(opts, WithCapabilities(capabilities))
+			}
+			sociStore, indexDesc, other := setup(t, fake, registry)
+
+			// the tag moved since it was resolved
+			err := registry.TagIndex(ctx, "repo", indexDesc, "latest-soci", append(opts, WithExpectedTagDigest(digest.FromString("stale").String()))...)
+			if !errors.Is(err, ErrConcurrentModification) {
+				t.Fatalf("Expected ErrConcurrentModification but got %v", err)
+			}
+			_, err = registry.Push(ctx, sociStore, indexDesc, "repo", "latest-soci", append(opts, WithExpectedTagDigest(""))...)
+			if !errors.Is(err, ErrConcurrentModification) {
+				t.Fatalf("Expected ErrConcurrentModification for an existing tag but got %v", err)
+			}
+			if fake.tagged("repo", "latest-soci") != other.Digest.String() {
+				t.Fatalf("Expected the tag to be left on %s but got %s", other.Digest, fake.tagged("repo", "latest-soci"))
+			}
+
+			// the tag is where it was resolved
+			if err := registry.TagIndex(ctx, "repo", indexDesc, "latest-soci", append(opts, WithExpectedTagDigest(other.Digest.String()))...); err != nil {
+				t.Fatalf("Expected the tag to be moved, got: %v", err)
+			}
+			if fake.tagged("repo", "latest-soci") != indexDesc.Digest.String() {
+				t.Fatalf("Expected the tag to be moved to %s but got %s", indexDesc.Digest, fake.tagged("repo", "latest-soci"))
+			}
+			if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", "new-soci", append(opts, WithExpectedTagDigest(""))...); err != nil {
+				t.Fatalf("Expected a new tag to be created, got: %v", err)
+			}
+			if fake.tagged("repo", "new-soci") != indexDesc.Digest.String() {
+				t.Fatalf("Expected new-soci to point to %s but got %s", indexDesc.Digest, fake.tagged("repo", "new-soci"))
+			}
+		})
+	}
+
+	doTest("conditional requests", true)
+	doTest("resolve and verify fallback", false)
+
+	t.Run("fallback detects a concurrent move", func(t *testing.T) {
+		fake := newFakeRegistry(t)
+		registry := fake.registry(t)
+		_, indexDesc, other := setup(t, fake, registry)
+		// another builder moves the tag right after ours
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/latest-soci") {
+				fake.serveManifest(w, r, "repo", "latest-soci")
+				fake.putManifest("repo", other.MediaType, nil, digest.SHA256, "latest-soci")
+				return true
+			}
+			return false
+		}
+		err := registry.TagIndex(ctx, "repo", indexDesc, "latest-soci", WithExpectedTagDigest(other.Digest.String()))
+		if !errors.Is(err, ErrConcurrentModification) {
+			t.Fatalf("Expected ErrConcurrentModification but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+)
+
+var ErrConfigBlobMissing = errors.New("config blob of the image manifest is missing from the repository")
+
+// Check that the config blob of an image manifest exists in the repository with a HEAD request, so that a manifest
+// pointing at a deleted config is rejected before the pull rather than failing partway through it
+func (registry *Registry) checkConfigBlob(ctx context.Context, repositoryName string, manifest ocispec.Manifest) error {
+	return registry.withReauthorization(ctx, func() error {
+		repo, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		desc, err := repo.Blobs().Resolve(ctx, manifest.Config.Digest.String())
+		if errors.Is(err, errdef.ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrConfigBlobMissing, manifest.Config.Digest)
+		}
+		if err != nil {
+			return err
+		}
+		if desc.Size != manifest.Config.Size {
+			return fmt.Errorf("%w: config blob %s has size %d, expected %d", ErrContentMismatch, manifest.Config.Digest, desc.Size, manifest.Config.Size)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestValidateImageDigestDeepValidation(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-deep-validation")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType))
+
+	// a manifest whose config blob was deleted
+	missingConfig := ocispec.Descriptor{MediaType: MediaTypeOCIImageConfig, Digest: digest.FromString("deleted"), Size: 7}
+	dangling := imageManifest(MediaTypeOCIImageConfig)
+	dangling.Config = missingConfig
+	danglingDesc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, dangling)
+
+	doTest := func(desc ocispec.Descriptor, version SociIndexVersion, deep bool, expectedErr error) {
+		var opts []Option
+		if deep {
+			opts = append(opts, WithDeepValidation())
+		}
+		registry := fake.registry(t, opts...)
+		heads := fake.requestCount(http.MethodHead, "/blobs/")
+		err := registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), version)
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected %s to be valid, got: %v", desc.Digest, err)
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+		if heads = fake.requestCount(http.MethodHead, "/blobs/") - heads; deep != (heads > 0) {
+			t.Fatalf("Expected the config blob to be checked: %v, got %d requests", deep, heads)
+		}
+	}
+
+	doTest(image, "V1", true, nil)
+	doTest(image, "V2", true, nil)
+	doTest(danglingDesc, "V1", true, ErrConfigBlobMissing)
+	doTest(danglingDesc, "V2", true, ErrConfigBlobMissing)
+	// the config blob isn't checked by default
+	doTest(danglingDesc, "V1", false, nil)
+}
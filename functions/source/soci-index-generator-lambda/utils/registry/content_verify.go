@@ -0,0 +1,53 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+// ErrContentDigestMismatch is returned by Pull when fetched content doesn't match the digest of its descriptor,
+// e.g. corrupted by a proxy between the registry and the Lambda. Use errors.As with a *ContentDigestMismatchError
+// to find the offending descriptor.
+var ErrContentDigestMismatch = errors.New("fetched content does not match its digest")
+
+// Content that didn't match the digest of its descriptor
+type ContentDigestMismatchError struct {
+	Descriptor ocispec.Descriptor
+	// The verification error, e.g. content.ErrMismatchedDigest
+	Err error
+}
+
+func (e *ContentDigestMismatchError) Error() string {
+	return fmt.Sprintf("%v: %s %s (%d bytes): %v", ErrContentDigestMismatch, e.Descriptor.MediaType, e.Descriptor.Digest, e.Descriptor.Size, e.Err)
+}
+
+func (e *ContentDigestMismatchError) Unwrap() []error {
+	return []error{ErrContentDigestMismatch, e.Err}
+}
+
+// The destination of Pull, verifying the content written to it against the digests of the descriptors so that
+// corrupted content aborts the pull with a *ContentDigestMismatchError rather than the store's own error
+type verifyingStore struct {
+	oras.Target
+}
+
+func (s *verifyingStore) Push(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	verifier := content.NewVerifyReader(r, desc)
+	err := s.Target.Push(ctx, desc, verifier)
+	if err == nil {
+		return nil
+	}
+	if verifyErr := verifier.Verify(); errors.Is(verifyErr, content.ErrMismatchedDigest) || errors.Is(verifyErr, content.ErrTrailingData) {
+		return &ContentDigestMismatchError{Descriptor: desc, Err: verifyErr}
+	}
+	return err
+}
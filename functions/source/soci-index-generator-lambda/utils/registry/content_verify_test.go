@@ -0,0 +1,55 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPullCorruptedLayer(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-corrupted-layer")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	intact := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("intact layer"))
+	corrupted := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("corrupted layer"))
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, intact, corrupted), "latest")
+
+	// a proxy flipping the bytes of a layer, keeping its length
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/blobs/"+corrupted.Digest.String()) {
+			return false
+		}
+		body := bytes.ToUpper([]byte("corrupted layer"))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+		return true
+	}
+
+	sociStore := newTestSociStore(t, ctx)
+	_, err := fake.registry(t).Pull(ctx, "repo", sociStore, "latest")
+	if !errors.Is(err, ErrContentDigestMismatch) {
+		t.Fatalf("Expected ErrContentDigestMismatch but got %v", err)
+	}
+	var mismatch *ContentDigestMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected a ContentDigestMismatchError but got %T", err)
+	}
+	if mismatch.Descriptor.Digest != corrupted.Digest || mismatch.Descriptor.Size != corrupted.Size {
+		t.Fatalf("Expected the corrupted layer %s to be named but got %s", corrupted.Digest, mismatch.Descriptor.Digest)
+	}
+	if !strings.Contains(err.Error(), corrupted.Digest.String()) {
+		t.Fatalf("Expected the error to name the corrupted layer but got %v", err)
+	}
+	if exists, _ := sociStore.Exists(ctx, corrupted); exists {
+		t.Fatalf("Expected the corrupted layer not to be stored")
+	}
+}
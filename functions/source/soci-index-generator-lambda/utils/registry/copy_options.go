@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+// Mutates the oras options of the copy of a Pull, e.g. to set MaxMetadataBytes or Concurrency
+type CopyOptionsMutator func(opts *oras.CopyOptions)
+
+// Mutates the oras options of the copy of a Push, e.g. to set MaxMetadataBytes or Concurrency
+type CopyGraphOptionsMutator func(opts *oras.CopyGraphOptions)
+
+// Finds the successors of a node of the graph a copy walks, such as the config and layers of an image manifest
+type FindSuccessorsFunc func(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor) ([]ocispec.Descriptor, error)
+
+// The successors a Pull copies by default: all the children of a node, as found by oras.
+// Custom FindSuccessorsFunc given with WithFindSuccessors can call it and filter what it returns.
+var DefaultFindSuccessors FindSuccessorsFunc = content.Successors
+
+// A copy hook of oras, such as PreCopy, PostCopy or OnCopySkipped
+type copyHook func(ctx context.Context, desc ocispec.Descriptor) error
+
+// Run the hook set by a caller, if any, before the hook of this package
+func chainCopyHooks(caller copyHook, own copyHook) copyHook {
+	if caller == nil {
+		return own
+	}
+	return func(ctx context.Context, desc ocispec.Descriptor) error {
+		if err := caller(ctx, desc); err != nil {
+			return err
+		}
+		return own(ctx, desc)
+	}
+}
+
+// Log each node a copy visits at the debug level, with its digest, media type and size, so that the copies of Pull
+// and Push read as a trace of what was copied, skipped or mounted. Leaves the options as they are at other levels.
+func traceCopyGraph(opts *oras.CopyGraphOptions) {
+	if !log.DebugEnabled() {
+		return
+	}
+	opts.PreCopy = traceCopyHook("Copying", opts.PreCopy)
+	opts.PostCopy = traceCopyHook("Copied", opts.PostCopy)
+	opts.OnCopySkipped = traceCopyHook("Skipped existing", opts.OnCopySkipped)
+	if opts.MountFrom != nil {
+		opts.OnMounted = traceCopyHook("Mounted", opts.OnMounted)
+	}
+}
+
+// Wrap a copy hook to log the node once the hook lets it through, or as skipped when the hook skips it
+func traceCopyHook(event string, hook copyHook) copyHook {
+	return func(ctx context.Context, desc ocispec.Descriptor) error {
+		var err error
+		if hook != nil {
+			err = hook(ctx, desc)
+		}
+		label := event
+		if errors.Is(err, oras.SkipNode) {
+			label = "Skipping"
+		} else if err != nil {
+			return err
+		}
+		log.Debug(ctx, fmt.Sprintf("%s %s (%s, %d bytes)", label, desc.Digest, desc.MediaType, desc.Size))
+		return err
+	}
+}
+
+// Return the FindSuccessors set by a caller, or the default of oras
+func findSuccessorsOrDefault(opts oras.CopyGraphOptions) FindSuccessorsFunc {
+	if opts.FindSuccessors != nil {
+		return opts.FindSuccessors
+	}
+	return DefaultFindSuccessors
+}
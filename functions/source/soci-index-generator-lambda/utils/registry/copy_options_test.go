@@ -0,0 +1,280 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+func TestPullWithCopyOptions(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-with-copy-options")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+	registry := fake.registry(t)
+
+	var mu sync.Mutex
+	copied := map[digest.Digest]bool{}
+	recordCopies := WithCopyOptions(func(opts *oras.CopyOptions) {
+		opts.PostCopy = func(ctx context.Context, desc ocispec.Descriptor) error {
+			mu.Lock()
+			defer mu.Unlock()
+			copied[desc.Digest] = true
+			return nil
+		}
+	})
+	pulled, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest", recordCopies)
+	if err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if len(copied) != 3 || !copied[image.Digest] || !copied[config.Digest] || !copied[layer.Digest] {
+		t.Fatalf("Expected the caller's PostCopy to see the manifest, config and layer but got %v", copied)
+	}
+	// the hooks of Pull still run
+	if expected := image.Size + config.Size + layer.Size; pulled.BytesCopied != expected {
+		t.Fatalf("Expected %d bytes to be copied but got %d", expected, pulled.BytesCopied)
+	}
+
+	limitMetadata := WithCopyOptions(func(opts *oras.CopyOptions) {
+		opts.MaxMetadataBytes = image.Size - 1
+	})
+	if _, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest", limitMetadata); err == nil {
+		t.Fatalf("Expected the pull to fail with a manifest larger than MaxMetadataBytes")
+	}
+}
+
+func TestPullWithFindSuccessors(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-with-find-successors")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	cache := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("huge cache layer"))
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer, cache), "latest")
+	registry := fake.registry(t)
+
+	excludeCache := WithFindSuccessors(func(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		successors, err := DefaultFindSuccessors(ctx, fetcher, desc)
+		if err != nil {
+			return nil, err
+		}
+		var kept []ocispec.Descriptor
+		for _, successor := range successors {
+			if successor.Digest != cache.Digest {
+				kept = append(kept, successor)
+			}
+		}
+		return kept, nil
+	})
+
+	doTest := func(opt PullOption, expectCache bool) {
+		sociStore := newTestSociStore(t, ctx)
+		fetches := fake.requestCount(http.MethodGet, "/blobs/"+cache.Digest.String())
+		if _, err := registry.Pull(ctx, "repo", sociStore, "latest", opt); err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+		fetched := fake.requestCount(http.MethodGet, "/blobs/"+cache.Digest.String()) > fetches
+		if fetched != expectCache {
+			t.Fatalf("Expected the cache layer to be fetched: %v but got %v", expectCache, fetched)
+		}
+		for _, desc := range []ocispec.Descriptor{config, layer, cache} {
+			expected := desc.Digest != cache.Digest || expectCache
+			if exists, err := sociStore.Exists(ctx, desc); err != nil || exists != expected {
+				t.Fatalf("Expected %s to be stored: %v but got %v (%v)", desc.Digest, expected, exists, err)
+			}
+		}
+	}
+
+	doTest(excludeCache, false)
+	// a nil FindSuccessors keeps the default, which copies every layer
+	doTest(WithFindSuccessors(nil), true)
+}
+
+func TestPushWithCopyGraphOptions(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-copy-graph-options")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc 1"), []byte("ztoc 2"))
+	excluded := digest.FromBytes([]byte("ztoc 2"))
+
+	// a custom FindSuccessors leaving out a blob, e.g. one known to be mounted separately
+	var mu sync.Mutex
+	var found []digest.Digest
+	excludeBlob := WithCopyGraphOptions(func(opts *oras.CopyGraphOptions) {
+		opts.FindSuccessors = func(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			mu.Lock()
+			found = append(found, desc.Digest)
+			mu.Unlock()
+			successors, err := content.Successors(ctx, fetcher, desc)
+			if err != nil {
+				return nil, err
+			}
+			var kept []ocispec.Descriptor
+			for _, successor := range successors {
+				if successor.Digest != excluded {
+					kept = append(kept, successor)
+				}
+			}
+			return kept, nil
+		}
+	})
+	result, err := registry.Push(ctx, sociStore, indexDesc, "repo", "", excludeBlob)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if len(found) == 0 || found[0] != indexDesc.Digest {
+		t.Fatalf("Expected the caller's FindSuccessors to be called with the index but got %v", found)
+	}
+	if fake.hasBlob("repo", excluded) {
+		t.Fatalf("Expected the excluded blob not to be pushed")
+	}
+	if !fake.hasBlob("repo", digest.FromBytes([]byte("ztoc 1"))) || !fake.hasManifest("repo", indexDesc.Digest) {
+		t.Fatalf("Expected the rest of the graph to be pushed")
+	}
+	// the config and the remaining ztoc
+	if result.BlobsUploaded != 2 {
+		t.Fatalf("Expected 2 blobs to be uploaded but got %d", result.BlobsUploaded)
+	}
+}
+
+func TestCopyGraphTrace(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-copy-graph-trace")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+	registry := fake.registry(t)
+
+	doTest := func(levelName string, copy func(), expectedLines ...string) string {
+		// the hooks of a copy run concurrently
+		buf := &lockedBuffer{}
+		restore := log.Redirect(buf, levelName)
+		copy()
+		restore()
+		trace := buf.String()
+		for _, expected := range expectedLines {
+			if !strings.Contains(trace, expected) {
+				t.Fatalf("Expected the trace to contain %q but got %s", expected, trace)
+			}
+		}
+		if len(expectedLines) == 0 && strings.Contains(trace, "bytes)") {
+			t.Fatalf("Expected no trace at level %s but got %s", levelName, trace)
+		}
+		return trace
+	}
+	pull := func() {
+		if _, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest"); err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+	}
+
+	doTest("debug", pull,
+		fmt.Sprintf("Copied %s (%s, %d bytes)", image.Digest, image.MediaType, image.Size),
+		fmt.Sprintf("Copied %s (%s, %d bytes)", config.Digest, config.MediaType, config.Size),
+		fmt.Sprintf("Copied %s (%s, %d bytes)", layer.Digest, layer.MediaType, layer.Size),
+	)
+	doTest("info", pull)
+
+	// a skipped node does not change the label of the nodes after it
+	skipConfig := WithCopyOptions(func(opts *oras.CopyOptions) {
+		opts.PreCopy = func(ctx context.Context, desc ocispec.Descriptor) error {
+			if desc.Digest == config.Digest {
+				return oras.SkipNode
+			}
+			return nil
+		}
+	})
+	pullSkippingConfig := func() {
+		if _, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest", skipConfig); err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+	}
+	trace := doTest("debug", pullSkippingConfig,
+		fmt.Sprintf("Skipping %s (%s, %d bytes)", config.Digest, config.MediaType, config.Size),
+		fmt.Sprintf("Copying %s (%s, %d bytes)", image.Digest, image.MediaType, image.Size),
+		fmt.Sprintf("Copying %s (%s, %d bytes)", layer.Digest, layer.MediaType, layer.Size),
+	)
+	if strings.Count(trace, "Skipping ") != 1 {
+		t.Fatalf("Expected only the config to be skipped but got %s", trace)
+	}
+
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	push := func() {
+		if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", ""); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	doTest("debug", push, fmt.Sprintf("Copying %s (%s, %d bytes)", indexDesc.Digest, indexDesc.MediaType, indexDesc.Size))
+	// the index is in the repository already
+	doTest("debug", push, fmt.Sprintf("Skipped existing %s (%s, %d bytes)", indexDesc.Digest, indexDesc.MediaType, indexDesc.Size))
+}
+
+// A bytes.Buffer safe to write from the concurrent hooks of a copy
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPushWithMaxMetadataBytes(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-max-metadata-bytes")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	doTest := func(expected int64, opts ...PushOption) {
+		t.Helper()
+		var applied int64
+		observe := WithCopyGraphOptions(func(opts *oras.CopyGraphOptions) {
+			applied = opts.MaxMetadataBytes
+		})
+		if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", "", append(opts, observe)...); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+		if applied != expected {
+			t.Fatalf("Expected MaxMetadataBytes %d but got %d", expected, applied)
+		}
+	}
+
+	doTest(DefaultMaxMetadataBytes)
+	doTest(64<<10, WithMaxMetadataBytes(64<<10))
+	doTest(DefaultMaxMetadataBytes, WithMaxMetadataBytes(0))
+
+	// an index larger than the limit fails the push without pushing it
+	largeDesc := storeTestIndex(t, ctx, sociStore, []byte("other ztoc"))
+	_, err := registry.Push(ctx, sociStore, largeDesc, "repo", "", WithMaxMetadataBytes(16))
+	if !errors.Is(err, ErrManifestTooLarge) {
+		t.Fatalf("Expected ErrManifestTooLarge but got %v", err)
+	}
+	if fake.hasManifest("repo", largeDesc.Digest) {
+		t.Fatalf("Expected the index not to be pushed")
+	}
+}
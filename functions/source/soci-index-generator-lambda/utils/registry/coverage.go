@@ -0,0 +1,110 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// How much of an image a SOCI index covers. Layers smaller than the builder's minimum layer size get no ztoc,
+// so a low ratio hints at lowering the minimum layer size.
+type IndexCoverage struct {
+	// Layers of the image with a ztoc in the index
+	CoveredLayers int
+	TotalLayers   int
+	// Bytes of the layers with a ztoc in the index
+	CoveredBytes int64
+	TotalBytes   int64
+}
+
+// Return the fraction of the image's layer bytes the index covers, 0 for an image without layers
+func (coverage IndexCoverage) Ratio() float64 {
+	if coverage.TotalBytes == 0 {
+		return 0
+	}
+	return float64(coverage.CoveredBytes) / float64(coverage.TotalBytes)
+}
+
+func (coverage *IndexCoverage) add(other IndexCoverage) {
+	coverage.CoveredLayers += other.CoveredLayers
+	coverage.TotalLayers += other.TotalLayers
+	coverage.CoveredBytes += other.CoveredBytes
+	coverage.TotalBytes += other.TotalBytes
+}
+
+// Compute the coverage of an image manifest by a SOCI index manifest, matching the layers
+// with the layer digests annotated on the index's ztocs
+func ComputeCoverage(image ocispec.Manifest, index ocispec.Manifest) IndexCoverage {
+	ztocs := ztocsByLayer(index)
+	coverage := IndexCoverage{TotalLayers: len(image.Layers)}
+	for _, layer := range image.Layers {
+		coverage.TotalBytes += layer.Size
+		if _, ok := ztocs[layer.Digest.String()]; ok {
+			coverage.CoveredLayers++
+			coverage.CoveredBytes += layer.Size
+		}
+	}
+	return coverage
+}
+
+// Compute the coverage of the images indexed by a SOCI index of the local store: either a V1 index manifest
+// referencing its image as subject, or a V2 image index whose image manifests are annotated with the digest
+// of their SOCI index. The coverage of a multi-platform image sums the coverage of its platforms.
+func StoreIndexCoverage(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor) (IndexCoverage, error) {
+	var coverage IndexCoverage
+	switch kindFromMediaType(indexDesc.MediaType) {
+	case ImageIndex:
+		var index ocispec.Index
+		if err := readStoreJSON(ctx, sociStore, indexDesc, DefaultMaxManifestSize, &index); err != nil {
+			return coverage, err
+		}
+		children := make(map[string]ocispec.Descriptor, len(index.Manifests))
+		for _, child := range index.Manifests {
+			children[child.Digest.String()] = child
+		}
+		for _, image := range index.Manifests {
+			sociIndexDigest, ok := image.Annotations[soci.ImageAnnotationSociIndexDigest]
+			if !ok {
+				continue
+			}
+			sociIndex, ok := children[sociIndexDigest]
+			if !ok {
+				return coverage, fmt.Errorf("SOCI index %s of image %s is missing from the image index", sociIndexDigest, image.Digest)
+			}
+			imageCoverage, err := storeImageCoverage(ctx, sociStore, image, sociIndex)
+			if err != nil {
+				return coverage, err
+			}
+			coverage.add(imageCoverage)
+		}
+		return coverage, nil
+	case ImageManifest:
+		var index ocispec.Manifest
+		if err := readStoreJSON(ctx, sociStore, indexDesc, DefaultMaxManifestSize, &index); err != nil {
+			return coverage, err
+		}
+		if index.Subject == nil {
+			return coverage, ErrMissingSubject
+		}
+		return storeImageCoverage(ctx, sociStore, *index.Subject, indexDesc)
+	default:
+		return coverage, fmt.Errorf("%w: %s", ErrNotImageManifest, indexDesc.MediaType)
+	}
+}
+
+func storeImageCoverage(ctx context.Context, sociStore *store.SociStore, imageDesc ocispec.Descriptor, indexDesc ocispec.Descriptor) (IndexCoverage, error) {
+	var image, index ocispec.Manifest
+	if err := readStoreJSON(ctx, sociStore, imageDesc, DefaultMaxManifestSize, &image); err != nil {
+		return IndexCoverage{}, fmt.Errorf("failed to read image manifest %s: %w", imageDesc.Digest, err)
+	}
+	if err := readStoreJSON(ctx, sociStore, indexDesc, DefaultMaxManifestSize, &index); err != nil {
+		return IndexCoverage{}, fmt.Errorf("failed to read SOCI index %s: %w", indexDesc.Digest, err)
+	}
+	return ComputeCoverage(image, index), nil
+}
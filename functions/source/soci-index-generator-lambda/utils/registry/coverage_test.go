@@ -0,0 +1,85 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// layerOf describes an image layer of the given size
+func layerOf(name string, size int64) ocispec.Descriptor {
+	return ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString(name), Size: size}
+}
+
+func TestComputeCoverage(t *testing.T) {
+	// the small layers are below the minimum layer size and get no ztoc
+	image := imageManifest(MediaTypeOCIImageConfig,
+		layerOf("large 1", 60<<20), layerOf("small 1", 1<<20), layerOf("large 2", 30<<20), layerOf("small 2", 9<<20))
+	index := sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("large 1", "ztoc 1"), ztocOf("large 2", "ztoc 2"))
+
+	coverage := ComputeCoverage(image, index)
+	expected := IndexCoverage{CoveredLayers: 2, TotalLayers: 4, CoveredBytes: 90 << 20, TotalBytes: 100 << 20}
+	if coverage != expected {
+		t.Fatalf("Expected coverage %+v but got %+v", expected, coverage)
+	}
+	if coverage.Ratio() != 0.9 {
+		t.Fatalf("Expected a coverage ratio of 0.9 but got %v", coverage.Ratio())
+	}
+	if (IndexCoverage{}).Ratio() != 0 {
+		t.Fatalf("Expected a coverage ratio of 0 for an image without layers")
+	}
+}
+
+func TestStoreIndexCoverage(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-store-index-coverage")
+	sociStore := newTestSociStore(t, ctx)
+	storeJSON := func(mediaType string, v interface{}) ocispec.Descriptor {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", mediaType, err)
+		}
+		return pushToStore(t, ctx, sociStore, mediaType, content)
+	}
+	image := imageManifest(MediaTypeOCIImageConfig, layerOf("large", 30<<20), layerOf("small", 10<<20))
+	imageDesc := storeJSON(MediaTypeOCIManifest, image)
+	expected := IndexCoverage{CoveredLayers: 1, TotalLayers: 2, CoveredBytes: 30 << 20, TotalBytes: 40 << 20}
+
+	// a V1 index references its image as subject
+	v1Index := sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("large", "ztoc"))
+	v1Index.Subject = &imageDesc
+	coverage, err := StoreIndexCoverage(ctx, sociStore, storeJSON(MediaTypeOCIManifest, v1Index))
+	if err != nil {
+		t.Fatalf("StoreIndexCoverage failed for a V1 index: %v", err)
+	}
+	if coverage != expected {
+		t.Fatalf("Expected coverage %+v of the V1 index but got %+v", expected, coverage)
+	}
+
+	// a V2 image index holds the images annotated with the digest of their index, and the indexes
+	v2IndexDesc := storeJSON(MediaTypeOCIManifest, sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("large", "ztoc")))
+	annotatedImage := imageDesc
+	annotatedImage.Annotations = map[string]string{soci.ImageAnnotationSociIndexDigest: v2IndexDesc.Digest.String()}
+	otherImage := image
+	otherImage.Annotations = map[string]string{"platform": "other"}
+	otherImageDesc := storeJSON(MediaTypeOCIManifest, otherImage)
+	otherImageDesc.Annotations = map[string]string{soci.ImageAnnotationSociIndexDigest: v2IndexDesc.Digest.String()}
+	imageIndex := ocispec.Index{
+		MediaType: MediaTypeOCIImageIndex,
+		Manifests: []ocispec.Descriptor{annotatedImage, otherImageDesc, v2IndexDesc},
+	}
+	imageIndex.SchemaVersion = 2
+	coverage, err = StoreIndexCoverage(ctx, sociStore, storeJSON(MediaTypeOCIImageIndex, imageIndex))
+	if err != nil {
+		t.Fatalf("StoreIndexCoverage failed for a V2 index: %v", err)
+	}
+	expected.add(expected)
+	if coverage != expected {
+		t.Fatalf("Expected coverage %+v of the V2 index but got %+v", expected, coverage)
+	}
+}
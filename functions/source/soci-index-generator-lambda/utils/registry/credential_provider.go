@@ -0,0 +1,32 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// CredentialProvider supplies the credentials of non ECR registries, e.g. from Secrets Manager or Vault.
+// It's consulted by host, i.e. the host and port of the registry, whenever the registry asks for authentication.
+// ECR registries keep their dedicated authorization, which refreshes the ECR authorization token.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, host string) (auth.Credential, error)
+}
+
+// CredentialProviderFunc adapts a function to a CredentialProvider
+type CredentialProviderFunc func(ctx context.Context, host string) (auth.Credential, error)
+
+func (f CredentialProviderFunc) Credentials(ctx context.Context, host string) (auth.Credential, error) {
+	return f(ctx, host)
+}
+
+// Return the credential function of the auth client of a non ECR registry, nil for anonymous access
+func credentialFunc(provider CredentialProvider) auth.CredentialFunc {
+	if provider == nil {
+		return nil
+	}
+	return provider.Credentials
+}
@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+func TestInitWithCredentialProvider(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-credential-provider")
+	fake := newFakeRegistry(t)
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if username, password, ok := r.BasicAuth(); ok && username == "user" && password == "secret" {
+			return false
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return true
+	}
+
+	var mu sync.Mutex
+	var hosts []string
+	provider := CredentialProviderFunc(func(ctx context.Context, host string) (auth.Credential, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		hosts = append(hosts, host)
+		if host == fake.host() {
+			return auth.Credential{Username: "user", Password: "secret"}, nil
+		}
+		return auth.EmptyCredential, nil
+	})
+
+	registry, err := Init(ctx, fake.host(), WithPlainHTTP(), WithCredentialProvider(provider))
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+		t.Fatalf("Expected the manifest to be found with the provided credentials, got: %v", err)
+	}
+	mu.Lock()
+	if len(hosts) == 0 || hosts[0] != fake.host() {
+		t.Fatalf("Expected the provider to be consulted for %s but got %v", fake.host(), hosts)
+	}
+	mu.Unlock()
+
+	// without the provider the registry is accessed anonymously
+	registry, err = Init(ctx, fake.host(), WithPlainHTTP())
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err == nil {
+		t.Fatalf("Expected the anonymous request to be unauthorized")
+	}
+
+	// the errors of the provider are surfaced
+	providerErr := errors.New("secret not found")
+	failing := CredentialProviderFunc(func(ctx context.Context, host string) (auth.Credential, error) {
+		return auth.EmptyCredential, providerErr
+	})
+	registry, err = Init(ctx, fake.host(), WithPlainHTTP(), WithCredentialProvider(failing))
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); !errors.Is(err, providerErr) {
+		t.Fatalf("Expected the error of the provider but got %v", err)
+	}
+}
+
+func TestInitEcrIgnoresCredentialProvider(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-ecr-ignores-credential-provider")
+	ecrClient := &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour)}
+	stubEcrClient(t, ecrClient)
+
+	consulted := false
+	provider := CredentialProviderFunc(func(ctx context.Context, host string) (auth.Credential, error) {
+		consulted = true
+		return auth.EmptyCredential, nil
+	})
+	registry, err := Init(ctx, testEcrRegistryUrl, WithCredentialProvider(provider))
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if registry.ecrCredentials == nil || ecrClient.tokenCalls() == 0 {
+		t.Fatalf("Expected ECR authorization to be used")
+	}
+	client := registry.registry.RepositoryOptions.Client.(*auth.Client)
+	if _, err := client.Credential(ctx, testEcrRegistryUrl); err != nil || consulted {
+		t.Fatalf("Expected the ECR credentials rather than the provider, got %v", err)
+	}
+}
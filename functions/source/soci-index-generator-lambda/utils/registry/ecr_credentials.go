@@ -0,0 +1,132 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// How long before its expiry an ECR authorization token is refreshed
+const ecrTokenRefreshMargin = 15 * time.Minute
+
+// The ECR API calls made by the registry package, implemented by the ECR client of the AWS SDK
+type ecrAPI interface {
+	GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+	DescribeImageScanFindings(ctx context.Context, params *ecr.DescribeImageScanFindingsInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error)
+	ListImages(ctx context.Context, params *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error)
+	DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error)
+}
+
+var _ ecrAPI = (*ecr.Client)(nil)
+
+// Create the ECR API client used for authorization, overridden in tests.
+// An empty region leaves the region to the default chain, and an empty profile the profile.
+var newEcrClient = func(ctx context.Context, region string, profile string, optFns ...func(*ecr.Options)) (ecrAPI, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, ecrConfigOptions(region, profile)...)
+	if err != nil {
+		return nil, err
+	}
+	ecrEndpoint := os.Getenv("ECR_ENDPOINT") // set this env var for custom, i.e. non default, aws ecr endpoint
+	if ecrEndpoint != "" {
+		optFns = append([]func(*ecr.Options){func(options *ecr.Options) {
+			options.BaseEndpoint = aws.String(ecrEndpoint)
+		}}, optFns...)
+	}
+	return ecr.NewFromConfig(cfg, optFns...), nil
+}
+
+// Return the options loading the config of the ECR API client.
+// A profile takes precedence over the AWS_PROFILE environment variable; the profile's credentials then take
+// precedence over the rest of the default chain, such as environment credentials or the Lambda execution role.
+func ecrConfigOptions(region string, profile string) []func(*config.LoadOptions) error {
+	var options []func(*config.LoadOptions) error
+	if region != "" {
+		options = append(options, config.WithRegion(region))
+	}
+	if profile != "" {
+		// the shared config file holds the profile's region, role and SSO settings
+		options = append(options, config.WithSharedConfigProfile(profile))
+	}
+	return options
+}
+
+// ECR credentials backing an oras auth.Client. The authorization token is cached and
+// re-fetched from ECR on demand once it is within ecrTokenRefreshMargin of its expiry.
+type ecrCredentials struct {
+	client ecrAPI
+
+	mu        sync.Mutex
+	username  string
+	password  string
+	expiresAt time.Time
+}
+
+// auth.CredentialFunc returning the cached ECR credential, refreshing it first if it nears expiry
+func (c *ecrCredentials) credential(ctx context.Context, hostport string) (auth.Credential, error) {
+	c.mu.Lock()
+	stale := c.password == "" || time.Until(c.expiresAt) < ecrTokenRefreshMargin
+	c.mu.Unlock()
+	if stale {
+		if err := c.refresh(ctx); err != nil {
+			return auth.EmptyCredential, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return auth.Credential{Username: c.username, Password: c.password}, nil
+}
+
+// Return the expiry time of the cached token
+func (c *ecrCredentials) expiry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expiresAt
+}
+
+// Fetch a new authorization token from ECR, giving up when ctx is done
+func (c *ecrCredentials) refresh(ctx context.Context) error {
+	getAuthorizationTokenResponse, err := c.client.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return err
+	}
+
+	if len(getAuthorizationTokenResponse.AuthorizationData) == 0 {
+		return errors.New("Couldn't authorize with ECR: empty authorization data returned")
+	}
+
+	authorizationData := getAuthorizationTokenResponse.AuthorizationData[0]
+	ecrAuthorizationToken := aws.ToString(authorizationData.AuthorizationToken)
+	if len(ecrAuthorizationToken) == 0 {
+		return errors.New("Couldn't authorize with ECR: empty authorization token returned")
+	}
+
+	// the token is the base64 encoding of "username:password"
+	decoded, err := base64.StdEncoding.DecodeString(ecrAuthorizationToken)
+	if err != nil {
+		return errors.New("Couldn't authorize with ECR: malformed authorization token returned")
+	}
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found || username == "" || password == "" {
+		return errors.New("Couldn't authorize with ECR: malformed authorization token returned")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = username
+	c.password = password
+	c.expiresAt = aws.ToTime(authorizationData.ExpiresAt)
+	return nil
+}
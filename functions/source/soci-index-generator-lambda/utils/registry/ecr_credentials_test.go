@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+func TestEcrCredentialsRefresh(t *testing.T) {
+	doTest := func(expiresAt time.Time, expectedPasswords []string, expectedCalls int) {
+		client := &fakeEcrClient{passwords: []string{"password-1", "password-2"}, expiresAt: expiresAt}
+		credentials := &ecrCredentials{client: client}
+
+		for i, expected := range expectedPasswords {
+			credential, err := credentials.credential(context.Background(), testEcrRegistryUrl)
+			if err != nil {
+				t.Fatalf("Credential call %d failed: %v", i, err)
+			}
+			if credential.Username != "AWS" || credential.Password != expected {
+				t.Fatalf("Incorrect credential on call %d. Expected AWS:%s but got %s:%s",
+					i, expected, credential.Username, credential.Password)
+			}
+		}
+		if client.tokenCalls() != expectedCalls {
+			t.Fatalf("Expected %d GetAuthorizationToken calls but got %d", expectedCalls, client.tokenCalls())
+		}
+	}
+
+	// a token about to expire is refreshed on every call
+	doTest(time.Now().Add(time.Minute), []string{"password-1", "password-2"}, 2)
+	// a fresh token is cached
+	doTest(time.Now().Add(12*time.Hour), []string{"password-1", "password-1"}, 1)
+}
+
+func TestInitCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(newTestContext("abcd-1234-test-init-canceled"), 50*time.Millisecond)
+	defer cancel()
+	stubEcrClient(t, &fakeEcrClient{hang: true})
+
+	start := time.Now()
+	_, err := Init(ctx, testEcrRegistryUrl)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the authorization to be interrupted by the deadline but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected Init to return promptly after the deadline, took %v", elapsed)
+	}
+}
+
+func TestInitWithProfile(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-profile")
+
+	doTest := func(expectedProfile string, opts ...Option) {
+		var profiles []string
+		original := newEcrClient
+		newEcrClient = func(ctx context.Context, region string, profile string, optFns ...func(*ecr.Options)) (ecrAPI, error) {
+			profiles = append(profiles, profile)
+			return &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour)}, nil
+		}
+		defer func() { newEcrClient = original }()
+
+		if _, err := Init(ctx, testEcrRegistryUrl, opts...); err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if len(profiles) != 1 || profiles[0] != expectedProfile {
+			t.Fatalf("Expected the ECR client to use profile %q, got %v", expectedProfile, profiles)
+		}
+
+		var options config.LoadOptions
+		for _, option := range ecrConfigOptions("us-west-2", expectedProfile) {
+			if err := option(&options); err != nil {
+				t.Fatalf("Failed to apply config option: %v", err)
+			}
+		}
+		if options.SharedConfigProfile != expectedProfile {
+			t.Fatalf("Expected config profile %q but got %q", expectedProfile, options.SharedConfigProfile)
+		}
+		if options.Region != "us-west-2" {
+			t.Fatalf("Expected config region us-west-2 but got %q", options.Region)
+		}
+	}
+
+	doTest("")
+	doTest("dev", WithProfile("dev"))
+}
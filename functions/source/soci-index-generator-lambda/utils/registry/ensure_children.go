@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+)
+
+var ErrIndexChildrenMissing = errors.New("children of the index are missing from the target repository")
+
+// How many times the children of an index are confirmed before giving up on the index
+const maxChildConfirmations = 3
+
+// Delay between two confirmations of the children of an index
+const defaultChildConfirmationDelay = time.Second
+
+// A PreCopy hook confirming that the target repository has every child of an image index before the index manifest is
+// PUT, pushing the missing children again. oras pushes the children first, but some registries only serve a manifest
+// some time after acknowledging it, and reject an index referencing manifests they don't serve yet.
+func (registry *Registry) ensureIndexChildren(sociStore *store.SociStore, repo oras.Target) func(ctx context.Context, desc ocispec.Descriptor) error {
+	return func(ctx context.Context, desc ocispec.Descriptor) error {
+		if kindFromMediaType(desc.MediaType) != ImageIndex {
+			return nil
+		}
+		var index ocispec.Index
+		if err := readStoreJSON(ctx, sociStore, desc, DefaultMaxManifestSize, &index); err != nil {
+			return err
+		}
+		for confirmation := 1; ; confirmation++ {
+			var missing []ocispec.Descriptor
+			for _, child := range index.Manifests {
+				exists, err := repo.Exists(ctx, child)
+				if err != nil {
+					return fmt.Errorf("failed to confirm child %s of index %s: %w", child.Digest, desc.Digest, err)
+				}
+				if !exists {
+					missing = append(missing, child)
+				}
+			}
+			if len(missing) == 0 {
+				return nil
+			}
+			if confirmation >= maxChildConfirmations {
+				return fmt.Errorf("%w: %d children of index %s, e.g. %s", ErrIndexChildrenMissing, len(missing), desc.Digest, missing[0].Digest)
+			}
+
+			log.Warn(ctx, fmt.Sprintf("%d children of index %s are missing from the target repository, pushing them again (confirmation %d of %d)",
+				len(missing), desc.Digest, confirmation, maxChildConfirmations))
+			for _, child := range missing {
+				// children not in the local store, e.g. the image manifests of an index referencing an image, are
+				// left to the registry
+				local, err := sociStore.Exists(ctx, child)
+				if err != nil {
+					return err
+				}
+				if !local {
+					continue
+				}
+				if err := oras.CopyGraph(ctx, sociStore, repo, child, oras.DefaultCopyGraphOptions); err != nil {
+					return fmt.Errorf("failed to push child %s of index %s: %w", child.Digest, desc.Digest, err)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(registry.config.childConfirmationDelay):
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// strictRegistry rejects an index referencing manifests it doesn't serve, and acknowledges the first PUT of each
+// child manifest without storing it, like a registry only serving a manifest some time after acknowledging it
+func strictRegistry(t *testing.T, fake *fakeRegistry, children []ocispec.Descriptor, dropped int) func() int {
+	var mu sync.Mutex
+	drops := map[digest.Digest]int{}
+	rejected := 0
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+			return false
+		}
+		for _, child := range children {
+			if !strings.HasSuffix(r.URL.Path, "/manifests/"+child.Digest.String()) {
+				continue
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if drops[child.Digest] < dropped {
+				drops[child.Digest]++
+				w.Header().Set("Docker-Content-Digest", child.Digest.String())
+				w.WriteHeader(http.StatusCreated)
+				return true
+			}
+			return false
+		}
+		if r.Header.Get("Content-Type") != MediaTypeOCIImageIndex {
+			return false
+		}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read the index: %v", err)
+			return false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(content))
+		var index ocispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			t.Errorf("Failed to decode the index: %v", err)
+			return false
+		}
+		for _, child := range index.Manifests {
+			if !fake.hasManifest("repo", child.Digest) {
+				mu.Lock()
+				rejected++
+				mu.Unlock()
+				writeRegistryError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "manifest references unknown manifest "+child.Digest.String())
+				return true
+			}
+		}
+		return false
+	}
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return rejected
+	}
+}
+
+func TestPushEnsuresIndexChildren(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-ensures-index-children")
+	sociStore := newTestSociStore(t, ctx)
+	first := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	second := storeTestIndex(t, ctx, sociStore, []byte("other ztoc"))
+	index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{first, second}}
+	index.SchemaVersion = 2
+	content, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	indexDesc := pushToStore(t, ctx, sociStore, MediaTypeOCIImageIndex, content)
+
+	// the children acknowledged but not served are pushed again before the index
+	fake := newFakeRegistry(t)
+	rejected := strictRegistry(t, fake, index.Manifests, 1)
+	registry := fake.registry(t)
+	registry.config.childConfirmationDelay = time.Millisecond
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", ""); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	for _, desc := range []ocispec.Descriptor{first, second, indexDesc} {
+		if !fake.hasManifest("repo", desc.Digest) {
+			t.Fatalf("Expected %s to be pushed", desc.Digest)
+		}
+	}
+	if rejected() != 0 {
+		t.Fatalf("Expected the index to be PUT once its children are present, got %d rejections", rejected())
+	}
+
+	// children that never show up fail the push without PUTting the index
+	fake = newFakeRegistry(t)
+	rejected = strictRegistry(t, fake, index.Manifests, maxChildConfirmations+1)
+	registry = fake.registry(t)
+	registry.config.childConfirmationDelay = time.Millisecond
+	_, err = registry.Push(ctx, sociStore, indexDesc, "repo", "")
+	if !errors.Is(err, ErrIndexChildrenMissing) {
+		t.Fatalf("Expected ErrIndexChildrenMissing but got %v", err)
+	}
+	if fake.hasManifest("repo", indexDesc.Digest) || rejected() != 0 {
+		t.Fatalf("Expected the index not to be PUT")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Compare a SOCI index of the repository against a freshly generated one of the local store, e.g. to decide
+// whether the existing index needs to be rebuilt. The indexes are equivalent when they have the same artifact type
+// and subject, and index the same layers with the same ztocs. Since a ztoc is derived from its layer and the span
+// size, a different ztoc for the same layer means the index was built with another span size or soci version.
+// Annotations, e.g. build timestamps, are ignored. The differences are summarized in a single line.
+func (registry *Registry) IndexesEquivalent(ctx context.Context, repositoryName string, existingDigest string, sociStore *store.SociStore, candidateDesc ocispec.Descriptor) (bool, string, error) {
+	equivalent, diff, err := registry.indexesEquivalent(ctx, repositoryName, existingDigest, sociStore, candidateDesc)
+	return equivalent, diff, registry.wrapError("compare index", repositoryName, existingDigest, err)
+}
+
+func (registry *Registry) indexesEquivalent(ctx context.Context, repositoryName string, existingDigest string, sociStore *store.SociStore, candidateDesc ocispec.Descriptor) (bool, string, error) {
+	if _, err := ParseDigest(existingDigest); err != nil {
+		return false, "", err
+	}
+	existing, err := registry.getManifest(ctx, repositoryName, existingDigest)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to fetch the existing index: %w", err)
+	}
+	var candidate ocispec.Manifest
+	if err := readStoreJSON(ctx, sociStore, candidateDesc, registry.config.maxManifestSize, &candidate); err != nil {
+		return false, "", fmt.Errorf("failed to read the candidate index: %w", err)
+	}
+
+	diffs := diffIndexes(existing, candidate)
+	return len(diffs) == 0, strings.Join(diffs, "; "), nil
+}
+
+// List the differences between two SOCI indexes, ignoring their annotations
+func diffIndexes(existing ocispec.Manifest, candidate ocispec.Manifest) []string {
+	var diffs []string
+	if existing.ArtifactType != candidate.ArtifactType {
+		diffs = append(diffs, fmt.Sprintf("artifact type %q != %q", existing.ArtifactType, candidate.ArtifactType))
+	}
+	if subjectDigest(existing) != subjectDigest(candidate) {
+		diffs = append(diffs, fmt.Sprintf("subject %q != %q", subjectDigest(existing), subjectDigest(candidate)))
+	}
+
+	existingZtocs := ztocsByLayer(existing)
+	candidateZtocs := ztocsByLayer(candidate)
+	var layers []string
+	for layer := range existingZtocs {
+		layers = append(layers, layer)
+	}
+	for layer := range candidateZtocs {
+		if _, ok := existingZtocs[layer]; !ok {
+			layers = append(layers, layer)
+		}
+	}
+	sort.Strings(layers)
+	for _, layer := range layers {
+		existingZtoc, inExisting := existingZtocs[layer]
+		candidateZtoc, inCandidate := candidateZtocs[layer]
+		switch {
+		case !inCandidate:
+			diffs = append(diffs, fmt.Sprintf("layer %s is only indexed by the existing index", layer))
+		case !inExisting:
+			diffs = append(diffs, fmt.Sprintf("layer %s is only indexed by the candidate index", layer))
+		case existingZtoc != candidateZtoc:
+			diffs = append(diffs, fmt.Sprintf("layer %s has ztoc %s != %s", layer, existingZtoc, candidateZtoc))
+		}
+	}
+	return diffs
+}
+
+// Map the digests of the layers indexed by a SOCI index to the digests of their ztocs
+func ztocsByLayer(manifest ocispec.Manifest) map[string]string {
+	ztocs := make(map[string]string, len(manifest.Layers))
+	for _, ztoc := range manifest.Layers {
+		layer, ok := ztoc.Annotations[soci.IndexAnnotationImageLayerDigest]
+		if !ok {
+			continue
+		}
+		ztocs[layer] = ztoc.Digest.String()
+	}
+	return ztocs
+}
+
+func subjectDigest(manifest ocispec.Manifest) string {
+	if manifest.Subject == nil {
+		return ""
+	}
+	return manifest.Subject.Digest.String()
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ztocOf describes the ztoc of a layer as a SOCI index layer
+func ztocOf(layer string, ztoc string) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType:   soci.SociLayerMediaType,
+		Digest:      digest.FromString(ztoc),
+		Size:        int64(len(ztoc)),
+		Annotations: map[string]string{soci.IndexAnnotationImageLayerDigest: digest.FromString(layer).String()},
+	}
+}
+
+func sociIndexManifest(created string, ztocs ...ocispec.Descriptor) ocispec.Manifest {
+	manifest := ocispec.Manifest{
+		MediaType:    MediaTypeOCIManifest,
+		ArtifactType: soci.SociIndexArtifactTypeV2,
+		Config:       ocispec.DescriptorEmptyJSON,
+		Layers:       ztocs,
+		Annotations:  map[string]string{ocispec.AnnotationCreated: created},
+	}
+	manifest.SchemaVersion = 2
+	return manifest
+}
+
+func TestIndexesEquivalent(t *testing.T) {
+	doTest := func(existing ocispec.Manifest, candidate ocispec.Manifest, expectedEquivalent bool, expectedDiff string) {
+		ctx := newTestContext("abcd-1234-test-indexes-equivalent")
+		fake := newFakeRegistry(t)
+		existingDesc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, existing)
+		sociStore := newTestSociStore(t, ctx)
+		content, err := json.Marshal(candidate)
+		if err != nil {
+			t.Fatalf("Failed to marshal the candidate index: %v", err)
+		}
+		candidateDesc := pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, content)
+
+		equivalent, diff, err := fake.registry(t).IndexesEquivalent(ctx, "repo", existingDesc.Digest.String(), sociStore, candidateDesc)
+		if err != nil {
+			t.Fatalf("IndexesEquivalent failed: %v", err)
+		}
+		if equivalent != expectedEquivalent {
+			t.Fatalf("Expected equivalent to be %v but got %v (%s)", expectedEquivalent, equivalent, diff)
+		}
+		if expectedDiff == "" && diff != "" {
+			t.Fatalf("Expected no differences but got %q", diff)
+		}
+		if !strings.Contains(diff, expectedDiff) {
+			t.Fatalf("Expected the differences to contain %q but got %q", expectedDiff, diff)
+		}
+	}
+
+	// equal up to the creation timestamp
+	doTest(
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1"), ztocOf("layer 2", "ztoc 2")),
+		sociIndexManifest("2024-06-01T00:00:00Z", ztocOf("layer 1", "ztoc 1"), ztocOf("layer 2", "ztoc 2")),
+		true, "")
+	// another span size yields other ztocs for the same layers
+	doTest(
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1"), ztocOf("layer 2", "ztoc 2")),
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1 with 1MiB spans"), ztocOf("layer 2", "ztoc 2")),
+		false, "layer "+digest.FromString("layer 1").String()+" has ztoc")
+	// a layer is no longer indexed, another one is
+	doTest(
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1"), ztocOf("layer 2", "ztoc 2")),
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1"), ztocOf("layer 3", "ztoc 3")),
+		false, "layer "+digest.FromString("layer 2").String()+" is only indexed by the existing index")
+	doTest(
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1")),
+		sociIndexManifest("2024-01-01T00:00:00Z", ztocOf("layer 1", "ztoc 1"), ztocOf("layer 3", "ztoc 3")),
+		false, "layer "+digest.FromString("layer 3").String()+" is only indexed by the candidate index")
+}
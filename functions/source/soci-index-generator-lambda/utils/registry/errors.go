@@ -0,0 +1,36 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Prefix an error returned by a Registry method with the operation and the artifact it failed on,
+// e.g. "pull 123456789012.dkr.ecr.us-west-1.amazonaws.com/repo:tag: ...". The error stays matchable
+// with errors.Is and errors.As.
+func (registry *Registry) wrapError(operation string, repositoryName string, reference string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %w", operation, registry.artifactName(repositoryName, reference), err)
+}
+
+// Return the full name of an artifact, with the reference appended as a digest or a tag.
+// No repository name means the registry itself.
+func (registry *Registry) artifactName(repositoryName string, reference string) string {
+	name := registry.registry.Reference.Registry
+	if repositoryName != "" {
+		name += "/" + repositoryName
+	}
+	if reference == "" {
+		return name
+	}
+	if _, err := digest.Parse(reference); err == nil {
+		return name + "@" + reference
+	}
+	return name + ":" + reference
+}
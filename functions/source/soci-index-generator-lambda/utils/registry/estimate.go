@@ -0,0 +1,106 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The download size of an image, as announced by its manifests
+type SizeEstimate struct {
+	// Total compressed size of the config and layer blobs, in bytes, without the foreign layers Pull skips
+	Bytes int64
+	// Number of image layers, whether uncompressed, gzip or zstd compressed
+	Layers int
+}
+
+// Estimate how much Pull would download for an image without fetching any blob, from its manifests only.
+// For an image index the sizes of the child manifests matching one of the platforms are summed;
+// no platform means every child manifest.
+func (registry *Registry) EstimateSize(ctx context.Context, repositoryName string, reference string, platformList ...ocispec.Platform) (SizeEstimate, error) {
+	estimate, err := registry.estimateSize(ctx, repositoryName, reference, platformList...)
+	return estimate, registry.wrapError("estimate size", repositoryName, reference, err)
+}
+
+func (registry *Registry) estimateSize(ctx context.Context, repositoryName string, reference string, platformList ...ocispec.Platform) (SizeEstimate, error) {
+	descriptor, err := registry.headManifest(ctx, repositoryName, reference)
+	if err != nil {
+		return SizeEstimate{}, err
+	}
+
+	switch kindFromMediaType(descriptor.MediaType) {
+	case ImageManifest:
+		return registry.estimateManifestSize(ctx, repositoryName, descriptor.Digest.String())
+	case ImageIndex:
+		content, _, err := registry.getManifestRaw(ctx, repositoryName, descriptor.Digest.String())
+		if err != nil {
+			return SizeEstimate{}, err
+		}
+		var index ocispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return SizeEstimate{}, err
+		}
+
+		children, err := registry.indexLeaves(ctx, repositoryName, index)
+		if err != nil {
+			return SizeEstimate{}, err
+		}
+
+		var total SizeEstimate
+		for _, child := range children {
+			if kindFromMediaType(child.MediaType) != ImageManifest || !matchesAnyPlatform(child.Platform, platformList) {
+				continue
+			}
+			estimate, err := registry.estimateManifestSize(ctx, repositoryName, child.Digest.String())
+			if err != nil {
+				return SizeEstimate{}, err
+			}
+			total.Bytes += estimate.Bytes
+			total.Layers += estimate.Layers
+		}
+		return total, nil
+	default:
+		return SizeEstimate{}, fmt.Errorf("cannot estimate the size of media type %s", descriptor.MediaType)
+	}
+}
+
+func (registry *Registry) estimateManifestSize(ctx context.Context, repositoryName string, digest string) (SizeEstimate, error) {
+	manifest, err := registry.getManifest(ctx, repositoryName, digest)
+	if err != nil {
+		return SizeEstimate{}, err
+	}
+	estimate := SizeEstimate{Bytes: manifest.Config.Size}
+	for _, layer := range manifest.Layers {
+		// Pull skips foreign layers, e.g. the base layers of Windows images
+		if IsForeignLayerMediaType(layer.MediaType) {
+			continue
+		}
+		estimate.Bytes += layer.Size
+		if IsImageLayerMediaType(layer.MediaType) {
+			estimate.Layers++
+		}
+	}
+	return estimate, nil
+}
+
+// Check if a platform matches one of the platform list. Every platform, including a missing one, matches an empty list.
+func matchesAnyPlatform(platform *ocispec.Platform, platformList []ocispec.Platform) bool {
+	if len(platformList) == 0 {
+		return true
+	}
+	if platform == nil {
+		return false
+	}
+	for _, candidate := range platformList {
+		if platforms.NewMatcher(candidate).Match(*platform) {
+			return true
+		}
+	}
+	return false
+}
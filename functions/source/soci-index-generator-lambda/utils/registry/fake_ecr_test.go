@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+const testEcrRegistryUrl = "123456789012.dkr.ecr.us-west-2.amazonaws.com"
+
+// fakeEcrClient stubs the ECR API calls made by the registry package
+type fakeEcrClient struct {
+	mu        sync.Mutex
+	passwords []string // one per GetAuthorizationToken call, the last one is repeated
+	expiresAt time.Time
+	calls     int
+	// hang, when set, makes every call hang until its context is done, like an unresponsive endpoint
+	hang bool
+	// scanFindings is returned by DescribeImageScanFindings, unless scanErr is set
+	scanFindings *ecr.DescribeImageScanFindingsOutput
+	scanErr      error
+	scanInputs   []*ecr.DescribeImageScanFindingsInput
+	// images is returned by ListImages, in pages of listImagesPageSize
+	images []types.ImageIdentifier
+	// describeImagesErr is returned by DescribeImages
+	describeImagesErr error
+	// describeImagesMisses is the number of DescribeImages calls failing with ImageNotFoundException before the image
+	// is found, like an image being replicated
+	describeImagesMisses int
+	describeImagesInputs []*ecr.DescribeImagesInput
+}
+
+const listImagesPageSize = 2
+
+var _ ecrAPI = (*fakeEcrClient)(nil)
+
+func (c *fakeEcrClient) GetAuthorizationToken(ctx context.Context, input *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	if c.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	password := c.passwords[min(c.calls, len(c.passwords)-1)]
+	c.calls++
+	token := base64.StdEncoding.EncodeToString([]byte("AWS:" + password))
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []types.AuthorizationData{{
+			AuthorizationToken: aws.String(token),
+			ExpiresAt:          aws.Time(c.expiresAt),
+		}},
+	}, nil
+}
+
+func (c *fakeEcrClient) DescribeImageScanFindings(ctx context.Context, input *ecr.DescribeImageScanFindingsInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scanInputs = append(c.scanInputs, input)
+	if c.scanErr != nil {
+		return nil, c.scanErr
+	}
+	return c.scanFindings, nil
+}
+
+func (c *fakeEcrClient) ListImages(ctx context.Context, input *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error) {
+	start := 0
+	if input.NextToken != nil {
+		start, _ = strconv.Atoi(*input.NextToken)
+	}
+	end := min(start+listImagesPageSize, len(c.images))
+	output := &ecr.ListImagesOutput{ImageIds: c.images[start:end]}
+	if end < len(c.images) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func (c *fakeEcrClient) DescribeImages(ctx context.Context, input *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.describeImagesInputs = append(c.describeImagesInputs, input)
+	if c.describeImagesErr != nil {
+		return nil, c.describeImagesErr
+	}
+	if len(c.describeImagesInputs) <= c.describeImagesMisses {
+		return nil, &types.ImageNotFoundException{Message: aws.String("image not found")}
+	}
+	return &ecr.DescribeImagesOutput{}, nil
+}
+
+func (c *fakeEcrClient) tokenCalls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// stubEcrClient makes the registry package use the given ECR client for the duration of the test.
+// The regions the client is created for are recorded in the returned slice.
+func stubEcrClient(t *testing.T, client ecrAPI) *[]string {
+	var regions []string
+	original := newEcrClient
+	newEcrClient = func(ctx context.Context, region string, profile string, optFns ...func(*ecr.Options)) (ecrAPI, error) {
+		regions = append(regions, region)
+		return client, nil
+	}
+	t.Cleanup(func() {
+		newEcrClient = original
+	})
+	return &regions
+}
@@ -0,0 +1,464 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// fakeRegistry is a minimal in-memory OCI distribution registry served over plain HTTP.
+// It supports enough of the distribution API for oras-go to resolve, fetch, push and tag content.
+type fakeRegistry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string]fakeManifest // key: repository@digest
+	tags      map[string]string       // key: repository:tag, value: digest
+	blobs     map[string][]byte       // key: repository@digest
+	uploads   int
+	requests  []string // "METHOD /path" of every request received
+
+	// referrersPageSize, when positive, splits referrers listings into pages linked with a Link header
+	referrersPageSize int
+	// ignoreReferrersFilter makes referrers listings ignore the artifactType filter, as some registries do
+	ignoreReferrersFilter bool
+
+	// intercept, when set, is consulted before the default handling of every request.
+	// Returning true means the request has been fully handled.
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+func newFakeRegistry(t testing.TB) *fakeRegistry {
+	f := &fakeRegistry{
+		manifests: map[string]fakeManifest{},
+		tags:      map[string]string{},
+		blobs:     map[string][]byte{},
+	}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// host returns the host:port of the fake registry
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// registry returns a Registry client talking to the fake registry over plain HTTP
+func (f *fakeRegistry) registry(t *testing.T, opts ...Option) *Registry {
+	reg, err := remote.NewRegistry(f.host())
+	if err != nil {
+		t.Fatalf("Failed to create registry client: %v", err)
+	}
+	reg.PlainHTTP = true
+	reg.RepositoryOptions.ManifestMediaTypes = ManifestMediaTypes
+	config := newRegistryConfig(opts)
+	return &Registry{registry: reg, config: config, resolveCache: newResolveCache(config)}
+}
+
+// putManifest stores a manifest under the given digest algorithm and tags, returning its descriptor
+func (f *fakeRegistry) putManifest(repo string, mediaType string, content []byte, algorithm digest.Algorithm, tags ...string) ocispec.Descriptor {
+	dgst := algorithm.FromBytes(content)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.manifests[repo+"@"+dgst.String()] = fakeManifest{mediaType: mediaType, content: content}
+	for _, tag := range tags {
+		f.tags[repo+":"+tag] = dgst.String()
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+}
+
+// putJSONManifest marshals v and stores it as a sha256 manifest
+func (f *fakeRegistry) putJSONManifest(t testing.TB, repo string, mediaType string, v interface{}, tags ...string) ocispec.Descriptor {
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	return f.putManifest(repo, mediaType, content, digest.SHA256, tags...)
+}
+
+// putBlob stores a blob in the given repository, returning its descriptor
+func (f *fakeRegistry) putBlob(repo string, mediaType string, content []byte) ocispec.Descriptor {
+	dgst := digest.FromBytes(content)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[repo+"@"+dgst.String()] = content
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+}
+
+func (f *fakeRegistry) hasBlob(repo string, dgst digest.Digest) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.blobs[repo+"@"+dgst.String()]
+	return ok
+}
+
+func (f *fakeRegistry) hasManifest(repo string, dgst digest.Digest) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.manifests[repo+"@"+dgst.String()]
+	return ok
+}
+
+// tagged returns the digest a tag points at, or an empty string
+func (f *fakeRegistry) tagged(repo string, tag string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tags[repo+":"+tag]
+}
+
+// requestCount returns the number of received requests matching the method and path substring
+func (f *fakeRegistry) requestCount(method string, pathContains string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, method+" ") && strings.Contains(request, pathContains) {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	intercept := f.intercept
+	f.mu.Unlock()
+
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	path := r.URL.Path
+	if path == "/v2/" || path == "/v2" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !strings.HasPrefix(path, "/v2/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rest := strings.TrimPrefix(path, "/v2/")
+
+	if i := strings.LastIndex(rest, "/manifests/"); i >= 0 {
+		f.serveManifest(w, r, rest[:i], rest[i+len("/manifests/"):])
+		return
+	}
+	if strings.HasSuffix(rest, "/tags/list") {
+		f.serveTags(w, r, strings.TrimSuffix(rest, "/tags/list"))
+		return
+	}
+	if i := strings.LastIndex(rest, "/referrers/"); i >= 0 {
+		f.serveReferrers(w, r, rest[:i], rest[i+len("/referrers/"):])
+		return
+	}
+	if i := strings.LastIndex(rest, "/blobs/uploads/"); i >= 0 {
+		f.serveUpload(w, r, rest[:i])
+		return
+	}
+	if i := strings.LastIndex(rest, "/blobs/"); i >= 0 {
+		f.serveBlob(w, r, rest[:i], rest[i+len("/blobs/"):])
+		return
+	}
+	writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", "unknown path")
+}
+
+func (f *fakeRegistry) serveManifest(w http.ResponseWriter, r *http.Request, repo string, reference string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f.mu.Lock()
+		dgst := reference
+		if _, err := digest.Parse(reference); err != nil {
+			dgst = f.tags[repo+":"+reference]
+		}
+		manifest, ok := f.manifests[repo+"@"+dgst]
+		f.mu.Unlock()
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest.content)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(manifest.content)
+		}
+	case http.MethodPut:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		algorithm := digest.SHA256
+		if d, err := digest.Parse(reference); err == nil {
+			algorithm = d.Algorithm()
+		}
+		desc := f.putManifest(repo, r.Header.Get("Content-Type"), content, algorithm)
+		if _, err := digest.Parse(reference); err != nil {
+			f.mu.Lock()
+			f.tags[repo+":"+reference] = desc.Digest.String()
+			f.mu.Unlock()
+		}
+		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.manifests, repo+"@"+reference)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveTags lists the tags of a repository in a single page
+func (f *fakeRegistry) serveTags(w http.ResponseWriter, r *http.Request, repo string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	tags := []string{}
+	f.mu.Lock()
+	for key := range f.tags {
+		if tag, ok := strings.CutPrefix(key, repo+":"); ok {
+			tags = append(tags, tag)
+		}
+	}
+	f.mu.Unlock()
+	sort.Strings(tags)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags})
+}
+
+// serveReferrers lists the manifests of a repository whose subject is the given digest,
+// filtered by the artifactType query parameter when present
+func (f *fakeRegistry) serveReferrers(w http.ResponseWriter, r *http.Request, repo string, dgst string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	artifactType := r.URL.Query().Get("artifactType")
+	if f.ignoreReferrersFilter {
+		artifactType = ""
+	}
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{}}
+	index.SchemaVersion = 2
+
+	f.mu.Lock()
+	for key, manifest := range f.manifests {
+		if !strings.HasPrefix(key, repo+"@") {
+			continue
+		}
+		var referrer struct {
+			ArtifactType string              `json:"artifactType"`
+			Config       ocispec.Descriptor  `json:"config"`
+			Subject      *ocispec.Descriptor `json:"subject"`
+			Annotations  map[string]string   `json:"annotations"`
+		}
+		if err := json.Unmarshal(manifest.content, &referrer); err != nil || referrer.Subject == nil || referrer.Subject.Digest.String() != dgst {
+			continue
+		}
+		if referrer.ArtifactType == "" {
+			referrer.ArtifactType = referrer.Config.MediaType
+		}
+		if artifactType != "" && referrer.ArtifactType != artifactType {
+			continue
+		}
+		index.Manifests = append(index.Manifests, ocispec.Descriptor{
+			MediaType:    manifest.mediaType,
+			ArtifactType: referrer.ArtifactType,
+			Digest:       digest.Digest(strings.TrimPrefix(key, repo+"@")),
+			Size:         int64(len(manifest.content)),
+			Annotations:  referrer.Annotations,
+		})
+	}
+	pageSize := f.referrersPageSize
+	f.mu.Unlock()
+
+	// pages are ordered by digest, the next page starting after the last digest of the previous one
+	sort.Slice(index.Manifests, func(i, j int) bool { return index.Manifests[i].Digest < index.Manifests[j].Digest })
+	if last := r.URL.Query().Get("last"); last != "" {
+		start := sort.Search(len(index.Manifests), func(i int) bool { return index.Manifests[i].Digest.String() > last })
+		index.Manifests = index.Manifests[start:]
+	}
+	if pageSize > 0 && len(index.Manifests) > pageSize {
+		index.Manifests = index.Manifests[:pageSize]
+		next := url.Values{"last": {index.Manifests[pageSize-1].Digest.String()}}
+		if query := r.URL.Query().Get("artifactType"); query != "" {
+			next.Set("artifactType", query)
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+	json.NewEncoder(w).Encode(index)
+}
+
+func (f *fakeRegistry) serveBlob(w http.ResponseWriter, r *http.Request, repo string, dgst string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f.mu.Lock()
+		content, ok := f.blobs[repo+"@"+dgst]
+		f.mu.Unlock()
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.blobs, repo+"@"+dgst)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeRegistry) serveUpload(w http.ResponseWriter, r *http.Request, repo string) {
+	switch r.Method {
+	case http.MethodPost:
+		if mount, from := r.URL.Query().Get("mount"), r.URL.Query().Get("from"); mount != "" && from != "" {
+			f.mu.Lock()
+			content, ok := f.blobs[from+"@"+mount]
+			if ok {
+				f.blobs[repo+"@"+mount] = content
+			}
+			f.mu.Unlock()
+			if ok {
+				w.Header().Set("Location", "/v2/"+repo+"/blobs/"+mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		f.mu.Lock()
+		f.uploads++
+		id := f.uploads
+		f.mu.Unlock()
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", repo, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeRegistryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+			return
+		}
+		expected, err := digest.Parse(r.URL.Query().Get("digest"))
+		if err != nil || expected.Algorithm().FromBytes(content) != expected {
+			writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest invalid")
+			return
+		}
+		f.mu.Lock()
+		f.blobs[repo+"@"+expected.String()] = content
+		f.mu.Unlock()
+		w.Header().Set("Docker-Content-Digest", expected.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, message)
+}
+
+// newTestContext returns a context carrying a Lambda context, which the log package requires
+func newTestContext(requestId string) context.Context {
+	lc := lambdacontext.LambdaContext{}
+	lc.AwsRequestID = requestId
+	return lambdacontext.NewContext(context.Background(), &lc)
+}
+
+// imageManifest returns a minimal image manifest with the given config media type and layers
+func imageManifest(configMediaType string, layers ...ocispec.Descriptor) ocispec.Manifest {
+	manifest := ocispec.Manifest{
+		MediaType: MediaTypeOCIManifest,
+		Config: ocispec.Descriptor{
+			MediaType: configMediaType,
+			Digest:    digest.FromString("{}"),
+			Size:      2,
+		},
+		Layers: layers,
+	}
+	manifest.SchemaVersion = 2
+	return manifest
+}
+
+// newTestSociStore returns an empty SOCI store in a temporary directory
+func newTestSociStore(t *testing.T, ctx context.Context) *store.SociStore {
+	ociStore, err := oci.NewWithContext(ctx, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create OCI store: %v", err)
+	}
+	return &store.SociStore{Store: ociStore}
+}
+
+// pushToStore writes content into a local store, returning its descriptor. Content already in the store is kept.
+func pushToStore(t *testing.T, ctx context.Context, sociStore *store.SociStore, mediaType string, content []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(content), Size: int64(len(content))}
+	if err := sociStore.Push(ctx, desc, bytes.NewReader(content)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		t.Fatalf("Failed to push %s to local store: %v", desc.Digest, err)
+	}
+	return desc
+}
+
+// storeTestIndex writes a minimal SOCI-like index manifest, its config and the given blobs into a local store,
+// returning the index manifest descriptor
+func storeTestIndex(t *testing.T, ctx context.Context, sociStore *store.SociStore, blobs ...[]byte) ocispec.Descriptor {
+	config := pushToStore(t, ctx, sociStore, "application/vnd.amazon.soci.index.v1+json", []byte("{}"))
+	var layers []ocispec.Descriptor
+	for _, blob := range blobs {
+		layers = append(layers, pushToStore(t, ctx, sociStore, "application/octet-stream", blob))
+	}
+	manifest := ocispec.Manifest{
+		MediaType: MediaTypeOCIManifest,
+		Config:    config,
+		Layers:    layers,
+	}
+	manifest.SchemaVersion = 2
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal index manifest: %v", err)
+	}
+	return pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, content)
+}
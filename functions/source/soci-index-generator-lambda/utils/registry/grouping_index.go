@@ -0,0 +1,74 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrNoPlatformIndexes = errors.New("no platform has a SOCI index")
+
+// The SOCI index generated for one platform of a multi-platform image
+type PlatformIndex struct {
+	Platform ocispec.Platform
+	// Descriptor of the platform's SOCI index in the local store, nil if the platform was skipped
+	Index *ocispec.Descriptor
+}
+
+// Assemble the per-platform SOCI indexes of a multi-platform image into a single OCI image index, in the order of
+// the source index's platforms, and push it along with the indexes as the discoverable artifact.
+// Skipped platforms are left out of the grouping index; ErrNoPlatformIndexes is returned if all were skipped.
+// The grouping index is written to sociStore before being pushed like any other index.
+func (registry *Registry) PushGroupingIndex(ctx context.Context, sociStore *store.SociStore, repositoryName string, platformIndexes []PlatformIndex, tag string, opts ...PushOption) (*PushResult, error) {
+	indexDesc, err := storeGroupingIndex(ctx, sociStore, platformIndexes)
+	if err != nil {
+		return nil, registry.wrapError("push", repositoryName, tag, err)
+	}
+	return registry.Push(ctx, sociStore, indexDesc, repositoryName, tag, opts...)
+}
+
+// Write the grouping index of the given per-platform indexes to the local store, returning its descriptor
+func storeGroupingIndex(ctx context.Context, sociStore *store.SociStore, platformIndexes []PlatformIndex) (ocispec.Descriptor, error) {
+	index := ocispec.Index{
+		MediaType:    MediaTypeOCIImageIndex,
+		ArtifactType: soci.SociIndexArtifactTypeV2,
+		Manifests:    []ocispec.Descriptor{},
+	}
+	index.SchemaVersion = 2
+	for _, platformIndex := range platformIndexes {
+		if platformIndex.Index == nil {
+			continue
+		}
+		child := *platformIndex.Index
+		platform := platformIndex.Platform
+		child.Platform = &platform
+		index.Manifests = append(index.Manifests, child)
+	}
+	if len(index.Manifests) == 0 {
+		return ocispec.Descriptor{}, ErrNoPlatformIndexes
+	}
+
+	content, err := json.Marshal(index)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    MediaTypeOCIImageIndex,
+		ArtifactType: index.ArtifactType,
+		Digest:       digest.FromBytes(content),
+		Size:         int64(len(content)),
+	}
+	if err := pushBytes(ctx, sociStore, desc, content); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to store the grouping index: %w", err)
+	}
+	return desc, nil
+}
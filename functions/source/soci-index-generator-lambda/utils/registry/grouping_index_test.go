@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPushGroupingIndex(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-grouping-index")
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	amd64 := storeTestIndex(t, ctx, sociStore, []byte("amd64 ztoc"))
+	arm64 := storeTestIndex(t, ctx, sociStore, []byte("arm64 ztoc"))
+
+	platformIndexes := []PlatformIndex{
+		{Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"}, Index: &amd64},
+		// skipped, e.g. filtered out by the platform allowlist
+		{Platform: ocispec.Platform{OS: "linux", Architecture: "s390x"}},
+		{Platform: ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Index: &arm64},
+	}
+	result, err := fake.registry(t).PushGroupingIndex(ctx, sociStore, "repo", platformIndexes, "latest-soci")
+	if err != nil {
+		t.Fatalf("PushGroupingIndex failed: %v", err)
+	}
+	if fake.tagged("repo", "latest-soci") != result.Descriptor.Digest.String() {
+		t.Fatalf("Expected the grouping index to be tagged")
+	}
+
+	fake.mu.Lock()
+	pushed := fake.manifests["repo@"+result.Descriptor.Digest.String()]
+	fake.mu.Unlock()
+	if pushed.mediaType != MediaTypeOCIImageIndex {
+		t.Fatalf("Expected media type %s but got %s", MediaTypeOCIImageIndex, pushed.mediaType)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(pushed.content, &index); err != nil {
+		t.Fatalf("Failed to decode the grouping index: %v", err)
+	}
+	if index.ArtifactType != soci.SociIndexArtifactTypeV2 || len(index.Manifests) != 2 {
+		t.Fatalf("Expected a SOCI grouping index of 2 platforms but got %+v", index)
+	}
+	for i, expected := range []PlatformIndex{platformIndexes[0], platformIndexes[2]} {
+		child := index.Manifests[i]
+		if child.Digest != expected.Index.Digest || child.Platform == nil ||
+			child.Platform.Architecture != expected.Platform.Architecture || child.Platform.Variant != expected.Platform.Variant {
+			t.Fatalf("Expected child %d to be %s for %+v but got %+v", i, expected.Index.Digest, expected.Platform, child)
+		}
+		if !fake.hasManifest("repo", child.Digest) {
+			t.Fatalf("Expected the SOCI index %s to be pushed", child.Digest)
+		}
+	}
+
+	// every platform skipped
+	_, err = fake.registry(t).PushGroupingIndex(ctx, sociStore, "repo", platformIndexes[1:2], "")
+	if !errors.Is(err, ErrNoPlatformIndexes) {
+		t.Fatalf("Expected ErrNoPlatformIndexes but got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Fetch the config blob of an image manifest, e.g. for its entrypoint, environment or layer diff IDs.
+// The blob is verified against the manifest's config descriptor. Docker and OCI image configs are supported,
+// both of which unmarshal into an ocispec.Image.
+func (registry *Registry) GetImageConfig(ctx context.Context, repositoryName string, manifest ocispec.Manifest) (ocispec.Image, error) {
+	config, err := registry.getImageConfig(ctx, repositoryName, manifest)
+	return config, registry.wrapError("get image config", repositoryName, manifest.Config.Digest.String(), err)
+}
+
+func (registry *Registry) getImageConfig(ctx context.Context, repositoryName string, manifest ocispec.Manifest) (ocispec.Image, error) {
+	var config ocispec.Image
+	if !slices.Contains(ImageConfigMediaTypes, manifest.Config.MediaType) {
+		return config, fmt.Errorf("not an image config: unexpected media type: %s, expected one of: %v",
+			manifest.Config.MediaType, ImageConfigMediaTypes)
+	}
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return config, err
+	}
+	repo, err := registry.registry.Repository(ctx, repositoryName)
+	if err != nil {
+		return config, err
+	}
+
+	rc, err := repo.Blobs().Fetch(ctx, manifest.Config)
+	if err != nil {
+		return config, err
+	}
+	defer rc.Close()
+
+	// configs are bounded like manifests, since both are buffered in memory
+	bytes, err := readManifest(rc, manifest.Config, registry.config.maxManifestSize)
+	if err != nil {
+		return config, err
+	}
+	if err := verifyContent(bytes, manifest.Config); err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return config, fmt.Errorf("failed to parse image config: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,69 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestGetImageConfig(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-get-image-config")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	diffID := digest.FromString("layer")
+	content, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "arm64"},
+		Config:   ocispec.ImageConfig{Entrypoint: []string{"/bin/server"}, Env: []string{"PORT=8080"}},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{diffID}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal image config: %v", err)
+	}
+
+	doTest := func(mediaType string) {
+		config := fake.putBlob("repo", mediaType, content)
+		manifest := imageManifest(mediaType)
+		manifest.Config = config
+		image, err := registry.GetImageConfig(ctx, "repo", manifest)
+		if err != nil {
+			t.Fatalf("GetImageConfig of %s failed: %v", mediaType, err)
+		}
+		if image.Architecture != "arm64" || len(image.Config.Entrypoint) != 1 || image.Config.Entrypoint[0] != "/bin/server" ||
+			len(image.Config.Env) != 1 || image.Config.Env[0] != "PORT=8080" {
+			t.Fatalf("Incorrect image config: %+v", image)
+		}
+		if len(image.RootFS.DiffIDs) != 1 || image.RootFS.DiffIDs[0] != diffID {
+			t.Fatalf("Expected diff IDs [%s] but got %v", diffID, image.RootFS.DiffIDs)
+		}
+	}
+
+	doTest(MediaTypeOCIImageConfig)
+	doTest(MediaTypeDockerImageConfig)
+
+	// a config not matching its descriptor is rejected
+	tampered := imageManifest(MediaTypeOCIImageConfig)
+	tampered.Config = fake.putBlob("repo", MediaTypeOCIImageConfig, content)
+	tampered.Config.Size--
+	if _, err := registry.GetImageConfig(ctx, "repo", tampered); err == nil {
+		t.Fatalf("Expected an error for a config of the wrong size")
+	}
+
+	// artifacts have no image config
+	artifact := imageManifest("application/vnd.oci.empty.v1+json")
+	if _, err := registry.GetImageConfig(ctx, "repo", artifact); err == nil {
+		t.Fatalf("Expected an error for an artifact config")
+	}
+
+	// a missing config
+	missing := imageManifest(MediaTypeOCIImageConfig)
+	missing.Config.Digest = digest.FromString("missing")
+	if _, err := registry.GetImageConfig(ctx, "repo", missing); err == nil || errors.Is(err, ErrContentMismatch) {
+		t.Fatalf("Expected a fetch error for a missing config but got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The outcome of validating one child manifest of an image index
+type ChildValidation struct {
+	Descriptor ocispec.Descriptor
+	// Platform of the child, e.g. linux/arm64/v8, empty when the child has no platform
+	Platform string
+	// Why the child can't be indexed, nil if it's a valid image manifest
+	Err error
+}
+
+// The outcome of validating the children of an image index, in the order of the index
+type IndexValidation struct {
+	Children []ChildValidation
+}
+
+// Check if every validated child is a valid image manifest. An index without any child to validate isn't valid.
+func (validation IndexValidation) Valid() bool {
+	return len(validation.Children) > 0 && len(validation.Failed()) == 0
+}
+
+// Return the children that failed validation
+func (validation IndexValidation) Failed() []ChildValidation {
+	var failed []ChildValidation
+	for _, child := range validation.Children {
+		if child.Err != nil {
+			failed = append(failed, child)
+		}
+	}
+	return failed
+}
+
+// Validate that the children of an image index matching one of the platforms are valid image manifests,
+// before spending time indexing them. No platform means every child.
+// A child failing validation is reported in its ChildValidation; the error is for failures to read the index itself.
+func (registry *Registry) ValidateImageIndexChildren(ctx context.Context, repositoryName string, indexDigest string, platformFilter ...ocispec.Platform) (IndexValidation, error) {
+	validation, err := registry.validateImageIndexChildren(ctx, repositoryName, indexDigest, platformFilter)
+	return validation, registry.wrapError("validate index children", repositoryName, indexDigest, err)
+}
+
+func (registry *Registry) validateImageIndexChildren(ctx context.Context, repositoryName string, indexDigest string, platformFilter []ocispec.Platform) (IndexValidation, error) {
+	content, descriptor, err := registry.getManifestRaw(ctx, repositoryName, indexDigest)
+	if err != nil {
+		return IndexValidation{}, err
+	}
+	if kindFromMediaType(descriptor.MediaType) != ImageIndex {
+		return IndexValidation{}, fmt.Errorf("%w: unexpected media type: %s", ErrNotImageIndex, descriptor.MediaType)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return IndexValidation{}, err
+	}
+
+	children, err := registry.indexLeaves(ctx, repositoryName, index)
+	if err != nil {
+		return IndexValidation{}, err
+	}
+
+	var validation IndexValidation
+	for _, child := range children {
+		if !matchesAnyPlatform(child.Platform, platformFilter) {
+			continue
+		}
+		result := ChildValidation{Descriptor: child}
+		if child.Platform != nil {
+			result.Platform = platforms.Format(*child.Platform)
+		}
+		if kindFromMediaType(child.MediaType) != ImageManifest {
+			result.Err = fmt.Errorf("%w: unexpected media type: %s", ErrNotImageManifest, child.MediaType)
+		} else {
+			result.Err = registry.validateImageManifest(ctx, repositoryName, child.Digest.String(), false)
+		}
+		validation.Children = append(validation.Children, result)
+	}
+	return validation, nil
+}
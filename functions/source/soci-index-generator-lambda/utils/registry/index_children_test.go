@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestValidateImageIndexChildren(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-image-index-children")
+	fake := newFakeRegistry(t)
+	amd64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json"))
+	layer := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}
+	arm64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json", layer, layer))
+	// an artifact isn't a valid child, even though SOCI V2 accepts artifacts at the top level
+	attestation := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.in-toto+json"))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	attestation.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+	index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{amd64, arm64, attestation}}
+	index.SchemaVersion = 2
+	indexDesc := fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index)
+	registry := fake.registry(t)
+
+	doTest := func(platformFilter []ocispec.Platform, expectedPlatforms []string, expectedFailed []string) {
+		validation, err := registry.ValidateImageIndexChildren(ctx, "repo", indexDesc.Digest.String(), platformFilter...)
+		if err != nil {
+			t.Fatalf("ValidateImageIndexChildren failed: %v", err)
+		}
+		var validated []string
+		for _, child := range validation.Children {
+			validated = append(validated, child.Platform)
+		}
+		var failed []string
+		for _, child := range validation.Failed() {
+			failed = append(failed, child.Platform)
+		}
+		if len(validated) != len(expectedPlatforms) || len(failed) != len(expectedFailed) {
+			t.Fatalf("Expected %v validated and %v failed but got %v and %v", expectedPlatforms, expectedFailed, validated, failed)
+		}
+		for i := range validated {
+			if validated[i] != expectedPlatforms[i] {
+				t.Fatalf("Expected %v validated but got %v", expectedPlatforms, validated)
+			}
+		}
+		for i := range failed {
+			if failed[i] != expectedFailed[i] {
+				t.Fatalf("Expected %v failed but got %v", expectedFailed, failed)
+			}
+		}
+		if validation.Valid() != (len(validated) > 0 && len(failed) == 0) {
+			t.Fatalf("Unexpected Valid() %v for %v validated and %v failed", validation.Valid(), validated, failed)
+		}
+	}
+
+	doTest(nil, []string{"linux/amd64", "linux/arm64/v8", "unknown/unknown"}, []string{"unknown/unknown"})
+	doTest([]ocispec.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}, []string{"linux/amd64", "linux/arm64/v8"}, nil)
+	doTest([]ocispec.Platform{{OS: "linux", Architecture: "s390x"}}, nil, nil)
+
+	// an image manifest isn't an index
+	if _, err := registry.ValidateImageIndexChildren(ctx, "repo", amd64.Digest.String()); err == nil {
+		t.Fatalf("Expected an error for an image manifest")
+	}
+
+	// a child with too many layers fails with ErrTooManyLayers
+	validation, err := fake.registry(t, WithMaxLayers(1)).ValidateImageIndexChildren(ctx, "repo", indexDesc.Digest.String())
+	if err != nil {
+		t.Fatalf("ValidateImageIndexChildren failed: %v", err)
+	}
+	if validation.Children[0].Err != nil {
+		t.Fatalf("Expected the amd64 child without layers to be valid, got: %v", validation.Children[0].Err)
+	}
+	if !errors.Is(validation.Children[1].Err, ErrTooManyLayers) {
+		t.Fatalf("Expected ErrTooManyLayers for the arm64 child but got %v", validation.Children[1].Err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Annotations whose values change between runs over the same image, left out of computed index digests
+var timestampAnnotations = []string{
+	ocispec.AnnotationCreated,
+	AnnotationBuilderBuildTime,
+}
+
+// Compute the descriptor of the SOCI index manifest referencing the given ztocs, serialized the way the SOCI builder
+// does, so that identical inputs always produce the same digest, e.g. to look up or dedupe an index before building it.
+// ztocs keep their order, which is the order of the image layers, and annotation keys are serialized sorted.
+// Timestamp annotations are left out. The subject only applies to V1 indexes, V2 indexes have none.
+func ComputeIndexDigest(sociIndexVersion SociIndexVersion, ztocs []ocispec.Descriptor, subject *ocispec.Descriptor, annotations map[string]string) (ocispec.Descriptor, error) {
+	var version soci.IndexVersion
+	switch sociIndexVersion {
+	case V1:
+		version = soci.V1
+	case V2:
+		version = soci.V2
+		subject = nil
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("%w: %q", ErrUnknownSociIndexVersion, sociIndexVersion)
+	}
+
+	annotations = maps.Clone(annotations)
+	for _, key := range timestampAnnotations {
+		delete(annotations, key)
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	index := soci.NewIndex(version, ztocs, subject, annotations)
+	content, err := soci.MarshalIndex(index)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return ocispec.Descriptor{
+		MediaType:    MediaTypeOCIManifest,
+		ArtifactType: index.ArtifactType,
+		Digest:       digest.FromBytes(content),
+		Size:         int64(len(content)),
+	}, nil
+}
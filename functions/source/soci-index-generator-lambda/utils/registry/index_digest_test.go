@@ -0,0 +1,156 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestComputeIndexDigest(t *testing.T) {
+	ztocs := []ocispec.Descriptor{
+		{MediaType: soci.SociLayerMediaType, Digest: digest.FromString("ztoc 1"), Size: 10,
+			Annotations: map[string]string{soci.IndexAnnotationImageLayerDigest: digest.FromString("layer 1").String(), soci.IndexAnnotationImageLayerMediaType: MediaTypeOCIImageLayerGzip}},
+		{MediaType: soci.SociLayerMediaType, Digest: digest.FromString("ztoc 2"), Size: 20},
+	}
+	subject := &ocispec.Descriptor{MediaType: MediaTypeOCIManifest, Digest: digest.FromString("image"), Size: 30}
+
+	expected, err := ComputeIndexDigest("V1", ztocs, subject, map[string]string{"a": "1", "b": "2", "c": "3"})
+	if err != nil {
+		t.Fatalf("ComputeIndexDigest failed: %v", err)
+	}
+	// maps are built in a different order on every run, and timestamps differ
+	for i := 0; i < 20; i++ {
+		annotations := map[string]string{}
+		keys := []string{"a", "b", "c"}
+		for j := range keys {
+			key := keys[(i+j)%len(keys)]
+			annotations[key] = fmt.Sprint(strings.Index("abc", key) + 1)
+		}
+		annotations[ocispec.AnnotationCreated] = fmt.Sprintf("2024-01-01T00:00:%02dZ", i)
+		annotations[AnnotationBuilderBuildTime] = fmt.Sprintf("2024-01-01T00:%02d:00Z", i)
+		desc, err := ComputeIndexDigest("V1", ztocs, subject, annotations)
+		if err != nil {
+			t.Fatalf("ComputeIndexDigest failed: %v", err)
+		}
+		if desc.Digest != expected.Digest || desc.Size != expected.Size {
+			t.Fatalf("Expected run %d to produce %s but got %s", i, expected.Digest, desc.Digest)
+		}
+		if _, ok := annotations[ocispec.AnnotationCreated]; !ok {
+			t.Fatalf("Expected the annotations of the caller to be left unchanged")
+		}
+	}
+
+	// the same serialization as the SOCI builder
+	content, err := soci.MarshalIndex(soci.NewIndex(soci.V1, ztocs, subject, map[string]string{"a": "1", "b": "2", "c": "3"}))
+	if err != nil {
+		t.Fatalf("Failed to marshal the index: %v", err)
+	}
+	if expected.Digest != digest.FromBytes(content) || expected.ArtifactType != soci.SociIndexArtifactTypeV1 || expected.MediaType != MediaTypeOCIManifest {
+		t.Fatalf("Expected the digest of the SOCI builder serialization but got %+v", expected)
+	}
+
+	v2, err := ComputeIndexDigest("V2", ztocs, subject, nil)
+	if err != nil {
+		t.Fatalf("ComputeIndexDigest failed: %v", err)
+	}
+	v2WithoutSubject, err := ComputeIndexDigest("V2", ztocs, nil, map[string]string{ocispec.AnnotationCreated: "2024-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("ComputeIndexDigest failed: %v", err)
+	}
+	if v2.Digest != v2WithoutSubject.Digest || v2.Digest == expected.Digest || v2.ArtifactType != soci.SociIndexArtifactTypeV2 {
+		t.Fatalf("Expected V2 indexes to ignore the subject and differ from V1 indexes, got %+v and %+v", v2, v2WithoutSubject)
+	}
+
+	if _, err := ComputeIndexDigest("V3", ztocs, subject, nil); err == nil {
+		t.Fatalf("Expected an error for an unknown SOCI index version")
+	}
+}
+
+// Build a V1 SOCI index of a single layer image in a fresh store
+func buildTestIndex(t *testing.T, ctx context.Context, layer []byte) *soci.IndexWithMetadata {
+	storeDir := t.TempDir()
+	contentStore, err := local.NewStore(storeDir)
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+	artifactsDb, err := soci.NewDB(path.Join(t.TempDir(), "artifacts.db"))
+	if err != nil {
+		t.Fatalf("Failed to create artifacts db: %v", err)
+	}
+	write := func(mediaType string, blob []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+		if err := content.WriteBlob(ctx, contentStore, desc.Digest.String(), bytes.NewReader(blob), desc); err != nil {
+			t.Fatalf("Failed to write blob: %v", err)
+		}
+		return desc
+	}
+
+	platform := platforms.DefaultSpec()
+	config, err := json.Marshal(ocispec.Image{Platform: platform, RootFS: ocispec.RootFS{Type: "layers"}})
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	manifest := imageManifest(MediaTypeOCIImageConfig, write(MediaTypeOCIImageLayerGzip, layer))
+	manifest.Config = write(MediaTypeOCIImageConfig, config)
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	image := images.Image{Target: write(MediaTypeOCIManifest, manifestBytes)}
+
+	builder, err := soci.NewIndexBuilder(contentStore, newTestSociStore(t, ctx), soci.WithArtifactsDb(artifactsDb),
+		soci.WithMinLayerSize(0), soci.WithBuildToolIdentifier("test"))
+	if err != nil {
+		t.Fatalf("Failed to create index builder: %v", err)
+	}
+	built, err := builder.Build(ctx, image, soci.WithPlatform(platform))
+	if err != nil {
+		t.Fatalf("Failed to build the SOCI index: %v", err)
+	}
+	return built
+}
+
+func TestIndexDigestReproducible(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-index-digest-reproducible")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	data := bytes.Repeat([]byte("soci"), 1024)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(data))}); err != nil {
+		t.Fatalf("Failed to write tar header: %v", err)
+	}
+	tw.Write(data)
+	tw.Close()
+	gz.Close()
+
+	first := buildTestIndex(t, ctx, buf.Bytes())
+	second := buildTestIndex(t, ctx, buf.Bytes())
+	if first.Desc.Digest != second.Desc.Digest {
+		t.Fatalf("Expected two builds of the same image to produce the same index, got %s and %s", first.Desc.Digest, second.Desc.Digest)
+	}
+
+	computed, err := ComputeIndexDigest("V1", first.Index.Blobs, first.Index.Subject, first.Index.Annotations)
+	if err != nil {
+		t.Fatalf("ComputeIndexDigest failed: %v", err)
+	}
+	if computed.Digest != first.Desc.Digest || computed.Size != first.Desc.Size {
+		t.Fatalf("Expected the computed digest to match the built index %s but got %s", first.Desc.Digest, computed.Digest)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Write the verbatim bytes of the pushed root to the writer of WithIndexManifestWriter, if any
+func writeIndexManifest(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor, config *pushConfig) error {
+	if config.indexManifestWriter == nil {
+		return nil
+	}
+	rc, err := sociStore.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("failed to read the index manifest: %w", err)
+	}
+	defer rc.Close()
+	content, err := readManifest(rc, desc, config.maxMetadataBytes)
+	if err != nil {
+		return fmt.Errorf("failed to read the index manifest: %w", err)
+	}
+	if err := verifyContent(content, desc); err != nil {
+		return err
+	}
+	if _, err := config.indexManifestWriter.Write(content); err != nil {
+		return fmt.Errorf("failed to write the index manifest: %w", err)
+	}
+	return nil
+}
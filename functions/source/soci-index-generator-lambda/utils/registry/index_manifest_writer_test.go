@@ -0,0 +1,64 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestPushWithIndexManifestWriter(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-index-manifest-writer")
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	// annotated on push, so the written manifest is the annotated copy rather than the stored one
+	var written bytes.Buffer
+	result, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest",
+		WithIndexManifestWriter(&written), WithAnnotations(map[string]string{"com.example.build": "42"}))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if digest.FromBytes(written.Bytes()) != result.Descriptor.Digest || result.Descriptor.Digest == indexDesc.Digest {
+		t.Fatalf("Expected the written bytes to hash to the pushed digest %s", result.Descriptor.Digest)
+	}
+	content, _, err := registry.GetManifestRaw(ctx, "repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifestRaw failed: %v", err)
+	}
+	var writtenManifest, pushedManifest ocispec.Manifest
+	if err := json.Unmarshal(written.Bytes(), &writtenManifest); err != nil {
+		t.Fatalf("Failed to unmarshal the written manifest: %v", err)
+	}
+	if err := json.Unmarshal(content, &pushedManifest); err != nil {
+		t.Fatalf("Failed to unmarshal the pushed manifest: %v", err)
+	}
+	if !reflect.DeepEqual(writtenManifest, pushedManifest) || writtenManifest.Annotations["com.example.build"] != "42" {
+		t.Fatalf("Expected the written manifest %+v to be the pushed manifest %+v", writtenManifest, pushedManifest)
+	}
+
+	// a failing writer fails the push before anything is uploaded
+	fake = newFakeRegistry(t)
+	registry = fake.registry(t)
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest", WithIndexManifestWriter(failingWriter{})); err == nil {
+		t.Fatalf("Expected the push to fail")
+	}
+	if fake.hasManifest("repo", indexDesc.Digest) || fake.requestCount("PUT", "") != 0 {
+		t.Fatalf("Expected nothing to be uploaded")
+	}
+}
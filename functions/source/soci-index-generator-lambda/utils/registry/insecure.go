@@ -0,0 +1,34 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+var ErrInsecureEcrRegistry = errors.New("insecure connections are not allowed to ECR registries")
+
+// Apply the options relaxing the transport security, which are meant for local development registries only:
+// they are rejected with ErrInsecureEcrRegistry for ECR registries.
+func configureInsecureTransport(ctx context.Context, registry *remote.Registry, registryUrl string, config registryConfig) error {
+	if !config.plainHTTP && !config.insecureSkipTLSVerify {
+		return nil
+	}
+	if isEcrRegistry(registryUrl) {
+		return ErrInsecureEcrRegistry
+	}
+	if config.plainHTTP {
+		log.Warn(ctx, "Connecting to the registry over plain HTTP")
+		registry.PlainHTTP = true
+	}
+	if config.insecureSkipTLSVerify {
+		// the transport itself is built by newHTTPClient
+		log.Warn(ctx, "INSECURE: TLS certificate verification of the registry is disabled, do not use this outside of development")
+	}
+	return nil
+}
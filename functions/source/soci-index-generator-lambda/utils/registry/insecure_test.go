@@ -0,0 +1,80 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInitWithPlainHTTP(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-plain-http")
+	fake := newFakeRegistry(t)
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+
+	registry, err := Init(ctx, fake.host(), WithPlainHTTP())
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+		t.Fatalf("Expected the manifest to be found over plain HTTP, got: %v", err)
+	}
+
+	// HTTPS is the default
+	registry, err = Init(ctx, fake.host())
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err == nil {
+		t.Fatalf("Expected the HTTPS request to a plain HTTP registry to fail")
+	}
+
+	ecrClient := &fakeEcrClient{passwords: []string{"password"}}
+	regions := stubEcrClient(t, ecrClient)
+	_, err = Init(ctx, testEcrRegistryUrl, WithPlainHTTP())
+	if !errors.Is(err, ErrInsecureEcrRegistry) {
+		t.Fatalf("Expected ErrInsecureEcrRegistry but got %v", err)
+	}
+	if len(*regions) != 0 {
+		t.Fatalf("Expected ECR authorization to be skipped")
+	}
+}
+
+func TestInitWithInsecureSkipTLSVerify(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-insecure-skip-tls-verify")
+	fake := newFakeRegistry(t)
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	registry, err := Init(ctx, host, WithInsecureSkipTLSVerify())
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+		t.Fatalf("Expected the manifest to be found despite the self-signed certificate, got: %v", err)
+	}
+
+	// the certificate is verified by default
+	registry, err = Init(ctx, host)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err == nil {
+		t.Fatalf("Expected the self-signed certificate to be rejected")
+	}
+
+	ecrClient := &fakeEcrClient{passwords: []string{"password"}}
+	regions := stubEcrClient(t, ecrClient)
+	_, err = Init(ctx, testEcrRegistryUrl, WithInsecureSkipTLSVerify())
+	if !errors.Is(err, ErrInsecureEcrRegistry) {
+		t.Fatalf("Expected ErrInsecureEcrRegistry but got %v", err)
+	}
+	if len(*regions) != 0 {
+		t.Fatalf("Expected ECR authorization to be skipped")
+	}
+}
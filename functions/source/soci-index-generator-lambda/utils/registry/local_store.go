@@ -0,0 +1,77 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// Resolve a manifest of the local store by digest. A manifest missing from the store's index.json is resolved as a
+// plain blob, so its media type is read from its content.
+func resolveStoredManifest(ctx context.Context, sociStore *store.SociStore, reference string) (ocispec.Descriptor, error) {
+	desc, err := sociStore.Resolve(ctx, reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if isManifestMediaType(desc.MediaType) {
+		return desc, nil
+	}
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := readStoreJSON(ctx, sociStore, desc, DefaultMaxManifestSize, &manifest); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to read manifest %s: %w", reference, err)
+	}
+	if !isManifestMediaType(manifest.MediaType) {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: %s has media type %q", ErrNotImageManifest, reference, manifest.MediaType)
+	}
+	desc.MediaType = manifest.MediaType
+	return desc, nil
+}
+
+// Return the descriptor of the image with the given digest if its whole graph, minus foreign layers, is already in
+// the local store, i.e. Pull would have nothing to download
+func storedImage(ctx context.Context, sociStore *store.SociStore, imageDigest string) (ocispec.Descriptor, bool, error) {
+	desc, err := resolveStoredManifest(ctx, sociStore, imageDigest)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return ocispec.Descriptor{}, false, nil
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	complete, err := isGraphStored(ctx, sociStore, desc)
+	if err != nil || !complete {
+		return ocispec.Descriptor{}, false, err
+	}
+	return desc, true, nil
+}
+
+// Check if a node and all of its successors are in the local store
+func isGraphStored(ctx context.Context, sociStore *store.SociStore, node ocispec.Descriptor) (bool, error) {
+	if IsForeignLayerMediaType(node.MediaType) {
+		return true, nil
+	}
+	exists, err := sociStore.Exists(ctx, node)
+	if err != nil || !exists {
+		return false, err
+	}
+	successors, err := content.Successors(ctx, sociStore, node)
+	if err != nil {
+		return false, err
+	}
+	for _, successor := range successors {
+		complete, err := isGraphStored(ctx, sociStore, successor)
+		if err != nil || !complete {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPullSkipsStoredImage(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-skips-stored-image")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	manifest := imageManifest(config.MediaType, layer)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, manifest, "latest")
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	registry := fake.registry(t)
+
+	doTest := func(reference string, stageLayer bool, opts []PullOption, expectPull bool) {
+		// the image is staged without going through Pull, so the manifest is missing from the store's index.json
+		sociStore := newTestSociStore(t, ctx)
+		pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, content)
+		pushToStore(t, ctx, sociStore, config.MediaType, []byte("{}"))
+		if stageLayer {
+			pushToStore(t, ctx, sociStore, layer.MediaType, []byte("layer"))
+		}
+
+		requests := fake.requestCount(http.MethodGet, "/v2/") + fake.requestCount(http.MethodHead, "/v2/")
+		pulled, err := registry.Pull(ctx, "repo", sociStore, reference, opts...)
+		if err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+		if pulled.Digest != image.Digest || pulled.MediaType != MediaTypeOCIManifest || pulled.Size != image.Size {
+			t.Fatalf("Expected to pull %v but got %v", image, pulled.Descriptor)
+		}
+		requests = fake.requestCount(http.MethodGet, "/v2/") + fake.requestCount(http.MethodHead, "/v2/") - requests
+		if expectPull != (requests > 0) {
+			t.Fatalf("Expected the image to be pulled from the registry: %v, got %d requests", expectPull, requests)
+		}
+	}
+
+	doTest(image.Digest.String(), true, []PullOption{WithSkipPullIfStored()}, false)
+	// a partially staged image is pulled
+	doTest(image.Digest.String(), false, []PullOption{WithSkipPullIfStored()}, true)
+	// tags may have been moved, so they are always resolved by the registry
+	doTest("latest", true, []PullOption{WithSkipPullIfStored()}, true)
+	// images are pulled by default
+	doTest(image.Digest.String(), true, nil, true)
+}
@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// A Docker manifest list, i.e. application/vnd.docker.distribution.manifest.list.v2+json
+type dockerManifestList struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Manifests     []ocispec.Descriptor `json:"manifests"`
+}
+
+// Re-serialize an OCI image index of the local store as a Docker manifest list, store it and return its descriptor.
+// The child manifests are kept as is. Fields without a Docker equivalent, such as artifactType, subject
+// and annotations, are dropped.
+func convertToDockerManifestList(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	rc, err := sociStore.Fetch(ctx, indexDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer rc.Close()
+
+	var index ocispec.Index
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	list := dockerManifestList{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifestList,
+		Manifests:     index.Manifests,
+	}
+	content, err := json.Marshal(list)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	listDesc := ocispec.Descriptor{
+		MediaType: MediaTypeDockerManifestList,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+	if err := pushBytes(ctx, sociStore, listDesc, content); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to store Docker manifest list: %w", err)
+	}
+	return listDesc, nil
+}
+
+// A Docker manifest list as received, with the platform fields of the Docker schema
+type dockerManifestListJSON struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MediaType     string `json:"mediaType"`
+	Manifests     []struct {
+		MediaType string          `json:"mediaType"`
+		Digest    digest.Digest   `json:"digest"`
+		Size      int64           `json:"size"`
+		URLs      []string        `json:"urls,omitempty"`
+		Platform  *dockerPlatform `json:"platform,omitempty"`
+	} `json:"manifests"`
+}
+
+type dockerPlatform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	// CPU features, which OCI platforms don't have
+	Features []string `json:"features,omitempty"`
+}
+
+// Convert a Docker manifest list to an OCI image index, e.g. to operate on the children of an image regardless of
+// its format. The digests, sizes, URLs and platforms of the children are kept; their media types are kept too, as
+// the digests refer to the children as they're stored, which a Docker manifest stays. CPU features are dropped,
+// OCI platforms have no equivalent. Lists with children other than manifests and manifest lists, such as schema 1
+// manifests, are rejected with ErrNotImageIndex.
+func ConvertDockerListToOCIIndex(raw []byte) (ocispec.Index, error) {
+	var list dockerManifestListJSON
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return ocispec.Index{}, fmt.Errorf("%w: %w", ErrNotImageIndex, err)
+	}
+	if list.SchemaVersion != 2 || list.MediaType != MediaTypeDockerManifestList {
+		return ocispec.Index{}, fmt.Errorf("%w: schema version %d and media type %q, expected a Docker manifest list", ErrNotImageIndex, list.SchemaVersion, list.MediaType)
+	}
+
+	index := ocispec.Index{
+		MediaType: MediaTypeOCIImageIndex,
+		Manifests: make([]ocispec.Descriptor, 0, len(list.Manifests)),
+	}
+	index.SchemaVersion = 2
+	for _, child := range list.Manifests {
+		if !isManifestMediaType(child.MediaType) {
+			return ocispec.Index{}, fmt.Errorf("%w: child %s has the unsupported media type %q", ErrNotImageIndex, child.Digest, child.MediaType)
+		}
+		if err := child.Digest.Validate(); err != nil {
+			return ocispec.Index{}, fmt.Errorf("%w: child digest %q: %w", ErrNotImageIndex, child.Digest, err)
+		}
+		desc := ocispec.Descriptor{MediaType: child.MediaType, Digest: child.Digest, Size: child.Size, URLs: child.URLs}
+		if child.Platform != nil {
+			desc.Platform = &ocispec.Platform{
+				Architecture: child.Platform.Architecture,
+				OS:           child.Platform.OS,
+				OSVersion:    child.Platform.OSVersion,
+				OSFeatures:   child.Platform.OSFeatures,
+				Variant:      child.Platform.Variant,
+			}
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	return index, nil
+}
+
+// Write content to the local store, ignoring content that is already present
+func pushBytes(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor, content []byte) error {
+	err := sociStore.Push(ctx, desc, io.Reader(bytes.NewReader(content)))
+	if err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return err
+	}
+	return nil
+}
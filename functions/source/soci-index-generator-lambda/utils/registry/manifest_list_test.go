@@ -0,0 +1,101 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// A Docker manifest list as pushed by docker buildx, with a Windows child
+const testDockerManifestList = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+  "manifests": [
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+      "size": 1234,
+      "platform": {"architecture": "amd64", "os": "linux"}
+    },
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+      "size": 1235,
+      "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}
+    },
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "digest": "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+      "size": 2048,
+      "platform": {"architecture": "amd64", "os": "windows", "os.version": "10.0.17763.5458", "os.features": ["win32k"]}
+    }
+  ]
+}`
+
+func TestConvertDockerListToOCIIndex(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-convert-docker-list-to-oci-index")
+
+	index, err := ConvertDockerListToOCIIndex([]byte(testDockerManifestList))
+	if err != nil {
+		t.Fatalf("ConvertDockerListToOCIIndex failed: %v", err)
+	}
+	if index.SchemaVersion != 2 || index.MediaType != MediaTypeOCIImageIndex || len(index.Manifests) != 3 {
+		t.Fatalf("Unexpected index %+v", index)
+	}
+	windows := index.Manifests[2]
+	if windows.MediaType != MediaTypeDockerManifest || windows.Size != 2048 || !strings.HasPrefix(windows.Digest.String(), "sha256:3333") {
+		t.Fatalf("Expected the child to be kept but got %+v", windows)
+	}
+	expectedPlatform := ocispec.Platform{Architecture: "amd64", OS: "windows", OSVersion: "10.0.17763.5458", OSFeatures: []string{"win32k"}}
+	if windows.Platform == nil || !reflect.DeepEqual(*windows.Platform, expectedPlatform) {
+		t.Fatalf("Expected platform %+v but got %+v", expectedPlatform, windows.Platform)
+	}
+	if arm := index.Manifests[1].Platform; arm == nil || arm.Variant != "v8" {
+		t.Fatalf("Expected the variant to be kept but got %+v", arm)
+	}
+
+	// converting the index back to a Docker manifest list gives the original list
+	sociStore := newTestSociStore(t, ctx)
+	encoded, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal the index: %v", err)
+	}
+	listDesc, err := convertToDockerManifestList(ctx, sociStore, pushToStore(t, ctx, sociStore, MediaTypeOCIImageIndex, encoded))
+	if err != nil {
+		t.Fatalf("convertToDockerManifestList failed: %v", err)
+	}
+	roundTripped, err := content.FetchAll(ctx, sociStore, listDesc)
+	if err != nil {
+		t.Fatalf("Failed to read the Docker manifest list: %v", err)
+	}
+	var expected, actual interface{}
+	json.Unmarshal([]byte(testDockerManifestList), &expected)
+	json.Unmarshal(roundTripped, &actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected the round trip to give the original list but got %s", roundTripped)
+	}
+}
+
+func TestConvertDockerListToOCIIndexRejectsInvalidLists(t *testing.T) {
+	doTest := func(raw string) {
+		if _, err := ConvertDockerListToOCIIndex([]byte(raw)); !errors.Is(err, ErrNotImageIndex) {
+			t.Fatalf("Expected ErrNotImageIndex for %s but got %v", raw, err)
+		}
+	}
+
+	doTest(`not json`)
+	doTest(`{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`)
+	doTest(`{"schemaVersion": 1, "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json", "manifests": []}`)
+	doTest(`{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json", "manifests": [
+		{"mediaType": "application/vnd.docker.distribution.manifest.v1+prettyjws", "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111", "size": 1}]}`)
+	doTest(`{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json", "manifests": [
+		{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "sha256:short", "size": 1}]}`)
+}
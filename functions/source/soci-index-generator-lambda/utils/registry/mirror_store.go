@@ -0,0 +1,107 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// ErrMirrorFailed is returned by Pull when writing to the store of WithMirrorStore fails along with
+// WithMirrorStoreRequired
+var ErrMirrorFailed = errors.New("failed to write to the mirror store")
+
+// The destination of Pull along with a mirror store, e.g. a store shared between Lambdas on EFS. The content is
+// streamed to both stores at once, so it is only fetched once. Content the local store already has, e.g. left
+// behind by a previous attempt, is fetched again for the mirror when the mirror lacks it.
+type mirroringStore struct {
+	oras.Target
+
+	mirror content.Storage
+	// Fail the pull when writing to the mirror fails, rather than logging and carrying on
+	required bool
+}
+
+func (s *mirroringStore) Exists(ctx context.Context, desc ocispec.Descriptor) (bool, error) {
+	exists, err := s.Target.Exists(ctx, desc)
+	if err != nil || !exists {
+		return exists, err
+	}
+	mirrored, err := s.mirror.Exists(ctx, desc)
+	if err != nil {
+		return true, s.mirrorFailed(ctx, desc, err)
+	}
+	return mirrored, nil
+}
+
+func (s *mirroringStore) Push(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	exists, err := s.Target.Exists(ctx, desc)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return s.pushMirror(ctx, desc, r)
+	}
+
+	pr, pw := io.Pipe()
+	mirrored := make(chan error, 1)
+	go func() {
+		err := s.mirror.Push(ctx, desc, pr)
+		// unblock the writes of the primary store if the mirror stopped reading early
+		pr.CloseWithError(errMirrorDone)
+		mirrored <- err
+	}()
+	err = s.Target.Push(ctx, desc, io.TeeReader(r, &mirrorWriter{pw: pw}))
+	pw.CloseWithError(err)
+	mirrorErr := <-mirrored
+	if err != nil {
+		return err
+	}
+	if mirrorErr != nil && !errors.Is(mirrorErr, errdef.ErrAlreadyExists) {
+		return s.mirrorFailed(ctx, desc, mirrorErr)
+	}
+	return nil
+}
+
+// Write content the primary store already has to the mirror only
+func (s *mirroringStore) pushMirror(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	if err := s.mirror.Push(ctx, desc, r); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return s.mirrorFailed(ctx, desc, err)
+	}
+	return nil
+}
+
+func (s *mirroringStore) mirrorFailed(ctx context.Context, desc ocispec.Descriptor, err error) error {
+	if s.required {
+		return fmt.Errorf("%w: %s: %w", ErrMirrorFailed, desc.Digest, err)
+	}
+	log.Warn(ctx, fmt.Sprintf("Failed to mirror %s, continuing the pull: %v", desc.Digest, err))
+	return nil
+}
+
+var errMirrorDone = errors.New("mirror store stopped reading")
+
+// Feeds the mirror store through a pipe, dropping the writes once the mirror stopped reading so that a failing
+// mirror doesn't fail the primary store
+type mirrorWriter struct {
+	pw     *io.PipeWriter
+	closed bool
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	if !w.closed {
+		if _, err := w.pw.Write(p); err != nil {
+			w.closed = true
+		}
+	}
+	return len(p), nil
+}
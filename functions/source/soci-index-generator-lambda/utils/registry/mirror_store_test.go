@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// A mirror store failing every write after reading part of the content
+type failingMirror struct {
+	*memory.Store
+}
+
+func (m failingMirror) Push(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	io.ReadFull(r, make([]byte, 1))
+	return errors.New("mirror is full")
+}
+
+func TestPullWithMirrorStore(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-with-mirror-store")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+	registry := fake.registry(t)
+	nodes := []ocispec.Descriptor{image, config, layer}
+
+	doTest := func(mirror *memory.Store, opts ...PullOption) {
+		t.Helper()
+		sociStore := newTestSociStore(t, ctx)
+		if _, err := registry.Pull(ctx, "repo", sociStore, "latest", opts...); err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+		for _, node := range nodes {
+			if exists, err := sociStore.Exists(ctx, node); err != nil || !exists {
+				t.Fatalf("Expected %s in the local store", node.Digest)
+			}
+			if mirror == nil {
+				continue
+			}
+			if exists, err := mirror.Exists(ctx, node); err != nil || !exists {
+				t.Fatalf("Expected %s in the mirror store", node.Digest)
+			}
+		}
+	}
+
+	// a single pull populates both stores
+	mirror := memory.New()
+	requests := fake.requestCount(http.MethodGet, "/blobs/"+layer.Digest.String())
+	doTest(mirror, WithMirrorStore(mirror))
+	if fake.requestCount(http.MethodGet, "/blobs/"+layer.Digest.String()) != requests+1 {
+		t.Fatalf("Expected the layer to be fetched once for both stores")
+	}
+
+	// a failing mirror doesn't fail the pull
+	doTest(nil, WithMirrorStore(failingMirror{memory.New()}))
+
+	// unless required
+	_, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest", WithMirrorStore(failingMirror{memory.New()}), WithMirrorStoreRequired())
+	if !errors.Is(err, ErrMirrorFailed) {
+		t.Fatalf("Expected ErrMirrorFailed but got %v", err)
+	}
+
+	// content already in the local store is mirrored too
+	sociStore := newTestSociStore(t, ctx)
+	if _, err := registry.Pull(ctx, "repo", sociStore, "latest"); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	mirror = memory.New()
+	if _, err := registry.Pull(ctx, "repo", sociStore, "latest", WithMirrorStore(mirror)); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	for _, node := range nodes {
+		if exists, err := mirror.Exists(ctx, node); err != nil || !exists {
+			t.Fatalf("Expected %s in the mirror store", node.Digest)
+		}
+	}
+}
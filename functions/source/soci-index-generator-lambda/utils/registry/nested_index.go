@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Default upper bound of the nesting of image indexes, the top level index included
+const DefaultMaxIndexDepth = 4
+
+var ErrIndexTooDeep = errors.New("image index is nested deeper than the maximum index depth")
+
+// Return the leaves of an image index, i.e. its children that aren't image indexes themselves, in the order of the
+// index. Nested indexes are flattened, and their leaves without a platform take the platform of the nested index.
+// Indexes nested deeper than the maximum index depth are rejected with ErrIndexTooDeep.
+func (registry *Registry) indexLeaves(ctx context.Context, repositoryName string, index ocispec.Index) ([]ocispec.Descriptor, error) {
+	return registry.collectIndexLeaves(ctx, repositoryName, index, 1)
+}
+
+func (registry *Registry) collectIndexLeaves(ctx context.Context, repositoryName string, index ocispec.Index, depth int) ([]ocispec.Descriptor, error) {
+	var leaves []ocispec.Descriptor
+	for _, child := range index.Manifests {
+		if kindFromMediaType(child.MediaType) != ImageIndex {
+			leaves = append(leaves, child)
+			continue
+		}
+		if depth >= registry.config.maxIndexDepth {
+			return nil, fmt.Errorf("%w: %s is at depth %d, the limit is %d", ErrIndexTooDeep, child.Digest, depth+1, registry.config.maxIndexDepth)
+		}
+		content, _, err := registry.getManifestRaw(ctx, repositoryName, child.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get nested index %s: %w", child.Digest, err)
+		}
+		var nested ocispec.Index
+		if err := json.Unmarshal(content, &nested); err != nil {
+			return nil, fmt.Errorf("failed to parse nested index %s: %w", child.Digest, err)
+		}
+		nestedLeaves, err := registry.collectIndexLeaves(ctx, repositoryName, nested, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, leaf := range nestedLeaves {
+			if leaf.Platform == nil {
+				leaf.Platform = child.Platform
+			}
+			leaves = append(leaves, leaf)
+		}
+	}
+	return leaves, nil
+}
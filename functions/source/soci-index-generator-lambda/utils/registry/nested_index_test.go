@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNestedImageIndexes(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-nested-image-indexes")
+	fake := newFakeRegistry(t)
+	putIndex := func(children ...ocispec.Descriptor) ocispec.Descriptor {
+		index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: children}
+		index.SchemaVersion = 2
+		return fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index)
+	}
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	amd64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, config))
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	windows := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, config, config))
+
+	// top -> [amd64, middle -> [arm64, bottom (windows/amd64) -> [windows]]]
+	bottom := putIndex(windows)
+	bottom.Platform = &ocispec.Platform{OS: "windows", Architecture: "amd64"}
+	middle := putIndex(arm64, bottom)
+	top := putIndex(amd64, middle)
+
+	doTest := func(maxIndexDepth int, expectedPlatforms []string, expectedErr error) {
+		registry := fake.registry(t, WithMaxIndexDepth(maxIndexDepth))
+
+		platformList, err := registry.ListPlatforms(ctx, "repo", top.Digest.String())
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+		validation, validationErr := registry.ValidateImageIndexChildren(ctx, "repo", top.Digest.String())
+		if !errors.Is(validationErr, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, validationErr)
+		}
+		_, estimateErr := registry.EstimateSize(ctx, "repo", top.Digest.String())
+		if !errors.Is(estimateErr, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, estimateErr)
+		}
+		if expectedErr != nil {
+			return
+		}
+
+		var listed []string
+		for _, platform := range platformList {
+			listed = append(listed, platforms.Format(platform))
+		}
+		if len(listed) != len(expectedPlatforms) {
+			t.Fatalf("Expected platforms %v but got %v", expectedPlatforms, listed)
+		}
+		for i := range listed {
+			if listed[i] != expectedPlatforms[i] || validation.Children[i].Platform != expectedPlatforms[i] {
+				t.Fatalf("Expected platforms %v but got %v and %+v", expectedPlatforms, listed, validation.Children)
+			}
+		}
+		if !validation.Valid() {
+			t.Fatalf("Expected the leaves of the nested indexes to be valid but got %+v", validation.Failed())
+		}
+	}
+
+	// the leaf without a platform takes the platform of its nested index
+	doTest(3, []string{"linux/amd64", "linux/arm64", "windows/amd64"}, nil)
+	doTest(0, []string{"linux/amd64", "linux/arm64", "windows/amd64"}, nil)
+	doTest(2, nil, ErrIndexTooDeep)
+	doTest(1, nil, ErrIndexTooDeep)
+}
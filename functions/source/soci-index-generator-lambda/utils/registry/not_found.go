@@ -0,0 +1,119 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+var ErrRepositoryNotFound = errors.New("repository not found")
+
+var ErrImageNotFound = errors.New("image not found")
+
+// Stops listing tags after the first page
+var errStopListing = errors.New("stop listing")
+
+// Tell apart a missing repository from a missing image in the not found error of a lookup, so that callers can
+// remediate either, returning the error wrapped with ErrRepositoryNotFound or ErrImageNotFound. Registries don't
+// return an error code with the 404 of a manifest HEAD, and oras-go drops it from the 404 of a GET, so the error
+// is classified by describing the image with the ECR API, or by listing the tags of the repository for other
+// registries, which fails with NAME_UNKNOWN for a missing repository. The error is returned as is otherwise.
+func (registry *Registry) classifyNotFound(ctx context.Context, repositoryName string, reference string, err error) error {
+	if err == nil || errors.Is(err, ErrRepositoryNotFound) || errors.Is(err, ErrImageNotFound) {
+		return err
+	}
+	if notFound := notFoundFromCode(err); notFound != nil {
+		return fmt.Errorf("%w: %w", notFound, err)
+	}
+	if !errors.Is(err, errdef.ErrNotFound) {
+		return err
+	}
+	var notFound error
+	if registry.ecrCredentials != nil {
+		notFound = registry.describeEcrImage(ctx, repositoryName, reference)
+	} else {
+		notFound = registry.probeRepository(ctx, repositoryName)
+	}
+	if notFound == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", notFound, err)
+}
+
+// Map the error codes of the ECR API and the registry API to ErrRepositoryNotFound or ErrImageNotFound,
+// nil for other errors
+func notFoundFromCode(err error) error {
+	var repositoryNotFound *types.RepositoryNotFoundException
+	if errors.As(err, &repositoryNotFound) {
+		return ErrRepositoryNotFound
+	}
+	var imageNotFound *types.ImageNotFoundException
+	if errors.As(err, &imageNotFound) {
+		return ErrImageNotFound
+	}
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) {
+		for _, e := range errResp.Errors {
+			switch e.Code {
+			case errcode.ErrorCodeNameUnknown:
+				return ErrRepositoryNotFound
+			case errcode.ErrorCodeManifestUnknown:
+				return ErrImageNotFound
+			}
+		}
+	}
+	return nil
+}
+
+// Describe an image of an ECR repository, returning whether the repository or the image is missing.
+// Nil is returned when the image exists, e.g. when a blob of the image is missing, or when it can't be described.
+func (registry *Registry) describeEcrImage(ctx context.Context, repositoryName string, reference string) error {
+	imageId := &types.ImageIdentifier{}
+	if normalized, isDigest, err := NormalizeReference(reference); err == nil && isDigest {
+		imageId.ImageDigest = aws.String(normalized)
+	} else {
+		imageId.ImageTag = aws.String(reference)
+	}
+	input := &ecr.DescribeImagesInput{RepositoryName: aws.String(repositoryName), ImageIds: []types.ImageIdentifier{*imageId}}
+	if registryId := ecrRegistryId(registry.registry.Reference.Registry); registryId != "" {
+		input.RegistryId = aws.String(registryId)
+	}
+	_, err := registry.ecrCredentials.client.DescribeImages(ctx, input)
+	if err == nil {
+		return nil
+	}
+	if notFound := notFoundFromCode(err); notFound != nil {
+		return notFound
+	}
+	log.Debug(ctx, fmt.Sprintf("Failed to describe the image to classify a not found error: %v", err))
+	return nil
+}
+
+// List the tags of a repository, returning ErrRepositoryNotFound if the registry doesn't know the repository and
+// ErrImageNotFound if it does. Nil is returned when the tags can't be listed.
+func (registry *Registry) probeRepository(ctx context.Context, repositoryName string) error {
+	repo, err := registry.registry.Repository(ctx, repositoryName)
+	if err == nil {
+		err = repo.Tags(ctx, "", func(tags []string) error {
+			return errStopListing
+		})
+	}
+	if err == nil || errors.Is(err, errStopListing) {
+		return ErrImageNotFound
+	}
+	if notFoundFromCode(err) == ErrRepositoryNotFound {
+		return ErrRepositoryNotFound
+	}
+	log.Debug(ctx, fmt.Sprintf("Failed to list tags to classify a not found error: %v", err))
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/opencontainers/go-digest"
+	"oras.land/oras-go/v2/errdef"
+)
+
+func TestNotFoundErrors(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-not-found-errors")
+	missingDigest := digest.FromString("missing").String()
+
+	// the lookups of a missing image, each classified the same way
+	lookups := map[string]func(registry *Registry, reference string) error{
+		"head manifest": func(registry *Registry, reference string) error {
+			_, err := registry.HeadManifest(ctx, "repo", reference)
+			return err
+		},
+		"get manifest": func(registry *Registry, reference string) error {
+			_, err := registry.GetManifest(ctx, "repo", missingDigest)
+			return err
+		},
+		"pull": func(registry *Registry, reference string) error {
+			_, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), reference)
+			return err
+		},
+	}
+
+	doTest := func(name string, describeImagesErr error, repositoryMissing bool, expected error) {
+		for lookup, call := range lookups {
+			t.Run(name+"/"+lookup, func(t *testing.T) {
+				fake := newFakeRegistry(t)
+				if repositoryMissing {
+					fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+						if strings.HasSuffix(r.URL.Path, "/tags/list") {
+							writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+							return true
+						}
+						return false
+					}
+				}
+				registry := fake.registry(t)
+				var ecrClient *fakeEcrClient
+				if describeImagesErr != nil {
+					ecrClient = &fakeEcrClient{describeImagesErr: describeImagesErr}
+					registry.ecrCredentials = &ecrCredentials{client: ecrClient}
+				}
+
+				err := call(registry, "latest")
+				if !errors.Is(err, expected) {
+					t.Fatalf("Expected %v but got %v", expected, err)
+				}
+				// the not found error of the registry stays matchable
+				if !errors.Is(err, errdef.ErrNotFound) {
+					t.Fatalf("Expected the error to wrap errdef.ErrNotFound but got %v", err)
+				}
+				if ecrClient != nil {
+					if len(ecrClient.describeImagesInputs) != 1 || aws.ToString(ecrClient.describeImagesInputs[0].RepositoryName) != "repo" {
+						t.Fatalf("Expected the image to be described once but got %v", ecrClient.describeImagesInputs)
+					}
+				}
+			})
+		}
+	}
+
+	doTest("ECR RepositoryNotFoundException", &types.RepositoryNotFoundException{Message: aws.String("no repo")}, false, ErrRepositoryNotFound)
+	doTest("ECR ImageNotFoundException", &types.ImageNotFoundException{Message: aws.String("no image")}, false, ErrImageNotFound)
+	doTest("registry NAME_UNKNOWN", nil, true, ErrRepositoryNotFound)
+	doTest("registry tags listed", nil, false, ErrImageNotFound)
+
+	t.Run("unclassified without an ECR error code", func(t *testing.T) {
+		fake := newFakeRegistry(t)
+		registry := fake.registry(t)
+		registry.ecrCredentials = &ecrCredentials{client: &fakeEcrClient{describeImagesErr: errors.New("throttled")}}
+		_, err := registry.HeadManifest(ctx, "repo", missingDigest)
+		if !errors.Is(err, errdef.ErrNotFound) || errors.Is(err, ErrRepositoryNotFound) || errors.Is(err, ErrImageNotFound) {
+			t.Fatalf("Expected an unclassified not found error but got %v", err)
+		}
+	})
+
+	t.Run("digests are described by digest", func(t *testing.T) {
+		fake := newFakeRegistry(t)
+		registry := fake.registry(t)
+		ecrClient := &fakeEcrClient{describeImagesErr: &types.ImageNotFoundException{Message: aws.String("no image")}}
+		registry.ecrCredentials = &ecrCredentials{client: ecrClient}
+		if _, err := registry.HeadManifest(ctx, "repo", missingDigest); !errors.Is(err, ErrImageNotFound) {
+			t.Fatalf("Expected ErrImageNotFound but got %v", err)
+		}
+		imageId := ecrClient.describeImagesInputs[0].ImageIds[0]
+		if aws.ToString(imageId.ImageDigest) != missingDigest || imageId.ImageTag != nil {
+			t.Fatalf("Expected the image to be described by digest but got %+v", imageId)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// Pull an image into an OCI image layout at destPath, e.g. for a separate offline process to index it.
+// The layout's index.json references the image by the given reference. A destPath ending with .tar gets
+// a tarball of the layout rather than a directory.
+func (registry *Registry) PullToOCILayout(ctx context.Context, repositoryName string, reference string, destPath string) (*ocispec.Descriptor, error) {
+	desc, err := registry.pullToOCILayout(ctx, repositoryName, reference, destPath)
+	return desc, registry.wrapError("pull", repositoryName, reference, err)
+}
+
+func (registry *Registry) pullToOCILayout(ctx context.Context, repositoryName string, reference string, destPath string) (*ocispec.Descriptor, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return nil, err
+	}
+	reference, _, err := NormalizeReference(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	layoutPath := destPath
+	isTar := strings.HasSuffix(destPath, ".tar")
+	if isTar {
+		// the layout is written next to the tarball, so that both are on the same volume
+		layoutPath, err = os.MkdirTemp(filepath.Dir(destPath), ".oci-layout-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(layoutPath)
+	}
+	layout, err := oci.NewWithContext(ctx, layoutPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OCI layout: %w", err)
+	}
+
+	log.Info(ctx, fmt.Sprintf("Pulling image to OCI layout %s", destPath))
+	imageDescriptor, err := registry.copyImageTo(ctx, repositoryName, layout, reference, &pullTally{})
+	if err != nil {
+		return nil, err
+	}
+
+	if isTar {
+		if err := writeTar(layoutPath, destPath); err != nil {
+			return nil, fmt.Errorf("failed to archive the OCI layout: %w", err)
+		}
+	}
+	return &imageDescriptor, nil
+}
+
+// Push a SOCI index generated externally from the OCI image layout directory at layoutPath, along with its ztocs,
+// like Push does from the local store. The index must be in the layout, but doesn't have to be referenced by its
+// index.json. Options which write a new root, such as WithArtifactType or the Docker manifest list fallback,
+// write it into the layout.
+func (registry *Registry) PushFromOCILayout(ctx context.Context, layoutPath string, indexDigest string, repositoryName string, tag string, opts ...PushOption) (*PushResult, error) {
+	sociStore, indexDesc, err := openIndexInOCILayout(ctx, layoutPath, indexDigest)
+	if err != nil {
+		return nil, registry.wrapError("push", newPushConfig(opts).targetRepository(repositoryName), indexDigest, err)
+	}
+	return registry.Push(ctx, sociStore, indexDesc, repositoryName, tag, opts...)
+}
+
+// Open an OCI image layout as a store and resolve the descriptor of the index with the given digest
+func openIndexInOCILayout(ctx context.Context, layoutPath string, indexDigest string) (*store.SociStore, ocispec.Descriptor, error) {
+	if _, err := ParseDigest(indexDigest); err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	if _, err := os.Stat(filepath.Join(layoutPath, ocispec.ImageLayoutFile)); err != nil {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("%s is not an OCI layout: %w", layoutPath, err)
+	}
+	layout, err := oci.NewWithContext(ctx, layoutPath)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("failed to open the OCI layout: %w", err)
+	}
+	sociStore := &store.SociStore{Store: layout}
+
+	indexDesc, err := resolveStoredManifest(ctx, sociStore, indexDigest)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("failed to find index %s in the OCI layout: %w", indexDigest, err)
+	}
+	return sociStore, indexDesc, nil
+}
+
+// Archive the files of a directory into a tarball, with paths relative to the directory
+func writeTar(dir string, tarPath string) error {
+	file, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
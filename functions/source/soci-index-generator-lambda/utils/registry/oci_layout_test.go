@@ -0,0 +1,186 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+func TestPullToOCILayout(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-to-oci-layout")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+	registry := fake.registry(t)
+
+	// a directory
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+	desc, err := registry.PullToOCILayout(ctx, "repo", "latest", layoutPath)
+	if err != nil {
+		t.Fatalf("PullToOCILayout failed: %v", err)
+	}
+	if desc.Digest != image.Digest {
+		t.Fatalf("Expected to pull %s but got %s", image.Digest, desc.Digest)
+	}
+	var layoutFile ocispec.ImageLayout
+	readJSONFile(t, filepath.Join(layoutPath, ocispec.ImageLayoutFile), &layoutFile)
+	if layoutFile.Version != ocispec.ImageLayoutVersion {
+		t.Fatalf("Expected OCI layout version %s but got %s", ocispec.ImageLayoutVersion, layoutFile.Version)
+	}
+	var index ocispec.Index
+	readJSONFile(t, filepath.Join(layoutPath, "index.json"), &index)
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != image.Digest || index.Manifests[0].Annotations[ocispec.AnnotationRefName] != "latest" {
+		t.Fatalf("Expected index.json to reference %s as latest but got %v", image.Digest, index.Manifests)
+	}
+	layout, err := oci.NewWithContext(ctx, layoutPath)
+	if err != nil {
+		t.Fatalf("Failed to open the OCI layout: %v", err)
+	}
+	for _, blob := range []ocispec.Descriptor{image, config, layer} {
+		if exists, err := layout.Exists(ctx, blob); err != nil || !exists {
+			t.Fatalf("Expected %s to be in the OCI layout, got: %v", blob.Digest, err)
+		}
+	}
+
+	// a tarball
+	tarPath := filepath.Join(t.TempDir(), "image.tar")
+	if _, err := registry.PullToOCILayout(ctx, "repo", image.Digest.String(), tarPath); err != nil {
+		t.Fatalf("PullToOCILayout failed for a tarball: %v", err)
+	}
+	entries := readTar(t, tarPath)
+	for _, name := range []string{ocispec.ImageLayoutFile, "index.json", blobPath(image.Digest), blobPath(config.Digest), blobPath(layer.Digest)} {
+		if _, ok := entries[name]; !ok {
+			t.Fatalf("Expected %s in the tarball but got %v", name, entries)
+		}
+	}
+	if err := json.Unmarshal(entries["index.json"], &index); err != nil {
+		t.Fatalf("Failed to parse the tarball's index.json: %v", err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != image.Digest {
+		t.Fatalf("Expected the tarball's index.json to reference %s but got %v", image.Digest, index.Manifests)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(tarPath), ".oci-layout-*")); len(matches) != 0 {
+		t.Fatalf("Expected the temporary layout to be removed but found %v", matches)
+	}
+
+	_, err = registry.PullToOCILayout(ctx, "repo", "missing", filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatalf("Expected pulling a missing image to fail")
+	}
+}
+
+func readJSONFile(t *testing.T, path string, v interface{}) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		t.Fatalf("Failed to parse %s: %v", path, err)
+	}
+}
+
+// readTar returns the content of the regular files of a tarball, keyed by name
+func readTar(t *testing.T, path string) map[string][]byte {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+	entries := map[string][]byte{}
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read %s from %s: %v", header.Name, path, err)
+		}
+		entries[header.Name] = content
+	}
+}
+
+func blobPath(dgst digest.Digest) string {
+	return "blobs/" + dgst.Algorithm().String() + "/" + dgst.Encoded()
+}
+
+func TestPushFromOCILayout(t *testing.T) {
+	doTest := func(tagInLayout bool, intercept func(w http.ResponseWriter, r *http.Request) bool, expectedErr error) {
+		ctx := newTestContext("abcd-1234-test-push-from-oci-layout")
+		fake := newFakeRegistry(t)
+		fake.intercept = intercept
+
+		// an index generated externally into a layout directory
+		layoutPath := t.TempDir()
+		layout, err := oci.NewWithContext(ctx, layoutPath)
+		if err != nil {
+			t.Fatalf("Failed to create the OCI layout: %v", err)
+		}
+		indexDesc := storeTestIndex(t, ctx, &store.SociStore{Store: layout}, []byte("ztoc"))
+		if tagInLayout {
+			if err := layout.Tag(ctx, indexDesc, "soci"); err != nil {
+				t.Fatalf("Failed to tag the index in the OCI layout: %v", err)
+			}
+		}
+
+		result, err := fake.registry(t).PushFromOCILayout(ctx, layoutPath, indexDesc.Digest.String(), "repo", "latest-soci")
+		if expectedErr != nil {
+			if !errors.Is(err, expectedErr) {
+				t.Fatalf("Expected %v but got %v", expectedErr, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("PushFromOCILayout failed: %v", err)
+		}
+		if result.Descriptor.Digest != indexDesc.Digest || result.Descriptor.MediaType != MediaTypeOCIManifest {
+			t.Fatalf("Expected %s to be pushed as a manifest but got %v", indexDesc.Digest, result.Descriptor)
+		}
+		if fake.tagged("repo", "latest-soci") != indexDesc.Digest.String() {
+			t.Fatalf("Expected latest-soci to point to %s but got %q", indexDesc.Digest, fake.tagged("repo", "latest-soci"))
+		}
+		if !fake.hasBlob("repo", digest.FromBytes([]byte("ztoc"))) {
+			t.Fatalf("Expected the ztoc to be pushed")
+		}
+	}
+
+	doTest(true, nil, nil)
+	doTest(false, nil, nil)
+	doTest(true, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			writeRegistryError(w, http.StatusUnsupportedMediaType, errcode.ErrorCodeManifestInvalid, "unsupported media type")
+			return true
+		}
+		return false
+	}, RegistryNotSupportingOciArtifacts)
+
+	ctx := newTestContext("abcd-1234-test-push-from-oci-layout")
+	fake := newFakeRegistry(t)
+	_, err := fake.registry(t).PushFromOCILayout(ctx, t.TempDir(), digest.FromString("index").String(), "repo", "")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected pushing from a directory without an OCI layout to fail with os.ErrNotExist but got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrOperationTimeout = errors.New("registry operation timed out")
+
+// Run a discrete registry operation, e.g. a resolve or the fetch of a single manifest, under the timeout of
+// WithPerOperationTimeout, if any. An operation outliving its timeout fails with ErrOperationTimeout while ctx is
+// still valid, so that the caller can retry it; the expiry of ctx itself is returned as is.
+func (registry *Registry) withOperationTimeout(ctx context.Context, operation func(ctx context.Context) error) error {
+	timeout := registry.config.perOperationTimeout
+	if timeout <= 0 {
+		return operation(ctx)
+	}
+	operationCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := operation(operationCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(operationCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %w", ErrOperationTimeout, timeout, err)
+	}
+	return err
+}
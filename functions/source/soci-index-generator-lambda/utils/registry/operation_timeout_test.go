@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPerOperationTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(newTestContext("abcd-1234-test-per-operation-timeout"), time.Minute)
+	defer cancel()
+	fake := newFakeRegistry(t)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+
+	// the first manifest request of each path hangs until the client gives up on it
+	var mu sync.Mutex
+	slow := map[string]bool{}
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			return false
+		}
+		mu.Lock()
+		seen := slow[r.URL.Path]
+		slow[r.URL.Path] = true
+		mu.Unlock()
+		if seen {
+			return false
+		}
+		<-r.Context().Done()
+		return true
+	}
+	registry := fake.registry(t, WithPerOperationTimeout(50*time.Millisecond))
+
+	doTest := func(name string, operation func() error) {
+		start := time.Now()
+		err := operation()
+		if !errors.Is(err, ErrOperationTimeout) {
+			t.Fatalf("Expected the slow %s to fail with ErrOperationTimeout but got %v", name, err)
+		}
+		if !DefaultIsRetryable(err) {
+			t.Fatalf("Expected the timed out %s to be retryable", name)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Fatalf("Expected the slow %s to fail fast but it took %s", name, elapsed)
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Expected the overall context to remain valid but got %v", ctx.Err())
+		}
+		// the operation's timeout doesn't carry over to the retry
+		if err := operation(); err != nil {
+			t.Fatalf("Expected the retried %s to succeed but got %v", name, err)
+		}
+	}
+
+	doTest("resolve", func() error {
+		_, err := registry.HeadManifest(ctx, "repo", "latest")
+		return err
+	})
+	doTest("manifest fetch", func() error {
+		_, err := registry.GetManifest(ctx, "repo", image.Digest.String())
+		return err
+	})
+}
+
+func TestPerOperationTimeoutOverallDeadline(t *testing.T) {
+	fake := newFakeRegistry(t)
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		<-r.Context().Done()
+		return true
+	}
+
+	// the expiry of the overall context isn't an operation timeout, and isn't retryable
+	ctx, cancel := context.WithTimeout(newTestContext("abcd-1234-test-per-operation-timeout-overall-deadline"), 50*time.Millisecond)
+	defer cancel()
+	registry := fake.registry(t, WithPerOperationTimeout(time.Minute))
+	_, err := registry.HeadManifest(ctx, "repo", "latest")
+	if err == nil || errors.Is(err, ErrOperationTimeout) || DefaultIsRetryable(err) {
+		t.Fatalf("Expected the expiry of the overall context to be returned as is but got %v", err)
+	}
+}
@@ -0,0 +1,652 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// Default upper bound of the size of a manifest read into memory
+const DefaultMaxManifestSize int64 = 4 << 20 // 4 MiB
+
+// Default upper bound of the size of a manifest buffered in memory by the copy of a Push, which is conservative for
+// Lambdas with little memory: SOCI indexes and image manifests are well below it
+const DefaultMaxMetadataBytes int64 = 1 << 20 // 1 MiB
+
+// Default upper bound of the number of layers of an image to index
+const DefaultMaxLayers = 1000
+
+// Option configures a Registry at initialization
+type Option func(*registryConfig)
+
+type registryConfig struct {
+	maxManifestSize             int64
+	maxLayers                   int
+	maxIndexDepth               int
+	region                      string
+	profile                     string
+	unsupportedArtifactMatchers []UnsupportedArtifactMatcher
+	pullThroughCachePrefixes    []string
+	pullThroughCacheRetryDelay  time.Duration
+	replicationPollInterval     time.Duration
+	pushRetryDelay              time.Duration
+	childConfirmationDelay      time.Duration
+	isRetryable                 func(error) bool
+	authClient                  *auth.Client
+	credentialProvider          CredentialProvider
+	plainHTTP                   bool
+	insecureSkipTLSVerify       bool
+	requestsPerSecond           float64
+	requestBurst                int
+	retryBudget                 *rateLimiter
+	traceHeaders                http.Header
+	transportTuning             TransportTuning
+	deepValidation              bool
+	additionalConfigMediaTypes  []string
+	resolveCache                bool
+	resolveCacheTTL             time.Duration
+	provenanceAnnotations       bool
+	perOperationTimeout         time.Duration
+	pushAllowedRegistries       []string
+}
+
+func newRegistryConfig(opts []Option) registryConfig {
+	config := registryConfig{
+		maxManifestSize:            DefaultMaxManifestSize,
+		maxLayers:                  DefaultMaxLayers,
+		maxIndexDepth:              DefaultMaxIndexDepth,
+		pullThroughCacheRetryDelay: defaultPullThroughCacheRetryDelay,
+		replicationPollInterval:    defaultReplicationPollInterval,
+		pushRetryDelay:             defaultPushRetryDelay,
+		childConfirmationDelay:     defaultChildConfirmationDelay,
+		isRetryable:                DefaultIsRetryable,
+		transportTuning:            DefaultTransportTuning,
+		provenanceAnnotations:      true,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// Whether Push annotates the pushed indexes with ProvenanceAnnotations, which it does by default
+func WithProvenanceAnnotations(enabled bool) Option {
+	return func(config *registryConfig) {
+		config.provenanceAnnotations = enabled
+	}
+}
+
+// Bound each discrete registry operation, i.e. the resolve of a reference or the fetch of a single manifest, with
+// its own timeout on top of the deadline of the context, so that a slow request fails fast with ErrOperationTimeout
+// rather than eating the whole budget of the invocation. DefaultIsRetryable deems such failures retryable.
+// Operations aren't bounded by default, and non positive timeouts keep them unbounded.
+func WithPerOperationTimeout(timeout time.Duration) Option {
+	return func(config *registryConfig) {
+		config.perOperationTimeout = timeout
+	}
+}
+
+// Only allow Push to the registries matching one of the patterns, e.g. as a guardrail in multi-tenant setups:
+// an AWS account id allows the ECR registries of the account in any region, and other patterns are registry hosts,
+// optionally with a port, where * matches any run of characters but dots, e.g. "*.dkr.ecr.us-west-2.amazonaws.com".
+// Pushes to other registries fail with ErrPushDestinationNotAllowed before anything is uploaded. Any registry is
+// allowed by default.
+func WithPushAllowedRegistries(patterns ...string) Option {
+	return func(config *registryConfig) {
+		config.pushAllowedRegistries = append(config.pushAllowedRegistries, patterns...)
+	}
+}
+
+// Memoize the descriptors HeadManifest resolves references to, for the given time or for the life of the
+// Registry with a non positive TTL, so that repeated existence checks of a tag during a batch don't each
+// reach the registry. Use WithoutResolveCache or InvalidateResolveCache to observe a tag moved by another writer.
+func WithResolveCache(ttl time.Duration) Option {
+	return func(config *registryConfig) {
+		config.resolveCache = true
+		config.resolveCacheTTL = ttl
+	}
+}
+
+// Limit the size of manifests read into memory. Larger manifests are rejected with ErrManifestTooLarge.
+// Non positive values keep the default of DefaultMaxManifestSize.
+func WithMaxManifestSize(maxManifestSize int64) Option {
+	return func(config *registryConfig) {
+		if maxManifestSize > 0 {
+			config.maxManifestSize = maxManifestSize
+		}
+	}
+}
+
+// Limit the number of layers of the images to index. Images with more layers are rejected with ErrTooManyLayers
+// when validating their digest, before any layer is pulled. Non positive values keep the default of DefaultMaxLayers.
+func WithMaxLayers(maxLayers int) Option {
+	return func(config *registryConfig) {
+		if maxLayers > 0 {
+			config.maxLayers = maxLayers
+		}
+	}
+}
+
+// Also accept image manifests with a config of these media types, e.g. WASM or other OCI artifact configs, on top of
+// ImageConfigMediaTypes, so that such images are validated, pulled and indexed like any other image
+func WithAdditionalConfigMediaTypes(mediaTypes ...string) Option {
+	return func(config *registryConfig) {
+		config.additionalConfigMediaTypes = append(config.additionalConfigMediaTypes, mediaTypes...)
+	}
+}
+
+// Also check that the config blob of an image manifest exists when validating its digest, rejecting manifests
+// pointing at a deleted config with ErrConfigBlobMissing. This costs a request per validation, so it is off by default.
+func WithDeepValidation() Option {
+	return func(config *registryConfig) {
+		config.deepValidation = true
+	}
+}
+
+// Limit the nesting of image indexes, the top level index included. Nested indexes are flattened to their image
+// manifests, and deeper indexes are rejected with ErrIndexTooDeep. Non positive values keep the default of
+// DefaultMaxIndexDepth.
+func WithMaxIndexDepth(maxIndexDepth int) Option {
+	return func(config *registryConfig) {
+		if maxIndexDepth > 0 {
+			config.maxIndexDepth = maxIndexDepth
+		}
+	}
+}
+
+// Use the given AWS region for the ECR client, instead of the region in the registry URL or the default chain
+func WithRegion(region string) Option {
+	return func(config *registryConfig) {
+		config.region = region
+	}
+}
+
+// Use the given auth client verbatim for every request to the registry, e.g. one with a custom credential chain,
+// cache or retry policy. The built-in ECR authorization is skipped, along with its re-authorization on expired tokens.
+func WithAuthClient(client *auth.Client) Option {
+	return func(config *registryConfig) {
+		config.authClient = client
+	}
+}
+
+// Authenticate with a non ECR registry using the credentials of the given provider, e.g. one reading them from a
+// secret backend. ECR registries ignore the provider, as does a client given with WithAuthClient.
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(config *registryConfig) {
+		config.credentialProvider = provider
+	}
+}
+
+// Authorize with ECR using the credentials of a named profile of the shared AWS config, e.g. for local runs.
+// The profile takes precedence over the AWS_PROFILE environment variable and the rest of the default chain;
+// an explicit WithRegion still takes precedence over the profile's region.
+func WithProfile(profile string) Option {
+	return func(config *registryConfig) {
+		config.profile = profile
+	}
+}
+
+// Connect to the registry over plain HTTP instead of HTTPS, e.g. to a local registry:2 container during development.
+// Init rejects the option for ECR registries with ErrInsecureEcrRegistry.
+func WithPlainHTTP() Option {
+	return func(config *registryConfig) {
+		config.plainHTTP = true
+	}
+}
+
+// Skip the verification of the registry's TLS certificate, e.g. for a test registry with a self-signed certificate.
+// Init rejects the option for ECR registries with ErrInsecureEcrRegistry. It doesn't apply to a client given
+// with WithAuthClient, which brings its own transport.
+func WithInsecureSkipTLSVerify() Option {
+	return func(config *registryConfig) {
+		config.insecureSkipTLSVerify = true
+	}
+}
+
+// Limit the rate of the requests to the registry to requestsPerSecond, allowing bursts of up to burst requests,
+// e.g. to stay under the ECR request quotas during large backfills. Retries count as requests. Requests aren't
+// limited by default, and non positive rates keep them unlimited. The limit doesn't apply to a client given with
+// WithAuthClient.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(config *registryConfig) {
+		config.requestsPerSecond = requestsPerSecond
+		config.requestBurst = burst
+	}
+}
+
+// Share a budget of retries across all the operations of a Registry, refilled at retriesPerSecond retries per
+// second and holding up to burst retries, so that a widespread outage of the registry doesn't turn every operation
+// of a batch into a retry storm. Once the budget is spent, operations fail fast with ErrRetryBudgetExhausted
+// instead of retrying, and requests retried by the transport return their last response. The budget covers the
+// retries of the transport, of WithMaxPushRetries and of expired pre-signed URLs. Retries are unlimited by default.
+func WithRetryBudget(retriesPerSecond float64, burst int) Option {
+	return func(config *registryConfig) {
+		config.retryBudget = newRateLimiter(max(retriesPerSecond, 0), burst)
+	}
+}
+
+// Tune the connection reuse of the transport of the requests to the registry, e.g. to keep more connections open
+// for large batches. Non positive numbers and durations keep the values of DefaultTransportTuning. The tuning
+// doesn't apply to a client given with WithAuthClient.
+func WithTransportTuning(tuning TransportTuning) Option {
+	return func(config *registryConfig) {
+		if tuning.MaxIdleConns > 0 {
+			config.transportTuning.MaxIdleConns = tuning.MaxIdleConns
+		}
+		if tuning.MaxIdleConnsPerHost > 0 {
+			config.transportTuning.MaxIdleConnsPerHost = tuning.MaxIdleConnsPerHost
+		}
+		if tuning.IdleConnTimeout > 0 {
+			config.transportTuning.IdleConnTimeout = tuning.IdleConnTimeout
+		}
+		config.transportTuning.ForceAttemptHTTP2 = tuning.ForceAttemptHTTP2
+	}
+}
+
+// Add the given headers to every request to the registry and to the ECR API, e.g. an X-Amzn-Trace-Id header to
+// correlate registry latency with upstream spans. Headers given more than once are merged, and replace the headers
+// of the same name the client sets. They don't apply to a client given with WithAuthClient.
+func WithTraceHeaders(headers http.Header) Option {
+	return func(config *registryConfig) {
+		if config.traceHeaders == nil {
+			config.traceHeaders = http.Header{}
+		}
+		for name, values := range headers {
+			config.traceHeaders[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+}
+
+// Decide which failed push attempts are retried with the given policy instead of DefaultIsRetryable, e.g. to never
+// retry throttled requests. The policy is only consulted for pushes with WithMaxPushRetries. A nil policy keeps
+// the default.
+func WithIsRetryable(isRetryable func(error) bool) Option {
+	return func(config *registryConfig) {
+		if isRetryable != nil {
+			config.isRetryable = isRetryable
+		}
+	}
+}
+
+// Recognize additional registry errors as RegistryNotSupportingOciArtifacts when pushing,
+// on top of the signatures recognized by default
+func WithUnsupportedArtifactMatchers(matchers ...UnsupportedArtifactMatcher) Option {
+	return func(config *registryConfig) {
+		config.unsupportedArtifactMatchers = append(config.unsupportedArtifactMatchers, matchers...)
+	}
+}
+
+// PullOption configures a Pull
+type PullOption func(*pullConfig)
+
+type pullConfig struct {
+	expectedDigest digest.Digest
+	skipIfStored   bool
+	copyOptions    []CopyOptionsMutator
+	mirrorStore    content.Storage
+	mirrorRequired bool
+}
+
+func newPullConfig(opts []PullOption) *pullConfig {
+	config := &pullConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// Fail the pull with ErrDigestMismatch if the image reference doesn't resolve to the expected digest,
+// e.g. when pulling by a tag that was moved since the image was pushed
+func WithExpectedDigest(expectedDigest digest.Digest) PullOption {
+	return func(config *pullConfig) {
+		config.expectedDigest = expectedDigest
+	}
+}
+
+// Skip the pull when the image is already in the local store, e.g. staged by a prior step, and return its descriptor
+// without contacting the registry. The image must be pulled by digest and its whole graph must be in the store,
+// otherwise it is pulled as usual.
+func WithSkipPullIfStored() PullOption {
+	return func(config *pullConfig) {
+		config.skipIfStored = true
+	}
+}
+
+// Mutate the oras copy options of the pull, on top of the defaults of Pull, e.g. to set MaxMetadataBytes or a custom
+// FindSuccessors. Hooks set by the mutators run before the hooks of Pull, which keep tallying the PullResult;
+// replacing PreCopy drops the skipping of foreign layers.
+func WithCopyOptions(mutate CopyOptionsMutator) PullOption {
+	return func(config *pullConfig) {
+		config.copyOptions = append(config.copyOptions, mutate)
+	}
+}
+
+// Find the successors the pull copies with findSuccessors instead of DefaultFindSuccessors, to prune the graph,
+// e.g. to leave out huge cache layers that are irrelevant to indexing: the descriptors it leaves out aren't fetched,
+// and neither are their own successors. The layers left out aren't in the store, so the SOCI index built from the
+// pull can't span them. A nil findSuccessors keeps the default.
+func WithFindSuccessors(findSuccessors FindSuccessorsFunc) PullOption {
+	return func(config *pullConfig) {
+		if findSuccessors == nil {
+			return
+		}
+		config.copyOptions = append(config.copyOptions, func(opts *oras.CopyOptions) {
+			opts.FindSuccessors = findSuccessors
+		})
+	}
+}
+
+// Write the pulled content to a second store as it's written to the local store, e.g. a store shared between Lambdas
+// on EFS. By default a failure to write to the mirror is logged and the pull carries on, see WithMirrorStoreRequired.
+// Images skipped by WithSkipPullIfStored aren't mirrored.
+func WithMirrorStore(mirror content.Storage) PullOption {
+	return func(config *pullConfig) {
+		config.mirrorStore = mirror
+	}
+}
+
+// Fail the pull with ErrMirrorFailed when writing to the store of WithMirrorStore fails
+func WithMirrorStoreRequired() PullOption {
+	return func(config *pullConfig) {
+		config.mirrorRequired = true
+	}
+}
+
+// Treat repositories under the given prefixes as ECR pull through cache repositories, e.g. "docker-hub" for
+// docker-hub/library/redis: a manifest they don't have yet is pulled to warm the cache, then looked up again.
+func WithPullThroughCachePrefixes(prefixes ...string) Option {
+	return func(config *registryConfig) {
+		config.pullThroughCachePrefixes = append(config.pullThroughCachePrefixes, prefixes...)
+	}
+}
+
+// Maps the source image's repository name to the repository a SOCI index is pushed to
+type RepositoryMapper func(repositoryName string) string
+
+// PushOption configures a Push
+type PushOption func(*pushConfig)
+
+type pushConfig struct {
+	repositoryMapper           RepositoryMapper
+	dockerManifestListFallback bool
+	rootMapper                 RootMapper
+	maxPushRetries             int
+	requireOCIArtifacts        bool
+	artifactType               string
+	copyGraphOptions           []CopyGraphOptionsMutator
+	sociIndexVersion           SociIndexVersion
+	verifyTag                  bool
+	capabilities               *Capabilities
+	mountFrom                  []string
+	conditionalTag             bool
+	expectedTagDigest          string
+	maxMetadataBytes           int64
+	additionalTags             []string
+	annotations                map[string]string
+	snapshotterValidation      bool
+	indexManifestWriter        io.Writer
+	snapshotterCompatibility   *SnapshotterCompatibility
+}
+
+func newPushConfig(opts []PushOption) *pushConfig {
+	config := &pushConfig{maxMetadataBytes: DefaultMaxMetadataBytes}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// Push into a repository derived from the source repository name instead of the source repository itself
+func WithRepositoryMapper(mapper RepositoryMapper) PushOption {
+	return func(config *pushConfig) {
+		config.repositoryMapper = mapper
+	}
+}
+
+// Push into the repository given by an explicit source -> target map.
+// Repositories missing from the map are pushed to unchanged.
+func WithRepositoryMap(repositories map[string]string) PushOption {
+	return WithRepositoryMapper(func(repositoryName string) string {
+		if target, ok := repositories[repositoryName]; ok {
+			return target
+		}
+		return repositoryName
+	})
+}
+
+// Push into a sibling repository named after the source repository plus a suffix, e.g. app -> app-soci
+func WithRepositorySuffix(suffix string) PushOption {
+	return WithRepositoryMapper(func(repositoryName string) string {
+		return repositoryName + suffix
+	})
+}
+
+// Limit the size of the manifests the copy of a Push buffers in memory, i.e. MaxMetadataBytes of the oras copy
+// options. Larger manifests fail the push with ErrManifestTooLarge. Non positive values keep the default of
+// DefaultMaxMetadataBytes.
+func WithMaxMetadataBytes(maxBytes int64) PushOption {
+	return func(config *pushConfig) {
+		if maxBytes > 0 {
+			config.maxMetadataBytes = maxBytes
+		}
+	}
+}
+
+// Add annotations to the pushed index, overriding the provenance annotations of the same keys. An empty value
+// removes the annotation, e.g. to leave out the commit of the builder.
+func WithAnnotations(annotations map[string]string) PushOption {
+	return func(config *pushConfig) {
+		if config.annotations == nil {
+			config.annotations = map[string]string{}
+		}
+		for key, value := range annotations {
+			config.annotations[key] = value
+		}
+	}
+}
+
+// When the registry rejects an OCI image index with RegistryNotSupportingOciArtifacts, re-serialize the index
+// as a Docker manifest list and push it again. This only applies to OCI image indexes, i.e. SOCI V2 pushes.
+func WithDockerManifestListFallback() PushOption {
+	return func(config *pushConfig) {
+		config.dockerManifestListFallback = true
+	}
+}
+
+// Require the index to be pushed as an OCI artifact: a registry that doesn't support OCI artifacts fails the push
+// with RegistryNotSupportingOciArtifacts right away. This strict mode and WithDockerManifestListFallback are
+// mutually exclusive, Push rejects options with both.
+func WithRequireOCIArtifacts() PushOption {
+	return func(config *pushConfig) {
+		config.requireOCIArtifacts = true
+	}
+}
+
+// Push the index with the given artifactType, e.g. the artifact type a legacy SOCI consumer expects, instead of
+// the one it was built with. The index is rewritten, so its digest changes; the tag, if any, is applied to the
+// rewritten index. Push rejects artifact types that aren't media types with ErrInvalidArtifactType.
+func WithArtifactType(artifactType string) PushOption {
+	return func(config *pushConfig) {
+		config.artifactType = artifactType
+	}
+}
+
+// Adjust the pushed index for the snapshotter versions of compatibility, e.g. SnapshotterCompatibilityPreV2 for
+// clusters running snapshotters that predate V2 indexes. Indexes the snapshotters don't understand are rejected
+// with ErrSnapshotterIncompatible. An index the compatibility rewrites gets another digest, and a WithArtifactType
+// applies on top of the compatibility. Pushes target SnapshotterCompatibilityCurrent by default.
+func WithSnapshotterCompatibility(compatibility SnapshotterCompatibility) PushOption {
+	return func(config *pushConfig) {
+		config.snapshotterCompatibility = &compatibility
+	}
+}
+
+// Mutate the oras copy options of the push, on top of the defaults of Push, e.g. to set Concurrency or a custom
+// FindSuccessors. Hooks set by the mutators run before the hooks of Push, which keep tallying the PushResult, and
+// blobs already present in the target repository are still skipped.
+func WithCopyGraphOptions(mutate CopyGraphOptionsMutator) PushOption {
+	return func(config *pushConfig) {
+		config.copyGraphOptions = append(config.copyGraphOptions, mutate)
+	}
+}
+
+// Check that the index matches the given SOCI index version, V1 or V2, before pushing it, failing the push with
+// ErrVersionMismatch otherwise: a V1 index must be a SOCI V1 index manifest, and a V2 index either an image index or
+// a SOCI V2 index manifest. The check applies to the given index, before any WithRootMapper mapping.
+func WithSociIndexVersion(sociIndexVersion SociIndexVersion) PushOption {
+	return func(config *pushConfig) {
+		config.sociIndexVersion = sociIndexVersion
+	}
+}
+
+// Maps the root of the graph to push to another root, e.g. an index rewritten to reference another subject.
+// The returned root and all of its successors must be in the local store, so a mapper creating a new root
+// must push it to sociStore.
+type RootMapper func(ctx context.Context, sociStore *store.SociStore, root ocispec.Descriptor) (ocispec.Descriptor, error)
+
+// Push the graph rooted at the descriptor returned by mapper instead of the given descriptor, the equivalent of
+// oras CopyOptions.MapRoot for Push. The tag, if any, is applied to the mapped root, and the Docker manifest list
+// fallback applies to the mapped root rather than the original one. Returning the root unchanged pushes it as is.
+func WithRootMapper(mapper RootMapper) PushOption {
+	return func(config *pushConfig) {
+		config.rootMapper = mapper
+	}
+}
+
+// Retry the copy of a push up to maxPushRetries times when it fails with a transient error, e.g. a 5xx
+// response or a connection reset partway through. Blobs uploaded by a failed attempt are not uploaded again,
+// so a retry resumes the push. Pushes aren't retried by default.
+func WithMaxPushRetries(maxPushRetries int) PushOption {
+	return func(config *pushConfig) {
+		if maxPushRetries > 0 {
+			config.maxPushRetries = maxPushRetries
+		}
+	}
+}
+
+// After tagging, check that the tag resolves to the pushed index, failing the push with ErrTagNotMoved otherwise,
+// e.g. when a concurrent push moved the tag again in the meantime
+func WithTagVerification() PushOption {
+	return func(config *pushConfig) {
+		config.verifyTag = true
+	}
+}
+
+// Also apply these tags to the pushed index, after the tag given to Push, e.g. both latest-soci and a digest derived
+// tag. Each tag is applied on its own: a tag failing, e.g. with ErrTagImmutable, doesn't undo the others. The applied
+// and failed tags are reported in the PushResult. WithExpectedTagDigest only applies to the tag given to Push.
+func WithAdditionalTags(tags ...string) PushOption {
+	return func(config *pushConfig) {
+		config.additionalTags = append(config.additionalTags, tags...)
+	}
+}
+
+// The tags to apply to the pushed index, without empty or duplicate tags
+func (config *pushConfig) tags(tag string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range append([]string{tag}, config.additionalTags...) {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// Only move the tag if it still points at the given digest, e.g. the digest it was resolved to before building the
+// index, or if it doesn't exist yet with an empty digest. A tag moved in the meantime fails the push or TagIndex with
+// ErrConcurrentModification. The check is atomic with WithCapabilities of a registry supporting conditional requests.
+func WithExpectedTagDigest(expectedDigest string) PushOption {
+	return func(config *pushConfig) {
+		config.conditionalTag = true
+		config.expectedTagDigest = expectedDigest
+	}
+}
+
+// Push according to the capabilities of the target repository, e.g. as returned by DetectCapabilities: an OCI
+// image index or a manifest with a subject the registry doesn't support is handled as RegistryNotSupportingOciArtifacts
+// up front, without attempting to push it first
+func WithCapabilities(capabilities Capabilities) PushOption {
+	return func(config *pushConfig) {
+		config.capabilities = &capabilities
+	}
+}
+
+// Mount the blobs missing from the target repository from the given repositories of the same registry, tried in
+// turn, instead of uploading them, e.g. from the repository of the source image when pushing to another repository.
+// Blobs are uploaded when no repository can mount them.
+func WithBlobMountFrom(repositoryNames ...string) PushOption {
+	return func(config *pushConfig) {
+		config.mountFrom = append(config.mountFrom, repositoryNames...)
+	}
+}
+
+// Return the repository to push to for the given source repository
+func (config *pushConfig) targetRepository(repositoryName string) string {
+	if config.repositoryMapper == nil {
+		return repositoryName
+	}
+	return config.repositoryMapper(repositoryName)
+}
+
+// PruneOption configures a PruneStore
+type PruneOption func(*pruneConfig)
+
+type pruneConfig struct {
+	pruneSharedBlobs bool
+	dryRun           bool
+}
+
+func newPruneConfig(opts []PruneOption) *pruneConfig {
+	config := &pruneConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// Remove every blob of the image, including the blobs other images of the store still reference,
+// e.g. when the store isn't reused for other images
+func WithPruneSharedBlobs() PruneOption {
+	return func(config *pruneConfig) {
+		config.pruneSharedBlobs = true
+	}
+}
+
+// Only report what PruneOrphanedIndexes would delete, without deleting anything
+func WithDryRun() PruneOption {
+	return func(config *pruneConfig) {
+		config.dryRun = true
+	}
+}
+
+// Check that the pushed index parses the way the SOCI snapshotter parses the indexes it fetches, as
+// ValidateIndexParsable does, before tagging it. An index the snapshotter would reject fails the push with
+// ErrIndexNotParsable and is left untagged.
+func WithSnapshotterValidation() PushOption {
+	return func(config *pushConfig) {
+		config.snapshotterValidation = true
+	}
+}
+
+// Write the verbatim bytes of the index manifest to w before uploading it, e.g. to log or archive it. The bytes are
+// those of the pushed root, after WithArtifactType and the annotations are applied, so they hash to the pushed
+// digest. When WithDockerManifestListFallback converts the index, the manifest list is written to w as well.
+// A failure to write to w fails the push before anything is uploaded.
+func WithIndexManifestWriter(w io.Writer) PushOption {
+	return func(config *pushConfig) {
+		config.indexManifestWriter = w
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// Delete the SOCI indexes of a repository whose subject image no longer exists, returning the orphaned indexes.
+// With WithDryRun, the orphaned indexes are only returned. The manifests of ECR repositories are listed with the ECR
+// API; other registries have no API listing untagged manifests, so only the tagged manifests are considered there.
+// SOCI indexes without a subject, such as SOCI V1 indexes pushed as image manifests, are never deleted.
+// Library-only: the Lambda never prunes, so its role is not granted the ecr:ListImages and ecr:BatchDeleteImage
+// permissions this needs on ECR.
+func (registry *Registry) PruneOrphanedIndexes(ctx context.Context, repositoryName string, opts ...PruneOption) ([]ocispec.Descriptor, error) {
+	orphans, err := registry.pruneOrphanedIndexes(ctx, repositoryName, opts...)
+	return orphans, registry.wrapError("prune orphaned indexes", repositoryName, "", err)
+}
+
+func (registry *Registry) pruneOrphanedIndexes(ctx context.Context, repositoryName string, opts ...PruneOption) ([]ocispec.Descriptor, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return nil, err
+	}
+	config := newPruneConfig(opts)
+	digests, err := registry.listManifestDigests(ctx, repositoryName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the manifests of the repository: %w", err)
+	}
+
+	var orphans []ocispec.Descriptor
+	for _, dgst := range digests {
+		content, desc, err := registry.getManifestRaw(ctx, repositoryName, dgst.String())
+		if errors.Is(err, errdef.ErrNotFound) {
+			// deleted since it was listed
+			continue
+		}
+		if err != nil {
+			return orphans, err
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil || !isSociIndexManifest(manifest) || manifest.Subject == nil {
+			continue
+		}
+		_, err = registry.headManifest(ctx, repositoryName, manifest.Subject.Digest.String())
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errdef.ErrNotFound) {
+			return orphans, fmt.Errorf("failed to resolve the subject %s of index %s: %w", manifest.Subject.Digest, dgst, err)
+		}
+
+		if config.dryRun {
+			log.Info(ctx, fmt.Sprintf("Would delete index %s, its subject %s no longer exists", dgst, manifest.Subject.Digest))
+		} else {
+			log.Info(ctx, fmt.Sprintf("Deleting index %s, its subject %s no longer exists", dgst, manifest.Subject.Digest))
+			if err := registry.deleteManifest(ctx, repositoryName, desc); err != nil {
+				return orphans, fmt.Errorf("failed to delete index %s: %w", dgst, err)
+			}
+		}
+		orphans = append(orphans, desc)
+	}
+	return orphans, nil
+}
+
+// List the digests of the manifests of a repository: all of them for ECR repositories, the tagged ones otherwise
+func (registry *Registry) listManifestDigests(ctx context.Context, repositoryName string) ([]digest.Digest, error) {
+	if registry.ecrCredentials != nil {
+		return registry.listEcrImageDigests(ctx, repositoryName)
+	}
+
+	var digests []digest.Digest
+	err := registry.withReauthorization(ctx, func() error {
+		digests = nil
+		repo, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		var tags []string
+		err = repo.Tags(ctx, "", func(page []string) error {
+			tags = append(tags, page...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		seen := map[digest.Digest]bool{}
+		for _, tag := range tags {
+			desc, err := repo.Resolve(ctx, tag)
+			if err != nil {
+				return fmt.Errorf("failed to resolve tag %s: %w", tag, err)
+			}
+			if !seen[desc.Digest] {
+				seen[desc.Digest] = true
+				digests = append(digests, desc.Digest)
+			}
+		}
+		return nil
+	})
+	return digests, err
+}
+
+// List the digests of the images of an ECR repository, tagged or not
+func (registry *Registry) listEcrImageDigests(ctx context.Context, repositoryName string) ([]digest.Digest, error) {
+	input := &ecr.ListImagesInput{RepositoryName: aws.String(repositoryName)}
+	if registryId := ecrRegistryId(registry.registry.Reference.Registry); registryId != "" {
+		input.RegistryId = aws.String(registryId)
+	}
+	var digests []digest.Digest
+	seen := map[digest.Digest]bool{}
+	for {
+		output, err := registry.ecrCredentials.client.ListImages(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		// an image is listed once per tag
+		for _, imageId := range output.ImageIds {
+			dgst := digest.Digest(aws.ToString(imageId.ImageDigest))
+			if dgst != "" && !seen[dgst] {
+				seen[dgst] = true
+				digests = append(digests, dgst)
+			}
+		}
+		if aws.ToString(output.NextToken) == "" {
+			return digests, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// Delete a manifest from a repository
+func (registry *Registry) deleteManifest(ctx context.Context, repositoryName string, desc ocispec.Descriptor) error {
+	return registry.withReauthorization(ctx, func() error {
+		repo, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		return repo.Manifests().Delete(ctx, desc)
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPruneOrphanedIndexes(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-prune-orphaned-indexes")
+
+	// a live image and its index, and indexes of a deleted image
+	newRepository := func() (*fakeRegistry, []ocispec.Descriptor, []ocispec.Descriptor) {
+		fake := newFakeRegistry(t)
+		live := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "live")
+		deleted := ocispec.Descriptor{MediaType: MediaTypeOCIManifest, Digest: digest.FromString("deleted"), Size: 8}
+		referrer := func(artifactType string, configMediaType string, subject ocispec.Descriptor, tags ...string) ocispec.Descriptor {
+			manifest := imageManifest(configMediaType)
+			manifest.ArtifactType = artifactType
+			manifest.Subject = &subject
+			return fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, manifest, tags...)
+		}
+		liveIndex := referrer(soci.SociIndexArtifactTypeV2, ocispec.MediaTypeEmptyJSON, live)
+		orphanV2 := referrer(soci.SociIndexArtifactTypeV2, ocispec.MediaTypeEmptyJSON, deleted, "orphan")
+		orphanV1 := referrer("", soci.SociIndexArtifactTypeV1, deleted)
+		// other orphaned artifacts aren't SOCI indexes, and are left alone
+		sbom := referrer("application/vnd.example.sbom", ocispec.MediaTypeEmptyJSON, deleted)
+		return fake, []ocispec.Descriptor{live, liveIndex, sbom}, []ocispec.Descriptor{orphanV2, orphanV1}
+	}
+
+	doTest := func(withEcr bool, dryRun bool) {
+		fake, kept, orphans := newRepository()
+		registry := fake.registry(t)
+		if withEcr {
+			ecrClient := &fakeEcrClient{}
+			// an image is listed once per tag and once untagged
+			for _, desc := range append(append([]ocispec.Descriptor{}, kept...), append(orphans, orphans[0])...) {
+				ecrClient.images = append(ecrClient.images, types.ImageIdentifier{ImageDigest: aws.String(desc.Digest.String())})
+			}
+			registry.ecrCredentials = &ecrCredentials{client: ecrClient}
+		} else {
+			// only the tagged manifests can be listed
+			orphans = orphans[:1]
+		}
+
+		var opts []PruneOption
+		if dryRun {
+			opts = append(opts, WithDryRun())
+		}
+		pruned, err := registry.PruneOrphanedIndexes(ctx, "repo", opts...)
+		if err != nil {
+			t.Fatalf("PruneOrphanedIndexes failed: %v", err)
+		}
+		var prunedDigests []digest.Digest
+		for _, desc := range pruned {
+			prunedDigests = append(prunedDigests, desc.Digest)
+		}
+		for _, orphan := range orphans {
+			if !slices.Contains(prunedDigests, orphan.Digest) {
+				t.Fatalf("Expected orphan %s to be pruned but got %v", orphan.Digest, prunedDigests)
+			}
+			if fake.hasManifest("repo", orphan.Digest) != dryRun {
+				t.Fatalf("Expected orphan %s to be deleted: %v", orphan.Digest, !dryRun)
+			}
+		}
+		if len(pruned) != len(orphans) {
+			t.Fatalf("Expected %d orphans but got %v", len(orphans), prunedDigests)
+		}
+		for _, desc := range kept {
+			if !fake.hasManifest("repo", desc.Digest) {
+				t.Fatalf("Expected %s to be kept", desc.Digest)
+			}
+		}
+	}
+
+	doTest(true, false)
+	doTest(true, true)
+	doTest(false, false)
+	doTest(false, true)
+}
@@ -0,0 +1,39 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var ErrRegistryUnreachable = errors.New("registry is unreachable")
+
+var ErrRegistryUnauthorized = errors.New("registry rejected the credentials")
+
+// Check that the registry can be reached and authenticated to, without pulling anything, e.g. before processing a batch.
+// Network failures are returned as ErrRegistryUnreachable and rejected credentials as ErrRegistryUnauthorized,
+// both wrapping the underlying error.
+func (registry *Registry) Ping(ctx context.Context) error {
+	err := registry.withReauthorization(ctx, func() error {
+		return registry.registry.Ping(ctx)
+	})
+	switch {
+	case err == nil:
+		return nil
+	case isAuthorizationError(err):
+		err = fmt.Errorf("%w: %w", ErrRegistryUnauthorized, err)
+	case isNetworkError(err):
+		err = fmt.Errorf("%w: %w", ErrRegistryUnreachable, err)
+	}
+	return registry.wrapError("ping", "", "", err)
+}
+
+// Check if an error is a failure to reach the server, such as a refused connection, a DNS failure or a timeout
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
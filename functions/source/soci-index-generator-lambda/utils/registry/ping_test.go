@@ -0,0 +1,46 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-ping")
+
+	doTest := func(fake *fakeRegistry, expectedErr error) {
+		err := fake.registry(t).Ping(ctx)
+		if expectedErr == nil {
+			if err != nil {
+				t.Fatalf("Expected the ping to succeed but got %v", err)
+			}
+			return
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+		if !strings.HasPrefix(err.Error(), "ping "+fake.host()+": ") {
+			t.Fatalf("Expected the error to name the registry, got: %v", err)
+		}
+	}
+
+	doTest(newFakeRegistry(t), nil)
+
+	unauthorized := newFakeRegistry(t)
+	unauthorized.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return true
+	}
+	doTest(unauthorized, ErrRegistryUnauthorized)
+
+	// the server is closed, so connections are refused
+	closed := newFakeRegistry(t)
+	closed.server.Close()
+	doTest(closed, ErrRegistryUnreachable)
+
+}
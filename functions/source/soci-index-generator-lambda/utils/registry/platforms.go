@@ -0,0 +1,152 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrNoMatchingPlatform = errors.New("no platform of the image matches the requested platform")
+
+// List the platforms of an image: the platforms of the children of an image index, in the order of the index and
+// with nested indexes flattened, or the platform of an image manifest's config. Attestations and other children of an unknown/unknown platform,
+// as well as children without a platform, are skipped.
+func (registry *Registry) ListPlatforms(ctx context.Context, repositoryName string, reference string) ([]ocispec.Platform, error) {
+	platformList, err := registry.listPlatforms(ctx, repositoryName, reference)
+	return platformList, registry.wrapError("list platforms", repositoryName, reference, err)
+}
+
+func (registry *Registry) listPlatforms(ctx context.Context, repositoryName string, reference string) ([]ocispec.Platform, error) {
+	content, descriptor, err := registry.getManifestRaw(ctx, repositoryName, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kindFromMediaType(descriptor.MediaType) {
+	case ImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, err
+		}
+		children, err := registry.indexLeaves(ctx, repositoryName, index)
+		if err != nil {
+			return nil, err
+		}
+		var platformList []ocispec.Platform
+		for _, child := range children {
+			if child.Platform == nil || isUnknownPlatform(*child.Platform) {
+				continue
+			}
+			platformList = append(platformList, *child.Platform)
+		}
+		return platformList, nil
+	case ImageManifest:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, err
+		}
+		config, err := registry.getImageConfig(ctx, repositoryName, manifest)
+		if err != nil {
+			return nil, err
+		}
+		if isUnknownPlatform(config.Platform) {
+			return nil, nil
+		}
+		return []ocispec.Platform{config.Platform}, nil
+	default:
+		return nil, fmt.Errorf("cannot list the platforms of media type %s", descriptor.MediaType)
+	}
+}
+
+// Check if a platform is unknown, as used by the attestation manifests of an image index
+func isUnknownPlatform(platform ocispec.Platform) bool {
+	return (platform.OS == "" || platform.OS == "unknown") && (platform.Architecture == "" || platform.Architecture == "unknown")
+}
+
+// Find the image manifest of an image index's children best matching a platform, the way containerd does: an exact
+// match, after normalization, e.g. linux/arm64 for linux/arm64/v8, is preferred, then the closest compatible platform,
+// e.g. linux/arm/v6 for linux/arm/v7. Returns the chosen descriptor and its platform, or ErrNoMatchingPlatform.
+func MatchPlatform(children []ocispec.Descriptor, platform ocispec.Platform) (ocispec.Descriptor, ocispec.Platform, error) {
+	matcher := platforms.Only(platform)
+	var best *ocispec.Descriptor
+	for i, child := range children {
+		if kindFromMediaType(child.MediaType) != ImageManifest || child.Platform == nil || !matcher.Match(*child.Platform) {
+			continue
+		}
+		if best == nil || matcher.Less(*child.Platform, *best.Platform) {
+			best = &children[i]
+		}
+	}
+	if best == nil {
+		return ocispec.Descriptor{}, ocispec.Platform{}, fmt.Errorf("%w: %s", ErrNoMatchingPlatform, platforms.Format(platform))
+	}
+	return *best, *best.Platform, nil
+}
+
+// Resolve the image manifest of an image best matching a platform with MatchPlatform: a child of an image index, with
+// nested indexes flattened, or the image manifest itself when its config's platform matches.
+// Returns the chosen descriptor and the matched platform.
+func (registry *Registry) ResolvePlatform(ctx context.Context, repositoryName string, reference string, platform ocispec.Platform) (ocispec.Descriptor, ocispec.Platform, error) {
+	descriptor, matched, err := registry.resolvePlatform(ctx, repositoryName, reference, platform)
+	return descriptor, matched, registry.wrapError("resolve platform", repositoryName, reference, err)
+}
+
+func (registry *Registry) resolvePlatform(ctx context.Context, repositoryName string, reference string, platform ocispec.Platform) (ocispec.Descriptor, ocispec.Platform, error) {
+	content, descriptor, err := registry.getManifestRaw(ctx, repositoryName, reference)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Platform{}, err
+	}
+
+	switch kindFromMediaType(descriptor.MediaType) {
+	case ImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return ocispec.Descriptor{}, ocispec.Platform{}, err
+		}
+		children, err := registry.indexLeaves(ctx, repositoryName, index)
+		if err != nil {
+			return ocispec.Descriptor{}, ocispec.Platform{}, err
+		}
+		return MatchPlatform(children, platform)
+	case ImageManifest:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return ocispec.Descriptor{}, ocispec.Platform{}, err
+		}
+		config, err := registry.getImageConfig(ctx, repositoryName, manifest)
+		if err != nil {
+			return ocispec.Descriptor{}, ocispec.Platform{}, err
+		}
+		descriptor.Platform = &config.Platform
+		return MatchPlatform([]ocispec.Descriptor{descriptor}, platform)
+	default:
+		return ocispec.Descriptor{}, ocispec.Platform{}, fmt.Errorf("cannot resolve the platforms of media type %s", descriptor.MediaType)
+	}
+}
+
+// Pull the image manifest of an image best matching a platform, resolved with ResolvePlatform, rather than every
+// platform of an image index. Returns the pull result, whose descriptor is the chosen image manifest, and the
+// matched platform.
+func (registry *Registry) PullPlatform(ctx context.Context, repositoryName string, sociStore *store.SociStore, reference string, platform ocispec.Platform, opts ...PullOption) (*PullResult, ocispec.Platform, error) {
+	descriptor, matched, err := registry.ResolvePlatform(ctx, repositoryName, reference, platform)
+	if err != nil {
+		return nil, ocispec.Platform{}, err
+	}
+	if !platforms.NewMatcher(platform).Match(matched) {
+		log.Info(ctx, fmt.Sprintf("Platform %s is not in the image, pulling the compatible platform %s", platforms.Format(platform), platforms.Format(matched)))
+	}
+	result, err := registry.Pull(ctx, repositoryName, sociStore, descriptor.Digest.String(), opts...)
+	if err != nil {
+		return nil, ocispec.Platform{}, err
+	}
+	return result, matched, nil
+}
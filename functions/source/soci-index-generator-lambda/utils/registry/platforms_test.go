@@ -0,0 +1,127 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestListPlatforms(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-list-platforms")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	configContent, err := json.Marshal(ocispec.Image{Platform: ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}})
+	if err != nil {
+		t.Fatalf("Failed to marshal image config: %v", err)
+	}
+	manifest := imageManifest(MediaTypeOCIImageConfig)
+	manifest.Config = fake.putBlob("repo", MediaTypeOCIImageConfig, configContent)
+	arm64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, manifest, "arm64")
+
+	amd64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	attestation := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.in-toto+json"))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64Child := arm64
+	arm64Child.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	attestation.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+	index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{amd64, attestation, arm64Child}}
+	index.SchemaVersion = 2
+	fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index, "multi")
+
+	doTest := func(reference string, expected []string) {
+		platformList, err := registry.ListPlatforms(ctx, "repo", reference)
+		if err != nil {
+			t.Fatalf("ListPlatforms of %s failed: %v", reference, err)
+		}
+		var actual []string
+		for _, platform := range platformList {
+			actual = append(actual, platforms.Format(platform))
+		}
+		if len(actual) != len(expected) {
+			t.Fatalf("Expected platforms %v of %s but got %v", expected, reference, actual)
+		}
+		for i := range expected {
+			if actual[i] != expected[i] {
+				t.Fatalf("Expected platforms %v of %s but got %v", expected, reference, actual)
+			}
+		}
+	}
+
+	doTest("arm64", []string{"linux/arm64/v8"})
+	doTest("multi", []string{"linux/amd64", "linux/arm64/v8"})
+}
+
+func TestMatchPlatform(t *testing.T) {
+	var children []ocispec.Descriptor
+	for _, platform := range []string{"linux/amd64", "linux/arm64", "linux/arm/v5", "linux/arm/v6", "unknown/unknown"} {
+		p := platforms.MustParse(platform)
+		children = append(children, ocispec.Descriptor{MediaType: MediaTypeOCIManifest, Digest: digest.FromString(platform), Platform: &p})
+	}
+	doTest := func(requested string, expected string) {
+		desc, matched, err := MatchPlatform(children, platforms.MustParse(requested))
+		if expected == "" {
+			if !errors.Is(err, ErrNoMatchingPlatform) {
+				t.Fatalf("Expected ErrNoMatchingPlatform for %s but got %v", requested, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("MatchPlatform of %s failed: %v", requested, err)
+		}
+		if platforms.Format(matched) != expected || desc.Digest != digest.FromString(expected) {
+			t.Fatalf("Expected %s to match %s but got %s (%s)", requested, expected, platforms.Format(matched), desc.Digest)
+		}
+	}
+
+	// exact matches, after normalization
+	doTest("linux/amd64", "linux/amd64")
+	doTest("linux/arm64/v8", "linux/arm64")
+	doTest("linux/arm/v6", "linux/arm/v6")
+	// the closest compatible variant
+	doTest("linux/arm/v7", "linux/arm/v6")
+	doTest("linux/amd64/v3", "linux/amd64")
+	// no compatible platform
+	doTest("linux/s390x", "")
+	doTest("windows/amd64", "")
+	doTest("linux/arm/v4", "")
+}
+
+func TestPullPlatform(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-platform")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+
+	armV6 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	armV6.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}
+	amd64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeDockerImageConfig))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{amd64, armV6}}
+	index.SchemaVersion = 2
+	fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index, "multi")
+
+	result, matched, err := registry.PullPlatform(ctx, "repo", sociStore, "multi", platforms.MustParse("linux/arm/v7"))
+	if err != nil {
+		t.Fatalf("PullPlatform failed: %v", err)
+	}
+	if result.Descriptor.Digest != armV6.Digest || platforms.Format(matched) != "linux/arm/v6" {
+		t.Fatalf("Expected linux/arm/v6 to be pulled but got %s (%s)", platforms.Format(matched), result.Descriptor.Digest)
+	}
+	if exists, err := sociStore.Exists(ctx, amd64); err != nil || exists {
+		t.Fatalf("Expected only the matched platform to be pulled")
+	}
+
+	_, _, err = registry.PullPlatform(ctx, "repo", sociStore, "multi", platforms.MustParse("linux/ppc64le"))
+	if !errors.Is(err, ErrNoMatchingPlatform) {
+		t.Fatalf("Expected ErrNoMatchingPlatform but got %v", err)
+	}
+}
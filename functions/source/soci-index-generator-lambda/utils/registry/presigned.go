@@ -0,0 +1,53 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Number of times a pull is retried after a layer fetch failed on an expired pre-signed URL
+const presignedUrlRetries = 2
+
+// Query parameters of the pre-signed S3 URLs ECR redirects blob fetches to
+var presignedUrlParameters = []string{"X-Amz-Signature", "X-Amz-Expires", "Signature", "Expires"}
+
+// Check if an error is a blob fetch rejected by S3 because the pre-signed URL ECR redirected to has expired,
+// e.g. when a slow pull reached a layer long after its redirect was issued. Such a URL is rejected with a 403
+// even though the registry credentials are valid, and fetching the blob again gets a fresh URL.
+func isExpiredPresignedUrlError(err error) bool {
+	var errResp *errcode.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.StatusCode != http.StatusForbidden || errResp.URL == nil {
+		return false
+	}
+	query := errResp.URL.Query()
+	for _, parameter := range presignedUrlParameters {
+		if query.Has(parameter) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run a pull, running it again when a layer fetch failed on an expired pre-signed URL.
+// Every run re-resolves the layers with the registry, which redirects to freshly signed URLs,
+// and the layers already in the local store are not fetched again.
+func (registry *Registry) withPresignedUrlRetries(ctx context.Context, pull func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := pull()
+		if err == nil || attempt > presignedUrlRetries || !isExpiredPresignedUrlError(err) {
+			return err
+		}
+		if !registry.config.allowRetry() {
+			return retryBudgetExhausted(err)
+		}
+		log.Warn(ctx, fmt.Sprintf("Layer fetch failed on an expired pre-signed URL, re-resolving (retry %d of %d)", attempt, presignedUrlRetries))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPullExpiredPresignedUrl(t *testing.T) {
+	doTest := func(expiredFetches int32, expectSuccess bool) {
+		ctx := newTestContext("abcd-1234-test-pull-expired-presigned-url")
+		fake := newFakeRegistry(t)
+		config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+		layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+		fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+
+		// the layer is redirected to a pre-signed URL, which has expired for the first fetches
+		var fetches atomic.Int32
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == "/v2/repo/blobs/"+layer.Digest.String() {
+				http.Redirect(w, r, "/s3/"+layer.Digest.Encoded()+"?X-Amz-Expires=600&X-Amz-Signature=abcd", http.StatusTemporaryRedirect)
+				return true
+			}
+			if !strings.HasPrefix(r.URL.Path, "/s3/") {
+				return false
+			}
+			if fetches.Add(1) <= expiredFetches {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>"))
+				return true
+			}
+			fake.serveBlob(w, r, "repo", layer.Digest.String())
+			return true
+		}
+
+		registry := fake.registry(t)
+		_, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest")
+		if expectSuccess && err != nil {
+			t.Fatalf("Expected the pull to succeed after re-resolving the layer, got: %v", err)
+		}
+		if !expectSuccess {
+			if err == nil {
+				t.Fatalf("Expected the pull to fail but it succeeded")
+			}
+			if !isExpiredPresignedUrlError(err) {
+				t.Fatalf("Expected an expired pre-signed URL error but got %v", err)
+			}
+			if isAuthorizationError(err) {
+				t.Fatalf("Expected an expired pre-signed URL not to be an authorization error")
+			}
+		}
+		if expected := min(expiredFetches+1, presignedUrlRetries+1); fetches.Load() != expected {
+			t.Fatalf("Expected %d fetches of the pre-signed URL but got %d", expected, fetches.Load())
+		}
+	}
+
+	doTest(0, true)
+	doTest(1, true)
+	doTest(presignedUrlRetries, true)
+	doTest(presignedUrlRetries+1, false)
+}
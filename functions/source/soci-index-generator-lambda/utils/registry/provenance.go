@@ -0,0 +1,115 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The builder that pushes the indexes, set at build time, e.g.
+// -ldflags "-X github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/registry.BuilderVersion=v0.2"
+// Builds without them, such as test binaries, push indexes without provenance annotations.
+var (
+	BuilderVersion = ""
+	// The git commit the builder was built from
+	BuilderCommit = ""
+	// When the builder was built, in RFC 3339
+	BuildTime = ""
+)
+
+// Annotations recording the builder that pushed an index
+const (
+	AnnotationBuilderVersion   = "com.amazon.soci.index-builder.version"
+	AnnotationBuilderCommit    = "com.amazon.soci.index-builder.commit"
+	AnnotationBuilderBuildTime = "com.amazon.soci.index-builder.build-time"
+)
+
+// The annotations tracing an index to the builder that pushed it, from the variables set at build time. Unset
+// variables are left out. The build time is used rather than the time of the push so that pushing the same index
+// again yields the same digest, and ComputeIndexDigest leaves it out like other timestamps.
+func ProvenanceAnnotations() map[string]string {
+	annotations := map[string]string{}
+	for key, value := range map[string]string{
+		AnnotationBuilderVersion:   BuilderVersion,
+		AnnotationBuilderCommit:    BuilderCommit,
+		AnnotationBuilderBuildTime: BuildTime,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// The annotations to add to the pushed root: the provenance annotations unless disabled, overridden by those of
+// WithAnnotations
+func (registry *Registry) pushAnnotations(config *pushConfig) map[string]string {
+	annotations := map[string]string{}
+	if registry.config.provenanceAnnotations {
+		maps.Copy(annotations, ProvenanceAnnotations())
+	}
+	maps.Copy(annotations, config.annotations)
+	return annotations
+}
+
+// Add annotations to a manifest or an index of the local store, storing the annotated copy and returning its
+// descriptor. Annotations with an empty value are removed. The descriptor is returned as is when nothing changes.
+func setAnnotations(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor, annotations map[string]string) (ocispec.Descriptor, error) {
+	rc, err := sociStore.Fetch(ctx, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	current := map[string]string{}
+	if raw, ok := fields["annotations"]; ok {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	updated := maps.Clone(current)
+	for key, value := range annotations {
+		if value == "" {
+			delete(updated, key)
+		} else {
+			updated[key] = value
+		}
+	}
+	if maps.Equal(current, updated) {
+		return desc, nil
+	}
+
+	if len(updated) == 0 {
+		delete(fields, "annotations")
+	} else if fields["annotations"], err = json.Marshal(updated); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	content, err = json.Marshal(fields)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	mappedDesc := desc
+	mappedDesc.Digest = digest.FromBytes(content)
+	mappedDesc.Size = int64(len(content))
+	if err := pushBytes(ctx, sociStore, mappedDesc, content); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to store the annotated index: %w", err)
+	}
+	return mappedDesc, nil
+}
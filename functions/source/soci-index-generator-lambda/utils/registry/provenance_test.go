@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPushProvenanceAnnotations(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-provenance-annotations")
+	// as injected with -ldflags -X
+	defer func(version, commit, buildTime string) {
+		BuilderVersion, BuilderCommit, BuildTime = version, commit, buildTime
+	}(BuilderVersion, BuilderCommit, BuildTime)
+	BuilderVersion, BuilderCommit, BuildTime = "v1.2.3", "0123abc", "2026-01-02T03:04:05Z"
+
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	// on by default
+	registry := fake.registry(t)
+
+	doTest := func(registry *Registry, expected map[string]string, opts ...PushOption) {
+		t.Helper()
+		result, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest-soci", opts...)
+		if err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+		if fake.tagged("repo", "latest-soci") != result.Descriptor.Digest.String() {
+			t.Fatalf("Expected the tag to point to the annotated index %s", result.Descriptor.Digest)
+		}
+		fake.mu.Lock()
+		pushed := fake.manifests["repo@"+result.Descriptor.Digest.String()]
+		fake.mu.Unlock()
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(pushed.content, &manifest); err != nil {
+			t.Fatalf("Failed to decode the pushed index: %v", err)
+		}
+		if len(manifest.Annotations) != len(expected) {
+			t.Fatalf("Expected annotations %v but got %v", expected, manifest.Annotations)
+		}
+		for key, value := range expected {
+			if manifest.Annotations[key] != value {
+				t.Fatalf("Expected annotation %s to be %q but got %q", key, value, manifest.Annotations[key])
+			}
+		}
+		if len(manifest.Layers) != 1 {
+			t.Fatalf("Expected the annotated index to keep its ztoc but got %v", manifest.Layers)
+		}
+	}
+
+	provenance := map[string]string{
+		AnnotationBuilderVersion:   "v1.2.3",
+		AnnotationBuilderCommit:    "0123abc",
+		AnnotationBuilderBuildTime: "2026-01-02T03:04:05Z",
+	}
+	doTest(registry, provenance)
+	// pushing again yields the same index
+	pushes := fake.requestCount("PUT", "/manifests/sha256:")
+	doTest(registry, provenance)
+	if fake.requestCount("PUT", "/manifests/sha256:") != pushes {
+		t.Fatalf("Expected the same annotated index not to be pushed again")
+	}
+
+	doTest(registry, map[string]string{
+		AnnotationBuilderVersion:   "custom",
+		AnnotationBuilderBuildTime: "2026-01-02T03:04:05Z",
+		"team":                     "platform",
+	}, WithAnnotations(map[string]string{AnnotationBuilderVersion: "custom", AnnotationBuilderCommit: "", "team": "platform"}))
+	// callers drop the annotations with empty values
+	doTest(registry, map[string]string{}, WithAnnotations(map[string]string{
+		AnnotationBuilderVersion:   "",
+		AnnotationBuilderCommit:    "",
+		AnnotationBuilderBuildTime: "",
+	}))
+
+	// without provenance the index is pushed as is
+	doTest(fake.registry(t, WithProvenanceAnnotations(false)), map[string]string{})
+	if fake.tagged("repo", "latest-soci") != indexDesc.Digest.String() {
+		t.Fatalf("Expected the stored index to be pushed as is")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// Remove an image from the local store once its index is pushed, to free /tmp for the next images of an invocation.
+// The manifests referring to the image, such as its SOCI indexes, are removed along with it.
+// By default only the blobs no other manifest of the store references are removed, so that base layers shared
+// with other images stay; WithPruneSharedBlobs removes every blob of the image.
+func PruneStore(ctx context.Context, sociStore *store.SociStore, image ocispec.Descriptor, opts ...PruneOption) error {
+	config := newPruneConfig(opts)
+
+	// collected first, since removing the image forgets its graph
+	var graph []ocispec.Descriptor
+	if config.pruneSharedBlobs {
+		var err error
+		graph, err = storeGraph(ctx, sociStore, image)
+		if err != nil {
+			return fmt.Errorf("failed to read the graph of %s: %w", image.Digest, err)
+		}
+	}
+
+	// the store removes the blobs left unreferenced by the removal of the image.
+	// SociStore.Delete is a no-op, hence the embedded OCI store.
+	if err := sociStore.Store.Delete(ctx, image); err != nil {
+		return fmt.Errorf("failed to remove %s from the local store: %w", image.Digest, err)
+	}
+
+	for _, desc := range graph {
+		exists, err := sociStore.Exists(ctx, desc)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := sociStore.Store.Delete(ctx, desc); err != nil {
+			return fmt.Errorf("failed to remove %s from the local store: %w", desc.Digest, err)
+		}
+	}
+	log.Debug(ctx, fmt.Sprintf("Removed %s from the local store", image.Digest))
+	return nil
+}
+
+// Return the descriptors of the graph rooted at root that are in the local store, root excluded
+func storeGraph(ctx context.Context, sociStore *store.SociStore, root ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	var graph []ocispec.Descriptor
+	visited := map[digest.Digest]bool{root.Digest: true}
+	pending := []ocispec.Descriptor{root}
+	for len(pending) > 0 {
+		desc := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		exists, err := sociStore.Exists(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		successors, err := content.Successors(ctx, sociStore, desc)
+		if err != nil {
+			return nil, err
+		}
+		for _, successor := range successors {
+			if !visited[successor.Digest] {
+				visited[successor.Digest] = true
+				graph = append(graph, successor)
+				pending = append(pending, successor)
+			}
+		}
+	}
+	return graph, nil
+}
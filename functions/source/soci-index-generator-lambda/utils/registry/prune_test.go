@@ -0,0 +1,80 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPruneStore(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-prune-store")
+
+	type image struct {
+		manifest, config, base, top ocispec.Descriptor
+	}
+	storeImage := func(sociStore *store.SociStore, name string, base ocispec.Descriptor) image {
+		img := image{base: base}
+		img.config = pushToStore(t, ctx, sociStore, MediaTypeOCIImageConfig, []byte(`{"architecture":"`+name+`"}`))
+		img.top = pushToStore(t, ctx, sociStore, ocispec.MediaTypeImageLayerGzip, []byte("top layer of "+name))
+		manifest := imageManifest(MediaTypeOCIImageConfig, img.base, img.top)
+		manifest.Config = img.config
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatalf("Failed to marshal manifest: %v", err)
+		}
+		img.manifest = pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, content)
+		return img
+	}
+	exists := func(sociStore *store.SociStore, desc ocispec.Descriptor) bool {
+		exists, err := sociStore.Exists(ctx, desc)
+		if err != nil {
+			t.Fatalf("Exists failed: %v", err)
+		}
+		return exists
+	}
+
+	doTest := func(pruneSharedBlobs bool) {
+		sociStore := newTestSociStore(t, ctx)
+		base := pushToStore(t, ctx, sociStore, ocispec.MediaTypeImageLayerGzip, []byte("shared base layer"))
+		pruned := storeImage(sociStore, "amd64", base)
+		kept := storeImage(sociStore, "arm64", base)
+		// a SOCI index referring to the pruned image
+		index := imageManifest("application/vnd.amazon.soci.index.v2+json")
+		index.Subject = &pruned.manifest
+		indexContent, err := json.Marshal(index)
+		if err != nil {
+			t.Fatalf("Failed to marshal index: %v", err)
+		}
+		indexDesc := pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, indexContent)
+
+		var opts []PruneOption
+		if pruneSharedBlobs {
+			opts = append(opts, WithPruneSharedBlobs())
+		}
+		if err := PruneStore(ctx, sociStore, pruned.manifest, opts...); err != nil {
+			t.Fatalf("PruneStore failed: %v", err)
+		}
+
+		for _, desc := range []ocispec.Descriptor{pruned.manifest, pruned.config, pruned.top, indexDesc} {
+			if exists(sociStore, desc) {
+				t.Fatalf("Expected %s to be removed", desc.Digest)
+			}
+		}
+		if exists(sociStore, pruned.base) == pruneSharedBlobs {
+			t.Fatalf("Expected the shared base layer to be removed only when pruning shared blobs")
+		}
+		for _, desc := range []ocispec.Descriptor{kept.manifest, kept.config, kept.top} {
+			if !exists(sociStore, desc) {
+				t.Fatalf("Expected %s of the other image to be kept", desc.Digest)
+			}
+		}
+	}
+
+	doTest(false)
+	doTest(true)
+}
@@ -0,0 +1,73 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+// The outcome of a Pull
+type PullResult struct {
+	// Descriptor of the pulled image
+	ocispec.Descriptor
+	// Time spent resolving the image reference, across retries
+	ResolveDuration time.Duration
+	// Time spent copying the image into the local store, across retries
+	CopyDuration time.Duration
+	// Content copied into the local store, i.e. without the content the store already had
+	BytesCopied int64
+}
+
+// Tallies the timings and bytes of a pull. Content is copied concurrently, hence the mutex.
+type pullTally struct {
+	mu     sync.Mutex
+	result PullResult
+	// Caller mutations of the copy options, applied on top of the defaults of Pull
+	mutators []CopyOptionsMutator
+}
+
+// Copy the image at reference from src to dst with oras.Copy, recording the time spent resolving the reference and
+// copying the graph. Each call is an attempt of the pull, and the timings of the attempts add up.
+func (tally *pullTally) copy(ctx context.Context, src oras.ReadOnlyTarget, dst oras.Target, reference string) (ocispec.Descriptor, error) {
+	start := time.Now()
+	var resolved time.Time
+	opts := pullCopyOptions()
+	for _, mutate := range tally.mutators {
+		mutate(&opts)
+	}
+	mapRoot := opts.MapRoot
+	opts.MapRoot = func(ctx context.Context, src content.ReadOnlyStorage, root ocispec.Descriptor) (ocispec.Descriptor, error) {
+		resolved = time.Now()
+		if mapRoot != nil {
+			return mapRoot(ctx, src, root)
+		}
+		return root, nil
+	}
+	opts.PostCopy = chainCopyHooks(opts.PostCopy, func(ctx context.Context, desc ocispec.Descriptor) error {
+		tally.mu.Lock()
+		defer tally.mu.Unlock()
+		tally.result.BytesCopied += desc.Size
+		return nil
+	})
+	traceCopyGraph(&opts.CopyGraphOptions)
+
+	desc, err := oras.Copy(ctx, src, reference, dst, reference, opts)
+
+	end := time.Now()
+	tally.mu.Lock()
+	defer tally.mu.Unlock()
+	if resolved.IsZero() {
+		tally.result.ResolveDuration += end.Sub(start)
+	} else {
+		tally.result.ResolveDuration += resolved.Sub(start)
+		tally.result.CopyDuration += end.Sub(resolved)
+	}
+	return desc, err
+}
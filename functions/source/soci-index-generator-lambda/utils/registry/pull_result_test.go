@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPullAndPushTimings(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-and-push-timings")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+
+	// slow the registry down so that every phase takes measurable time
+	delay := 5 * time.Millisecond
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		time.Sleep(delay)
+		return false
+	}
+
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	start := time.Now()
+	pulled, err := registry.Pull(ctx, "repo", sociStore, "latest")
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if pulled.Digest != image.Digest {
+		t.Fatalf("Expected to pull %s but got %s", image.Digest, pulled.Digest)
+	}
+	if pulled.ResolveDuration < delay || pulled.CopyDuration < delay {
+		t.Fatalf("Expected resolving and copying to take at least %s but got %s and %s", delay, pulled.ResolveDuration, pulled.CopyDuration)
+	}
+	if pulled.ResolveDuration+pulled.CopyDuration > elapsed {
+		t.Fatalf("Expected the pull timings to add up to at most %s but got %s and %s", elapsed, pulled.ResolveDuration, pulled.CopyDuration)
+	}
+	if expected := image.Size + config.Size + layer.Size; pulled.BytesCopied != expected {
+		t.Fatalf("Expected %d bytes to be copied but got %d", expected, pulled.BytesCopied)
+	}
+
+	// pulling again copies nothing
+	pulled, err = registry.Pull(ctx, "repo", sociStore, "latest")
+	if err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if pulled.BytesCopied != 0 {
+		t.Fatalf("Expected no bytes to be copied into a store with the image but got %d", pulled.BytesCopied)
+	}
+
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	start = time.Now()
+	pushed, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest-soci")
+	elapsed = time.Since(start)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if pushed.ReconcileDuration < delay || pushed.CopyDuration < delay || pushed.TagDuration < delay {
+		t.Fatalf("Expected every push phase to take at least %s but got %s, %s and %s", delay, pushed.ReconcileDuration, pushed.CopyDuration, pushed.TagDuration)
+	}
+	if pushed.ReconcileDuration+pushed.CopyDuration+pushed.TagDuration > elapsed {
+		t.Fatalf("Expected the push timings to add up to at most %s but got %s, %s and %s", elapsed, pushed.ReconcileDuration, pushed.CopyDuration, pushed.TagDuration)
+	}
+
+	pushed, err = registry.Push(ctx, sociStore, indexDesc, "repo", "")
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if pushed.TagDuration != 0 {
+		t.Fatalf("Expected no tag duration without a tag but got %s", pushed.TagDuration)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"oras.land/oras-go/v2/errdef"
+)
+
+const (
+	// Number of times a manifest missing from a pull through cache repository is pulled to warm the cache
+	pullThroughCacheWarmAttempts = 3
+	// Default delay before looking a manifest up again after warming the cache
+	defaultPullThroughCacheRetryDelay = 2 * time.Second
+)
+
+// Check if a repository is an ECR pull through cache repository, i.e. its name starts with the
+// repository prefix of one of the configured pull through cache rules, e.g. docker-hub/library/redis
+func (config *registryConfig) isPullThroughCacheRepository(repositoryName string) bool {
+	for _, prefix := range config.pullThroughCachePrefixes {
+		if strings.HasPrefix(repositoryName, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Run a manifest lookup and, if a pull through cache repository doesn't have the manifest yet,
+// pull it to make ECR fill the cache from the upstream registry, then look it up again.
+// A lookup that pulls the manifest, i.e. a GET rather than a HEAD, already makes ECR fill the cache,
+// so lookupPulls skips the separate pull.
+func (registry *Registry) withPullThroughCacheWarmup(ctx context.Context, repositoryName string, reference string, lookupPulls bool, lookup func() error) error {
+	err := lookup()
+	if err == nil || !errors.Is(err, errdef.ErrNotFound) || !registry.config.isPullThroughCacheRepository(repositoryName) {
+		return err
+	}
+
+	for attempt := 1; attempt <= pullThroughCacheWarmAttempts; attempt++ {
+		if lookupPulls {
+			log.Info(ctx, fmt.Sprintf("%s is not cached yet in pull through cache repository %s, waiting for the cache to be warmed (attempt %d)", reference, repositoryName, attempt))
+		} else {
+			log.Info(ctx, fmt.Sprintf("%s is not cached yet in pull through cache repository %s, pulling it to warm the cache (attempt %d)", reference, repositoryName, attempt))
+			registry.warmPullThroughCache(ctx, repositoryName, reference)
+		}
+
+		select {
+		case <-time.After(registry.config.pullThroughCacheRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err = lookup()
+		if err == nil || !errors.Is(err, errdef.ErrNotFound) {
+			return err
+		}
+	}
+	return err
+}
+
+// Pull a manifest of a pull through cache repository, which makes ECR fetch it from the upstream registry.
+// Failures are only logged: the lookup that follows reports whether the manifest got cached.
+func (registry *Registry) warmPullThroughCache(ctx context.Context, repositoryName string, reference string) {
+	repo, err := registry.registry.Repository(ctx, repositoryName)
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to warm pull through cache: %v", err))
+		return
+	}
+	_, rc, err := repo.FetchReference(ctx, reference)
+	if err != nil {
+		log.Warn(ctx, fmt.Sprintf("Failed to warm pull through cache: %v", err))
+		return
+	}
+	rc.Close()
+}
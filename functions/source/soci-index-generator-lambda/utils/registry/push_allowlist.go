@@ -0,0 +1,43 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var ErrPushDestinationNotAllowed = errors.New("pushing to the registry is not allowed")
+
+var accountIdRegex = regexp.MustCompile(`^\d{12}$`)
+
+// Check that the registry is one of those of WithPushAllowedRegistries, which allows any registry when empty
+func (registry *Registry) checkPushAllowed() error {
+	patterns := registry.config.pushAllowedRegistries
+	if len(patterns) == 0 {
+		return nil
+	}
+	host := registry.registry.Reference.Registry
+	for _, pattern := range patterns {
+		if pushAllowedBy(pattern, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s matches none of %v", ErrPushDestinationNotAllowed, host, patterns)
+}
+
+// Check if a registry host matches a pattern of WithPushAllowedRegistries: either an AWS account id, matching the
+// ECR registries of the account in any region, or a host pattern where * matches any run of characters but dots
+func pushAllowedBy(pattern string, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(host)
+	if accountIdRegex.MatchString(pattern) {
+		return isEcrRegistry(host) && strings.HasPrefix(host, pattern+".")
+	}
+	matched, err := path.Match(strings.ReplaceAll(pattern, ".", "/"), strings.ReplaceAll(host, ".", "/"))
+	return err == nil && matched
+}
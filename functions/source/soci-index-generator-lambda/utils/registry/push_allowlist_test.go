@@ -0,0 +1,59 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPushAllowedBy(t *testing.T) {
+	doTest := func(pattern string, host string, expected bool) {
+		if pushAllowedBy(pattern, host) != expected {
+			t.Fatalf("Expected pattern %q to allow %s %v", pattern, host, expected)
+		}
+	}
+
+	ecr := "123456789012.dkr.ecr.us-west-2.amazonaws.com"
+	doTest(ecr, ecr, true)
+	doTest("123456789012", ecr, true)
+	doTest("123456789012", "123456789012.dkr.ecr.eu-west-1.amazonaws.com", true)
+	doTest("210987654321", ecr, false)
+	doTest("*.dkr.ecr.us-west-2.amazonaws.com", ecr, true)
+	doTest("*.dkr.ecr.*.amazonaws.com", ecr, true)
+	doTest("*.dkr.ecr.eu-west-1.amazonaws.com", ecr, false)
+	// * doesn't span dots
+	doTest("*.amazonaws.com", ecr, false)
+	doTest("*", ecr, false)
+	doTest("registry.example.com:*", "registry.example.com:5000", true)
+	doTest("Registry.Example.com", "registry.example.com", true)
+	doTest("registry.example.com", "registry.example.com.evil.com", false)
+	// an account id only matches ECR registries
+	doTest("123456789012", "123456789012.example.com", false)
+}
+
+func TestPushAllowedRegistries(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-allowed-registries")
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t, WithPushAllowedRegistries("123456789012", fake.host()))
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest"); err != nil {
+		t.Fatalf("Expected the push to an allowed registry to succeed but got %v", err)
+	}
+	if !fake.hasManifest("repo", indexDesc.Digest) {
+		t.Fatalf("Expected the index to be pushed")
+	}
+
+	fake = newFakeRegistry(t)
+	registry = fake.registry(t, WithPushAllowedRegistries("123456789012", "*.dkr.ecr.us-west-2.amazonaws.com"))
+	_, err := registry.Push(ctx, sociStore, indexDesc, "repo", "latest")
+	if !errors.Is(err, ErrPushDestinationNotAllowed) {
+		t.Fatalf("Expected ErrPushDestinationNotAllowed but got %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Fatalf("Expected no request to the blocked registry but got %v", fake.requests)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+// The outcome of a Push
+type PushResult struct {
+	// Descriptor of the pushed root, which differs from the given descriptor when it was mapped or converted
+	Descriptor ocispec.Descriptor
+	// Blobs, i.e. non manifest content, uploaded to the target repository
+	BlobsUploaded int
+	BytesUploaded int64
+	// Blobs already present in the target repository, which weren't uploaded again
+	BlobsSkipped int
+	BytesSkipped int64
+	// Blobs mounted from another repository with WithBlobMountFrom, which weren't uploaded
+	BlobsMounted int
+	BytesMounted int64
+	// Whether the index was pushed as a Docker manifest list by WithDockerManifestListFallback, and the error of
+	// the registry that triggered the fallback
+	FallbackUsed   bool
+	FallbackReason string
+	// Time spent checking which blobs the target repository already has
+	ReconcileDuration time.Duration
+	// Time spent copying the graph to the target repository, across retries
+	CopyDuration time.Duration
+	// Time spent tagging the pushed root, zero without a tag
+	TagDuration time.Duration
+	// Tags applied to the pushed root, i.e. the tag given to Push and those of WithAdditionalTags, and the tags that
+	// failed along with their errors
+	AppliedTags []string
+	FailedTags  map[string]error
+}
+
+// Tallies the blobs of a push. Blobs are copied concurrently, hence the mutex.
+type pushTally struct {
+	mu     sync.Mutex
+	result PushResult
+	// Blobs found in the target repository before copying, keyed by digest
+	existing map[digest.Digest]bool
+	// Blobs counted as uploaded or skipped, since a blob shared by several manifests is found once per manifest
+	counted map[digest.Digest]bool
+	// Caller mutations of the copy options, applied on top of the defaults of Push
+	mutators []CopyGraphOptionsMutator
+	// Repositories to mount missing blobs from
+	mountFrom []string
+	// Upper bound of the size of a manifest buffered in memory
+	maxMetadataBytes int64
+}
+
+// Find the blobs of the graph rooted at root that the target repository already has, so that they're skipped
+// even if oras' own existence check would disagree, e.g. when the local store and the registry are out of sync
+func reconcileBlobs(ctx context.Context, sociStore *store.SociStore, repo oras.Target, root ocispec.Descriptor) (*pushTally, error) {
+	tally := &pushTally{existing: map[digest.Digest]bool{}, counted: map[digest.Digest]bool{}}
+	visited := map[digest.Digest]bool{}
+	pending := []ocispec.Descriptor{root}
+	for len(pending) > 0 {
+		desc := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[desc.Digest] {
+			continue
+		}
+		visited[desc.Digest] = true
+
+		if isManifestMediaType(desc.MediaType) {
+			// manifests referenced but not stored locally, such as the subject of an index, are left to oras
+			local, err := sociStore.Exists(ctx, desc)
+			if err != nil {
+				return nil, err
+			}
+			if !local {
+				continue
+			}
+			successors, err := content.Successors(ctx, sociStore, desc)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, successors...)
+			continue
+		}
+
+		exists, err := repo.Exists(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			tally.existing[desc.Digest] = true
+		}
+	}
+	if len(tally.existing) > 0 {
+		log.Debug(ctx, fmt.Sprintf("%d blobs are already present in the target repository", len(tally.existing)))
+	}
+	return tally, nil
+}
+
+// Copy options skipping the blobs found by reconcileBlobs and counting uploaded and skipped blobs
+func (tally *pushTally) copyGraphOptions() oras.CopyGraphOptions {
+	opts := oras.DefaultCopyGraphOptions
+	opts.MaxMetadataBytes = tally.maxMetadataBytes
+	for _, mutate := range tally.mutators {
+		mutate(&opts)
+	}
+	findSuccessors := findSuccessorsOrDefault(opts)
+	opts.FindSuccessors = func(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		successors, err := findSuccessors(ctx, fetcher, desc)
+		if err != nil {
+			return nil, err
+		}
+		var missing []ocispec.Descriptor
+		for _, successor := range successors {
+			if tally.existing[successor.Digest] {
+				tally.skipped(successor)
+				continue
+			}
+			missing = append(missing, successor)
+		}
+		return missing, nil
+	}
+	opts.PostCopy = chainCopyHooks(opts.PostCopy, func(ctx context.Context, desc ocispec.Descriptor) error {
+		if !isManifestMediaType(desc.MediaType) {
+			tally.mu.Lock()
+			defer tally.mu.Unlock()
+			// a blob uploaded by a failed attempt is found again by the retry, and isn't counted as skipped then
+			tally.counted[desc.Digest] = true
+			tally.result.BlobsUploaded++
+			tally.result.BytesUploaded += desc.Size
+		}
+		return nil
+	})
+	opts.OnCopySkipped = chainCopyHooks(opts.OnCopySkipped, func(ctx context.Context, desc ocispec.Descriptor) error {
+		tally.skipped(desc)
+		return nil
+	})
+	if len(tally.mountFrom) > 0 {
+		opts.MountFrom = func(ctx context.Context, desc ocispec.Descriptor) ([]string, error) {
+			return tally.mountFrom, nil
+		}
+		opts.OnMounted = chainCopyHooks(opts.OnMounted, func(ctx context.Context, desc ocispec.Descriptor) error {
+			tally.mu.Lock()
+			defer tally.mu.Unlock()
+			tally.counted[desc.Digest] = true
+			tally.result.BlobsMounted++
+			tally.result.BytesMounted += desc.Size
+			return nil
+		})
+	}
+	traceCopyGraph(&opts)
+	return opts
+}
+
+func (tally *pushTally) skipped(desc ocispec.Descriptor) {
+	if isManifestMediaType(desc.MediaType) {
+		return
+	}
+	tally.mu.Lock()
+	defer tally.mu.Unlock()
+	if tally.counted[desc.Digest] {
+		return
+	}
+	tally.counted[desc.Digest] = true
+	tally.result.BlobsSkipped++
+	tally.result.BytesSkipped += desc.Size
+}
+
+// Return the number and total size of the blobs uploaded so far
+func (tally *pushTally) uploaded() (int, int64) {
+	tally.mu.Lock()
+	defer tally.mu.Unlock()
+	return tally.result.BlobsUploaded, tally.result.BytesUploaded
+}
+
+// Check if a media type is a manifest or an index media type
+func isManifestMediaType(mediaType string) bool {
+	return kindFromMediaType(mediaType) != Unknown
+}
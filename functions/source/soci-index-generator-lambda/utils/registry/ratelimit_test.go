@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInitWithRateLimit(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-rate-limit")
+	fake := newFakeRegistry(t)
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+
+	const requestsPerSecond = 20
+	const requests = 6
+	registry, err := Init(ctx, fake.host(), WithPlainHTTP(), WithRateLimit(requestsPerSecond, 1))
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	start := time.Now()
+	for i := 0; i < requests; i++ {
+		if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+			t.Fatalf("HeadManifest failed: %v", err)
+		}
+	}
+	// the first request takes the burst token, each of the others waits for a new one
+	minElapsed := time.Duration(requests-1) * time.Second / requestsPerSecond
+	if elapsed := time.Since(start); elapsed < minElapsed {
+		t.Fatalf("Expected %d requests to take at least %v but got %v", requests, minElapsed, elapsed)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	doTest := func(name string, rate float64, burst int, requests int, minElapsed time.Duration) {
+		t.Run(name, func(t *testing.T) {
+			limiter := newRateLimiter(rate, burst)
+			start := time.Now()
+			for i := 0; i < requests; i++ {
+				if err := limiter.wait(context.Background()); err != nil {
+					t.Fatalf("wait failed: %v", err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < minElapsed {
+				t.Fatalf("Expected at least %v but got %v", minElapsed, elapsed)
+			}
+		})
+	}
+
+	doTest("burst is not paced", 1, 5, 5, 0)
+	doTest("requests past the burst are paced", 50, 2, 7, 100*time.Millisecond)
+	doTest("non positive burst allows a single request", 50, 0, 3, 40*time.Millisecond)
+
+	t.Run("wait honours the context", func(t *testing.T) {
+		limiter := newRateLimiter(0.1, 1)
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := limiter.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+var ErrInvalidReference = errors.New("invalid reference")
+
+var ErrAmbiguousReference = errors.New("ambiguous reference")
+
+// A tag as defined by the OCI distribution spec
+var tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// Algorithms of digests given as bare hex, keyed by the length of their encoded portion
+var bareDigestAlgorithms = map[int]digest.Algorithm{
+	digest.SHA256.Size() * 2: digest.SHA256,
+	digest.SHA384.Size() * 2: digest.SHA384,
+	digest.SHA512.Size() * 2: digest.SHA512,
+}
+
+var hexRegexp = regexp.MustCompile(`^[a-fA-F0-9]+$`)
+
+// Canonicalize a tag or digest reference of an image of the repository before it is sent to the registry:
+//   - surrounding whitespace and a leading ':' or '@' separator are dropped, e.g. ":latest" -> "latest"
+//   - digests are lower cased, e.g. "SHA256:ABC..." -> "sha256:abc..."
+//   - bare hex of the length of a digest is a digest, e.g. "abc..." -> "sha256:abc...", like Docker which doesn't
+//     allow such tags
+//
+// References naming a repository or combining a tag and a digest, e.g. "repo:tag" or "tag@sha256:...",
+// are rejected with ErrAmbiguousReference, and malformed ones with ErrInvalidReference.
+func NormalizeReference(reference string) (normalized string, isDigest bool, err error) {
+	trimmed := strings.TrimSpace(reference)
+	if strings.HasPrefix(trimmed, ":") || strings.HasPrefix(trimmed, "@") {
+		trimmed = trimmed[1:]
+	}
+	if trimmed == "" {
+		return "", false, fmt.Errorf("%w: empty reference %q", ErrInvalidReference, reference)
+	}
+	if strings.Contains(trimmed, "/") || strings.Contains(trimmed, "@") {
+		return "", false, fmt.Errorf("%w: %q is not a single tag or digest", ErrAmbiguousReference, reference)
+	}
+
+	if algorithm, _, found := strings.Cut(trimmed, ":"); found {
+		if !digest.Algorithm(strings.ToLower(algorithm)).Available() {
+			return "", false, fmt.Errorf("%w: %q is neither a tag nor a digest", ErrAmbiguousReference, reference)
+		}
+		dgst, err := digest.Parse(strings.ToLower(trimmed))
+		if err != nil {
+			return "", false, fmt.Errorf("%w: %q: %w", ErrInvalidReference, reference, err)
+		}
+		return dgst.String(), true, nil
+	}
+
+	if algorithm, ok := bareDigestAlgorithms[len(trimmed)]; ok && hexRegexp.MatchString(trimmed) {
+		return digest.NewDigestFromEncoded(algorithm, strings.ToLower(trimmed)).String(), true, nil
+	}
+
+	if !tagRegexp.MatchString(trimmed) {
+		return "", false, fmt.Errorf("%w: %q is not a valid tag", ErrInvalidReference, reference)
+	}
+	return trimmed, false, nil
+}
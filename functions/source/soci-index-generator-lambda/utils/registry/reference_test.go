@@ -0,0 +1,89 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestNormalizeReference(t *testing.T) {
+	doTest := func(reference string, expected string, expectedIsDigest bool) {
+		normalized, isDigest, err := NormalizeReference(reference)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", reference, err)
+		}
+		if normalized != expected || isDigest != expectedIsDigest {
+			t.Fatalf("Incorrect normalization of %q. Expected %s (digest: %v) but got %s (digest: %v)", reference, expected, expectedIsDigest, normalized, isDigest)
+		}
+	}
+	sha256 := digest.SHA256.FromString("foo")
+	sha512 := digest.SHA512.FromString("foo")
+
+	doTest("latest", "latest", false)
+	doTest(":latest", "latest", false)
+	doTest("  v1.2.3-rc_1 ", "v1.2.3-rc_1", false)
+	doTest("deadbeef", "deadbeef", false)
+	doTest(sha256.String(), sha256.String(), true)
+	doTest("@"+sha256.String(), sha256.String(), true)
+	doTest(strings.ToUpper(sha256.String()), sha256.String(), true)
+	doTest(sha256.Encoded(), sha256.String(), true)
+	doTest(strings.ToUpper(sha256.Encoded()), sha256.String(), true)
+	doTest(sha512.Encoded(), sha512.String(), true)
+
+	doTestError := func(reference string, expectedErr error) {
+		_, _, err := NormalizeReference(reference)
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v for %q but got %v", expectedErr, reference, err)
+		}
+	}
+	doTestError("", ErrInvalidReference)
+	doTestError(" : ", ErrInvalidReference)
+	doTestError("-latest", ErrInvalidReference)
+	doTestError(strings.Repeat("a", 129), ErrInvalidReference)
+	doTestError("sha256:xyz", ErrInvalidReference)
+	doTestError("sha256:"+sha512.Encoded(), ErrInvalidReference)
+	doTestError("repo:latest", ErrAmbiguousReference)
+	doTestError("repo/image", ErrAmbiguousReference)
+	doTestError("latest@"+sha256.String(), ErrAmbiguousReference)
+}
+
+func TestNormalizeReferenceBeforeRegistryCalls(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-normalize-reference-before-registry-calls")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	desc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType), "latest")
+	registry := fake.registry(t)
+
+	for _, reference := range []string{":latest", desc.Digest.Encoded(), strings.ToUpper(desc.Digest.String())} {
+		resolved, err := registry.HeadManifest(ctx, "repo", reference)
+		if err != nil {
+			t.Fatalf("HeadManifest failed for %q: %v", reference, err)
+		}
+		if resolved.Digest != desc.Digest {
+			t.Fatalf("Expected %q to resolve to %s but got %s", reference, desc.Digest, resolved.Digest)
+		}
+	}
+	if _, err := registry.GetManifest(ctx, "repo", "@"+desc.Digest.String()); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	pulled, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), " :latest")
+	if err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if pulled.Digest != desc.Digest {
+		t.Fatalf("Expected to pull %s but got %s", desc.Digest, pulled.Digest)
+	}
+
+	if _, err := registry.HeadManifest(ctx, "repo", "repo:latest"); !errors.Is(err, ErrAmbiguousReference) {
+		t.Fatalf("Expected ErrAmbiguousReference but got %v", err)
+	}
+	if count := fake.requestCount(http.MethodHead, "/manifests/repo:latest"); count != 0 {
+		t.Fatalf("Expected the ambiguous reference not to reach the registry")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	orasregistry "oras.land/oras-go/v2/registry"
+)
+
+// List the manifests referring to a subject, e.g. the SOCI V2 indexes of an image, across every page of the
+// referrers API. A non empty artifactType is sent to the registry as a filter, and applied again to the
+// results in case the registry ignored it. Registries without the referrers API are served through the
+// referrers tag schema.
+func (registry *Registry) ListReferrers(ctx context.Context, repositoryName string, subjectDigest string, artifactType string) ([]ocispec.Descriptor, error) {
+	referrers, err := registry.listReferrers(ctx, repositoryName, subjectDigest, artifactType)
+	return referrers, registry.wrapError("list referrers", repositoryName, subjectDigest, err)
+}
+
+func (registry *Registry) listReferrers(ctx context.Context, repositoryName string, subjectDigest string, artifactType string) ([]ocispec.Descriptor, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return nil, err
+	}
+	subject, err := ParseDigest(subjectDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	var referrers []ocispec.Descriptor
+	err = registry.withReauthorization(ctx, func() error {
+		referrers = nil
+		repo, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		lister, ok := repo.(orasregistry.ReferrerLister)
+		if !ok {
+			return fmt.Errorf("repository %s does not support listing referrers", repositoryName)
+		}
+		// the lister is called once per page
+		return lister.Referrers(ctx, ocispec.Descriptor{Digest: subject}, artifactType, func(page []ocispec.Descriptor) error {
+			for _, referrer := range page {
+				if artifactType == "" || referrer.ArtifactType == artifactType {
+					referrers = append(referrers, referrer)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return referrers, nil
+}
@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestListReferrers(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-list-referrers")
+	const sociIndexArtifactType = "application/vnd.amazon.soci.index.v2+json"
+	const signatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	doTest := func(ignoreFilter bool, artifactType string, expected int) {
+		fake := newFakeRegistry(t)
+		fake.referrersPageSize = 2
+		fake.ignoreReferrersFilter = ignoreFilter
+		var mu sync.Mutex
+		var queries []string
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if strings.Contains(r.URL.Path, "/referrers/") {
+				mu.Lock()
+				queries = append(queries, r.URL.Query().Get("artifactType"))
+				mu.Unlock()
+			}
+			return false
+		}
+
+		image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json"))
+		for i, referrerType := range []string{sociIndexArtifactType, signatureArtifactType, sociIndexArtifactType, signatureArtifactType, sociIndexArtifactType} {
+			referrer := imageManifest("application/vnd.oci.empty.v1+json")
+			referrer.ArtifactType = referrerType
+			referrer.Subject = &image
+			referrer.Annotations = map[string]string{"index": string(rune('a' + i))}
+			fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, referrer)
+		}
+		// a manifest referring to another subject isn't listed
+		other := imageManifest("application/vnd.oci.empty.v1+json")
+		other.ArtifactType = sociIndexArtifactType
+		other.Subject = &ocispec.Descriptor{MediaType: MediaTypeOCIManifest, Digest: fake.putBlob("repo", "", []byte("other")).Digest}
+		fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, other)
+
+		referrers, err := fake.registry(t).ListReferrers(ctx, "repo", image.Digest.String(), artifactType)
+		if err != nil {
+			t.Fatalf("ListReferrers failed: %v", err)
+		}
+		if len(referrers) != expected {
+			t.Fatalf("Expected %d referrers but got %d: %v", expected, len(referrers), referrers)
+		}
+		seen := map[string]bool{}
+		for _, referrer := range referrers {
+			if artifactType != "" && referrer.ArtifactType != artifactType {
+				t.Fatalf("Expected referrers of type %s but got %s", artifactType, referrer.ArtifactType)
+			}
+			if seen[referrer.Digest.String()] {
+				t.Fatalf("Referrer %s listed twice", referrer.Digest)
+			}
+			seen[referrer.Digest.String()] = true
+		}
+
+		// every page carries the filter
+		mu.Lock()
+		defer mu.Unlock()
+		if len(queries) < 2 {
+			t.Fatalf("Expected several pages but got %d", len(queries))
+		}
+		for _, query := range queries {
+			if query != artifactType {
+				t.Fatalf("Expected the artifactType query %q but got %q", artifactType, query)
+			}
+		}
+	}
+
+	// the server filters
+	doTest(false, sociIndexArtifactType, 3)
+	// the server ignores the filter, which is applied client side
+	doTest(true, sociIndexArtifactType, 3)
+	doTest(true, signatureArtifactType, 2)
+	// no filter
+	doTest(false, "", 5)
+}
@@ -5,28 +5,30 @@ package registry
 
 import (
 	"context"
+	_ "crypto/sha512" // registers sha384/sha512 so go-digest can validate those algorithms
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"regexp"
-	"strings"
+	"time"
 
 	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/errdef"
+	orasregistry "oras.land/oras-go/v2/registry"
 	"oras.land/oras-go/v2/registry/remote"
 	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/awslabs/soci-snapshotter/soci"
 	"github.com/awslabs/soci-snapshotter/soci/store"
 	"github.com/containerd/containerd/images"
 
 	"slices"
 
 	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -38,156 +40,843 @@ const (
 
 	MediaTypeDockerImageConfig = "application/vnd.docker.container.image.v1+json"
 	MediaTypeOCIImageConfig    = "application/vnd.oci.image.config.v1+json"
+
+	MediaTypeOCIImageLayer        = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCIImageLayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCIImageLayerZstd    = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeDockerImageLayer     = "application/vnd.docker.image.rootfs.diff.tar"
+	MediaTypeDockerImageLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerImageLayerZstd = "application/vnd.docker.image.rootfs.diff.tar.zstd"
+
+	MediaTypeDockerForeignLayer           = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCINondistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCINondistributableLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeOCINondistributableLayerZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
 )
 
+// List of manifest media types accepted from the registry, sent as the Accept header of manifest requests
+// so that registries negotiating the representation return any of them rather than a default one
+var ManifestMediaTypes = []string{MediaTypeOCIImageIndex, MediaTypeOCIManifest, MediaTypeDockerManifestList, MediaTypeDockerManifest}
+
 // List of config's media type for images
 var ImageConfigMediaTypes = []string{MediaTypeDockerImageConfig, MediaTypeOCIImageConfig}
 
+// List of layer's media type for images, whether uncompressed, gzip or zstd compressed
+var ImageLayerMediaTypes = []string{
+	MediaTypeOCIImageLayer,
+	MediaTypeOCIImageLayerGzip,
+	MediaTypeOCIImageLayerZstd,
+	MediaTypeDockerImageLayer,
+	MediaTypeDockerImageLayerGzip,
+	MediaTypeDockerImageLayerZstd,
+}
+
+// List of foreign layer's media type, i.e. non distributable layers such as Windows base layers, which are
+// fetched from their own URLs rather than from the registry
+var ForeignLayerMediaTypes = []string{
+	MediaTypeDockerForeignLayer,
+	MediaTypeOCINondistributableLayer,
+	MediaTypeOCINondistributableLayerGzip,
+	MediaTypeOCINondistributableLayerZstd,
+}
+
+// Check if a media type is a foreign layer media type
+func IsForeignLayerMediaType(mediaType string) bool {
+	return slices.Contains(ForeignLayerMediaTypes, mediaType)
+}
+
+// Check if a media type is an image layer media type, regardless of its compression
+func IsImageLayerMediaType(mediaType string) bool {
+	return slices.Contains(ImageLayerMediaTypes, mediaType)
+}
+
+// Number of encoded characters kept by ShortDigest
+const ShortDigestLength = 12
+
+// ArtifactKind classifies what a reference in a repository points at
+type ArtifactKind int
+
+const (
+	Unknown ArtifactKind = iota
+	ImageManifest
+	ImageIndex
+	ArtifactManifest
+)
+
+func (kind ArtifactKind) String() string {
+	switch kind {
+	case ImageManifest:
+		return "ImageManifest"
+	case ImageIndex:
+		return "ImageIndex"
+	case ArtifactManifest:
+		return "ArtifactManifest"
+	default:
+		return "Unknown"
+	}
+}
+
 type Registry struct {
 	registry *remote.Registry
+	config   registryConfig
+	// ECR authorization credentials, nil for non ECR registries
+	ecrCredentials *ecrCredentials
+	// Region of the ECR client, empty for the default chain
+	ecrRegion string
+	// HTTP client of the requests to the registry
+	httpClient *http.Client
+	// Resolutions memoized by HeadManifest, nil without WithResolveCache
+	resolveCache *resolveCache
 }
 
+// RegistryClient is the subset of Registry operations used to pull, index and push an image,
+// so that consumers can substitute a fake in their tests
+type RegistryClient interface {
+	Pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string, opts ...PullOption) (*PullResult, error)
+	Push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string, opts ...PushOption) (*PushResult, error)
+	HeadManifest(ctx context.Context, repositoryName string, reference string) (ocispec.Descriptor, error)
+	GetManifest(ctx context.Context, repositoryName string, digest string) (ocispec.Manifest, error)
+	ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion SociIndexVersion) error
+	CheckImageScan(ctx context.Context, repositoryName string, digest string, severityThreshold string) error
+	ListReferrers(ctx context.Context, repositoryName string, subjectDigest string, artifactType string) ([]ocispec.Descriptor, error)
+	WaitForReplication(ctx context.Context, repositoryName string, digest string, timeout time.Duration) error
+}
+
+var _ RegistryClient = (*Registry)(nil)
+
 var RegistryNotSupportingOciArtifacts = errors.New("Registry does not support OCI artifacts")
 
+var ErrManifestTooLarge = errors.New("manifest exceeds the maximum manifest size")
+
+var ErrContentMismatch = errors.New("content does not match its descriptor")
+
+var ErrInvalidRepositoryName = errors.New("invalid repository name")
+
+var ErrTooManyLayers = errors.New("image has more layers than the maximum number of layers")
+
+var ErrDigestMismatch = errors.New("pulled image digest does not match the expected digest")
+
+var ErrDigestHeaderMismatch = errors.New("Docker-Content-Digest header does not match the manifest digest")
+
+var ErrConflictingPushOptions = errors.New("WithRequireOCIArtifacts and WithDockerManifestListFallback are mutually exclusive")
+
+var ErrNotImageManifest = errors.New("not a valid image manifest")
+
+var ErrNotImageIndex = errors.New("not a valid image index")
+
+// ErrIndexNotSupportedInV1 is returned when validating the digest of an image index for a V1 SOCI index,
+// which only spans a single image manifest
+var ErrIndexNotSupportedInV1 = errors.New("image indexes are only supported by SOCI index version V2")
+
+var ErrSubjectIsSociIndex = errors.New("image is a SOCI index, not a runnable image")
+
 // Initialize a remote registry
-func Init(ctx context.Context, registryUrl string) (*Registry, error) {
-	log.Info(ctx, "Initializing registry client")
+func Init(ctx context.Context, registryUrl string, opts ...Option) (*Registry, error) {
+	log.Debug(ctx, "Initializing registry client")
+	config := newRegistryConfig(opts)
 	registry, err := remote.NewRegistry(registryUrl)
 	if err != nil {
 		return nil, err
 	}
-	if isEcrRegistry(registryUrl) {
-		err := authorizeEcr(registry)
+	registry.RepositoryOptions.ManifestMediaTypes = ManifestMediaTypes
+	if err := configureInsecureTransport(ctx, registry, registryUrl, config); err != nil {
+		return nil, err
+	}
+	httpClient := newHTTPClient(config)
+	var credentials *ecrCredentials
+	var region string
+	if config.authClient != nil {
+		log.Debug(ctx, "Using the provided auth client")
+		registry.RepositoryOptions.Client = config.authClient
+	} else if isEcrRegistry(registryUrl) {
+		// an explicit region takes precedence over the registry URL's region
+		region = config.region
+		if region == "" {
+			region = ecrRegionFromUrl(registryUrl)
+		}
+		credentials, err = authorizeEcr(ctx, registry, region, config, httpClient)
 		if err != nil {
 			return nil, err
 		}
+	} else {
+		registry.RepositoryOptions.Client = &auth.Client{
+			Client:     httpClient,
+			Header:     auth.DefaultClient.Header,
+			Cache:      auth.NewCache(),
+			Credential: credentialFunc(config.credentialProvider),
+		}
 	}
-	return &Registry{registry}, nil
+	return &Registry{registry: registry, config: config, ecrCredentials: credentials, ecrRegion: region, httpClient: httpClient, resolveCache: newResolveCache(config)}, nil
+}
+
+// Return the expiry time of the registry's current ECR authorization token.
+// ECR tokens are valid for 12 hours, so long running operations can use it to budget their time.
+// The zero time is returned for non ECR registries.
+func (registry *Registry) TokenExpiry() time.Time {
+	if registry.ecrCredentials == nil {
+		return time.Time{}
+	}
+	return registry.ecrCredentials.expiry()
 }
 
 // Pull an image from the remote registry to a local OCI Store
 // imageReference can be either a digest or a tag
-func (registry *Registry) Pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string) (*ocispec.Descriptor, error) {
-	log.Info(ctx, "Pulling image")
-	repo, err := registry.registry.Repository(ctx, repositoryName)
+func (registry *Registry) Pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string, opts ...PullOption) (*PullResult, error) {
+	result, err := registry.pull(ctx, repositoryName, sociStore, imageReference, opts...)
+	return result, registry.wrapError("pull", repositoryName, imageReference, registry.classifyNotFound(ctx, repositoryName, imageReference, err))
+}
+
+func (registry *Registry) pull(ctx context.Context, repositoryName string, sociStore *store.SociStore, imageReference string, opts ...PullOption) (*PullResult, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return nil, err
+	}
+	imageReference, isDigest, err := NormalizeReference(imageReference)
 	if err != nil {
 		return nil, err
 	}
-
-	imageDescriptor, err := oras.Copy(ctx, repo, imageReference, sociStore, imageReference, oras.DefaultCopyOptions)
+	config := newPullConfig(opts)
+	// only digests are looked up, as a tag may have been moved since the image was staged
+	if config.skipIfStored && isDigest {
+		imageDescriptor, stored, err := storedImage(ctx, sociStore, imageReference)
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			log.Debug(ctx, "Skipping pull as the image is already in the local store")
+			return &PullResult{Descriptor: imageDescriptor}, nil
+		}
+	}
+	log.Debug(ctx, "Pulling image")
+	tally := &pullTally{mutators: config.copyOptions}
+	var dst oras.Target = newResumingStore(sociStore)
+	if config.mirrorStore != nil {
+		dst = &mirroringStore{Target: dst, mirror: config.mirrorStore, required: config.mirrorRequired}
+	}
+	dst = &verifyingStore{Target: dst}
+	imageDescriptor, err := registry.copyImageTo(ctx, repositoryName, dst, imageReference, tally)
 	if err != nil {
 		return nil, err
 	}
 
-	return &imageDescriptor, nil
+	// the tag may have been moved between the event and the pull
+	if config.expectedDigest != "" && imageDescriptor.Digest != config.expectedDigest {
+		return nil, fmt.Errorf("%w: expected %s but %s resolved to %s", ErrDigestMismatch, config.expectedDigest, imageReference, imageDescriptor.Digest)
+	}
+
+	result := tally.result
+	result.Descriptor = imageDescriptor
+	return &result, nil
+}
+
+// Copy the image at reference from the repository to dst, re-authorizing and re-resolving layers as needed
+func (registry *Registry) copyImageTo(ctx context.Context, repositoryName string, dst oras.Target, reference string, tally *pullTally) (ocispec.Descriptor, error) {
+	var imageDescriptor ocispec.Descriptor
+	err := registry.withReauthorization(ctx, func() error {
+		repo, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		return registry.withPresignedUrlRetries(ctx, func() error {
+			imageDescriptor, err = tally.copy(ctx, repo, dst, reference)
+			return err
+		})
+	})
+	return imageDescriptor, err
+}
+
+// Copy options of Pull, which skip foreign layers: they aren't distributed by the registry and can't be indexed
+func pullCopyOptions() oras.CopyOptions {
+	opts := oras.DefaultCopyOptions
+	opts.PreCopy = func(ctx context.Context, desc ocispec.Descriptor) error {
+		if IsForeignLayerMediaType(desc.MediaType) {
+			log.Debug(ctx, fmt.Sprintf("Skipping foreign layer %s", desc.Digest))
+			return oras.SkipNode
+		}
+		return nil
+	}
+	return opts
 }
 
 // Push a OCI artifact to remote registry
 // descriptor: ocispec Descriptor of the artifact
 // ociStore: the local OCI store
-// tag: optional tag to apply to the artifact (empty string means no tag)
-func (registry *Registry) Push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string) error {
-	log.Info(ctx, "Pushing artifact")
+// repositoryName: the source image's repository, which can be mapped to a different target repository with opts
+// tag: optional tag to apply to the artifact (empty string means no tag), applied only once the artifact is fully pushed
+// The result is also returned along with ErrTagImmutable, since the artifact was pushed, only not tagged.
+func (registry *Registry) Push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string, opts ...PushOption) (*PushResult, error) {
+	result, err := registry.push(ctx, sociStore, indexDesc, repositoryName, tag, opts...)
+	reference := tag
+	if reference == "" {
+		reference = indexDesc.Digest.String()
+	}
+	return result, registry.wrapError("push", newPushConfig(opts).targetRepository(repositoryName), reference, err)
+}
 
-	repo, err := registry.registry.Repository(ctx, repositoryName)
-	if err != nil {
+func (registry *Registry) push(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, repositoryName string, tag string, opts ...PushOption) (*PushResult, error) {
+	if err := registry.checkPushAllowed(); err != nil {
+		return nil, err
+	}
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return nil, err
+	}
+	config := newPushConfig(opts)
+	if config.requireOCIArtifacts && config.dockerManifestListFallback {
+		return nil, ErrConflictingPushOptions
+	}
+	if config.artifactType != "" {
+		if err := validateArtifactType(config.artifactType); err != nil {
+			return nil, err
+		}
+	}
+	if config.sociIndexVersion != "" {
+		if err := checkSociIndexVersion(ctx, sociStore, indexDesc, config.sociIndexVersion); err != nil {
+			return nil, err
+		}
+	}
+	targetRepositoryName := config.targetRepository(repositoryName)
+	if err := ValidateRepositoryName(targetRepositoryName); err != nil {
+		return nil, fmt.Errorf("invalid target repository: %w", err)
+	}
+	if targetRepositoryName != repositoryName {
+		log.Debug(ctx, fmt.Sprintf("Pushing artifact to repository %s", targetRepositoryName))
+	} else {
+		log.Debug(ctx, "Pushing artifact")
+	}
+
+	var result *PushResult
+	err := registry.withReauthorization(ctx, func() error {
+		var err error
+		result, err = registry.pushOnce(ctx, sociStore, indexDesc, targetRepositoryName, tag, config)
 		return err
+	})
+	if result != nil {
+		log.Debug(ctx, fmt.Sprintf("Uploaded %d blobs (%d bytes), skipped %d blobs already present (%d bytes)",
+			result.BlobsUploaded, result.BytesUploaded, result.BlobsSkipped, result.BytesSkipped))
+		if result.BlobsMounted > 0 {
+			log.Debug(ctx, fmt.Sprintf("Mounted %d blobs (%d bytes) from other repositories", result.BlobsMounted, result.BytesMounted))
+		}
 	}
+	return result, err
+}
 
-	err = oras.CopyGraph(ctx, sociStore, repo, indexDesc, oras.DefaultCopyGraphOptions)
+func (registry *Registry) pushOnce(ctx context.Context, sociStore *store.SociStore, indexDesc ocispec.Descriptor, targetRepositoryName string, tag string, config *pushConfig) (*PushResult, error) {
+	repo, err := registry.registry.Repository(ctx, targetRepositoryName)
 	if err != nil {
-		// TODO: There might be a better way to check if a registry supporting OCI or not
-		if strings.Contains(err.Error(), "Response status code 405: unsupported: Invalid parameter at 'ImageManifest' failed to satisfy constraint: 'Invalid JSON syntax'") {
-			log.Warn(ctx, fmt.Sprintf("Error when pushing: %v", err))
-			return RegistryNotSupportingOciArtifacts
+		return nil, fmt.Errorf("invalid target repository %q: %w", targetRepositoryName, err)
+	}
+
+	if config.rootMapper != nil {
+		mappedDesc, err := config.rootMapper(ctx, sociStore, indexDesc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map the root of the artifact: %w", err)
 		}
-		return err
+		if mappedDesc.Digest != indexDesc.Digest {
+			log.Debug(ctx, fmt.Sprintf("Pushing mapped root %s instead of %s", mappedDesc.Digest, indexDesc.Digest))
+		}
+		indexDesc = mappedDesc
+	}
+	if compatibility := config.snapshotterCompatibility; compatibility != nil {
+		indexDesc, err = compatibility.adjust(ctx, sociStore, indexDesc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to adjust the index for snapshotters %s: %w", compatibility.Versions, err)
+		}
+	}
+	if config.artifactType != "" {
+		indexDesc, err = setArtifactType(ctx, sociStore, indexDesc, config.artifactType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set the artifact type of the index: %w", err)
+		}
+	}
+	if annotations := registry.pushAnnotations(config); len(annotations) > 0 {
+		indexDesc, err = setAnnotations(ctx, sociStore, indexDesc, annotations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to annotate the index: %w", err)
+		}
+	}
+
+	if err := writeIndexManifest(ctx, sociStore, indexDesc, config); err != nil {
+		return nil, err
 	}
 
-	// If a tag is provided, tag the artifact in the remote repository
-	if tag != "" {
-		log.Info(ctx, fmt.Sprintf("Tagging index with %s", tag))
-		err = repo.Tag(ctx, indexDesc, tag)
+	reconcileStart := time.Now()
+	tally, err := reconcileBlobs(ctx, sociStore, repo, indexDesc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the blobs present in the target repository: %w", err)
+	}
+	tally.mutators = config.copyGraphOptions
+	tally.maxMetadataBytes = config.maxMetadataBytes
+	var target oras.Target = repo
+	if len(config.mountFrom) > 0 {
+		tally.mountFrom = config.mountFrom
+		target = &mountFallbackRepository{Repository: repo}
+	}
+	reconcileDuration := time.Since(reconcileStart)
+	copyStart := time.Now()
+	unsupported := false
+	if config.capabilities != nil {
+		unsupported, err = config.capabilities.rejects(ctx, sociStore, indexDesc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if unsupported {
+		log.Info(ctx, "Registry capabilities do not support the artifact, skipping pushing it as is")
+		err = fmt.Errorf("%w: rejected by the registry capabilities", RegistryNotSupportingOciArtifacts)
+	} else {
+		err = registry.copyGraphWithRetries(ctx, sociStore, target, indexDesc, tally, config.maxPushRetries)
+	}
+	if errors.Is(err, RegistryNotSupportingOciArtifacts) && config.requireOCIArtifacts {
+		log.Warn(ctx, "Registry does not support OCI artifacts, which are required")
+		return nil, err
+	}
+	fallbackReason := ""
+	if errors.Is(err, RegistryNotSupportingOciArtifacts) && config.dockerManifestListFallback && indexDesc.MediaType == MediaTypeOCIImageIndex {
+		fallbackReason = err.Error()
+		log.Warn(ctx, fmt.Sprintf("Registry rejected the OCI image index, retrying as a Docker manifest list: %s", fallbackReason))
+		indexDesc, err = convertToDockerManifestList(ctx, sociStore, indexDesc)
 		if err != nil {
-			return fmt.Errorf("failed to tag artifact: %w", err)
+			return nil, fmt.Errorf("failed to convert OCI image index to a Docker manifest list: %w", err)
+		}
+		log.Info(ctx, fmt.Sprintf("Pushing Docker manifest list %s", indexDesc.Digest))
+		if err := writeIndexManifest(ctx, sociStore, indexDesc, config); err != nil {
+			return nil, err
 		}
+		err = registry.copyGraphWithRetries(ctx, sociStore, target, indexDesc, tally, config.maxPushRetries)
 	}
+	if err != nil {
+		return nil, err
+	}
+	result := tally.result
+	result.Descriptor = indexDesc
+	result.FallbackUsed = fallbackReason != ""
+	result.FallbackReason = fallbackReason
+	result.ReconcileDuration = reconcileDuration
+	result.CopyDuration = time.Since(copyStart)
+
+	if config.snapshotterValidation {
+		if err := validateParsable(ctx, repo, indexDesc, registry.config.maxManifestSize); err != nil {
+			return &result, fmt.Errorf("pushed index failed validation: %w", err)
+		}
+	}
+
+	// If a tag is provided, tag the artifact in the remote repository. Tagging is the last step, once the whole
+	// graph is uploaded and the index verified, so that a moving tag never points at an incomplete artifact.
+	if tags := config.tags(tag); len(tags) > 0 {
+		if err := verifyPushedIndex(ctx, repo, indexDesc); err != nil {
+			return &result, err
+		}
+		tagStart := time.Now()
+		var errs []error
+		for _, t := range tags {
+			log.Debug(ctx, fmt.Sprintf("Tagging index with %s", t))
+			if config.conditionalTag && t == tag {
+				err = registry.tagConditionally(ctx, repo, targetRepositoryName, indexDesc, t, config)
+			} else {
+				err = tagError(repo.Tag(ctx, indexDesc, t), targetRepositoryName, t)
+			}
+			registry.InvalidateResolveCache(targetRepositoryName, t)
+			if err == nil && config.verifyTag {
+				err = verifyTag(ctx, repo, indexDesc, t)
+			}
+			if err != nil {
+				if len(tags) > 1 {
+					log.Warn(ctx, fmt.Sprintf("Failed to tag index with %s, carrying on with the other tags: %v", t, err))
+				}
+				if result.FailedTags == nil {
+					result.FailedTags = map[string]error{}
+				}
+				result.FailedTags[t] = err
+				errs = append(errs, err)
+				continue
+			}
+			result.AppliedTags = append(result.AppliedTags, t)
+		}
+		result.TagDuration = time.Since(tagStart)
+		if len(errs) > 0 {
+			return &result, errors.Join(errs...)
+		}
+	}
+
+	return &result, nil
+}
 
+// Copy the graph rooted at desc from the local store to the remote repository,
+// mapping errors of registries that don't support OCI artifacts to RegistryNotSupportingOciArtifacts
+func (registry *Registry) copyGraph(ctx context.Context, sociStore *store.SociStore, repo oras.Target, desc ocispec.Descriptor, opts oras.CopyGraphOptions) error {
+	err := oras.CopyGraph(ctx, sociStore, repo, desc, opts)
+	if err != nil {
+		if isUnsupportedArtifactError(err, registry.config.unsupportedArtifactMatchers) {
+			log.Warn(ctx, fmt.Sprintf("Error when pushing: %v", err))
+			return fmt.Errorf("%w: %v", RegistryNotSupportingOciArtifacts, err)
+		}
+		if errors.Is(err, errdef.ErrSizeExceedsLimit) {
+			return fmt.Errorf("%w, see WithMaxMetadataBytes: %w", ErrManifestTooLarge, err)
+		}
+		return err
+	}
 	return nil
 }
 
-// Call registry's headManifest and return the manifest's descriptor
+// Copy an image, along with its referrers, from a repository of this registry to a repository of dstRegistry
+// without indexing it, e.g. to mirror an image into ECR before indexing it.
+// Blobs are streamed from source to destination rather than staged locally, and each registry uses its own credentials.
+// dstReference defaults to srcReference when empty.
+func (registry *Registry) CopyImage(ctx context.Context, srcRepositoryName string, srcReference string, dstRegistry *Registry, dstRepositoryName string, dstReference string) (*ocispec.Descriptor, error) {
+	imageDescriptor, err := registry.copyImage(ctx, srcRepositoryName, srcReference, dstRegistry, dstRepositoryName, dstReference)
+	return imageDescriptor, registry.wrapError("copy", srcRepositoryName, srcReference, err)
+}
+
+func (registry *Registry) copyImage(ctx context.Context, srcRepositoryName string, srcReference string, dstRegistry *Registry, dstRepositoryName string, dstReference string) (*ocispec.Descriptor, error) {
+	log.Debug(ctx, fmt.Sprintf("Copying image %s:%s to repository %s", srcRepositoryName, srcReference, dstRepositoryName))
+	repo, err := registry.registry.Repository(ctx, srcRepositoryName)
+	if err != nil {
+		return nil, err
+	}
+	// Listing referrers requires the graph capabilities of the concrete remote repository
+	srcRepo, ok := repo.(oras.ReadOnlyGraphTarget)
+	if !ok {
+		return nil, fmt.Errorf("repository %s does not support listing referrers", srcRepositoryName)
+	}
+	dstRepo, err := dstRegistry.registry.Repository(ctx, dstRepositoryName)
+	if err != nil {
+		return nil, err
+	}
+
+	imageDescriptor, err := oras.ExtendedCopy(ctx, srcRepo, srcReference, dstRepo, dstReference, oras.DefaultExtendedCopyOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy image: %w", err)
+	}
+	return &imageDescriptor, nil
+}
+
+// Call registry's headManifest and return the manifest's descriptor.
+// A tag is resolved with a GET instead, to verify the Docker-Content-Digest header against the manifest:
+// a mismatch is returned as ErrDigestHeaderMismatch. A missing repository or manifest is returned as
+// ErrRepositoryNotFound or ErrImageNotFound.
 func (registry *Registry) HeadManifest(ctx context.Context, repositoryName string, reference string) (ocispec.Descriptor, error) {
+	descriptor, err := registry.headManifest(ctx, repositoryName, reference)
+	return descriptor, registry.wrapError("head manifest", repositoryName, reference, registry.classifyNotFound(ctx, repositoryName, reference, err))
+}
+
+func (registry *Registry) headManifest(ctx context.Context, repositoryName string, reference string) (ocispec.Descriptor, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	reference, isDigest, err := NormalizeReference(reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if registry.resolveCache != nil && !bypassesResolveCache(ctx) {
+		if descriptor, ok := registry.resolveCache.get(repositoryName, reference); ok {
+			return descriptor, nil
+		}
+	}
 	repo, err := registry.registry.Repository(ctx, repositoryName)
 	if err != nil {
 		return ocispec.Descriptor{}, err
 	}
 
-	descriptor, err := repo.Resolve(ctx, reference)
+	isTag := !isDigest
+	var descriptor ocispec.Descriptor
+	err = registry.withPullThroughCacheWarmup(ctx, repositoryName, reference, isTag, func() error {
+		return registry.withOperationTimeout(ctx, func(ctx context.Context) error {
+			if isTag {
+				descriptor, err = registry.resolveTag(ctx, repo, reference)
+				return err
+			}
+			// oras-go already rejects a Docker-Content-Digest header that differs from the requested digest
+			descriptor, err = repo.Resolve(ctx, reference)
+			return err
+		})
+	})
+	if err != nil {
+		return descriptor, err
+	}
+	if registry.resolveCache != nil {
+		registry.resolveCache.put(repositoryName, reference, descriptor)
+	}
+
+	return descriptor, nil
+}
+
+// Resolve a tag, verifying the Docker-Content-Digest header against the digest of the manifest.
+// A HEAD response only carries the header, which a misbehaving cache or proxy can get wrong,
+// so the manifest is fetched to compute its digest.
+func (registry *Registry) resolveTag(ctx context.Context, repo orasregistry.ReferenceFetcher, tag string) (ocispec.Descriptor, error) {
+	descriptor, rc, err := repo.FetchReference(ctx, tag)
 	if err != nil {
 		return descriptor, err
 	}
+	defer rc.Close()
 
+	bytes, err := readManifest(rc, descriptor, registry.config.maxManifestSize)
+	if err != nil {
+		return descriptor, err
+	}
+	// without the header, oras-go computes the descriptor's digest from the manifest itself
+	if actual := descriptor.Digest.Algorithm().FromBytes(bytes); actual != descriptor.Digest {
+		return descriptor, fmt.Errorf("%w: %s resolved to %s but the manifest digest is %s", ErrDigestHeaderMismatch, tag, descriptor.Digest, actual)
+	}
 	return descriptor, nil
 }
 
+// Resolve a reference and classify it as an image manifest, an image index, an artifact manifest or unknown.
+// Manifests are fetched to tell images apart from artifacts; indexes are classified from the media type alone.
+func (registry *Registry) ResolveKind(ctx context.Context, repositoryName string, reference string) (ArtifactKind, ocispec.Descriptor, error) {
+	kind, descriptor, err := registry.resolveKind(ctx, repositoryName, reference)
+	return kind, descriptor, registry.wrapError("resolve kind", repositoryName, reference, err)
+}
+
+func (registry *Registry) resolveKind(ctx context.Context, repositoryName string, reference string) (ArtifactKind, ocispec.Descriptor, error) {
+	descriptor, err := registry.headManifest(ctx, repositoryName, reference)
+	if err != nil {
+		return Unknown, descriptor, err
+	}
+
+	kind := kindFromMediaType(descriptor.MediaType)
+	if kind != ImageManifest {
+		return kind, descriptor, nil
+	}
+
+	manifest, err := registry.getManifest(ctx, repositoryName, descriptor.Digest.String())
+	if err != nil {
+		return Unknown, descriptor, err
+	}
+	return kindFromManifest(manifest, registry.configMediaTypes()), descriptor, nil
+}
+
+// Return the config media types of image manifests: ImageConfigMediaTypes and those of WithAdditionalConfigMediaTypes
+func (registry *Registry) configMediaTypes() []string {
+	return append(slices.Clip(ImageConfigMediaTypes), registry.config.additionalConfigMediaTypes...)
+}
+
+// Classify a descriptor by its media type. Every manifest media type is reported as ImageManifest,
+// since telling an image apart from an artifact requires the manifest content.
+func kindFromMediaType(mediaType string) ArtifactKind {
+	switch {
+	case images.IsIndexType(mediaType):
+		return ImageIndex
+	case images.IsManifestType(mediaType):
+		return ImageManifest
+	default:
+		return Unknown
+	}
+}
+
+// Classify a manifest as an image manifest, when its config is of one of the given media types, or an artifact
+// manifest otherwise
+func kindFromManifest(manifest ocispec.Manifest, configMediaTypes []string) ArtifactKind {
+	if manifest.ArtifactType == "" && slices.Contains(configMediaTypes, manifest.Config.MediaType) {
+		return ImageManifest
+	}
+	return ArtifactManifest
+}
+
 // Call registry's getManifest and return the image's manifest
 // The image reference must be a digest because that's what oras-go FetchReference takes
 func (registry *Registry) GetManifest(ctx context.Context, repositoryName string, digest string) (ocispec.Manifest, error) {
-	repo, err := registry.registry.Repository(ctx, repositoryName)
+	manifest, err := registry.getManifest(ctx, repositoryName, digest)
+	return manifest, registry.wrapError("get manifest", repositoryName, digest, registry.classifyNotFound(ctx, repositoryName, digest, err))
+}
+
+func (registry *Registry) getManifest(ctx context.Context, repositoryName string, digest string) (ocispec.Manifest, error) {
 	var manifest ocispec.Manifest
+	err := registry.withOperationTimeout(ctx, func(ctx context.Context) error {
+		descriptor, rc, err := registry.fetchManifest(ctx, repositoryName, digest)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		// decoded as it streams in rather than buffered, which adds up when indexing many images in one invocation
+		return decodeManifest(rc, descriptor, registry.config.maxManifestSize, &manifest)
+	})
 	if err != nil {
 		return manifest, err
 	}
 
-	_, rc, err := repo.FetchReference(ctx, digest)
+	return manifest, nil
+}
+
+// Call registry's getManifest and return the verbatim manifest bytes along with the resolved descriptor.
+// The bytes are verified against the descriptor's digest and size, so they can be used to recompute digests or re-sign.
+func (registry *Registry) GetManifestRaw(ctx context.Context, repositoryName string, reference string) ([]byte, ocispec.Descriptor, error) {
+	bytes, descriptor, err := registry.getManifestRaw(ctx, repositoryName, reference)
+	return bytes, descriptor, registry.wrapError("get manifest", repositoryName, reference, registry.classifyNotFound(ctx, repositoryName, reference, err))
+}
+
+func (registry *Registry) getManifestRaw(ctx context.Context, repositoryName string, reference string) ([]byte, ocispec.Descriptor, error) {
+	var descriptor ocispec.Descriptor
+	var bytes []byte
+	err := registry.withOperationTimeout(ctx, func(ctx context.Context) error {
+		var rc io.ReadCloser
+		var err error
+		descriptor, rc, err = registry.fetchManifest(ctx, repositoryName, reference)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		bytes, err = readManifest(rc, descriptor, registry.config.maxManifestSize)
+		return err
+	})
 	if err != nil {
-		return manifest, err
+		return nil, descriptor, err
 	}
 
-	bytes, err := io.ReadAll(rc)
+	if err := verifyContent(bytes, descriptor); err != nil {
+		return nil, descriptor, err
+	}
+
+	return bytes, descriptor, nil
+}
+
+// Fetch a manifest by tag or digest, returning its descriptor and unread body, which the caller must close
+func (registry *Registry) fetchManifest(ctx context.Context, repositoryName string, reference string) (ocispec.Descriptor, io.ReadCloser, error) {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	reference, _, err := NormalizeReference(reference)
 	if err != nil {
-		return manifest, err
+		return ocispec.Descriptor{}, nil, err
+	}
+	repo, err := registry.registry.Repository(ctx, repositoryName)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
 	}
 
-	err = json.Unmarshal(bytes, &manifest)
+	var descriptor ocispec.Descriptor
+	var rc io.ReadCloser
+	err = registry.withPullThroughCacheWarmup(ctx, repositoryName, reference, true, func() error {
+		descriptor, rc, err = repo.FetchReference(ctx, reference)
+		return err
+	})
+	return descriptor, rc, err
+}
+
+// Verify content matches the size and digest of its descriptor
+func verifyContent(content []byte, descriptor ocispec.Descriptor) error {
+	if int64(len(content)) != descriptor.Size {
+		return fmt.Errorf("%w: %s: expected %d bytes but got %d", ErrContentMismatch, descriptor.Digest, descriptor.Size, len(content))
+	}
+	if err := descriptor.Digest.Validate(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrContentMismatch, descriptor.Digest, err)
+	}
+	if actual := descriptor.Digest.Algorithm().FromBytes(content); actual != descriptor.Digest {
+		return fmt.Errorf("%w: expected %s but got %s", ErrContentMismatch, descriptor.Digest, actual)
+	}
+	return nil
+}
+
+// Read a manifest body, refusing to buffer more than maxSize bytes
+func readManifest(rc io.Reader, descriptor ocispec.Descriptor, maxSize int64) ([]byte, error) {
+	if descriptor.Size > maxSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d bytes", ErrManifestTooLarge, descriptor.Digest, descriptor.Size, maxSize)
+	}
+	bytes, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
 	if err != nil {
-		return manifest, err
+		return nil, err
 	}
+	if int64(len(bytes)) > maxSize {
+		return nil, fmt.Errorf("%w: %s is more than %d bytes", ErrManifestTooLarge, descriptor.Digest, maxSize)
+	}
+	return bytes, nil
+}
 
-	return manifest, nil
+// Decode a manifest body into v as it is read, refusing to read more than maxSize bytes. The body is hashed
+// on the way through, so it is verified against the size and digest of its descriptor like with verifyContent.
+func decodeManifest(rc io.Reader, descriptor ocispec.Descriptor, maxSize int64, v any) error {
+	if descriptor.Size > maxSize {
+		return fmt.Errorf("%w: %s is %d bytes, the limit is %d bytes", ErrManifestTooLarge, descriptor.Digest, descriptor.Size, maxSize)
+	}
+	if err := descriptor.Digest.Validate(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrContentMismatch, descriptor.Digest, err)
+	}
+	limited := &io.LimitedReader{R: rc, N: maxSize + 1}
+	digester := descriptor.Digest.Algorithm().Digester()
+	body := io.TeeReader(limited, digester.Hash())
+
+	decodeErr := json.NewDecoder(body).Decode(v)
+	// drain what follows the JSON value, e.g. a trailing newline, so that the digest covers the whole body
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+	size := maxSize + 1 - limited.N
+	if size > maxSize {
+		return fmt.Errorf("%w: %s is more than %d bytes", ErrManifestTooLarge, descriptor.Digest, maxSize)
+	}
+	if size != descriptor.Size {
+		return fmt.Errorf("%w: %s: expected %d bytes but got %d", ErrContentMismatch, descriptor.Digest, descriptor.Size, size)
+	}
+	if actual := digester.Digest(); actual != descriptor.Digest {
+		return fmt.Errorf("%w: expected %s but got %s", ErrContentMismatch, descriptor.Digest, actual)
+	}
+	return decodeErr
 }
 
-func (registry *Registry) validateImageManifest(ctx context.Context, repositoryName string, digest string) error {
+// Validate that a digest points at an image manifest.
+// allowArtifacts additionally accepts OCI artifact manifests, i.e. manifests with an artifactType and an empty config.
+func (registry *Registry) validateImageManifest(ctx context.Context, repositoryName string, digest string, allowArtifacts bool) error {
 	// Get the manifest content
-	manifest, err := registry.GetManifest(ctx, repositoryName, digest)
+	manifest, err := registry.getManifest(ctx, repositoryName, digest)
 	if err != nil {
 		return err
 	}
 
+	// an event pointing at an index pushed by this Lambda would otherwise get an index of an index
+	if isSociIndexManifest(manifest) {
+		return fmt.Errorf("%w: artifact type %q, config media type %q", ErrSubjectIsSociIndex, manifest.ArtifactType, manifest.Config.MediaType)
+	}
+
+	// checked first, so that no further work is spent on pathological images
+	if len(manifest.Layers) > registry.config.maxLayers {
+		return fmt.Errorf("%w: %d layers, the limit is %d", ErrTooManyLayers, len(manifest.Layers), registry.config.maxLayers)
+	}
+
+	if allowArtifacts && isArtifactManifest(manifest) {
+		return nil
+	}
+
 	// Valid image manifests must have a config with a valid media type
 	if manifest.Config.MediaType == "" {
-		return fmt.Errorf("not a valid image manifest: empty config media type")
+		return fmt.Errorf("%w: empty config media type", ErrNotImageManifest)
 	}
 
-	if !slices.Contains(ImageConfigMediaTypes, manifest.Config.MediaType) {
-		return fmt.Errorf("not a valid image manifest: unexpected config media type: %s, expected one of: %v",
-			manifest.Config.MediaType, ImageConfigMediaTypes)
+	configMediaTypes := registry.configMediaTypes()
+	if !slices.Contains(configMediaTypes, manifest.Config.MediaType) {
+		return fmt.Errorf("%w: unexpected config media type: %s, expected one of: %v", ErrNotImageManifest,
+			manifest.Config.MediaType, configMediaTypes)
 	}
 
+	if registry.config.deepValidation {
+		return registry.checkConfigBlob(ctx, repositoryName, manifest)
+	}
 	return nil
 }
 
+// Check if a manifest is an OCI artifact manifest, which carries its type in artifactType
+// and uses the empty descriptor as config
+func isArtifactManifest(manifest ocispec.Manifest) bool {
+	return manifest.ArtifactType != "" && manifest.Config.MediaType == ocispec.MediaTypeEmptyJSON
+}
+
+// Check if a manifest is a SOCI index manifest of any version. Both the artifact type and the config media type are
+// checked, as SOCI V1 indexes were also pushed as image manifests without an artifact type.
+func isSociIndexManifest(manifest ocispec.Manifest) bool {
+	sociIndexTypes := []string{soci.SociIndexArtifactTypeV1, soci.SociIndexArtifactTypeV2}
+	return slices.Contains(sociIndexTypes, manifest.ArtifactType) || slices.Contains(sociIndexTypes, manifest.Config.MediaType)
+}
+
 func (registry *Registry) validateImageIndex(ctx context.Context, repositoryName string, digest string) error {
 	// Get the descriptor to check media type
-	descriptor, err := registry.HeadManifest(ctx, repositoryName, digest)
+	descriptor, err := registry.headManifest(ctx, repositoryName, digest)
 	if err != nil {
 		return err
 	}
 
 	// Check if it's an image index by media type
-	if !images.IsIndexType(descriptor.MediaType) {
-		return fmt.Errorf("not a valid image index: unexpected media type: %s", descriptor.MediaType)
+	if kindFromMediaType(descriptor.MediaType) != ImageIndex {
+		return fmt.Errorf("%w: unexpected media type: %s", ErrNotImageIndex, descriptor.MediaType)
 	}
 
 	return nil
@@ -195,31 +884,96 @@ func (registry *Registry) validateImageIndex(ctx context.Context, repositoryName
 
 // ValidateImageDigest validates if a digest is valid based on SOCI index version requirements
 // For SOCI V1, only image manifests are supported
-// For SOCI V2, both image manifests and image indexes are supported
-func (registry *Registry) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion string) error {
-	var err error
-	if sociIndexVersion == "V1" {
-		err = registry.validateImageManifest(ctx, repositoryName, digest)
+// For SOCI V2, image manifests, image indexes and OCI artifact manifests are supported
+func (registry *Registry) ValidateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion SociIndexVersion) error {
+	return registry.wrapError("validate", repositoryName, digest, registry.validateImageDigest(ctx, repositoryName, digest, sociIndexVersion))
+}
+
+func (registry *Registry) validateImageDigest(ctx context.Context, repositoryName string, digest string, sociIndexVersion SociIndexVersion) error {
+	_, err := ParseDigest(digest)
+	if err != nil {
+		return err
+	}
+	// an unknown version would otherwise validate anything
+	if sociIndexVersion != V1 && sociIndexVersion != V2 {
+		return fmt.Errorf("%w: %q", ErrUnknownSociIndexVersion, sociIndexVersion)
+	}
+	if sociIndexVersion == V1 {
+		err = registry.validateImageManifest(ctx, repositoryName, digest, false)
+		if errors.Is(err, ErrNotImageManifest) {
+			// an image index decodes as a manifest without config, which would be reported as such
+			if descriptor, headErr := registry.headManifest(ctx, repositoryName, digest); headErr == nil && kindFromMediaType(descriptor.MediaType) == ImageIndex {
+				// still matching ErrNotImageManifest, as before
+				return fmt.Errorf("%w (%w): %s is an image index (%s), build a V2 SOCI index or use the digest of one of its image manifests",
+					ErrIndexNotSupportedInV1, ErrNotImageManifest, digest, descriptor.MediaType)
+			}
+		}
 		if err != nil {
 			return err
 		}
-		log.Info(ctx, "Validated image manifest")
+		log.Debug(ctx, "Validated image manifest")
 	}
-	if sociIndexVersion == "V2" {
+	if sociIndexVersion == V2 {
 		err = registry.validateImageIndex(ctx, repositoryName, digest)
 		if err == nil {
-			log.Info(ctx, "Validated image index")
+			log.Debug(ctx, "Validated image index")
 			return nil
 		}
-		err = registry.validateImageManifest(ctx, repositoryName, digest)
+		if !errors.Is(err, ErrNotImageIndex) {
+			return err
+		}
+		err = registry.validateImageManifest(ctx, repositoryName, digest, true)
 		if err == nil {
-			log.Info(ctx, "Validated image manifest")
+			log.Debug(ctx, "Validated image manifest")
 			return nil
 		}
 	}
 	return err
 }
 
+// Parse and validate a digest string. Any algorithm supported by go-digest (sha256, sha384, sha512) is accepted.
+func ParseDigest(dgst string) (digest.Digest, error) {
+	d, err := digest.Parse(dgst)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest %q: %w", dgst, err)
+	}
+	return d, nil
+}
+
+// Return a short form of a digest for logs and tags: the first ShortDigestLength characters of the encoded portion,
+// without the algorithm prefix. The encoded length depends on the algorithm, so it is never assumed to be sha256.
+func ShortDigest(dgst string) (string, error) {
+	d, err := ParseDigest(dgst)
+	if err != nil {
+		return "", err
+	}
+	encoded := d.Encoded()
+	if len(encoded) > ShortDigestLength {
+		encoded = encoded[:ShortDigestLength]
+	}
+	return encoded, nil
+}
+
+// Maximum length of a repository name in ECR
+const maxRepositoryNameLength = 256
+
+// Path components separated by slashes, as allowed by the OCI distribution spec and ECR
+var repositoryNameRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+// Validate a repository name, i.e. lowercase path components separated by slashes as in the OCI distribution spec,
+// e.g. "org/team/app" on GHCR or Harbor. Returns ErrInvalidRepositoryName along with the offending name.
+func ValidateRepositoryName(repositoryName string) error {
+	switch {
+	case repositoryName == "":
+		return fmt.Errorf("%w: repository name must not be empty", ErrInvalidRepositoryName)
+	case len(repositoryName) > maxRepositoryNameLength:
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidRepositoryName, repositoryName, maxRepositoryNameLength)
+	case !repositoryNameRegex.MatchString(repositoryName):
+		return fmt.Errorf("%w: %q must be lowercase alphanumeric path components separated by '/', '.', '_', '__' or '-'", ErrInvalidRepositoryName, repositoryName)
+	}
+	return nil
+}
+
 // Check if a registry is an ECR registry
 func isEcrRegistry(registryUrl string) bool {
 	ecrRegistryUrlRegex := "\\d{12}\\.dkr\\.ecr\\.\\S+\\.amazonaws\\.com"
@@ -230,36 +984,69 @@ func isEcrRegistry(registryUrl string) bool {
 	return match
 }
 
-// Authorize ECR registry
-func authorizeEcr(ecrRegistry *remote.Registry) error {
-	// getting ecr auth token
-	input := &ecr.GetAuthorizationTokenInput{}
-	var ecrClient *ecr.ECR
-	ecrEndpoint := os.Getenv("ECR_ENDPOINT") // set this env var for custom, i.e. non default, aws ecr endpoint
-	if ecrEndpoint != "" {
-		ecrClient = ecr.New(session.New(&aws.Config{Endpoint: aws.String(ecrEndpoint)}))
-	} else {
-		ecrClient = ecr.New(session.New())
-	}
-	getAuthorizationTokenResponse, err := ecrClient.GetAuthorizationToken(input)
-	if err != nil {
-		return err
+// Extract the AWS region from an ECR registry URL, e.g. us-west-2 from 123456789012.dkr.ecr.us-west-2.amazonaws.com
+// Returns an empty string if the URL isn't an ECR registry URL.
+func ecrRegionFromUrl(registryUrl string) string {
+	match := ecrRegionRegex.FindStringSubmatch(registryUrl)
+	if match == nil {
+		return ""
 	}
+	return match[1]
+}
 
-	if len(getAuthorizationTokenResponse.AuthorizationData) == 0 {
-		return errors.New("Couldn't authorize with ECR: empty authorization data returned")
-	}
+var ecrRegionRegex = regexp.MustCompile(`^\d{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
 
-	ecrAuthorizationToken := getAuthorizationTokenResponse.AuthorizationData[0].AuthorizationToken
-	if len(*ecrAuthorizationToken) == 0 {
-		return errors.New("Couldn't authorize with ECR: empty authorization token returned")
+// Authorize ECR registry with credentials that are re-fetched from ECR whenever the token nears its expiry.
+// region is the region of the ECR client; an empty region falls back to the default chain.
+// httpClient sends the requests to the registry.
+func authorizeEcr(ctx context.Context, ecrRegistry *remote.Registry, region string, config registryConfig, httpClient *http.Client) (*ecrCredentials, error) {
+	client, err := newEcrClient(ctx, region, config.profile, ecrTraceHeaders(config.traceHeaders))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the ECR client: %w", err)
+	}
+	credentials := &ecrCredentials{client: client}
+	// fetch a first token eagerly so that authorization errors surface at initialization
+	if err := credentials.refresh(ctx); err != nil {
+		return nil, err
 	}
 
 	ecrRegistry.RepositoryOptions.Client = &auth.Client{
+		Client: httpClient,
 		Header: http.Header{
-			"Authorization": {"Basic " + *ecrAuthorizationToken},
-			"User-Agent":    {"SOCI Index Builder (oras-go)"},
+			"User-Agent": {"SOCI Index Builder (oras-go)"},
 		},
+		Cache:      auth.NewCache(),
+		Credential: credentials.credential,
 	}
-	return nil
+	return credentials, nil
+}
+
+// Run a registry operation, and if ECR rejects the credentials mid-operation (e.g. the token expired),
+// re-authorize with ECR and run the operation once more. The credentials are refreshed in place rather than
+// replaced, since the Registry may be shared by concurrent operations, e.g. the workers of a batch: the auth client
+// picks the new token up on its next challenge.
+func (registry *Registry) withReauthorization(ctx context.Context, operation func() error) error {
+	err := operation()
+	if err == nil || registry.ecrCredentials == nil || !isAuthorizationError(err) {
+		return err
+	}
+
+	log.Warn(ctx, fmt.Sprintf("Registry rejected the ECR credentials, re-authorizing and retrying: %v", err))
+	if authErr := registry.ecrCredentials.refresh(ctx); authErr != nil {
+		return fmt.Errorf("failed to re-authorize with ECR: %w", authErr)
+	}
+	return operation()
+}
+
+// Check if a registry error is a 401 Unauthorized or 403 Forbidden response
+func isAuthorizationError(err error) bool {
+	var errResp *errcode.ErrorResponse
+	if !errors.As(err, &errResp) {
+		return false
+	}
+	if isExpiredPresignedUrlError(err) {
+		// S3 rejected the URL the registry redirected to, not the registry credentials
+		return false
+	}
+	return errResp.StatusCode == http.StatusUnauthorized || errResp.StatusCode == http.StatusForbidden
 }
@@ -4,11 +4,28 @@
 package registry
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
 )
 
 type ExpectedResponse struct {
@@ -133,3 +150,1354 @@ func TestGetManifest(t *testing.T) {
 	}
 	doTest("docker.io", "library/redis", "sha256:afd1957d6b59bfff9615d7ec07001afb4eeea39eb341fc777c0caac3fcf52187", expected)
 }
+
+func TestValidateImageDigestSha512(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-sha512")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	manifest := imageManifest(MediaTypeOCIImageConfig)
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	desc := fake.putManifest("repo", MediaTypeOCIManifest, content, digest.SHA512)
+	if len(desc.Digest.Encoded()) != 128 {
+		t.Fatalf("Expected a sha512 digest, got %s", desc.Digest)
+	}
+
+	for _, version := range []SociIndexVersion{V1, V2} {
+		err = registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), version)
+		if err != nil {
+			t.Fatalf("Expected sha512 digest to validate with version %s, got: %v", version, err)
+		}
+	}
+
+	err = registry.ValidateImageDigest(ctx, "repo", "sha512:abc", "V1")
+	if err == nil {
+		t.Fatalf("Expected truncated sha512 digest to fail validation")
+	}
+}
+
+func TestShortDigest(t *testing.T) {
+	doTest := func(dgst string, expected string) {
+		short, err := ShortDigest(dgst)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", dgst, err)
+		}
+		if short != expected {
+			t.Fatalf("Incorrect short digest of %s. Expected %s but got %s", dgst, expected, short)
+		}
+	}
+
+	doTest(digest.SHA256.FromString("foo").String(), digest.SHA256.FromString("foo").Encoded()[:ShortDigestLength])
+	doTest(digest.SHA512.FromString("foo").String(), digest.SHA512.FromString("foo").Encoded()[:ShortDigestLength])
+
+	for _, invalid := range []string{"", "foo", "sha256:xyz", "sha512:" + digest.SHA256.FromString("foo").Encoded()} {
+		if _, err := ShortDigest(invalid); err == nil {
+			t.Fatalf("Expected an error for invalid digest %q", invalid)
+		}
+	}
+}
+
+func TestResolveKind(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-resolve-kind")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	image := imageManifest(MediaTypeOCIImageConfig)
+	imageDesc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, image, "image")
+
+	artifact := imageManifest(ocispec.MediaTypeEmptyJSON)
+	artifact.ArtifactType = "application/vnd.example.artifact"
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, artifact, "artifact")
+
+	index := ocispec.Index{
+		MediaType: MediaTypeOCIImageIndex,
+		Manifests: []ocispec.Descriptor{imageDesc},
+	}
+	index.SchemaVersion = 2
+	fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index, "index")
+
+	fake.putManifest("repo", "application/json", []byte("{}"), digest.SHA256, "unknown")
+
+	doTest := func(reference string, expected ArtifactKind) {
+		kind, descriptor, err := registry.ResolveKind(ctx, "repo", reference)
+		if err != nil {
+			t.Fatalf("ResolveKind of %s failed: %v", reference, err)
+		}
+		if kind != expected {
+			t.Fatalf("Incorrect kind of %s. Expected %s but got %s", reference, expected, kind)
+		}
+		if descriptor.Digest.String() != fake.tagged("repo", reference) {
+			t.Fatalf("Incorrect descriptor digest of %s: %s", reference, descriptor.Digest)
+		}
+	}
+
+	doTest("image", ImageManifest)
+	doTest("artifact", ArtifactManifest)
+	doTest("index", ImageIndex)
+	doTest("unknown", Unknown)
+}
+
+func TestPushRepositoryMapping(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-mapping")
+
+	doTest := func(expectedRepository string, opts ...PushOption) {
+		fake := newFakeRegistry(t)
+		registry := fake.registry(t)
+		sociStore := newTestSociStore(t, ctx)
+		indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+		_, err := registry.Push(ctx, sociStore, indexDesc, "app", "latest-soci", opts...)
+		if err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+		if !fake.hasManifest(expectedRepository, indexDesc.Digest) {
+			t.Fatalf("Expected index to be pushed to %s", expectedRepository)
+		}
+		if fake.tagged(expectedRepository, "latest-soci") != indexDesc.Digest.String() {
+			t.Fatalf("Expected tag to be applied in %s", expectedRepository)
+		}
+		if expectedRepository != "app" && fake.hasManifest("app", indexDesc.Digest) {
+			t.Fatalf("Expected index not to be pushed to the source repository")
+		}
+	}
+
+	doTest("app")
+	doTest("app-soci", WithRepositorySuffix("-soci"))
+	doTest("soci/app", WithRepositoryMap(map[string]string{"app": "soci/app"}))
+	doTest("app", WithRepositoryMap(map[string]string{"other": "soci/other"}))
+
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore)
+	_, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "app", "", WithRepositorySuffix("/Invalid"))
+	if err == nil {
+		t.Fatalf("Expected push to an invalid target repository to fail")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-token-expiry")
+	expiresAt := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	stubEcrClient(t, &fakeEcrClient{passwords: []string{"password"}, expiresAt: expiresAt})
+
+	registry, err := Init(ctx, testEcrRegistryUrl)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if !registry.TokenExpiry().Equal(expiresAt) {
+		t.Fatalf("Incorrect token expiry. Expected %v but got %v", expiresAt, registry.TokenExpiry())
+	}
+
+	fake := newFakeRegistry(t)
+	registry, err = Init(ctx, fake.host())
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if !registry.TokenExpiry().IsZero() {
+		t.Fatalf("Expected no token expiry for a non ECR registry, got %v", registry.TokenExpiry())
+	}
+}
+
+func TestGetManifestSizeLimit(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-manifest-size")
+	fake := newFakeRegistry(t)
+
+	manifest := imageManifest(MediaTypeOCIImageConfig)
+	manifest.Annotations = map[string]string{"padding": strings.Repeat("a", 2048)}
+	desc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, manifest)
+
+	_, err := fake.registry(t).GetManifest(ctx, "repo", desc.Digest.String())
+	if err != nil {
+		t.Fatalf("Expected manifest within the default limit to be read, got: %v", err)
+	}
+
+	_, err = fake.registry(t, WithMaxManifestSize(desc.Size)).GetManifest(ctx, "repo", desc.Digest.String())
+	if err != nil {
+		t.Fatalf("Expected manifest at the limit to be read, got: %v", err)
+	}
+
+	_, err = fake.registry(t, WithMaxManifestSize(1024)).GetManifest(ctx, "repo", desc.Digest.String())
+	if !errors.Is(err, ErrManifestTooLarge) {
+		t.Fatalf("Expected ErrManifestTooLarge, got: %v", err)
+	}
+
+	// a registry under-reporting the manifest size must not get around the limit
+	_, err = readManifest(strings.NewReader(strings.Repeat("a", 2048)), ocispec.Descriptor{Size: 2}, 1024)
+	if !errors.Is(err, ErrManifestTooLarge) {
+		t.Fatalf("Expected ErrManifestTooLarge for an oversized body, got: %v", err)
+	}
+}
+
+func TestDecodeManifest(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-decode-manifest")
+	fake := newFakeRegistry(t)
+
+	manifest := imageManifest(MediaTypeOCIImageConfig,
+		ocispec.Descriptor{MediaType: MediaTypeOCIImageLayerGzip, Digest: digest.FromString("layer"), Size: 5})
+	manifest.Annotations = map[string]string{"org.opencontainers.image.created": "2024-01-01T00:00:00Z"}
+	desc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, manifest)
+	registry := fake.registry(t)
+
+	// the streamed manifest matches the one unmarshalled from the verbatim bytes
+	streamed, err := registry.GetManifest(ctx, "repo", desc.Digest.String())
+	if err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	raw, _, err := registry.GetManifestRaw(ctx, "repo", desc.Digest.String())
+	if err != nil {
+		t.Fatalf("GetManifestRaw failed: %v", err)
+	}
+	var buffered ocispec.Manifest
+	if err := json.Unmarshal(raw, &buffered); err != nil {
+		t.Fatalf("Failed to unmarshal the manifest: %v", err)
+	}
+	if !reflect.DeepEqual(streamed, buffered) {
+		t.Fatalf("Expected the streamed manifest %+v to equal %+v", streamed, buffered)
+	}
+
+	doTest := func(body string, descriptor ocispec.Descriptor, maxSize int64, expectedErr error) {
+		var decoded ocispec.Manifest
+		err := decodeManifest(strings.NewReader(body), descriptor, maxSize, &decoded)
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected %q to be decoded but got %v", body, err)
+		}
+		if expectedErr != nil && !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v for %q but got %v", expectedErr, body, err)
+		}
+	}
+	descriptorOf := func(body string) ocispec.Descriptor {
+		return ocispec.Descriptor{Digest: digest.FromString(body), Size: int64(len(body))}
+	}
+
+	body := string(raw)
+	doTest(body, descriptorOf(body), int64(len(body)), nil)
+	// trailing bytes are part of the verified body
+	doTest(body+"\n", descriptorOf(body+"\n"), 1024, nil)
+	doTest(body+"\n", descriptorOf(body), 1024, ErrContentMismatch)
+	doTest(body, ocispec.Descriptor{Digest: digest.FromString("other"), Size: int64(len(body))}, 1024, ErrContentMismatch)
+	doTest(body, descriptorOf(body), int64(len(body))-1, ErrManifestTooLarge)
+	// a registry under-reporting the manifest size must not get around the limit
+	padded := `{"schemaVersion":2,"annotations":{"padding":"` + strings.Repeat("a", 2048) + `"}}`
+	doTest(padded, ocispec.Descriptor{Digest: digest.FromString(padded), Size: 2}, 1024, ErrManifestTooLarge)
+}
+
+func TestValidateImageDigestArtifactManifest(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-artifact-manifest")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+
+	artifactManifest := imageManifest(ocispec.MediaTypeEmptyJSON)
+	artifactManifest.ArtifactType = "application/vnd.example.sbom"
+	artifactManifest.Subject = &image
+	artifact := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, artifactManifest)
+
+	untypedManifest := imageManifest(ocispec.MediaTypeEmptyJSON)
+	untyped := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, untypedManifest)
+
+	doTest := func(desc ocispec.Descriptor, version SociIndexVersion, expectValid bool) {
+		err := registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), version)
+		if expectValid && err != nil {
+			t.Fatalf("Expected %s to be valid with version %s, got: %v", desc.Digest, version, err)
+		}
+		if !expectValid && err == nil {
+			t.Fatalf("Expected %s to be invalid with version %s", desc.Digest, version)
+		}
+	}
+
+	doTest(image, "V1", true)
+	doTest(image, "V2", true)
+	doTest(artifact, "V1", false)
+	doTest(artifact, "V2", true)
+	doTest(untyped, "V2", false)
+}
+
+func TestValidateImageDigestIndexInV1(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-index-in-v1")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	index := ocispec.Index{Manifests: []ocispec.Descriptor{image}}
+	index.SchemaVersion = 2
+	ociIndex := fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index)
+	index.MediaType = MediaTypeDockerManifestList
+	dockerList := fake.putJSONManifest(t, "repo", MediaTypeDockerManifestList, index)
+
+	for _, desc := range []ocispec.Descriptor{ociIndex, dockerList} {
+		err := registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), "V1")
+		if !errors.Is(err, ErrIndexNotSupportedInV1) {
+			t.Fatalf("Expected ErrIndexNotSupportedInV1 for %s but got %v", desc.MediaType, err)
+		}
+		if !strings.Contains(err.Error(), "V2") {
+			t.Fatalf("Expected the error to point at V2 but got %v", err)
+		}
+		if err := registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), "V2"); err != nil {
+			t.Fatalf("Expected %s to be valid with version V2, got: %v", desc.MediaType, err)
+		}
+	}
+
+	// other invalid manifests keep their error
+	invalid := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.example.config"))
+	err := registry.ValidateImageDigest(ctx, "repo", invalid.Digest.String(), "V1")
+	if !errors.Is(err, ErrNotImageManifest) || errors.Is(err, ErrIndexNotSupportedInV1) {
+		t.Fatalf("Expected ErrNotImageManifest but got %v", err)
+	}
+}
+
+func TestValidateImageDigestAdditionalConfigMediaTypes(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-additional-config-media-types")
+	fake := newFakeRegistry(t)
+	const wasmConfig = "application/vnd.wasm.config.v0+json"
+	wasm := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(wasmConfig))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+
+	// rejected by default
+	registry := fake.registry(t)
+	for _, version := range []SociIndexVersion{V1, V2} {
+		if err := registry.ValidateImageDigest(ctx, "repo", wasm.Digest.String(), version); !errors.Is(err, ErrNotImageManifest) {
+			t.Fatalf("Expected ErrNotImageManifest with version %s but got %v", version, err)
+		}
+	}
+
+	registry = fake.registry(t, WithAdditionalConfigMediaTypes(wasmConfig))
+	for _, version := range []SociIndexVersion{V1, V2} {
+		for _, desc := range []ocispec.Descriptor{wasm, image} {
+			if err := registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), version); err != nil {
+				t.Fatalf("Expected %s to be valid with version %s, got: %v", desc.Digest, version, err)
+			}
+		}
+	}
+	kind, _, err := registry.ResolveKind(ctx, "repo", wasm.Digest.String())
+	if err != nil || kind != ImageManifest {
+		t.Fatalf("Expected the manifest to resolve to an image manifest but got %v, %v", kind, err)
+	}
+	if len(ImageConfigMediaTypes) != 2 {
+		t.Fatalf("Expected the default config media types to be left as is but got %v", ImageConfigMediaTypes)
+	}
+}
+
+func TestValidateImageDigestUnknownVersion(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-unknown-version")
+	fake := newFakeRegistry(t)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	registry := fake.registry(t)
+
+	for _, version := range []SociIndexVersion{"V3", "", "v1"} {
+		err := registry.ValidateImageDigest(ctx, "repo", image.Digest.String(), version)
+		if !errors.Is(err, ErrUnknownSociIndexVersion) {
+			t.Fatalf("Expected ErrUnknownSociIndexVersion for %q but got %v", version, err)
+		}
+	}
+	if requests := fake.requestCount(http.MethodGet, "/manifests/") + fake.requestCount(http.MethodHead, "/manifests/"); requests != 0 {
+		t.Fatalf("Expected an unknown version to be rejected without requests, got %d", requests)
+	}
+}
+
+func TestValidateImageDigestSociIndex(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-soci-index")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+
+	v2Index := imageManifest(ocispec.MediaTypeEmptyJSON)
+	v2Index.ArtifactType = soci.SociIndexArtifactTypeV2
+	v2Index.Subject = &image
+	v2 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, v2Index)
+
+	// SOCI V1 indexes pushed as image manifests carry their type in the config only
+	v1Index := imageManifest(soci.SociIndexArtifactTypeV1)
+	v1 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, v1Index)
+
+	doTest := func(desc ocispec.Descriptor, version SociIndexVersion, expectedErr error) {
+		err := registry.ValidateImageDigest(ctx, "repo", desc.Digest.String(), version)
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected %s to be valid with version %s, got: %v", desc.Digest, version, err)
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+	}
+
+	doTest(image, "V2", nil)
+	doTest(v2, "V1", ErrSubjectIsSociIndex)
+	doTest(v2, "V2", ErrSubjectIsSociIndex)
+	doTest(v1, "V1", ErrSubjectIsSociIndex)
+	doTest(v1, "V2", ErrSubjectIsSociIndex)
+}
+
+func TestInitWithRegion(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-region")
+
+	doTest := func(registryUrl string, expectedRegion string, opts ...Option) {
+		regions := stubEcrClient(t, &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour)})
+		_, err := Init(ctx, registryUrl, opts...)
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if len(*regions) != 1 || (*regions)[0] != expectedRegion {
+			t.Fatalf("Expected the ECR client to use region %q, got %v", expectedRegion, *regions)
+		}
+	}
+
+	doTest(testEcrRegistryUrl, "us-west-2")
+	doTest("123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn", "cn-north-1")
+	doTest(testEcrRegistryUrl, "eu-central-1", WithRegion("eu-central-1"))
+}
+
+func TestInitWithAuthClient(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-auth-client")
+	ecrClient := &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour)}
+	regions := stubEcrClient(t, ecrClient)
+
+	client := &auth.Client{Credential: auth.StaticCredential(testEcrRegistryUrl, auth.Credential{Username: "user", Password: "secret"})}
+	registry, err := Init(ctx, testEcrRegistryUrl, WithAuthClient(client))
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if registry.registry.RepositoryOptions.Client != client {
+		t.Fatalf("Expected the provided auth client to be used")
+	}
+	if len(*regions) != 0 || ecrClient.tokenCalls() != 0 || registry.ecrCredentials != nil {
+		t.Fatalf("Expected ECR authorization to be skipped")
+	}
+	if !registry.TokenExpiry().IsZero() {
+		t.Fatalf("Expected no token expiry without ECR credentials")
+	}
+}
+
+func TestGetManifestRaw(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-get-manifest-raw")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	// non canonical JSON with a field unknown to ocispec.Manifest
+	content := []byte(`{ "schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", "size": 2},
+		"layers": [], "x-unknown": {"kept": true} }`)
+	desc := fake.putManifest("repo", MediaTypeOCIManifest, content, digest.SHA256, "latest")
+
+	raw, descriptor, err := registry.GetManifestRaw(ctx, "repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifestRaw failed: %v", err)
+	}
+	if !bytes.Equal(raw, content) {
+		t.Fatalf("Expected the manifest bytes to round trip verbatim, got %s", raw)
+	}
+	if descriptor.Digest != desc.Digest || descriptor.Size != desc.Size || descriptor.MediaType != MediaTypeOCIManifest {
+		t.Fatalf("Unexpected descriptor %v, expected %v", descriptor, desc)
+	}
+
+	// a registry serving content that doesn't hash to the digest it claims
+	tampered := bytes.Replace(content, []byte(`"kept": true`), []byte(`"kept": null`), 1)
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/manifests/") {
+			return false
+		}
+		w.Header().Set("Content-Type", MediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(tampered)))
+		w.Write(tampered)
+		return true
+	}
+	_, _, err = registry.GetManifestRaw(ctx, "repo", desc.Digest.String())
+	if !errors.Is(err, ErrContentMismatch) {
+		t.Fatalf("Expected ErrContentMismatch for tampered content, got: %v", err)
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-copy-image")
+	src := newFakeRegistry(t)
+	dst := newFakeRegistry(t)
+
+	config := src.putBlob("library/redis", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	layer := src.putBlob("library/redis", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := src.putJSONManifest(t, "library/redis", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "7")
+	referrer := imageManifest(MediaTypeOCIManifest)
+	referrer.ArtifactType = "application/vnd.example.signature"
+	referrer.Config = ocispec.DescriptorEmptyJSON
+	referrer.Subject = &image
+	src.putBlob("library/redis", ocispec.MediaTypeEmptyJSON, ocispec.DescriptorEmptyJSON.Data)
+	signature := src.putJSONManifest(t, "library/redis", MediaTypeOCIManifest, referrer)
+
+	copied, err := src.registry(t).CopyImage(ctx, "library/redis", "7", dst.registry(t), "mirror/redis", "")
+	if err != nil {
+		t.Fatalf("Expected the copy to succeed, got: %v", err)
+	}
+	if copied.Digest != image.Digest {
+		t.Fatalf("Expected digest %s but got %s", image.Digest, copied.Digest)
+	}
+	if dst.tagged("mirror/redis", "7") != image.Digest.String() {
+		t.Fatalf("Expected tag 7 to point to %s but got %q", image.Digest, dst.tagged("mirror/redis", "7"))
+	}
+	for _, blob := range []ocispec.Descriptor{config, layer} {
+		if !dst.hasBlob("mirror/redis", blob.Digest) {
+			t.Fatalf("Expected blob %s to be copied", blob.Digest)
+		}
+	}
+	if !dst.hasManifest("mirror/redis", signature.Digest) {
+		t.Fatalf("Expected referrer %s to be copied", signature.Digest)
+	}
+	if src.requestCount(http.MethodPut, "/") != 0 {
+		t.Fatalf("Expected no writes to the source registry")
+	}
+}
+
+func TestReauthorizeOnExpiredToken(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-reauthorize")
+	// a registry only accepting the "fresh" password, authorized with the first of the given passwords
+	setup := func(passwords []string) (*fakeRegistry, *Registry, *fakeEcrClient) {
+		client := &fakeEcrClient{passwords: passwords, expiresAt: time.Now().Add(12 * time.Hour)}
+		stubEcrClient(t, client)
+		fake := newFakeRegistry(t)
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if _, password, ok := r.BasicAuth(); !ok || password != "fresh" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+				writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+				return true
+			}
+			return false
+		}
+		registry := fake.registry(t)
+		credentials, err := authorizeEcr(ctx, registry.registry, "", registryConfig{}, nil)
+		if err != nil {
+			t.Fatalf("Failed to authorize: %v", err)
+		}
+		registry.ecrCredentials = credentials
+		return fake, registry, client
+	}
+	pull := func(fake *fakeRegistry, registry *Registry) error {
+		config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+		fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType), "latest")
+		_, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest")
+		return err
+	}
+
+	// one token at initialization and one when re-authorizing
+	fake, registry, client := setup([]string{"expired", "fresh"})
+	if err := pull(fake, registry); err != nil {
+		t.Fatalf("Expected the pull to succeed after re-authorization, got: %v", err)
+	}
+	if client.tokenCalls() != 2 {
+		t.Fatalf("Expected 2 GetAuthorizationToken calls but got %d", client.tokenCalls())
+	}
+
+	fake, registry, client = setup([]string{"expired", "fresh"})
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", "soci"); err != nil {
+		t.Fatalf("Expected the push to succeed after re-authorization, got: %v", err)
+	}
+	if fake.tagged("repo", "soci") != indexDesc.Digest.String() {
+		t.Fatalf("Expected tag soci to point to %s but got %q", indexDesc.Digest, fake.tagged("repo", "soci"))
+	}
+	if client.tokenCalls() != 2 {
+		t.Fatalf("Expected 2 GetAuthorizationToken calls but got %d", client.tokenCalls())
+	}
+
+	// the operation is retried only once
+	fake, registry, client = setup([]string{"expired"})
+	if err := pull(fake, registry); !isAuthorizationError(err) {
+		t.Fatalf("Expected an authorization error, got: %v", err)
+	}
+	if client.tokenCalls() != 2 {
+		t.Fatalf("Expected 2 GetAuthorizationToken calls but got %d", client.tokenCalls())
+	}
+
+	// workers sharing the registry, as in a batch, re-authorize concurrently
+	fake, registry, _ = setup([]string{"expired", "fresh"})
+	config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType), "latest")
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest")
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Expected the concurrent pulls to succeed after re-authorization, got: %v", err)
+		}
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-estimate-size")
+	fake := newFakeRegistry(t)
+	layer := func(size int64) ocispec.Descriptor {
+		return ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString(strconv.FormatInt(size, 10)), Size: size}
+	}
+	amd64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json", layer(1000), layer(2000)), "amd64")
+	arm64 := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json", layer(300)))
+	foreign := ocispec.Descriptor{MediaType: MediaTypeDockerForeignLayer, Digest: digest.FromString("foreign"), Size: 900000}
+	windows := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json", foreign, layer(50000), layer(60000), layer(70000)))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	windows.Platform = &ocispec.Platform{OS: "windows", Architecture: "amd64"}
+	index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{amd64, arm64, windows}}
+	index.SchemaVersion = 2
+	fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index, "multi")
+
+	// the config of imageManifest is 2 bytes
+	doTest := func(reference string, platformList []ocispec.Platform, expected SizeEstimate) {
+		estimate, err := fake.registry(t).EstimateSize(ctx, "repo", reference, platformList...)
+		if err != nil {
+			t.Fatalf("EstimateSize of %s failed: %v", reference, err)
+		}
+		if estimate != expected {
+			t.Fatalf("Incorrect estimate of %s. Expected %+v but got %+v", reference, expected, estimate)
+		}
+	}
+
+	doTest("amd64", nil, SizeEstimate{Bytes: 3002, Layers: 2})
+	// without the foreign layer of the Windows image, which Pull skips
+	doTest("multi", nil, SizeEstimate{Bytes: 183306, Layers: 6})
+	doTest("multi", []ocispec.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}, SizeEstimate{Bytes: 3304, Layers: 3})
+	doTest("multi", []ocispec.Platform{{OS: "linux", Architecture: "s390x"}}, SizeEstimate{})
+
+	if fake.requestCount(http.MethodGet, "/blobs/") != 0 || fake.requestCount(http.MethodHead, "/blobs/") != 0 {
+		t.Fatalf("Expected no blob requests")
+	}
+}
+
+func TestMultiSegmentRepositoryName(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-multi-segment-repository-name")
+	fake := newFakeRegistry(t)
+	repositoryName := "org/team/app"
+	config := fake.putBlob(repositoryName, "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	layer := fake.putBlob(repositoryName, ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	image := fake.putJSONManifest(t, repositoryName, MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "v1")
+	registry := fake.registry(t)
+
+	head, err := registry.HeadManifest(ctx, repositoryName, "v1")
+	if err != nil {
+		t.Fatalf("HeadManifest failed: %v", err)
+	}
+	if head.Digest != image.Digest {
+		t.Fatalf("Expected digest %s but got %s", image.Digest, head.Digest)
+	}
+
+	sociStore := newTestSociStore(t, ctx)
+	pulled, err := registry.Pull(ctx, repositoryName, sociStore, "v1")
+	if err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if pulled.Digest != image.Digest {
+		t.Fatalf("Expected digest %s but got %s", image.Digest, pulled.Digest)
+	}
+	if fake.requestCount(http.MethodGet, "/v2/org/team/app/blobs/"+layer.Digest.String()) != 1 {
+		t.Fatalf("Expected the layer to be pulled from the nested repository")
+	}
+
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	if _, err := registry.Push(ctx, sociStore, indexDesc, repositoryName, "v1-soci"); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if !fake.hasManifest(repositoryName, indexDesc.Digest) || fake.tagged(repositoryName, "v1-soci") != indexDesc.Digest.String() {
+		t.Fatalf("Expected the index to be pushed and tagged in the nested repository")
+	}
+	// nothing lands in a parent repository
+	if fake.hasManifest("org/team", indexDesc.Digest) || fake.hasManifest("org", indexDesc.Digest) {
+		t.Fatalf("Expected no manifest outside of the nested repository")
+	}
+}
+
+func TestPullReusesStore(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-reuses-store")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	base := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("base layer"))
+	app1 := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("app layer 1"))
+	app2 := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("app layer 2"))
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, base, app1), "app1")
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, base, app2), "app2")
+
+	rootPath := t.TempDir()
+	registry := fake.registry(t)
+	sociStore, err := NewSociStore(ctx, rootPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, err := registry.Pull(ctx, "repo", sociStore, "app1"); err != nil {
+		t.Fatalf("Failed to pull app1: %v", err)
+	}
+
+	// a later invocation reopens the same store
+	sociStore, err = NewSociStore(ctx, rootPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	missing, err := registry.MissingLayers(ctx, "repo", "app2", sociStore)
+	if err != nil {
+		t.Fatalf("MissingLayers failed: %v", err)
+	}
+	if len(missing) != 1 || missing[0].Digest != app2.Digest {
+		t.Fatalf("Expected only %s to be missing but got %v", app2.Digest, missing)
+	}
+	if _, err := registry.Pull(ctx, "repo", sociStore, "app2"); err != nil {
+		t.Fatalf("Failed to pull app2: %v", err)
+	}
+
+	for _, blob := range []ocispec.Descriptor{config, base, app2} {
+		if count := fake.requestCount(http.MethodGet, "/blobs/"+blob.Digest.String()); count != 1 {
+			t.Fatalf("Expected blob %s to be downloaded once but got %d downloads", blob.Digest, count)
+		}
+	}
+}
+
+func TestPullExpectedDigest(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-expected-digest")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	original := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType), "latest")
+	// the tag is moved to another image before the pull
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	moved := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+
+	registry := fake.registry(t)
+	_, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest", WithExpectedDigest(original.Digest))
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("Expected ErrDigestMismatch but got %v", err)
+	}
+
+	desc, err := registry.Pull(ctx, "repo", newTestSociStore(t, ctx), "latest", WithExpectedDigest(moved.Digest))
+	if err != nil {
+		t.Fatalf("Expected the pull to succeed, got: %v", err)
+	}
+	if desc.Digest != moved.Digest {
+		t.Fatalf("Expected digest %s but got %s", moved.Digest, desc.Digest)
+	}
+}
+
+func TestZstdLayers(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-zstd-layers")
+	doTest := func(mediaType string, expected bool) {
+		if IsImageLayerMediaType(mediaType) != expected {
+			t.Fatalf("Expected IsImageLayerMediaType(%s) to be %v", mediaType, expected)
+		}
+	}
+	doTest(MediaTypeOCIImageLayerZstd, true)
+	doTest(MediaTypeDockerImageLayerZstd, true)
+	doTest(MediaTypeOCIImageLayerGzip, true)
+	doTest(MediaTypeDockerImageLayerGzip, true)
+	doTest(MediaTypeOCIImageLayer, true)
+	doTest("application/octet-stream", false)
+
+	fake := newFakeRegistry(t)
+	layer := func(mediaType string, size int64) ocispec.Descriptor {
+		return ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromString(mediaType), Size: size}
+	}
+	manifest := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig,
+		layer(MediaTypeOCIImageLayerZstd, 100),
+		layer(MediaTypeOCIImageLayerGzip, 200),
+		layer(MediaTypeDockerImageLayerZstd, 300),
+	), "zstd")
+
+	registry := fake.registry(t)
+	if err := registry.ValidateImageDigest(ctx, "repo", manifest.Digest.String(), "V1"); err != nil {
+		t.Fatalf("Expected a zstd image to be valid, got: %v", err)
+	}
+	estimate, err := registry.EstimateSize(ctx, "repo", "zstd")
+	if err != nil {
+		t.Fatalf("EstimateSize failed: %v", err)
+	}
+	if estimate != (SizeEstimate{Bytes: 602, Layers: 3}) {
+		t.Fatalf("Incorrect estimate. Expected {Bytes:602 Layers:3} but got %+v", estimate)
+	}
+}
+
+func TestValidateImageDigestMaxLayers(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-max-layers")
+	fake := newFakeRegistry(t)
+	manifestWithLayers := func(count int) string {
+		var layers []ocispec.Descriptor
+		for i := 0; i < count; i++ {
+			layers = append(layers, ocispec.Descriptor{MediaType: MediaTypeOCIImageLayerGzip, Digest: digest.FromString(strconv.Itoa(i)), Size: 1})
+		}
+		return fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig, layers...)).Digest.String()
+	}
+
+	registry := fake.registry(t, WithMaxLayers(3))
+	if err := registry.ValidateImageDigest(ctx, "repo", manifestWithLayers(3), "V1"); err != nil {
+		t.Fatalf("Expected an image at the layer limit to be valid, got: %v", err)
+	}
+	err := registry.ValidateImageDigest(ctx, "repo", manifestWithLayers(4), "V1")
+	if !errors.Is(err, ErrTooManyLayers) {
+		t.Fatalf("Expected ErrTooManyLayers but got %v", err)
+	}
+	err = registry.ValidateImageDigest(ctx, "repo", manifestWithLayers(4), "V2")
+	if !errors.Is(err, ErrTooManyLayers) {
+		t.Fatalf("Expected ErrTooManyLayers for V2 but got %v", err)
+	}
+
+	// the default limit is generous
+	if err := fake.registry(t).ValidateImageDigest(ctx, "repo", manifestWithLayers(DefaultMaxLayers), "V1"); err != nil {
+		t.Fatalf("Expected an image at the default layer limit to be valid, got: %v", err)
+	}
+	err = fake.registry(t).ValidateImageDigest(ctx, "repo", manifestWithLayers(DefaultMaxLayers+1), "V1")
+	if !errors.Is(err, ErrTooManyLayers) {
+		t.Fatalf("Expected ErrTooManyLayers above the default limit but got %v", err)
+	}
+}
+
+func TestPullThroughCacheWarmup(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-through-cache")
+	fake := newFakeRegistry(t)
+	fake.putBlob("docker-hub/library/redis", MediaTypeOCIImageConfig, []byte("{}"))
+	manifest, err := json.Marshal(imageManifest(MediaTypeOCIImageConfig))
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	// the first GET of an uncached manifest triggers the cache fill but still misses
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/manifests/7") && fake.tagged("docker-hub/library/redis", "7") == "" {
+			fake.putManifest("docker-hub/library/redis", MediaTypeOCIManifest, manifest, digest.SHA256, "7")
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return true
+		}
+		return false
+	}
+
+	registry := fake.registry(t, WithPullThroughCachePrefixes("docker-hub"))
+	registry.config.pullThroughCacheRetryDelay = time.Millisecond
+	desc, err := registry.HeadManifest(ctx, "docker-hub/library/redis", "7")
+	if err != nil {
+		t.Fatalf("Expected the manifest to resolve once cached, got: %v", err)
+	}
+	if desc.Digest != digest.FromBytes(manifest) {
+		t.Fatalf("Expected digest %s but got %s", digest.FromBytes(manifest), desc.Digest)
+	}
+	// resolving a tag pulls the manifest, so the lookup itself warms the cache and is retried once
+	if fake.requestCount(http.MethodGet, "/manifests/7") != 2 {
+		t.Fatalf("Expected the cache to be warmed by the lookup and the lookup to be retried once")
+	}
+	if _, err := registry.GetManifest(ctx, "docker-hub/library/redis", "7"); err != nil {
+		t.Fatalf("Expected the cached manifest to be fetched, got: %v", err)
+	}
+
+	// other repositories aren't warmed
+	_, err = registry.HeadManifest(ctx, "library/redis", "7")
+	if err == nil {
+		t.Fatalf("Expected a missing manifest outside of the pull through cache to fail")
+	}
+	if fake.requestCount(http.MethodGet, "/v2/library/redis/manifests/7") != 1 {
+		t.Fatalf("Expected a single lookup and no retry outside of the pull through cache")
+	}
+}
+
+func TestInvalidRepositoryName(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-invalid-repository-name")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	requestTotal := func() int {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.requests)
+	}
+
+	doTest := func(repositoryName string, valid bool) {
+		err := ValidateRepositoryName(repositoryName)
+		if valid {
+			if err != nil {
+				t.Fatalf("Expected %q to be valid, got: %v", repositoryName, err)
+			}
+			return
+		}
+		if !errors.Is(err, ErrInvalidRepositoryName) {
+			t.Fatalf("Expected ErrInvalidRepositoryName for %q but got %v", repositoryName, err)
+		}
+		if repositoryName != "" && !strings.Contains(err.Error(), strconv.Quote(repositoryName)) {
+			t.Fatalf("Expected the error to contain the offending name, got: %v", err)
+		}
+
+		// rejected before any request
+		requests := requestTotal()
+		if _, err := registry.Pull(ctx, repositoryName, sociStore, "latest"); !errors.Is(err, ErrInvalidRepositoryName) {
+			t.Fatalf("Expected Pull to return ErrInvalidRepositoryName for %q but got %v", repositoryName, err)
+		}
+		if _, err := registry.Push(ctx, sociStore, indexDesc, repositoryName, ""); !errors.Is(err, ErrInvalidRepositoryName) {
+			t.Fatalf("Expected Push to return ErrInvalidRepositoryName for %q but got %v", repositoryName, err)
+		}
+		if _, err := registry.HeadManifest(ctx, repositoryName, "latest"); !errors.Is(err, ErrInvalidRepositoryName) {
+			t.Fatalf("Expected HeadManifest to return ErrInvalidRepositoryName for %q but got %v", repositoryName, err)
+		}
+		if _, err := registry.GetManifest(ctx, repositoryName, indexDesc.Digest.String()); !errors.Is(err, ErrInvalidRepositoryName) {
+			t.Fatalf("Expected GetManifest to return ErrInvalidRepositoryName for %q but got %v", repositoryName, err)
+		}
+		if requestTotal() != requests {
+			t.Fatalf("Expected no request to the registry for %q", repositoryName)
+		}
+	}
+
+	doTest("redis", true)
+	doTest("library/redis", true)
+	doTest("team-a/app_1/web.server", true)
+	doTest("a__b/c---d", true)
+	doTest(strings.Repeat("a", 256), true)
+
+	doTest("", false)
+	doTest(strings.Repeat("a", 257), false)
+	doTest("Library/redis", false)
+	doTest("library/redis:latest", false)
+	doTest("/redis", false)
+	doTest("redis/", false)
+	doTest("library//redis", false)
+	doTest("-redis", false)
+	doTest("re dis", false)
+
+	// a mapped target repository is validated too
+	_, err := registry.Push(ctx, sociStore, indexDesc, "redis", "", WithRepositorySuffix("/"))
+	if !errors.Is(err, ErrInvalidRepositoryName) {
+		t.Fatalf("Expected ErrInvalidRepositoryName for an invalid target repository but got %v", err)
+	}
+}
+
+func TestPushRootMapper(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-root-mapper")
+	fake := newFakeRegistry(t)
+	subject := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	// rewrite the index to reference the image as its subject
+	var mappedDesc ocispec.Descriptor
+	mapper := func(ctx context.Context, sociStore *store.SociStore, root ocispec.Descriptor) (ocispec.Descriptor, error) {
+		rc, err := sociStore.Fetch(ctx, root)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		defer rc.Close()
+		var manifest ocispec.Manifest
+		if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		manifest.Subject = &subject
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		mappedDesc = pushToStore(t, ctx, sociStore, root.MediaType, content)
+		return mappedDesc, nil
+	}
+
+	_, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithRootMapper(mapper))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if fake.tagged("repo", "latest-soci") != mappedDesc.Digest.String() {
+		t.Fatalf("Expected latest-soci to point to the mapped root %s but got %q", mappedDesc.Digest, fake.tagged("repo", "latest-soci"))
+	}
+	if fake.hasManifest("repo", indexDesc.Digest) {
+		t.Fatalf("Expected the original root %s not to be pushed", indexDesc.Digest)
+	}
+	manifest, err := fake.registry(t).GetManifest(ctx, "repo", mappedDesc.Digest.String())
+	if err != nil {
+		t.Fatalf("Failed to get the mapped root: %v", err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject.Digest {
+		t.Fatalf("Expected the pushed root to reference subject %s but got %v", subject.Digest, manifest.Subject)
+	}
+}
+
+func TestPushSkipsExistingBlobs(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-skips-existing-blobs")
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	present := []byte("ztoc already pushed")
+	missing := []byte("new ztoc")
+	indexDesc := storeTestIndex(t, ctx, sociStore, present, missing)
+	// the config and the first ztoc are already in the target repository
+	fake.putBlob("repo", "application/vnd.amazon.soci.index.v1+json", []byte("{}"))
+	presentDesc := fake.putBlob("repo", "application/octet-stream", present)
+
+	result, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "")
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	expected := PushResult{
+		Descriptor:    indexDesc,
+		BlobsUploaded: 1,
+		BytesUploaded: int64(len(missing)),
+		BlobsSkipped:  2,
+		BytesSkipped:  int64(len(present)) + 2,
+	}
+	if result == nil {
+		t.Fatalf("Expected a push result")
+	}
+	// the timings are covered by TestPullAndPushTimings
+	expected.ReconcileDuration, expected.CopyDuration, expected.TagDuration = result.ReconcileDuration, result.CopyDuration, result.TagDuration
+	if !reflect.DeepEqual(*result, expected) {
+		t.Fatalf("Incorrect push result. Expected %+v but got %+v", expected, result)
+	}
+	if fake.requestCount(http.MethodPost, "/blobs/uploads/") != 1 {
+		t.Fatalf("Expected a single blob upload but got %d", fake.requestCount(http.MethodPost, "/blobs/uploads/"))
+	}
+	if fake.requestCount(http.MethodHead, "/blobs/"+presentDesc.Digest.String()) != 1 {
+		t.Fatalf("Expected the existing blob to be checked once")
+	}
+}
+
+func TestPullSkipsForeignLayers(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-skips-foreign-layers")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeDockerImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", MediaTypeDockerImageLayerGzip, []byte("layer"))
+	// the foreign layer is only available from its URL, not from the registry
+	foreign := ocispec.Descriptor{
+		MediaType: MediaTypeDockerForeignLayer,
+		Digest:    digest.FromString("windows base layer"),
+		Size:      1234,
+		URLs:      []string{"https://mcr.microsoft.com/v2/windows/servercore/blobs/sha256:abc"},
+	}
+	manifest := imageManifest(MediaTypeDockerImageConfig, foreign, layer)
+	manifest.MediaType = MediaTypeDockerManifest
+	fake.putJSONManifest(t, "repo", MediaTypeDockerManifest, manifest, "windows")
+
+	sociStore := newTestSociStore(t, ctx)
+	desc, err := fake.registry(t).Pull(ctx, "repo", sociStore, "windows")
+	if err != nil {
+		t.Fatalf("Expected the pull to skip the foreign layer, got: %v", err)
+	}
+	if fake.requestCount(http.MethodGet, "/blobs/"+foreign.Digest.String()) != 0 {
+		t.Fatalf("Expected the foreign layer not to be fetched")
+	}
+	for _, blob := range []ocispec.Descriptor{desc.Descriptor, config, layer} {
+		if exists, err := sociStore.Exists(ctx, blob); err != nil || !exists {
+			t.Fatalf("Expected %s to be pulled, got: %v", blob.Digest, err)
+		}
+	}
+	if exists, _ := sociStore.Exists(ctx, foreign); exists {
+		t.Fatalf("Expected the foreign layer not to be in the store")
+	}
+}
+
+func TestErrorWrapping(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-error-wrapping")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType), "latest")
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+
+	doTest := func(err error, prefix string, target error) {
+		if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+			t.Fatalf("Expected an error starting with %q but got %v", prefix, err)
+		}
+		if target != nil && !errors.Is(err, target) {
+			t.Fatalf("Expected %v to match %v", err, target)
+		}
+	}
+
+	_, err := registry.Pull(ctx, "repo", sociStore, "missing")
+	doTest(err, "pull "+fake.host()+"/repo:missing: ", errdef.ErrNotFound)
+
+	_, err = registry.Pull(ctx, "repo", sociStore, "latest", WithExpectedDigest(config.Digest))
+	doTest(err, "pull "+fake.host()+"/repo:latest: ", ErrDigestMismatch)
+
+	_, err = registry.HeadManifest(ctx, "Repo", image.Digest.String())
+	doTest(err, "head manifest "+fake.host()+"/Repo@"+image.Digest.String()+": ", ErrInvalidRepositoryName)
+
+	_, err = registry.GetManifest(ctx, "repo", config.Digest.String())
+	doTest(err, "get manifest "+fake.host()+"/repo@"+config.Digest.String()+": ", errdef.ErrNotFound)
+
+	err = registry.ValidateImageDigest(ctx, "repo", "sha256:1234", "V2")
+	doTest(err, "validate "+fake.host()+"/repo:sha256:1234: ", nil)
+
+	pushStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, pushStore, []byte("ztoc"))
+	_, err = registry.Push(ctx, pushStore, indexDesc, "repo", "", WithRepositorySuffix("/"))
+	doTest(err, "push "+fake.host()+"/repo/@"+indexDesc.Digest.String()+": ", ErrInvalidRepositoryName)
+
+	// no error is left nil
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+}
+
+func TestPushRetriesTransientFailures(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-retries-transient-failures")
+	blobs := [][]byte{[]byte("ztoc 1"), []byte("ztoc 2"), []byte("ztoc 3")}
+
+	// every blob upload is counted per digest, and the first upload of the failing blob fails with status
+	newFailingRegistry := func(failing digest.Digest, status int) (*fakeRegistry, map[string]int) {
+		fake := newFakeRegistry(t)
+		uploads := map[string]int{}
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/blobs/uploads/") {
+				return false
+			}
+			dgst := r.URL.Query().Get("digest")
+			fake.mu.Lock()
+			uploads[dgst]++
+			count := uploads[dgst]
+			fake.mu.Unlock()
+			if dgst == failing.String() && count == 1 {
+				writeRegistryError(w, status, "UNKNOWN", "upload failed")
+				return true
+			}
+			return false
+		}
+		return fake, uploads
+	}
+
+	doTest := func(status int, maxRetries int, expectSuccess bool) {
+		sociStore := newTestSociStore(t, ctx)
+		indexDesc := storeTestIndex(t, ctx, sociStore, blobs...)
+		failing := digest.FromBytes(blobs[1])
+		fake, uploads := newFailingRegistry(failing, status)
+		registry := fake.registry(t)
+		registry.config.pushRetryDelay = time.Millisecond
+
+		result, err := registry.Push(ctx, sociStore, indexDesc, "repo", "", WithMaxPushRetries(maxRetries))
+		if !expectSuccess {
+			if err == nil {
+				t.Fatalf("Expected the push to fail with status %d and %d retries", status, maxRetries)
+			}
+			if uploads[failing.String()] != 1 {
+				t.Fatalf("Expected the failing blob to be uploaded once but got %d", uploads[failing.String()])
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+		if !fake.hasManifest("repo", indexDesc.Digest) {
+			t.Fatalf("Expected the index to be pushed")
+		}
+		// the retry resumes the push: only the failed blob is uploaded twice
+		for _, blob := range blobs {
+			dgst := digest.FromBytes(blob)
+			expected := 1
+			if dgst == failing {
+				expected = 2
+			}
+			if uploads[dgst.String()] != expected {
+				t.Fatalf("Expected %d uploads of %s but got %d", expected, dgst, uploads[dgst.String()])
+			}
+		}
+		if result.BlobsUploaded+result.BlobsSkipped != len(blobs)+1 {
+			t.Fatalf("Expected %d blobs to be accounted for but got %+v", len(blobs)+1, result)
+		}
+	}
+
+	doTest(http.StatusServiceUnavailable, 1, true)
+	doTest(http.StatusTooManyRequests, 2, true)
+	// no retry by default
+	doTest(http.StatusServiceUnavailable, 0, false)
+	// client errors aren't retried
+	doTest(http.StatusBadRequest, 3, false)
+}
+
+func TestPushRetriesWithCustomPolicy(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-retries-custom-policy")
+
+	doTest := func(status int, retryable bool, expectSuccess bool) {
+		sociStore := newTestSociStore(t, ctx)
+		indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+		fake := newFakeRegistry(t)
+		failed := false
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if failed || r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/blobs/uploads/") {
+				return false
+			}
+			failed = true
+			writeRegistryError(w, status, "UNKNOWN", "upload failed")
+			return true
+		}
+		var consulted []error
+		registry := fake.registry(t, WithIsRetryable(func(err error) bool {
+			consulted = append(consulted, err)
+			return retryable
+		}))
+		registry.config.pushRetryDelay = time.Millisecond
+
+		_, err := registry.Push(ctx, sociStore, indexDesc, "repo", "", WithMaxPushRetries(1))
+		if expectSuccess != (err == nil) {
+			t.Fatalf("Expected success %v with status %d but got %v", expectSuccess, status, err)
+		}
+		if len(consulted) != 1 {
+			t.Fatalf("Expected the policy to be consulted once but got %d", len(consulted))
+		}
+		var errResp *errcode.ErrorResponse
+		if !errors.As(consulted[0], &errResp) || errResp.StatusCode != status {
+			t.Fatalf("Expected the policy to be given the %d response but got %v", status, consulted[0])
+		}
+	}
+
+	// the policy overrides the default in both directions
+	doTest(http.StatusTooManyRequests, false, false)
+	doTest(http.StatusBadRequest, true, true)
+}
+
+func TestDefaultIsRetryable(t *testing.T) {
+	doTest := func(err error, expected bool) {
+		if DefaultIsRetryable(err) != expected {
+			t.Fatalf("Expected DefaultIsRetryable(%v) to be %v", err, expected)
+		}
+	}
+
+	doTest(nil, false)
+	doTest(&errcode.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, true)
+	doTest(&errcode.ErrorResponse{StatusCode: http.StatusTooManyRequests}, true)
+	doTest(&errcode.ErrorResponse{StatusCode: http.StatusNotFound}, false)
+	doTest(fmt.Errorf("copy: %w", io.ErrUnexpectedEOF), true)
+	doTest(context.Canceled, false)
+	doTest(errors.New("invalid manifest"), false)
+}
+
+func TestValidationSentinelErrors(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validation-sentinel-errors")
+	fake := newFakeRegistry(t)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	signature := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.dev.cosign.artifact.sig.v1+json"))
+	noConfig := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(""))
+	index := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{image}}
+	index.SchemaVersion = 2
+	indexDesc := fake.putJSONManifest(t, "repo", MediaTypeOCIImageIndex, index)
+	registry := fake.registry(t)
+
+	doTest := func(name string, err error, expected error) {
+		if !errors.Is(err, expected) {
+			t.Fatalf("%s: expected %v but got %v", name, expected, err)
+		}
+	}
+
+	doTest("V1 index", registry.ValidateImageDigest(ctx, "repo", indexDesc.Digest.String(), "V1"), ErrNotImageManifest)
+	doTest("V1 signature", registry.ValidateImageDigest(ctx, "repo", signature.Digest.String(), "V1"), ErrNotImageManifest)
+	doTest("V1 empty config", registry.ValidateImageDigest(ctx, "repo", noConfig.Digest.String(), "V1"), ErrNotImageManifest)
+	doTest("V2 signature", registry.ValidateImageDigest(ctx, "repo", signature.Digest.String(), "V2"), ErrNotImageManifest)
+	doTest("V2 empty config", registry.ValidateImageDigest(ctx, "repo", noConfig.Digest.String(), "V2"), ErrNotImageManifest)
+	doTest("image index", registry.validateImageIndex(ctx, "repo", image.Digest.String()), ErrNotImageIndex)
+	_, err := registry.ValidateImageIndexChildren(ctx, "repo", image.Digest.String())
+	doTest("index children", err, ErrNotImageIndex)
+	_, err = registry.MissingLayers(ctx, "repo", indexDesc.Digest.String(), newTestSociStore(t, ctx))
+	doTest("missing layers", err, ErrNotImageManifest)
+
+	// V2 only falls back to validating a manifest when the digest isn't an index, so other errors surface as is
+	missing := digest.FromString("missing").String()
+	doTest("V2 missing", registry.ValidateImageDigest(ctx, "repo", missing, "V2"), errdef.ErrNotFound)
+}
+
+func TestHeadManifestDigestHeaderMismatch(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-head-manifest-digest-header-mismatch")
+	fake := newFakeRegistry(t)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+	stale := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeDockerImageConfig), "stale")
+	registry := fake.registry(t)
+
+	desc, err := registry.HeadManifest(ctx, "repo", "latest")
+	if err != nil {
+		t.Fatalf("HeadManifest failed: %v", err)
+	}
+	if desc.Digest != image.Digest || desc.Size != image.Size || desc.MediaType != MediaTypeOCIManifest {
+		t.Fatalf("Expected %v but got %v", image, desc)
+	}
+
+	// a cache in front of the registry serves the manifest of latest with the digest of another manifest
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			content, _, _ := registry.GetManifestRaw(ctx, "repo", image.Digest.String())
+			w.Header().Set("Content-Type", MediaTypeOCIManifest)
+			w.Header().Set("Docker-Content-Digest", stale.Digest.String())
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			if r.Method == http.MethodGet {
+				w.Write(content)
+			}
+			return true
+		}
+		return false
+	}
+	_, err = registry.HeadManifest(ctx, "repo", "latest")
+	if !errors.Is(err, ErrDigestHeaderMismatch) {
+		t.Fatalf("Expected ErrDigestHeaderMismatch but got %v", err)
+	}
+
+	// resolving by digest is unaffected
+	if _, err := registry.HeadManifest(ctx, "repo", image.Digest.String()); err != nil {
+		t.Fatalf("Expected resolving by digest to succeed, got: %v", err)
+	}
+}
+
+func TestManifestAcceptHeader(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-manifest-accept-header")
+	fake := newFakeRegistry(t)
+	desc := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+
+	var accepts []string
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			accepts = append(accepts, r.Header.Get("Accept"))
+		}
+		return false
+	}
+
+	registry, err := Init(ctx, fake.host(), WithPlainHTTP())
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	// resolving a tag, resolving a digest and fetching a manifest
+	if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+		t.Fatalf("HeadManifest failed for a tag: %v", err)
+	}
+	if _, err := registry.HeadManifest(ctx, "repo", desc.Digest.String()); err != nil {
+		t.Fatalf("HeadManifest failed for a digest: %v", err)
+	}
+	if _, err := registry.GetManifest(ctx, "repo", desc.Digest.String()); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+
+	if len(accepts) != 3 {
+		t.Fatalf("Expected 3 manifest requests but got %d", len(accepts))
+	}
+	for _, accept := range accepts {
+		for _, mediaType := range []string{MediaTypeOCIImageIndex, MediaTypeOCIManifest, MediaTypeDockerManifestList, MediaTypeDockerManifest} {
+			if !strings.Contains(accept, mediaType) {
+				t.Fatalf("Expected the Accept header %q to contain %s", accept, mediaType)
+			}
+		}
+	}
+}
+
+func TestRegistryLogLevel(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-registry-log-level")
+	fake := newFakeRegistry(t)
+	config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+	layer := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(config.MediaType, layer), "latest")
+	registry := fake.registry(t)
+	// the handler logs a summary of each image instead
+	chatty := []string{"Pulling image", "Pushing artifact", "Uploaded ", "Tagging index with"}
+
+	doTest := func(levelName string, expectChatty bool) {
+		t.Helper()
+		buf := &lockedBuffer{}
+		restore := log.Redirect(buf, levelName)
+		sociStore := newTestSociStore(t, ctx)
+		_, pullErr := registry.Pull(ctx, "repo", sociStore, "latest")
+		indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+		_, pushErr := registry.Push(ctx, sociStore, indexDesc, "repo", "latest-soci")
+		restore()
+		if pullErr != nil || pushErr != nil {
+			t.Fatalf("Expected the pull and the push to succeed but got %v, %v", pullErr, pushErr)
+		}
+		for _, line := range chatty {
+			if strings.Contains(buf.String(), line) != expectChatty {
+				t.Fatalf("Expected %q to be logged at level %s: %t, got %s", line, levelName, expectChatty, buf.String())
+			}
+		}
+	}
+
+	doTest("info", false)
+	doTest("debug", true)
+}
@@ -0,0 +1,117 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry"
+)
+
+// ErrRepairContentMissing is returned by RepairIndex when a blob to upload again isn't in the local store
+var ErrRepairContentMissing = errors.New("blob to repair is not in the local store")
+
+// What RepairIndex found broken and uploaded again
+type RepairResult struct {
+	// Number of blobs the index references, i.e. its config and its ztocs, that were checked
+	Checked int
+	// Blobs missing from the repository
+	Missing []ocispec.Descriptor
+	// Blobs whose content in the repository doesn't match their digest
+	Corrupt []ocispec.Descriptor
+}
+
+// Whether any blob was uploaded again
+func (result *RepairResult) Repaired() bool {
+	return len(result.Missing)+len(result.Corrupt) > 0
+}
+
+// Repair a SOCI index of the repository whose push was cut short, e.g. when the index manifest was pushed but the
+// upload of a ztoc failed. Each blob the index references is fetched and verified against its digest, and the
+// missing or corrupt ones are uploaded again from the local store, which holds the regenerated index. Blobs that
+// are fine aren't read from the local store, which only needs to hold the broken ones, else the repair fails with
+// ErrRepairContentMissing. Manifests other than SOCI indexes are rejected with ErrNotSociIndex.
+func (registry *Registry) RepairIndex(ctx context.Context, repositoryName string, indexDigest string, sociStore *store.SociStore) (*RepairResult, error) {
+	result, err := registry.repairIndex(ctx, repositoryName, indexDigest, sociStore)
+	return result, registry.wrapError("repair index", repositoryName, indexDigest, registry.classifyNotFound(ctx, repositoryName, indexDigest, err))
+}
+
+func (registry *Registry) repairIndex(ctx context.Context, repositoryName string, indexDigest string, sociStore *store.SociStore) (*RepairResult, error) {
+	if err := registry.checkPushAllowed(); err != nil {
+		return nil, err
+	}
+	if _, err := ParseDigest(indexDigest); err != nil {
+		return nil, err
+	}
+	manifest, err := registry.getManifest(ctx, repositoryName, indexDigest)
+	if err != nil {
+		return nil, err
+	}
+	if !isSociIndexManifest(manifest) {
+		return nil, fmt.Errorf("%w: artifact type %q, config media type %q", ErrNotSociIndex, manifest.ArtifactType, manifest.Config.MediaType)
+	}
+	repo, err := registry.registry.Repository(ctx, repositoryName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RepairResult{}
+	for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		result.Checked++
+		err := verifyBlob(ctx, repo.Blobs(), blob)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, errdef.ErrNotFound):
+			result.Missing = append(result.Missing, blob)
+		case errors.Is(err, content.ErrMismatchedDigest) || errors.Is(err, content.ErrTrailingData):
+			result.Corrupt = append(result.Corrupt, blob)
+		default:
+			return result, fmt.Errorf("failed to verify blob %s: %w", blob.Digest, err)
+		}
+		log.Warn(ctx, fmt.Sprintf("Blob %s of SOCI index %s is broken, uploading it again: %v", blob.Digest, indexDigest, err))
+		if err := uploadBlob(ctx, sociStore, repo.Blobs(), blob); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Fetch a blob of the repository and verify its content against its descriptor
+func verifyBlob(ctx context.Context, blobs registry.BlobStore, desc ocispec.Descriptor) error {
+	rc, err := blobs.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	verifier := content.NewVerifyReader(rc, desc)
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		return err
+	}
+	return verifier.Verify()
+}
+
+// Upload a blob of the local store to the repository
+func uploadBlob(ctx context.Context, sociStore *store.SociStore, blobs registry.BlobStore, desc ocispec.Descriptor) error {
+	rc, err := sociStore.Fetch(ctx, desc)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrRepairContentMissing, desc.Digest)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := blobs.Push(ctx, desc, rc); err != nil {
+		return fmt.Errorf("failed to upload blob %s: %w", desc.Digest, err)
+	}
+	return nil
+}
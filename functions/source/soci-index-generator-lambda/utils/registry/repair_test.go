@@ -0,0 +1,77 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestRepairIndex(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-repair-index")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	blobs := [][]byte{[]byte("ztoc 1"), []byte("ztoc 2"), []byte("ztoc 3")}
+	indexDesc := storeTestIndex(t, ctx, sociStore, blobs...)
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", ""); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	missing := digest.FromBytes(blobs[1])
+	corrupt := digest.FromBytes(blobs[2])
+
+	// the upload of a ztoc failed, and another one was stored with the wrong content
+	breakIndex := func() {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		delete(fake.blobs, "repo@"+missing.String())
+		fake.blobs["repo@"+corrupt.String()] = []byte("ztoc X")
+	}
+
+	breakIndex()
+	result, err := registry.RepairIndex(ctx, "repo", indexDesc.Digest.String(), sociStore)
+	if err != nil {
+		t.Fatalf("RepairIndex failed: %v", err)
+	}
+	if result.Checked != len(blobs)+1 || !result.Repaired() {
+		t.Fatalf("Expected the config and %d ztocs to be checked and repaired but got %+v", len(blobs), result)
+	}
+	if len(result.Missing) != 1 || result.Missing[0].Digest != missing {
+		t.Fatalf("Expected %s to be missing but got %v", missing, result.Missing)
+	}
+	if len(result.Corrupt) != 1 || result.Corrupt[0].Digest != corrupt {
+		t.Fatalf("Expected %s to be corrupt but got %v", corrupt, result.Corrupt)
+	}
+	for i, blob := range blobs {
+		fake.mu.Lock()
+		stored := fake.blobs["repo@"+digest.FromBytes(blob).String()]
+		fake.mu.Unlock()
+		if !bytes.Equal(stored, blob) {
+			t.Fatalf("Expected ztoc %d to be %q after the repair but got %q", i, blob, stored)
+		}
+	}
+
+	// a sound index needs no repair
+	result, err = registry.RepairIndex(ctx, "repo", indexDesc.Digest.String(), newTestSociStore(t, ctx))
+	if err != nil || result.Repaired() {
+		t.Fatalf("Expected nothing to repair but got %+v, %v", result, err)
+	}
+
+	// the broken blobs must be in the local store
+	breakIndex()
+	if _, err := registry.RepairIndex(ctx, "repo", indexDesc.Digest.String(), newTestSociStore(t, ctx)); !errors.Is(err, ErrRepairContentMissing) {
+		t.Fatalf("Expected %v but got %v", ErrRepairContentMissing, err)
+	}
+
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	if _, err := registry.RepairIndex(ctx, "repo", image.Digest.String(), sociStore); !errors.Is(err, ErrNotSociIndex) {
+		t.Fatalf("Expected %v but got %v", ErrNotSociIndex, err)
+	}
+	if _, err := registry.RepairIndex(ctx, "repo", digest.FromString("unknown").String(), sociStore); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("Expected %v but got %v", ErrImageNotFound, err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// Default delay between two polls of WaitForReplication
+const defaultReplicationPollInterval = 5 * time.Second
+
+var ErrReplicationTimeout = errors.New("image was not replicated in time")
+
+var ErrReplicationUnsupported = errors.New("waiting for replication is only available for ECR registries")
+
+// Wait for an image to be replicated to the registry by ECR cross-region replication, polling DescribeImages in the
+// region of the registry until the digest appears, e.g. when the push event of the source region fires before the
+// replica exists. The repository itself may not exist until the first image is replicated. Returns
+// ErrReplicationTimeout if the image doesn't appear within timeout; a non positive timeout polls once.
+func (registry *Registry) WaitForReplication(ctx context.Context, repositoryName string, digest string, timeout time.Duration) error {
+	return registry.wrapError("wait for replication", repositoryName, digest, registry.waitForReplication(ctx, repositoryName, digest, timeout))
+}
+
+func (registry *Registry) waitForReplication(ctx context.Context, repositoryName string, digest string, timeout time.Duration) error {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return err
+	}
+	if _, err := ParseDigest(digest); err != nil {
+		return err
+	}
+	if registry.ecrCredentials == nil {
+		return ErrReplicationUnsupported
+	}
+
+	input := &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(repositoryName),
+		ImageIds:       []types.ImageIdentifier{{ImageDigest: aws.String(digest)}},
+	}
+	if registryId := ecrRegistryId(registry.registry.Reference.Registry); registryId != "" {
+		input.RegistryId = aws.String(registryId)
+	}
+	deadline := time.After(timeout)
+	for poll := 1; ; poll++ {
+		_, err := registry.ecrCredentials.client.DescribeImages(ctx, input)
+		if err == nil {
+			if poll > 1 {
+				log.Info(ctx, fmt.Sprintf("Image was replicated to %s after %d polls", registry.ecrRegion, poll))
+			}
+			return nil
+		}
+		if notFoundFromCode(err) == nil {
+			return fmt.Errorf("failed to describe the image: %w", err)
+		}
+		log.Info(ctx, fmt.Sprintf("Image is not replicated to %s yet, waiting (poll %d): %v", registry.ecrRegion, poll, err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("%w after %s: %w", ErrReplicationTimeout, timeout, err)
+		case <-time.After(registry.config.replicationPollInterval):
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestWaitForReplication(t *testing.T) {
+	imageDigest := digest.FromString("image").String()
+	doTest := func(misses int, describeErr error, timeout time.Duration, expectedErr error, expectedPolls int) {
+		ctx := newTestContext("abcd-1234-test-wait-for-replication")
+		ecrClient := &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour), describeImagesMisses: misses, describeImagesErr: describeErr}
+		stubEcrClient(t, ecrClient)
+		registry, err := Init(ctx, testEcrRegistryUrl)
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		registry.config.replicationPollInterval = time.Millisecond
+
+		err = registry.WaitForReplication(ctx, "repo", imageDigest, timeout)
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected the image to be replicated after %d polls but got %v", misses, err)
+		}
+		if expectedErr != nil && !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+		if expectedPolls > 0 && len(ecrClient.describeImagesInputs) != expectedPolls {
+			t.Fatalf("Expected %d polls but got %d", expectedPolls, len(ecrClient.describeImagesInputs))
+		}
+		for _, input := range ecrClient.describeImagesInputs {
+			if aws.ToString(input.RepositoryName) != "repo" || aws.ToString(input.ImageIds[0].ImageDigest) != imageDigest || aws.ToString(input.RegistryId) != "123456789012" {
+				t.Fatalf("Unexpected DescribeImages input %+v", input)
+			}
+		}
+	}
+
+	// already replicated
+	doTest(0, nil, time.Minute, nil, 1)
+	// replicated after 3 polls
+	doTest(3, nil, time.Minute, nil, 4)
+	// never replicated, the number of polls depends on the timing
+	doTest(1<<30, nil, 20*time.Millisecond, ErrReplicationTimeout, 0)
+	doTest(1<<30, nil, 0, ErrReplicationTimeout, 1)
+	// other errors aren't waited out
+	accessDenied := errors.New("access denied")
+	doTest(0, accessDenied, time.Minute, accessDenied, 1)
+}
+
+func TestWaitForReplicationUnsupported(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-wait-for-replication-unsupported")
+	registry := newFakeRegistry(t).registry(t)
+	if err := registry.WaitForReplication(ctx, "repo", digest.FromString("image").String(), time.Minute); !errors.Is(err, ErrReplicationUnsupported) {
+		t.Fatalf("Expected %v but got %v", ErrReplicationUnsupported, err)
+	}
+}
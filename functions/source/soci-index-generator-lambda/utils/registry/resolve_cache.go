@@ -0,0 +1,101 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type bypassResolveCacheKey struct{}
+
+// Return a context whose HeadManifest calls skip the resolve cache, e.g. to observe a tag that was just moved by
+// another writer. The fresh resolution is stored in the cache.
+func WithoutResolveCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassResolveCacheKey{}, true)
+}
+
+func bypassesResolveCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassResolveCacheKey{}).(bool)
+	return bypass
+}
+
+// An in-memory cache of the descriptors references resolve to, keyed by repository and reference. Only successful
+// resolutions are cached.
+type resolveCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]resolveCacheEntry
+	// overridden in tests
+	now func() time.Time
+}
+
+type resolveCacheEntry struct {
+	descriptor ocispec.Descriptor
+	// zero for entries that don't expire
+	expiry time.Time
+}
+
+// Return the resolve cache of a registry configuration, nil without WithResolveCache
+func newResolveCache(config registryConfig) *resolveCache {
+	if !config.resolveCache {
+		return nil
+	}
+	return &resolveCache{ttl: config.resolveCacheTTL, entries: map[string]resolveCacheEntry{}, now: time.Now}
+}
+
+func resolveCacheKey(repositoryName string, reference string) string {
+	return repositoryName + ":" + reference
+}
+
+func (cache *resolveCache) get(repositoryName string, reference string) (ocispec.Descriptor, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	key := resolveCacheKey(repositoryName, reference)
+	entry, ok := cache.entries[key]
+	if !ok {
+		return ocispec.Descriptor{}, false
+	}
+	if !entry.expiry.IsZero() && !cache.now().Before(entry.expiry) {
+		delete(cache.entries, key)
+		return ocispec.Descriptor{}, false
+	}
+	return entry.descriptor, true
+}
+
+func (cache *resolveCache) put(repositoryName string, reference string, descriptor ocispec.Descriptor) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry := resolveCacheEntry{descriptor: descriptor}
+	if cache.ttl > 0 {
+		entry.expiry = cache.now().Add(cache.ttl)
+	}
+	cache.entries[resolveCacheKey(repositoryName, reference)] = entry
+}
+
+func (cache *resolveCache) invalidate(repositoryName string, reference string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if reference != "" {
+		delete(cache.entries, resolveCacheKey(repositoryName, reference))
+		return
+	}
+	for key := range cache.entries {
+		if strings.HasPrefix(key, repositoryName+":") {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+// Drop the cached resolution of a reference, or of every reference of the repository when reference is empty.
+// Tags moved through this Registry are invalidated already. Does nothing without WithResolveCache.
+func (registry *Registry) InvalidateResolveCache(repositoryName string, reference string) {
+	if registry.resolveCache != nil {
+		registry.resolveCache.invalidate(repositoryName, reference)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResolveCache(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-resolve-cache")
+	fake := newFakeRegistry(t)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+
+	doTest := func(registry *Registry, expectedRequests int) {
+		t.Helper()
+		before := fake.requestCount(http.MethodGet, "/manifests/latest") + fake.requestCount(http.MethodHead, "/manifests/latest")
+		descriptor, err := registry.HeadManifest(ctx, "repo", "latest")
+		if err != nil {
+			t.Fatalf("HeadManifest failed: %v", err)
+		}
+		if descriptor.Digest != image.Digest {
+			t.Fatalf("Expected %s but got %s", image.Digest, descriptor.Digest)
+		}
+		after := fake.requestCount(http.MethodGet, "/manifests/latest") + fake.requestCount(http.MethodHead, "/manifests/latest")
+		if after-before != expectedRequests {
+			t.Fatalf("Expected %d requests to resolve the tag but got %d", expectedRequests, after-before)
+		}
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		registry := fake.registry(t)
+		doTest(registry, 1)
+		doTest(registry, 1)
+	})
+
+	t.Run("cache hit", func(t *testing.T) {
+		registry := fake.registry(t, WithResolveCache(0))
+		doTest(registry, 1)
+		doTest(registry, 0)
+		doTest(registry, 0)
+	})
+
+	t.Run("ttl expiry", func(t *testing.T) {
+		registry := fake.registry(t, WithResolveCache(time.Minute))
+		now := time.Now()
+		registry.resolveCache.now = func() time.Time { return now }
+		doTest(registry, 1)
+		now = now.Add(59 * time.Second)
+		doTest(registry, 0)
+		now = now.Add(time.Second)
+		doTest(registry, 1)
+		doTest(registry, 0)
+	})
+
+	t.Run("bypass and invalidation", func(t *testing.T) {
+		registry := fake.registry(t, WithResolveCache(0))
+		doTest(registry, 1)
+		before := fake.requestCount(http.MethodGet, "/manifests/latest")
+		bypassed, err := registry.HeadManifest(WithoutResolveCache(ctx), "repo", "latest")
+		if err != nil || bypassed.Digest != image.Digest {
+			t.Fatalf("Expected a bypassed resolution of %s but got %s, %v", image.Digest, bypassed.Digest, err)
+		}
+		if requests := fake.requestCount(http.MethodGet, "/manifests/latest") - before; requests != 1 {
+			t.Fatalf("Expected the bypass to reach the registry, got %d requests", requests)
+		}
+		doTest(registry, 0)
+		registry.InvalidateResolveCache("repo", "latest")
+		doTest(registry, 1)
+		registry.InvalidateResolveCache("repo", "")
+		doTest(registry, 1)
+	})
+
+	t.Run("moving a tag invalidates it", func(t *testing.T) {
+		registry := fake.registry(t, WithResolveCache(0))
+		doTest(registry, 1)
+		other := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig, fake.putBlob("repo", "application/octet-stream", []byte("layer"))))
+		if err := registry.TagIndex(ctx, "repo", other, "latest"); err != nil {
+			t.Fatalf("TagIndex failed: %v", err)
+		}
+		descriptor, err := registry.HeadManifest(ctx, "repo", "latest")
+		if err != nil || descriptor.Digest != other.Digest {
+			t.Fatalf("Expected the moved tag to resolve to %s but got %s, %v", other.Digest, descriptor.Digest, err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// The local store as the destination of Pull, so that a pull retried on a warm Lambda, e.g. after a timeout,
+// resumes from the content a previous attempt left behind. oras-go skips the nodes the destination has, along
+// with their successors, so the stored content is verified before it's reused: blobs whose content doesn't match
+// their digest, e.g. truncated by an interrupted write, are discarded to be fetched again, and manifests only
+// count as stored once their whole graph is.
+type resumingStore struct {
+	*store.SociStore
+
+	mu sync.Mutex
+	// Digests of the nodes whose content and successors were verified
+	verified map[digest.Digest]bool
+}
+
+func newResumingStore(sociStore *store.SociStore) *resumingStore {
+	return &resumingStore{SociStore: sociStore, verified: map[digest.Digest]bool{}}
+}
+
+func (s *resumingStore) Exists(ctx context.Context, desc ocispec.Descriptor) (bool, error) {
+	s.mu.Lock()
+	verified := s.verified[desc.Digest]
+	s.mu.Unlock()
+	if verified {
+		return true, nil
+	}
+
+	exists, err := s.SociStore.Exists(ctx, desc)
+	if err != nil || !exists {
+		return false, err
+	}
+	if err := verifyStoredBlob(ctx, s.SociStore, desc); err != nil {
+		log.Warn(ctx, fmt.Sprintf("Discarding blob %s of the local store to fetch it again: %v", desc.Digest, err))
+		if err := s.SociStore.Store.Delete(ctx, desc); err != nil && !errors.Is(err, errdef.ErrNotFound) {
+			return false, fmt.Errorf("failed to discard blob %s of the local store: %w", desc.Digest, err)
+		}
+		return false, nil
+	}
+	if isManifestMediaType(desc.MediaType) {
+		successors, err := content.Successors(ctx, s.SociStore, desc)
+		if err != nil {
+			return false, err
+		}
+		for _, successor := range successors {
+			if IsForeignLayerMediaType(successor.MediaType) {
+				continue
+			}
+			// the manifest is pushed again once its missing successors are fetched
+			complete, err := s.Exists(ctx, successor)
+			if err != nil || !complete {
+				return false, err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.verified[desc.Digest] = true
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Check that a blob of the local store matches its descriptor, reading it whole
+func verifyStoredBlob(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor) error {
+	rc, err := sociStore.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	verifier := content.NewVerifyReader(rc, desc)
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		return err
+	}
+	return verifier.Verify()
+}
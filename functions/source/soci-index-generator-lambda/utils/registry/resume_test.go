@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+func TestPullResumesPartialStore(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-pull-resumes-partial-store")
+	goodContent := []byte("layer pulled whole by the interrupted attempt")
+	truncatedContent := []byte("layer whose write was interrupted by the timeout")
+
+	// manifestStored simulates an interrupted write that left a stored manifest behind a truncated layer
+	doTest := func(name string, manifestStored bool) {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeRegistry(t)
+			config := fake.putBlob("repo", MediaTypeOCIImageConfig, []byte("{}"))
+			good := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, goodContent)
+			truncated := fake.putBlob("repo", ocispec.MediaTypeImageLayerGzip, truncatedContent)
+			manifest := imageManifest(config.MediaType, good, truncated)
+			image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, manifest, "latest")
+
+			rootPath := t.TempDir()
+			sociStore, err := NewSociStore(ctx, rootPath)
+			if err != nil {
+				t.Fatalf("NewSociStore failed: %v", err)
+			}
+			pushToStore(t, ctx, sociStore, good.MediaType, goodContent)
+			truncatedPath := filepath.Join(rootPath, "blobs", truncated.Digest.Algorithm().String(), truncated.Digest.Encoded())
+			if err := os.MkdirAll(filepath.Dir(truncatedPath), 0o755); err != nil {
+				t.Fatalf("Failed to create the blobs directory: %v", err)
+			}
+			if err := os.WriteFile(truncatedPath, truncatedContent[:10], 0o644); err != nil {
+				t.Fatalf("Failed to write the truncated blob: %v", err)
+			}
+			if manifestStored {
+				manifestContent, err := json.Marshal(manifest)
+				if err != nil {
+					t.Fatalf("Failed to marshal the manifest: %v", err)
+				}
+				pushToStore(t, ctx, sociStore, image.MediaType, manifestContent)
+			}
+
+			result, err := fake.registry(t).Pull(ctx, "repo", sociStore, "latest")
+			if err != nil {
+				t.Fatalf("Pull failed: %v", err)
+			}
+			if result.Descriptor.Digest != image.Digest {
+				t.Fatalf("Expected %s to be pulled but got %s", image.Digest, result.Descriptor.Digest)
+			}
+			// the good blob is reused, the truncated one fetched again
+			if requests := fake.requestCount(http.MethodGet, "/blobs/"+good.Digest.String()); requests != 0 {
+				t.Fatalf("Expected the stored layer to be reused but it was fetched %d times", requests)
+			}
+			if requests := fake.requestCount(http.MethodGet, "/blobs/"+truncated.Digest.String()); requests != 1 {
+				t.Fatalf("Expected the truncated layer to be fetched again but it was fetched %d times", requests)
+			}
+			stored, err := content.FetchAll(ctx, sociStore, truncated)
+			if err != nil {
+				t.Fatalf("Expected the truncated layer to be replaced but got: %v", err)
+			}
+			if string(stored) != string(truncatedContent) {
+				t.Fatalf("Expected the refetched layer content but got %q", stored)
+			}
+		})
+	}
+
+	doTest("missing manifest", false)
+	doTest("stored manifest", true)
+}
@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Default delay between two attempts of a push's copy
+const defaultPushRetryDelay = time.Second
+
+// Copy the graph rooted at desc, retrying up to maxRetries times on failures the retry policy deems retryable.
+// Since the registry is content addressable, a retry only uploads the blobs the failed attempt didn't:
+// the blobs uploaded so far are found in the target repository and skipped, so a retry resumes the push.
+func (registry *Registry) copyGraphWithRetries(ctx context.Context, sociStore *store.SociStore, repo oras.Target, desc ocispec.Descriptor, tally *pushTally, maxRetries int) error {
+	for attempt := 0; ; attempt++ {
+		opts := tally.copyGraphOptions()
+		opts.PreCopy = chainCopyHooks(opts.PreCopy, registry.ensureIndexChildren(sociStore, repo))
+		err := registry.copyGraph(ctx, sociStore, repo, desc, opts)
+		if err == nil || attempt >= maxRetries || !registry.config.isRetryable(err) {
+			return err
+		}
+		if !registry.config.allowRetry() {
+			return retryBudgetExhausted(err)
+		}
+
+		uploaded, bytes := tally.uploaded()
+		log.Warn(ctx, fmt.Sprintf("Push failed after uploading %d blobs (%d bytes), resuming (retry %d of %d): %v",
+			uploaded, bytes, attempt+1, maxRetries, err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(registry.config.pushRetryDelay):
+		}
+	}
+}
+
+// The default retry policy: an error is retryable if it is likely to go away when retrying the request,
+// i.e. server errors, throttling, timeouts, including those of WithPerOperationTimeout, and connections closed
+// mid-transfer. Errors of canceled or expired contexts are not retryable. Custom policies given with WithIsRetryable can fall back to it.
+func DefaultIsRetryable(err error) bool {
+	if errors.Is(err, ErrOperationTimeout) {
+		return true
+	}
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.StatusCode >= http.StatusInternalServerError || errResp.StatusCode == http.StatusTooManyRequests
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return isNetworkError(err)
+}
@@ -0,0 +1,45 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// ErrRetryBudgetExhausted is returned in place of a retry when the retry budget of WithRetryBudget is spent
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// Take a retry from the retry budget of WithRetryBudget, returning false if the budget is spent.
+// Retries are unlimited without a budget.
+func (config registryConfig) allowRetry() bool {
+	return config.retryBudget == nil || config.retryBudget.tryTake()
+}
+
+// Return err wrapped with ErrRetryBudgetExhausted
+func retryBudgetExhausted(err error) error {
+	return fmt.Errorf("%w, giving up: %w", ErrRetryBudgetExhausted, err)
+}
+
+// A retry policy of the transport taking each retry of a request from the retry budget.
+// A request whose retry is denied returns the response of its last attempt.
+type budgetedRetryPolicy struct {
+	base   retry.Policy
+	budget *rateLimiter
+}
+
+func (policy *budgetedRetryPolicy) Retry(attempt int, resp *http.Response, err error) (time.Duration, error) {
+	delay, retryErr := policy.base.Retry(attempt, resp, err)
+	if retryErr != nil || delay < 0 {
+		return delay, retryErr
+	}
+	if !policy.budget.tryTake() {
+		return -1, nil
+	}
+	return delay, nil
+}
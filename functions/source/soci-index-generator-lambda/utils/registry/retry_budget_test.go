@@ -0,0 +1,100 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestPushRetryBudget(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-retry-budget")
+	blobs := [][]byte{[]byte("ztoc 1"), []byte("ztoc 2")}
+	failing := digest.FromBytes(blobs[1]).String()
+
+	doTest := func(opts []Option, expectedUploads []int) {
+		fake := newFakeRegistry(t)
+		var uploads atomic.Int32
+		// the upload of the failing blob always fails with a transient error
+		fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || r.URL.Query().Get("digest") != failing {
+				return false
+			}
+			uploads.Add(1)
+			writeRegistryError(w, http.StatusServiceUnavailable, "UNAVAILABLE", "service unavailable")
+			return true
+		}
+		registry := fake.registry(t, opts...)
+		registry.config.pushRetryDelay = time.Millisecond
+
+		// the pushes share the budget of the registry
+		for i, expected := range expectedUploads {
+			sociStore := newTestSociStore(t, ctx)
+			indexDesc := storeTestIndex(t, ctx, sociStore, blobs...)
+			uploads.Store(0)
+			_, err := registry.Push(ctx, sociStore, indexDesc, "repo", "", WithMaxPushRetries(3))
+			if err == nil {
+				t.Fatalf("Expected push %d to fail but it succeeded", i)
+			}
+			if exhausted := int(uploads.Load()) < 4; errors.Is(err, ErrRetryBudgetExhausted) != exhausted {
+				t.Fatalf("Expected push %d to exhaust the retry budget: %v but got %v", i, exhausted, err)
+			}
+			if int(uploads.Load()) != expected {
+				t.Fatalf("Expected %d uploads of the failing blob by push %d but got %d", expected, i, uploads.Load())
+			}
+		}
+	}
+
+	// without a budget, every push retries up to its maximum
+	doTest(nil, []int{4, 4})
+	// the retries stop once the budget is spent, and the next push doesn't retry at all
+	doTest([]Option{WithRetryBudget(0, 2)}, []int{3, 1})
+	// a budget larger than the retries of a push doesn't change it
+	doTest([]Option{WithRetryBudget(0, 10)}, []int{4, 4})
+}
+
+func TestTransportRetryBudget(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	client := newHTTPClient(newRegistryConfig([]Option{WithRetryBudget(0, 1)}))
+	doTest := func(expectedRequests int32) {
+		requests.Store(0)
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Expected no error but got %v", err)
+		}
+		resp.Body.Close()
+		// the response of the last attempt is returned once the budget is spent
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status %d but got %d", http.StatusServiceUnavailable, resp.StatusCode)
+		}
+		if requests.Load() != expectedRequests {
+			t.Fatalf("Expected %d requests but got %d", expectedRequests, requests.Load())
+		}
+	}
+
+	// the only retry of the budget is taken by the first request, the second one isn't retried
+	doTest(2)
+	doTest(1)
+}
+
+func TestRateLimiterTryTake(t *testing.T) {
+	limiter := newRateLimiter(0, 2)
+	for i, expected := range []bool{true, true, false, false} {
+		if limiter.tryTake() != expected {
+			t.Fatalf("Expected take %d to be %v but got %v", i, expected, !expected)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+var ErrScanNotPassed = errors.New("image did not pass the ECR image scan")
+
+var ErrScanUnsupported = errors.New("image scans are only available for ECR registries")
+
+// Severities of ECR image scan findings, from the most to the least severe
+var ScanSeverities = []string{
+	string(types.FindingSeverityCritical),
+	string(types.FindingSeverityHigh),
+	string(types.FindingSeverityMedium),
+	string(types.FindingSeverityLow),
+	string(types.FindingSeverityInformational),
+	string(types.FindingSeverityUndefined),
+}
+
+// Check that an image passed its ECR vulnerability scan, i.e. the scan is complete and has no finding at or above
+// severityThreshold, e.g. HIGH fails an image with HIGH or CRITICAL findings. Images that weren't scanned, whose scan
+// isn't complete or that have such findings are rejected with ErrScanNotPassed.
+func (registry *Registry) CheckImageScan(ctx context.Context, repositoryName string, digest string, severityThreshold string) error {
+	return registry.wrapError("check image scan", repositoryName, digest, registry.checkImageScan(ctx, repositoryName, digest, severityThreshold))
+}
+
+func (registry *Registry) checkImageScan(ctx context.Context, repositoryName string, digest string, severityThreshold string) error {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return err
+	}
+	if _, err := ParseDigest(digest); err != nil {
+		return err
+	}
+	threshold := slices.Index(ScanSeverities, strings.ToUpper(severityThreshold))
+	if threshold < 0 {
+		return fmt.Errorf("invalid severity threshold %q, expected one of %s", severityThreshold, strings.Join(ScanSeverities, ", "))
+	}
+	if registry.ecrCredentials == nil {
+		return ErrScanUnsupported
+	}
+
+	input := &ecr.DescribeImageScanFindingsInput{
+		RepositoryName: aws.String(repositoryName),
+		ImageId:        &types.ImageIdentifier{ImageDigest: aws.String(digest)},
+		MaxResults:     aws.Int32(1),
+	}
+	if registryId := ecrRegistryId(registry.registry.Reference.Registry); registryId != "" {
+		input.RegistryId = aws.String(registryId)
+	}
+	output, err := registry.ecrCredentials.client.DescribeImageScanFindings(ctx, input)
+	if err != nil {
+		var scanNotFound *types.ScanNotFoundException
+		if errors.As(err, &scanNotFound) {
+			return fmt.Errorf("%w: the image has not been scanned", ErrScanNotPassed)
+		}
+		return fmt.Errorf("failed to describe the image scan findings: %w", err)
+	}
+
+	var status types.ScanStatus
+	if output.ImageScanStatus != nil {
+		status = output.ImageScanStatus.Status
+	}
+	if status != types.ScanStatusComplete {
+		return fmt.Errorf("%w: the scan status is %q", ErrScanNotPassed, status)
+	}
+	if output.ImageScanFindings == nil {
+		return nil
+	}
+	for _, severity := range ScanSeverities[:threshold+1] {
+		if count := output.ImageScanFindings.FindingSeverityCounts[severity]; count > 0 {
+			return fmt.Errorf("%w: the scan found %d %s vulnerabilities", ErrScanNotPassed, count, severity)
+		}
+	}
+	return nil
+}
+
+// Return the account id of an ECR registry host, e.g. 123456789012 for 123456789012.dkr.ecr.us-west-2.amazonaws.com
+func ecrRegistryId(host string) string {
+	registryId, _, _ := strings.Cut(host, ".")
+	if len(registryId) != 12 || strings.Trim(registryId, "0123456789") != "" {
+		return ""
+	}
+	return registryId
+}
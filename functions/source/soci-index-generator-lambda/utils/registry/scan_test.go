@@ -0,0 +1,80 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+	"github.com/opencontainers/go-digest"
+)
+
+// scanFindings describes a scan with the given status and number of findings per severity
+func scanFindings(status types.ScanStatus, counts map[types.FindingSeverity]int32) *ecr.DescribeImageScanFindingsOutput {
+	output := &ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus:   &types.ImageScanStatus{Status: status},
+		ImageScanFindings: &types.ImageScanFindings{FindingSeverityCounts: map[string]int32{}},
+	}
+	for severity, count := range counts {
+		output.ImageScanFindings.FindingSeverityCounts[string(severity)] = count
+	}
+	return output
+}
+
+func TestCheckImageScan(t *testing.T) {
+	imageDigest := digest.FromString("image").String()
+	doTest := func(findings *ecr.DescribeImageScanFindingsOutput, scanErr error, threshold string, expectedErr error) {
+		ctx := newTestContext("abcd-1234-test-check-image-scan")
+		ecrClient := &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour), scanFindings: findings, scanErr: scanErr}
+		stubEcrClient(t, ecrClient)
+		registry, err := Init(ctx, testEcrRegistryUrl)
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+
+		err = registry.CheckImageScan(ctx, "repo", imageDigest, threshold)
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected the image to pass the scan with threshold %s but got %v", threshold, err)
+		}
+		if expectedErr != nil && !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v with threshold %s but got %v", expectedErr, threshold, err)
+		}
+		if len(ecrClient.scanInputs) == 1 {
+			input := ecrClient.scanInputs[0]
+			if aws.ToString(input.RepositoryName) != "repo" || aws.ToString(input.ImageId.ImageDigest) != imageDigest || aws.ToString(input.RegistryId) != "123456789012" {
+				t.Fatalf("Unexpected DescribeImageScanFindings input: %v", input)
+			}
+		}
+	}
+
+	doTest(scanFindings(types.ScanStatusComplete, nil), nil, "LOW", nil)
+	doTest(scanFindings(types.ScanStatusComplete, map[types.FindingSeverity]int32{types.FindingSeverityMedium: 3}), nil, "HIGH", nil)
+	doTest(scanFindings(types.ScanStatusComplete, map[types.FindingSeverity]int32{types.FindingSeverityMedium: 3}), nil, "medium", ErrScanNotPassed)
+	doTest(scanFindings(types.ScanStatusComplete, map[types.FindingSeverity]int32{types.FindingSeverityCritical: 1}), nil, "HIGH", ErrScanNotPassed)
+	doTest(scanFindings(types.ScanStatusInProgress, nil), nil, "HIGH", ErrScanNotPassed)
+	doTest(scanFindings(types.ScanStatusFailed, nil), nil, "HIGH", ErrScanNotPassed)
+	doTest(nil, &types.ScanNotFoundException{Message: aws.String("no scan")}, "HIGH", ErrScanNotPassed)
+
+	// other errors are not a verdict on the image
+	accessDenied := &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied"}
+	doTest(nil, accessDenied, "HIGH", accessDenied)
+
+	ctx := newTestContext("abcd-1234-test-check-image-scan")
+	if err := newFakeRegistry(t).registry(t).CheckImageScan(ctx, "repo", imageDigest, "HIGH"); !errors.Is(err, ErrScanUnsupported) {
+		t.Fatalf("Expected ErrScanUnsupported for a non ECR registry but got %v", err)
+	}
+	stubEcrClient(t, &fakeEcrClient{passwords: []string{"password"}, expiresAt: time.Now().Add(time.Hour)})
+	registry, err := Init(ctx, testEcrRegistryUrl)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if err := registry.CheckImageScan(ctx, "repo", imageDigest, "SEVERE"); err == nil || errors.Is(err, ErrScanNotPassed) {
+		t.Fatalf("Expected an invalid threshold error but got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrSnapshotterIncompatible = errors.New("index is not compatible with the targeted snapshotter versions")
+
+var ErrUnknownSnapshotterCompatibility = errors.New("unknown snapshotter compatibility")
+
+// How the SOCI index manifests pushed by Push are adjusted for a range of soci-snapshotter versions, so that one
+// builder can serve clusters running different snapshotter versions. See WithSnapshotterCompatibility.
+type SnapshotterCompatibility struct {
+	// The range of snapshotter versions, for logs and errors, e.g. ">= v0.8.0"
+	Versions string
+	// Whether the snapshotters understand V2 indexes. V1 indexes are understood by all.
+	SupportsV2 bool
+	// Set the artifactType field of the index manifests to the media type of their config, which carries the index
+	// version, for registries that don't derive the artifact type of the referrers API from the config
+	SetArtifactType bool
+	// Annotation keys of the index manifests and of their ztoc descriptors, renamed to the keys the snapshotters read
+	AnnotationKeys map[string]string
+}
+
+var (
+	// soci-snapshotter v0.8.0 and later, which understand V1 and V2 indexes as the SOCI library builds them.
+	// Indexes are pushed as is.
+	SnapshotterCompatibilityCurrent = SnapshotterCompatibility{Versions: ">= v0.8.0", SupportsV2: true}
+	// soci-snapshotter before v0.8.0, which predate V2 indexes and find V1 indexes with the referrers API.
+	// V2 indexes are rejected with ErrSnapshotterIncompatible, and V1 indexes carry their artifact type.
+	SnapshotterCompatibilityPreV2 = SnapshotterCompatibility{Versions: "< v0.8.0", SetArtifactType: true}
+)
+
+// The predefined compatibilities by name, as accepted by ParseSnapshotterCompatibility
+var snapshotterCompatibilities = map[string]SnapshotterCompatibility{
+	"current":  SnapshotterCompatibilityCurrent,
+	"pre-v0.8": SnapshotterCompatibilityPreV2,
+}
+
+// Parse the name of a predefined compatibility, "current" or "pre-v0.8". An empty name is the default, "current".
+func ParseSnapshotterCompatibility(name string) (SnapshotterCompatibility, error) {
+	if name == "" {
+		return SnapshotterCompatibilityCurrent, nil
+	}
+	compatibility, ok := snapshotterCompatibilities[strings.ToLower(name)]
+	if !ok {
+		names := slices.Sorted(maps.Keys(snapshotterCompatibilities))
+		return SnapshotterCompatibility{}, fmt.Errorf("%w %q, expected one of %s", ErrUnknownSnapshotterCompatibility, name, strings.Join(names, ", "))
+	}
+	return compatibility, nil
+}
+
+// Whether the compatibility rewrites index manifests
+func (compatibility SnapshotterCompatibility) rewrites() bool {
+	return compatibility.SetArtifactType || len(compatibility.AnnotationKeys) > 0
+}
+
+// Rewrite the index manifest of the local store described by desc for the snapshotters of the compatibility, store
+// it and return its descriptor. An index the compatibility doesn't change is returned unchanged. Image indexes
+// referencing V2 indexes are checked but not rewritten.
+func (compatibility SnapshotterCompatibility) adjust(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if kindFromMediaType(desc.MediaType) == ImageIndex {
+		if !compatibility.SupportsV2 {
+			return ocispec.Descriptor{}, fmt.Errorf("%w: snapshotters %s don't understand the V2 indexes of image index %s", ErrSnapshotterIncompatible, compatibility.Versions, desc.Digest)
+		}
+		if compatibility.rewrites() {
+			return ocispec.Descriptor{}, fmt.Errorf("%w: the V2 indexes of image index %s can't be rewritten for snapshotters %s", ErrSnapshotterIncompatible, desc.Digest, compatibility.Versions)
+		}
+		return desc, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := readStoreJSON(ctx, sociStore, desc, DefaultMaxManifestSize, &fields); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var manifest ocispec.Manifest
+	if err := readStoreJSON(ctx, sociStore, desc, DefaultMaxManifestSize, &manifest); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if manifest.Config.MediaType == soci.SociIndexArtifactTypeV2 && !compatibility.SupportsV2 {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: snapshotters %s don't understand V2 index %s", ErrSnapshotterIncompatible, compatibility.Versions, desc.Digest)
+	}
+	if !compatibility.rewrites() {
+		return desc, nil
+	}
+
+	artifactType := manifest.ArtifactType
+	if compatibility.SetArtifactType {
+		artifactType = manifest.Config.MediaType
+	}
+	annotations := compatibility.renameAnnotations(manifest.Annotations)
+	layers := slices.Clone(manifest.Layers)
+	for i := range layers {
+		layers[i].Annotations = compatibility.renameAnnotations(layers[i].Annotations)
+	}
+	if artifactType == manifest.ArtifactType && maps.Equal(annotations, manifest.Annotations) &&
+		slices.EqualFunc(layers, manifest.Layers, func(a, b ocispec.Descriptor) bool { return maps.Equal(a.Annotations, b.Annotations) }) {
+		return desc, nil
+	}
+
+	var err error
+	if fields["artifactType"], err = json.Marshal(artifactType); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if fields["layers"], err = json.Marshal(layers); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(annotations) > 0 {
+		if fields["annotations"], err = json.Marshal(annotations); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	mappedDesc := desc
+	mappedDesc.ArtifactType = artifactType
+	mappedDesc.Digest = digest.FromBytes(content)
+	mappedDesc.Size = int64(len(content))
+	if err := pushBytes(ctx, sociStore, mappedDesc, content); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to store the index adjusted for snapshotters %s: %w", compatibility.Versions, err)
+	}
+	return mappedDesc, nil
+}
+
+// Return a copy of annotations with the keys of AnnotationKeys renamed
+func (compatibility SnapshotterCompatibility) renameAnnotations(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 || len(compatibility.AnnotationKeys) == 0 {
+		return annotations
+	}
+	renamed := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if newKey, ok := compatibility.AnnotationKeys[key]; ok {
+			key = newKey
+		}
+		renamed[key] = value
+	}
+	return renamed
+}
@@ -0,0 +1,112 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Store a SOCI index of the given version with a ztoc annotated like the SOCI library annotates them
+func storeAnnotatedTestIndex(t *testing.T, ctx context.Context, sociStore *store.SociStore, version soci.IndexVersion) ocispec.Descriptor {
+	ztoc := pushToStore(t, ctx, sociStore, soci.SociLayerMediaType, []byte("ztoc"))
+	ztoc.Annotations = map[string]string{
+		soci.IndexAnnotationImageLayerDigest:    digest.FromString("layer").String(),
+		soci.IndexAnnotationImageLayerMediaType: ocispec.MediaTypeImageLayerGzip,
+	}
+	index := soci.NewIndex(version, []ocispec.Descriptor{ztoc}, nil, map[string]string{soci.IndexAnnotationBuildToolIdentifier: "test"})
+	pushToStore(t, ctx, sociStore, index.Config.MediaType, []byte("{}"))
+	content, err := soci.MarshalIndex(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal SOCI index: %v", err)
+	}
+	return pushToStore(t, ctx, sociStore, ocispec.MediaTypeImageManifest, content)
+}
+
+func TestParseSnapshotterCompatibility(t *testing.T) {
+	doTest := func(name string, expected string, expectedErr error) {
+		compatibility, err := ParseSnapshotterCompatibility(name)
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v parsing %q but got %v", expectedErr, name, err)
+		}
+		if compatibility.Versions != expected {
+			t.Fatalf("Expected %q to target snapshotters %q but got %q", name, expected, compatibility.Versions)
+		}
+	}
+	doTest("", SnapshotterCompatibilityCurrent.Versions, nil)
+	doTest("current", SnapshotterCompatibilityCurrent.Versions, nil)
+	doTest("Pre-v0.8", SnapshotterCompatibilityPreV2.Versions, nil)
+	doTest("v0.1", "", ErrUnknownSnapshotterCompatibility)
+}
+
+func TestPushWithSnapshotterCompatibility(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-snapshotter-compatibility")
+	renamedKey := "com.example.soci.image-layer-media-type"
+	custom := SnapshotterCompatibility{
+		Versions:       "custom",
+		AnnotationKeys: map[string]string{soci.IndexAnnotationImageLayerMediaType: renamedKey},
+	}
+
+	doTest := func(version soci.IndexVersion, opts []PushOption, expectedArtifactType string, expectedLayerKey string, expectedErr error) {
+		fake := newFakeRegistry(t)
+		registry := fake.registry(t)
+		sociStore := newTestSociStore(t, ctx)
+		indexDesc := storeAnnotatedTestIndex(t, ctx, sociStore, version)
+		var index ocispec.Manifest
+		if err := readStoreJSON(ctx, sociStore, indexDesc, DefaultMaxManifestSize, &index); err != nil {
+			t.Fatalf("Failed to read the index: %v", err)
+		}
+
+		pushed, err := registry.Push(ctx, sociStore, indexDesc, "repo", "", opts...)
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+		if expectedErr != nil {
+			if len(fake.requests) != 0 {
+				t.Fatalf("Expected an incompatible index not to be pushed but got %v", fake.requests)
+			}
+			return
+		}
+		// an index the compatibility doesn't change is pushed as is
+		rewritten := expectedArtifactType != "" || expectedLayerKey != soci.IndexAnnotationImageLayerMediaType
+		if (pushed.Descriptor.Digest != indexDesc.Digest) != rewritten {
+			t.Fatalf("Expected the index to be rewritten: %v but pushed %s for %s", rewritten, pushed.Descriptor.Digest, indexDesc.Digest)
+		}
+
+		manifest, err := registry.GetManifest(ctx, "repo", pushed.Descriptor.Digest.String())
+		if err != nil {
+			t.Fatalf("Failed to fetch the pushed index: %v", err)
+		}
+		if manifest.ArtifactType != expectedArtifactType {
+			t.Fatalf("Expected artifact type %q but got %q", expectedArtifactType, manifest.ArtifactType)
+		}
+		// the config media type still carries the index version
+		if manifest.Config.MediaType != index.Config.MediaType {
+			t.Fatalf("Expected config media type %q but got %q", index.Config.MediaType, manifest.Config.MediaType)
+		}
+		if manifest.Annotations[soci.IndexAnnotationBuildToolIdentifier] != "test" {
+			t.Fatalf("Expected the annotations of the index to be kept but got %v", manifest.Annotations)
+		}
+		layer := manifest.Layers[0]
+		if layer.Annotations[expectedLayerKey] != ocispec.MediaTypeImageLayerGzip || layer.Annotations[soci.IndexAnnotationImageLayerDigest] == "" {
+			t.Fatalf("Expected the layer media type under %q but got %v", expectedLayerKey, layer.Annotations)
+		}
+	}
+
+	// the default targets the current release, which understands both versions as built
+	doTest(soci.V1, nil, "", soci.IndexAnnotationImageLayerMediaType, nil)
+	doTest(soci.V2, nil, "", soci.IndexAnnotationImageLayerMediaType, nil)
+	doTest(soci.V2, []PushOption{WithSnapshotterCompatibility(SnapshotterCompatibilityCurrent)}, "", soci.IndexAnnotationImageLayerMediaType, nil)
+	// snapshotters predating V2 indexes get V1 indexes carrying their artifact type, and no V2 index
+	doTest(soci.V1, []PushOption{WithSnapshotterCompatibility(SnapshotterCompatibilityPreV2)}, soci.SociIndexArtifactTypeV1, soci.IndexAnnotationImageLayerMediaType, nil)
+	doTest(soci.V2, []PushOption{WithSnapshotterCompatibility(SnapshotterCompatibilityPreV2)}, "", "", ErrSnapshotterIncompatible)
+	// custom compatibilities rename annotation keys
+	doTest(soci.V1, []PushOption{WithSnapshotterCompatibility(custom)}, "", renamedKey, nil)
+}
@@ -0,0 +1,108 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+var ErrIndexNotParsable = errors.New("SOCI index can't be parsed by the SOCI snapshotter")
+
+// Check that the SOCI index at reference parses the way the SOCI snapshotter parses the indexes it fetches: the
+// index manifest is decoded as a SOCI index and each of its ztocs is unmarshaled, without mounting anything. The
+// V2 SOCI indexes of an image index are each checked. Indexes a registry accepted but the snapshotter would reject
+// fail with ErrIndexNotParsable.
+func (registry *Registry) ValidateIndexParsable(ctx context.Context, repositoryName string, reference string) error {
+	err := registry.validateIndexParsable(ctx, repositoryName, reference)
+	return registry.wrapError("validate index", repositoryName, reference, registry.classifyNotFound(ctx, repositoryName, reference, err))
+}
+
+func (registry *Registry) validateIndexParsable(ctx context.Context, repositoryName string, reference string) error {
+	desc, err := registry.headManifest(ctx, repositoryName, reference)
+	if err != nil {
+		return err
+	}
+	repo, err := registry.registry.Repository(ctx, repositoryName)
+	if err != nil {
+		return err
+	}
+	return validateParsable(ctx, repo, desc, registry.config.maxManifestSize)
+}
+
+// Parse the SOCI index desc, or the SOCI indexes of the image index desc, as the SOCI snapshotter does
+func validateParsable(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor, maxManifestSize int64) error {
+	if kindFromMediaType(desc.MediaType) != ImageIndex {
+		return validateSociIndexParsable(ctx, fetcher, desc, maxManifestSize)
+	}
+
+	var index ocispec.Index
+	if err := fetchJSON(ctx, fetcher, desc, maxManifestSize, &index); err != nil {
+		return err
+	}
+	children := make(map[string]ocispec.Descriptor, len(index.Manifests))
+	for _, child := range index.Manifests {
+		children[child.Digest.String()] = child
+	}
+	validated := 0
+	for _, image := range index.Manifests {
+		sociIndexDigest, ok := image.Annotations[soci.ImageAnnotationSociIndexDigest]
+		if !ok {
+			continue
+		}
+		sociIndex, ok := children[sociIndexDigest]
+		if !ok {
+			return fmt.Errorf("%w: SOCI index %s of image %s is missing from the image index", ErrIndexNotParsable, sociIndexDigest, image.Digest)
+		}
+		if err := validateSociIndexParsable(ctx, fetcher, sociIndex, maxManifestSize); err != nil {
+			return err
+		}
+		validated++
+	}
+	if validated == 0 {
+		return fmt.Errorf("%w: image index %s references no SOCI index", ErrIndexNotParsable, desc.Digest)
+	}
+	return nil
+}
+
+// Decode a SOCI index manifest and unmarshal its ztocs
+func validateSociIndexParsable(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor, maxManifestSize int64) error {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("failed to fetch SOCI index %s: %w", desc.Digest, err)
+	}
+	manifest, err := readManifest(rc, desc, maxManifestSize)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if err := verifyContent(manifest, desc); err != nil {
+		return err
+	}
+	var index soci.Index
+	if err := soci.UnmarshalIndex(manifest, &index); err != nil {
+		return fmt.Errorf("%w: SOCI index %s: %w", ErrIndexNotParsable, desc.Digest, err)
+	}
+
+	for _, blob := range index.Blobs {
+		if blob.MediaType != soci.SociLayerMediaType {
+			continue
+		}
+		serialized, err := content.FetchAll(ctx, fetcher, blob)
+		if err != nil {
+			return fmt.Errorf("failed to fetch ztoc %s of SOCI index %s: %w", blob.Digest, desc.Digest, err)
+		}
+		if _, err := ztoc.Unmarshal(bytes.NewReader(serialized)); err != nil {
+			return fmt.Errorf("%w: ztoc %s of SOCI index %s: %w", ErrIndexNotParsable, blob.Digest, desc.Digest, err)
+		}
+	}
+	return nil
+}
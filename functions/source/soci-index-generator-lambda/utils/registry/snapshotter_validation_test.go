@@ -0,0 +1,129 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Build the serialized ztoc of a small gzip layer
+func testZtoc(t *testing.T) []byte {
+	var layer bytes.Buffer
+	gz := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gz)
+	data := bytes.Repeat([]byte("layer content "), 1024)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0o644, Size: int64(len(data))})
+	tw.Write(data)
+	tw.Close()
+	gz.Close()
+	layerPath := filepath.Join(t.TempDir(), "layer.tar.gz")
+	if err := os.WriteFile(layerPath, layer.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write layer: %v", err)
+	}
+	built, err := ztoc.NewBuilder("test").BuildZtoc(layerPath, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to build ztoc: %v", err)
+	}
+	reader, _, err := ztoc.Marshal(built)
+	if err != nil {
+		t.Fatalf("Failed to marshal ztoc: %v", err)
+	}
+	serialized, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read ztoc: %v", err)
+	}
+	return serialized
+}
+
+// Store a SOCI index of the given ztocs in the local store, with the given config media type
+func storeParsableTestIndex(t *testing.T, ctx context.Context, sociStore *store.SociStore, configMediaType string, ztocs ...[]byte) ocispec.Descriptor {
+	config := pushToStore(t, ctx, sociStore, configMediaType, []byte("{}"))
+	var layers []ocispec.Descriptor
+	for _, serialized := range ztocs {
+		layers = append(layers, pushToStore(t, ctx, sociStore, soci.SociLayerMediaType, serialized))
+	}
+	manifest := ocispec.Manifest{MediaType: MediaTypeOCIManifest, ArtifactType: configMediaType, Config: config, Layers: layers}
+	manifest.SchemaVersion = 2
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal index manifest: %v", err)
+	}
+	return pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, content)
+}
+
+func TestValidateIndexParsable(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-index-parsable")
+	sociStore := newTestSociStore(t, ctx)
+	serialized := testZtoc(t)
+
+	good := storeParsableTestIndex(t, ctx, sociStore, soci.SociIndexArtifactTypeV1, serialized)
+	// the ztoc is cut short, as by a truncated upload the registry accepted under the digest of the truncated content
+	truncatedZtoc := storeParsableTestIndex(t, ctx, sociStore, soci.SociIndexArtifactTypeV1, serialized[:len(serialized)/4])
+	garbageZtoc := storeParsableTestIndex(t, ctx, sociStore, soci.SociIndexArtifactTypeV1, []byte("not a ztoc"))
+	unknownVersion := storeParsableTestIndex(t, ctx, sociStore, "application/vnd.amazon.soci.index.v9+json", serialized)
+
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	for _, desc := range []ocispec.Descriptor{good, truncatedZtoc, garbageZtoc, unknownVersion} {
+		if _, err := registry.Push(ctx, sociStore, desc, "repo", ""); err != nil {
+			t.Fatalf("Push of %s failed: %v", desc.Digest, err)
+		}
+	}
+
+	doTest := func(desc ocispec.Descriptor, expectedErr error) {
+		err := registry.ValidateIndexParsable(ctx, "repo", desc.Digest.String())
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected %s to parse but got %v", desc.Digest, err)
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v validating %s but got %v", expectedErr, desc.Digest, err)
+		}
+	}
+
+	doTest(good, nil)
+	doTest(truncatedZtoc, ErrIndexNotParsable)
+	doTest(garbageZtoc, ErrIndexNotParsable)
+	doTest(unknownVersion, ErrIndexNotParsable)
+	doTest(ocispec.Descriptor{Digest: digest.FromString("missing")}, ErrImageNotFound)
+}
+
+func TestPushWithSnapshotterValidation(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-snapshotter-validation")
+	sociStore := newTestSociStore(t, ctx)
+	good := storeParsableTestIndex(t, ctx, sociStore, soci.SociIndexArtifactTypeV1, testZtoc(t))
+	corrupted := storeParsableTestIndex(t, ctx, sociStore, soci.SociIndexArtifactTypeV1, []byte("not a ztoc"))
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	if _, err := registry.Push(ctx, sociStore, good, "repo", "good", WithSnapshotterValidation()); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if fake.tagged("repo", "good") != good.Digest.String() {
+		t.Fatalf("Expected the validated index to be tagged")
+	}
+
+	// the corrupted index is pushed, but left untagged
+	result, err := registry.Push(ctx, sociStore, corrupted, "repo", "corrupted", WithSnapshotterValidation())
+	if !errors.Is(err, ErrIndexNotParsable) {
+		t.Fatalf("Expected ErrIndexNotParsable but got %v", err)
+	}
+	if result == nil || result.Descriptor.Digest != corrupted.Digest || fake.tagged("repo", "corrupted") != "" {
+		t.Fatalf("Expected the corrupted index to be pushed but not tagged")
+	}
+}
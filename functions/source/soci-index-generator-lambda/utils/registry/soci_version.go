@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrVersionMismatch = errors.New("index does not match the SOCI index version")
+
+var ErrUnknownSociIndexVersion = errors.New("unknown SOCI index version, expected V1 or V2")
+
+// A SOCI index version, either V1 or V2
+type SociIndexVersion string
+
+const (
+	// SOCI index manifests referring to their image as subject
+	V1 SociIndexVersion = "V1"
+	// SOCI index manifests referenced by an image index alongside their image
+	V2 SociIndexVersion = "V2"
+)
+
+// Parse a SOCI index version, rejecting anything but V1 and V2
+func ParseSociIndexVersion(version string) (SociIndexVersion, error) {
+	switch sociIndexVersion := SociIndexVersion(version); sociIndexVersion {
+	case V1, V2:
+		return sociIndexVersion, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownSociIndexVersion, version)
+}
+
+// Check that the root to push matches a SOCI index version: a SOCI V1 index manifest for V1, and for V2 either an
+// image index converted to reference SOCI V2 indexes or a SOCI V2 index manifest.
+// The type of an index manifest is read from the local store.
+func checkSociIndexVersion(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor, sociIndexVersion SociIndexVersion) error {
+	var expectedType string
+	switch sociIndexVersion {
+	case V1:
+		expectedType = soci.SociIndexArtifactTypeV1
+	case V2:
+		expectedType = soci.SociIndexArtifactTypeV2
+		if kindFromMediaType(desc.MediaType) == ImageIndex {
+			return nil
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownSociIndexVersion, sociIndexVersion)
+	}
+	if kindFromMediaType(desc.MediaType) != ImageManifest {
+		return fmt.Errorf("%w: %s index %s has media type %s", ErrVersionMismatch, sociIndexVersion, desc.Digest, desc.MediaType)
+	}
+
+	var manifest ocispec.Manifest
+	if err := readStoreJSON(ctx, sociStore, desc, DefaultMaxManifestSize, &manifest); err != nil {
+		return fmt.Errorf("failed to read index %s: %w", desc.Digest, err)
+	}
+	if manifest.ArtifactType != expectedType && manifest.Config.MediaType != expectedType {
+		return fmt.Errorf("%w: %s index %s has artifact type %q and config media type %q", ErrVersionMismatch,
+			sociIndexVersion, desc.Digest, manifest.ArtifactType, manifest.Config.MediaType)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPushWithSociIndexVersion(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-soci-index-version")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+	sociStore := newTestSociStore(t, ctx)
+	pushJSON := func(mediaType string, v interface{}) ocispec.Descriptor {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", mediaType, err)
+		}
+		return pushToStore(t, ctx, sociStore, mediaType, content)
+	}
+
+	// storeTestIndex writes a SOCI V1 index manifest
+	v1Index := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	emptyConfig := pushToStore(t, ctx, sociStore, ocispec.MediaTypeEmptyJSON, []byte("{}"))
+	v2Manifest := imageManifest(ocispec.MediaTypeEmptyJSON)
+	v2Manifest.Config = emptyConfig
+	v2Manifest.ArtifactType = soci.SociIndexArtifactTypeV2
+	v2Index := pushJSON(MediaTypeOCIManifest, v2Manifest)
+	imageIndex := ocispec.Index{MediaType: MediaTypeOCIImageIndex, Manifests: []ocispec.Descriptor{v2Index}}
+	imageIndex.SchemaVersion = 2
+	convertedIndex := pushJSON(MediaTypeOCIImageIndex, imageIndex)
+
+	doTest := func(desc ocispec.Descriptor, sociIndexVersion SociIndexVersion, expectedErr error) {
+		_, err := registry.Push(ctx, sociStore, desc, "repo", "", WithSociIndexVersion(sociIndexVersion))
+		if expectedErr == nil && err != nil {
+			t.Fatalf("Expected %s to be pushed as %s but got %v", desc.Digest, sociIndexVersion, err)
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v pushing %s as %s but got %v", expectedErr, desc.Digest, sociIndexVersion, err)
+		}
+		if expectedErr != nil && fake.hasManifest("repo", desc.Digest) {
+			t.Fatalf("Expected %s not to be pushed", desc.Digest)
+		}
+	}
+
+	doTest(v1Index, "V1", nil)
+	doTest(v2Index, "V2", nil)
+	doTest(convertedIndex, "V2", nil)
+
+	fake = newFakeRegistry(t)
+	registry = fake.registry(t)
+	doTest(v1Index, "V2", ErrVersionMismatch)
+	doTest(v2Index, "V1", ErrVersionMismatch)
+	doTest(convertedIndex, "V1", ErrVersionMismatch)
+
+	// the version isn't checked by default
+	if _, err := registry.Push(ctx, sociStore, v1Index, "repo", ""); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if _, err := registry.Push(ctx, sociStore, v1Index, "repo", "", WithSociIndexVersion("V3")); err == nil {
+		t.Fatalf("Expected an unknown SOCI index version to be rejected")
+	}
+}
+
+func TestParseSociIndexVersion(t *testing.T) {
+	doTest := func(version string, expected SociIndexVersion, expectErr bool) {
+		parsed, err := ParseSociIndexVersion(version)
+		if expectErr != errors.Is(err, ErrUnknownSociIndexVersion) {
+			t.Fatalf("Expected error %v parsing %q but got %v", expectErr, version, err)
+		}
+		if parsed != expected {
+			t.Fatalf("Expected %q parsing %q but got %q", expected, version, parsed)
+		}
+	}
+
+	doTest("V1", V1, false)
+	doTest("V2", V2, false)
+	doTest("", "", true)
+	doTest("V3", "", true)
+	doTest("v2", "", true)
+	doTest(" V1", "", true)
+}
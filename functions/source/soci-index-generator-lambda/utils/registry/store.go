@@ -0,0 +1,126 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// Create the local store rooted at rootPath the way Pull, the SOCI index builder and Push expect it, so that callers
+// don't have to configure the OCI store themselves: the store accepts any media type, including the SOCI index
+// and zTOC media types, saves index.json on every manifest push so that pulled images resolve by digest, and
+// garbage-collects the blobs left unreferenced by PruneStore. An existing store at rootPath is opened as is, so
+// that a caller keeping the store across runs doesn't download the blobs it already pulled. The Lambda doesn't, its
+// store lives in the work directory of each invocation.
+func NewSociStore(ctx context.Context, rootPath string) (*store.SociStore, error) {
+	ociStore, err := oci.NewWithContext(ctx, rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OCI store at %s: %w", rootPath, err)
+	}
+	ociStore.AutoSaveIndex = true
+	ociStore.AutoGC = true
+	// store.SociStore wraps the OCI store because soci.WriteSociIndex expects a store.Store,
+	// which extends the OCI store with garbage collection
+	return &store.SociStore{Store: ociStore}, nil
+}
+
+// Disk usage of the blobs of a local OCI store
+type StoreUsage struct {
+	Bytes int64
+	Blobs int
+}
+
+// Report the disk usage of the blobs of the local OCI store rooted at rootPath, e.g. to log how much of /tmp
+// it takes when processing several images per invocation. The store is only read.
+func StoreStats(rootPath string) (StoreUsage, error) {
+	var usage StoreUsage
+	err := filepath.WalkDir(filepath.Join(rootPath, "blobs"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		usage.Bytes += info.Size()
+		usage.Blobs++
+		return nil
+	})
+	// a store nothing was pushed to has no blobs directory yet
+	if errors.Is(err, fs.ErrNotExist) {
+		return StoreUsage{}, nil
+	}
+	if err != nil {
+		return StoreUsage{}, fmt.Errorf("failed to read the OCI store at %s: %w", rootPath, err)
+	}
+	return usage, nil
+}
+
+// Return the layers of an image manifest that are not in the local store yet, i.e. the layers Pull would download
+func (registry *Registry) MissingLayers(ctx context.Context, repositoryName string, reference string, sociStore *store.SociStore) ([]ocispec.Descriptor, error) {
+	missing, err := registry.missingLayers(ctx, repositoryName, reference, sociStore)
+	return missing, registry.wrapError("list missing layers", repositoryName, reference, err)
+}
+
+func (registry *Registry) missingLayers(ctx context.Context, repositoryName string, reference string, sociStore *store.SociStore) ([]ocispec.Descriptor, error) {
+	descriptor, err := registry.headManifest(ctx, repositoryName, reference)
+	if err != nil {
+		return nil, err
+	}
+	if kindFromMediaType(descriptor.MediaType) != ImageManifest {
+		return nil, fmt.Errorf("%w: unexpected media type: %s", ErrNotImageManifest, descriptor.MediaType)
+	}
+
+	manifest, err := registry.getManifest(ctx, repositoryName, descriptor.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []ocispec.Descriptor
+	for _, layer := range manifest.Layers {
+		exists, err := sociStore.Exists(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, layer)
+		}
+	}
+	return missing, nil
+}
+
+// Read, verify and unmarshal a manifest of the local store
+func readStoreJSON(ctx context.Context, sociStore *store.SociStore, desc ocispec.Descriptor, maxSize int64, v interface{}) error {
+	return fetchJSON(ctx, sociStore, desc, maxSize, v)
+}
+
+// Read, verify and unmarshal a manifest of any store, e.g. a remote repository
+func fetchJSON(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor, maxSize int64, v interface{}) error {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	content, err := readManifest(rc, desc, maxSize)
+	if err != nil {
+		return err
+	}
+	if err := verifyContent(content, desc); err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
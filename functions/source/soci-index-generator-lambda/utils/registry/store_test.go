@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestStoreStats(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-store-stats")
+	rootPath := filepath.Join(t.TempDir(), "store")
+
+	doTest := func(expected StoreUsage) {
+		usage, err := StoreStats(rootPath)
+		if err != nil {
+			t.Fatalf("StoreStats failed: %v", err)
+		}
+		if usage != expected {
+			t.Fatalf("Expected %+v but got %+v", expected, usage)
+		}
+	}
+
+	// no store at all yet
+	doTest(StoreUsage{})
+
+	sociStore, err := NewSociStore(ctx, rootPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	doTest(StoreUsage{})
+
+	pushToStore(t, ctx, sociStore, "application/octet-stream", []byte("first blob"))
+	pushToStore(t, ctx, sociStore, "application/octet-stream", []byte("second"))
+	doTest(StoreUsage{Bytes: int64(len("first blob") + len("second")), Blobs: 2})
+}
+
+func TestNewSociStore(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-new-soci-store")
+	rootPath := filepath.Join(t.TempDir(), "store")
+	sociStore, err := NewSociStore(ctx, rootPath)
+	if err != nil {
+		t.Fatalf("NewSociStore failed: %v", err)
+	}
+
+	// a SOCI V1 index manifest with a zTOC, and a SOCI V2 index manifest
+	v1Index := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	v2Manifest := imageManifest(ocispec.MediaTypeEmptyJSON)
+	v2Manifest.ArtifactType = soci.SociIndexArtifactTypeV2
+	content, err := json.Marshal(v2Manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal index manifest: %v", err)
+	}
+	v2Index := pushToStore(t, ctx, sociStore, MediaTypeOCIManifest, content)
+	if err := sociStore.Tag(ctx, v2Index, "latest-soci"); err != nil {
+		t.Fatalf("Failed to tag the index: %v", err)
+	}
+
+	// index.json is saved, so that a store reopened at the same path resolves the indexes
+	reopened, err := NewSociStore(ctx, rootPath)
+	if err != nil {
+		t.Fatalf("NewSociStore failed: %v", err)
+	}
+	doTest := func(reference string, expected ocispec.Descriptor) {
+		desc, err := reopened.Resolve(ctx, reference)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", reference, err)
+		}
+		if desc.Digest != expected.Digest || desc.MediaType != expected.MediaType {
+			t.Fatalf("Expected %s to resolve to %v but got %v", reference, expected, desc)
+		}
+	}
+	doTest(v1Index.Digest.String(), v1Index)
+	doTest(v2Index.Digest.String(), v2Index)
+	doTest("latest-soci", v2Index)
+
+	// the wrapped store can be pushed from as is
+	fake := newFakeRegistry(t)
+	if _, err := fake.registry(t).Push(ctx, reopened, v1Index, "repo", "", WithSociIndexVersion("V1")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrMissingSubject = errors.New("artifact manifest has no subject")
+
+var ErrSubjectMismatch = errors.New("artifact subject does not match the expected subject")
+
+// Validate that a pushed artifact, e.g. a SOCI V2 index, references the expected image as its OCI 1.1 subject
+func (registry *Registry) ValidateSubject(ctx context.Context, repositoryName string, artifactDigest string, expectedSubjectDigest string) error {
+	return registry.wrapError("validate subject", repositoryName, artifactDigest, registry.validateSubject(ctx, repositoryName, artifactDigest, expectedSubjectDigest))
+}
+
+func (registry *Registry) validateSubject(ctx context.Context, repositoryName string, artifactDigest string, expectedSubjectDigest string) error {
+	expected, err := ParseDigest(expectedSubjectDigest)
+	if err != nil {
+		return err
+	}
+	manifest, err := registry.getManifest(ctx, repositoryName, artifactDigest)
+	if err != nil {
+		return err
+	}
+	if manifest.Subject == nil {
+		return ErrMissingSubject
+	}
+	if manifest.Subject.Digest != expected {
+		return fmt.Errorf("%w: got %s, expected %s", ErrSubjectMismatch, manifest.Subject.Digest, expected)
+	}
+	return nil
+}
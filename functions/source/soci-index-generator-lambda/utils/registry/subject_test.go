@@ -0,0 +1,45 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestValidateSubject(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-validate-subject")
+	fake := newFakeRegistry(t)
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.oci.image.config.v1+json"))
+	other := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.docker.container.image.v1+json"))
+
+	withSubject := imageManifest("application/vnd.amazon.soci.index.v2+json")
+	withSubject.Subject = &image
+	indexWithSubject := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, withSubject)
+	indexWithoutSubject := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest("application/vnd.amazon.soci.index.v1+json"))
+	registry := fake.registry(t)
+
+	doTest := func(artifact ocispec.Descriptor, expectedSubject ocispec.Descriptor, expectedErr error) {
+		err := registry.ValidateSubject(ctx, "repo", artifact.Digest.String(), expectedSubject.Digest.String())
+		if expectedErr == nil {
+			if err != nil {
+				t.Fatalf("Expected no error but got %v", err)
+			}
+			return
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v but got %v", expectedErr, err)
+		}
+	}
+
+	doTest(indexWithSubject, image, nil)
+	doTest(indexWithSubject, other, ErrSubjectMismatch)
+	doTest(indexWithoutSubject, image, ErrMissingSubject)
+
+	if err := registry.ValidateSubject(ctx, "repo", indexWithSubject.Digest.String(), "sha256:1234"); err == nil {
+		t.Fatalf("Expected an error for an invalid subject digest")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws-ia/cfn-aws-soci-index-builder/soci-index-generator-lambda/utils/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+var ErrTagImmutable = errors.New("tag already exists in a repository with immutable tags")
+
+var ErrTagNotMoved = errors.New("tag does not resolve to the pushed index")
+
+// Tag an index that was already pushed, e.g. to promote it to latest-soci, without copying its graph again.
+// Of the push options, only WithExpectedTagDigest and WithCapabilities apply.
+func (registry *Registry) TagIndex(ctx context.Context, repositoryName string, indexDesc ocispec.Descriptor, tag string, opts ...PushOption) error {
+	return registry.wrapError("tag", repositoryName, tag, registry.tagIndex(ctx, repositoryName, indexDesc, tag, opts...))
+}
+
+func (registry *Registry) tagIndex(ctx context.Context, repositoryName string, indexDesc ocispec.Descriptor, tag string, opts ...PushOption) error {
+	if err := ValidateRepositoryName(repositoryName); err != nil {
+		return err
+	}
+	log.Info(ctx, fmt.Sprintf("Tagging index %s with %s", indexDesc.Digest, tag))
+	return registry.withReauthorization(ctx, func() error {
+		repo, err := registry.registry.Repository(ctx, repositoryName)
+		if err != nil {
+			return err
+		}
+		if config := newPushConfig(opts); config.conditionalTag {
+			err = registry.tagConditionally(ctx, repo, repositoryName, indexDesc, tag, config)
+		} else {
+			err = tagError(repo.Tag(ctx, indexDesc, tag), repositoryName, tag)
+		}
+		registry.InvalidateResolveCache(repositoryName, tag)
+		return err
+	})
+}
+
+// Check that the index manifest is in the repository with the expected size before tagging it
+func verifyPushedIndex(ctx context.Context, repo registry.Repository, indexDesc ocispec.Descriptor) error {
+	pushed, err := repo.Resolve(ctx, indexDesc.Digest.String())
+	if err != nil {
+		return fmt.Errorf("failed to verify the pushed index before tagging: %w", err)
+	}
+	if pushed.Size != indexDesc.Size {
+		return fmt.Errorf("%w: pushed index %s has size %d, expected %d", ErrContentMismatch, indexDesc.Digest, pushed.Size, indexDesc.Size)
+	}
+	return nil
+}
+
+// Check that a tag resolves to the index it was just applied to
+func verifyTag(ctx context.Context, repo registry.Repository, indexDesc ocispec.Descriptor, tag string) error {
+	tagged, err := repo.Resolve(ctx, tag)
+	if err != nil {
+		return fmt.Errorf("failed to verify tag %s: %w", tag, err)
+	}
+	if tagged.Digest != indexDesc.Digest {
+		return fmt.Errorf("%w: tag %s resolves to %s instead of %s", ErrTagNotMoved, tag, tagged.Digest, indexDesc.Digest)
+	}
+	return nil
+}
+
+// Map an error of tagging to ErrTagImmutable when the tag can't be overwritten
+func tagError(err error, repositoryName string, tag string) error {
+	if err == nil {
+		return nil
+	}
+	if isTagImmutableError(err) {
+		return fmt.Errorf("%w: tag %s of repository %s: %v", ErrTagImmutable, tag, repositoryName, err)
+	}
+	return fmt.Errorf("failed to tag artifact: %w", err)
+}
+
+// Check if a registry error reports that a tag can't be overwritten. ECR answers the manifest PUT with
+// a 400 TAG_INVALID error whose message mentions the immutable repository (ImageTagAlreadyExistsException in the ECR API).
+func isTagImmutableError(err error) bool {
+	var errResp *errcode.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, e := range errResp.Errors {
+		message := strings.ToLower(e.Message)
+		if strings.Contains(message, "imagetagalreadyexistsexception") {
+			return true
+		}
+		if strings.Contains(message, "already exists") && (e.Code == "TAG_INVALID" || strings.Contains(message, "immutable")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// ecrImmutableTagMessage is the message of ECR when overwriting a tag of an immutable repository
+const ecrImmutableTagMessage = "The image tag 'latest-soci' already exists in the 'repo' repository and cannot be overwritten because the repository is immutable."
+
+func TestTagIndex(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-tag-index")
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	registry := fake.registry(t)
+	if _, err := registry.Push(ctx, sociStore, indexDesc, "repo", ""); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+	blobUploads := fake.requestCount(http.MethodPost, "/blobs/uploads/")
+
+	if err := registry.TagIndex(ctx, "repo", indexDesc, "latest-soci"); err != nil {
+		t.Fatalf("Expected TagIndex to succeed, got: %v", err)
+	}
+	if fake.tagged("repo", "latest-soci") != indexDesc.Digest.String() {
+		t.Fatalf("Expected latest-soci to point to %s but got %q", indexDesc.Digest, fake.tagged("repo", "latest-soci"))
+	}
+	if fake.requestCount(http.MethodPost, "/blobs/uploads/") != blobUploads {
+		t.Fatalf("Expected TagIndex not to upload any blob")
+	}
+
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/latest-soci") {
+			writeRegistryError(w, http.StatusBadRequest, "TAG_INVALID", ecrImmutableTagMessage)
+			return true
+		}
+		return false
+	}
+	err := registry.TagIndex(ctx, "repo", indexDesc, "latest-soci")
+	if !errors.Is(err, ErrTagImmutable) {
+		t.Fatalf("Expected ErrTagImmutable but got %v", err)
+	}
+}
+
+func TestIsTagImmutableError(t *testing.T) {
+	manifestUrl := &url.URL{Scheme: "https", Host: "registry.example.com", Path: "/v2/repo/manifests/latest-soci"}
+	doTest := func(status int, code string, message string, expected bool) {
+		err := fmt.Errorf("wrapped: %w", &errcode.ErrorResponse{
+			Method:     http.MethodPut,
+			URL:        manifestUrl,
+			StatusCode: status,
+			Errors:     errcode.Errors{{Code: code, Message: message}},
+		})
+		if isTagImmutableError(err) != expected {
+			t.Fatalf("Expected isTagImmutableError to be %v for %d %s %q", expected, status, code, message)
+		}
+	}
+
+	doTest(http.StatusBadRequest, "TAG_INVALID", ecrImmutableTagMessage, true)
+	doTest(http.StatusBadRequest, "UNKNOWN", "ImageTagAlreadyExistsException: tag exists", true)
+	doTest(http.StatusBadRequest, "TAG_INVALID", "invalid tag format", false)
+	doTest(http.StatusBadRequest, errcode.ErrorCodeManifestInvalid, "manifest invalid", false)
+	doTest(http.StatusNotFound, errcode.ErrorCodeManifestUnknown, "manifest unknown", false)
+}
+
+func TestPushTagImmutable(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-tag-immutable")
+	fake := newFakeRegistry(t)
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/latest-soci") {
+			writeRegistryError(w, http.StatusBadRequest, "TAG_INVALID", ecrImmutableTagMessage)
+			return true
+		}
+		return false
+	}
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	_, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci")
+	if !errors.Is(err, ErrTagImmutable) {
+		t.Fatalf("Expected ErrTagImmutable but got %v", err)
+	}
+	if !fake.hasManifest("repo", indexDesc.Digest) {
+		t.Fatalf("Expected the index to be pushed by digest before tagging")
+	}
+}
+
+func TestPushWithAdditionalTags(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-with-additional-tags")
+	fake := newFakeRegistry(t)
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/latest-soci") {
+			writeRegistryError(w, http.StatusBadRequest, "TAG_INVALID", ecrImmutableTagMessage)
+			return true
+		}
+		return false
+	}
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	// the failing tag doesn't prevent the following ones, and duplicates are applied once
+	result, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "v1-soci", WithAdditionalTags("latest-soci", "abc123-soci", "v1-soci"))
+	if !errors.Is(err, ErrTagImmutable) {
+		t.Fatalf("Expected ErrTagImmutable but got %v", err)
+	}
+	if result == nil || strings.Join(result.AppliedTags, ",") != "v1-soci,abc123-soci" {
+		t.Fatalf("Expected v1-soci and abc123-soci to be applied but got %+v", result)
+	}
+	if len(result.FailedTags) != 1 || !errors.Is(result.FailedTags["latest-soci"], ErrTagImmutable) {
+		t.Fatalf("Expected latest-soci to fail with ErrTagImmutable but got %v", result.FailedTags)
+	}
+	for _, tag := range []string{"v1-soci", "abc123-soci"} {
+		if fake.tagged("repo", tag) != indexDesc.Digest.String() {
+			t.Fatalf("Expected %s to point to %s but got %q", tag, indexDesc.Digest, fake.tagged("repo", tag))
+		}
+	}
+	if fake.tagged("repo", "latest-soci") != "" {
+		t.Fatalf("Expected latest-soci not to be applied")
+	}
+
+	// additional tags apply without the tag of Push too
+	result, err = fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "", WithAdditionalTags("v2-soci"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if len(result.AppliedTags) != 1 || result.FailedTags != nil || fake.tagged("repo", "v2-soci") != indexDesc.Digest.String() {
+		t.Fatalf("Expected v2-soci to be applied but got %+v", result)
+	}
+}
+
+func TestPushTagsLast(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-tags-last")
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	oldIndexDesc := storeTestIndex(t, ctx, sociStore, []byte("old ztoc"))
+	if _, err := fake.registry(t).Push(ctx, sociStore, oldIndexDesc, "repo", "latest-soci"); err != nil {
+		t.Fatalf("Failed to push the old index: %v", err)
+	}
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"), []byte("other ztoc"))
+
+	fake.mu.Lock()
+	fake.requests = nil
+	fake.mu.Unlock()
+	if _, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithTagVerification()); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if fake.tagged("repo", "latest-soci") != indexDesc.Digest.String() {
+		t.Fatalf("Expected latest-soci to point to %s but got %q", indexDesc.Digest, fake.tagged("repo", "latest-soci"))
+	}
+
+	fake.mu.Lock()
+	requests := fake.requests
+	fake.mu.Unlock()
+	tagPut := -1
+	for i, request := range requests {
+		if request == "PUT /v2/repo/manifests/latest-soci" {
+			tagPut = i
+		}
+	}
+	if tagPut < 0 {
+		t.Fatalf("Expected the tag to be pushed, got requests %v", requests)
+	}
+	// the index and its blobs are uploaded and the index verified before the tag moves, which is verified after
+	mustPrecede := []string{"PUT /v2/repo/blobs/uploads/", "PUT /v2/repo/manifests/" + indexDesc.Digest.String(), "HEAD /v2/repo/manifests/" + indexDesc.Digest.String()}
+	for _, expected := range mustPrecede {
+		found := false
+		for _, request := range requests[:tagPut] {
+			found = found || strings.HasPrefix(request, expected)
+		}
+		if !found {
+			t.Fatalf("Expected %q before tagging, got requests %v", expected, requests)
+		}
+	}
+	for _, request := range requests[tagPut+1:] {
+		if strings.HasPrefix(request, "PUT ") {
+			t.Fatalf("Expected tagging to be the last upload, got requests %v", requests)
+		}
+	}
+	if requests[len(requests)-1] != "HEAD /v2/repo/manifests/latest-soci" && requests[len(requests)-1] != "GET /v2/repo/manifests/latest-soci" {
+		t.Fatalf("Expected the tag to be resolved after tagging, got requests %v", requests)
+	}
+}
+
+func TestPushTagVerification(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-tag-verification")
+	fake := newFakeRegistry(t)
+	sociStore := newTestSociStore(t, ctx)
+	oldIndexDesc := storeTestIndex(t, ctx, sociStore, []byte("old ztoc"))
+	if _, err := fake.registry(t).Push(ctx, sociStore, oldIndexDesc, "repo", "latest-soci"); err != nil {
+		t.Fatalf("Failed to push the old index: %v", err)
+	}
+
+	// a registry acknowledging the tag without moving it, as if a concurrent push moved it back
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/latest-soci") {
+			w.WriteHeader(http.StatusCreated)
+			return true
+		}
+		return false
+	}
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	if _, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci"); err != nil {
+		t.Fatalf("Expected the push without verification to succeed but got %v", err)
+	}
+	_, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithTagVerification())
+	if !errors.Is(err, ErrTagNotMoved) {
+		t.Fatalf("Expected ErrTagNotMoved but got %v", err)
+	}
+	if !strings.Contains(err.Error(), oldIndexDesc.Digest.String()) {
+		t.Fatalf("Expected the error to name the digest the tag resolves to, got: %v", err)
+	}
+}
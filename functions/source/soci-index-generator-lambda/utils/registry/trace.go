@@ -0,0 +1,26 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Return an ECR API client option adding headers to every request, after the request is built and before it is signed
+func ecrTraceHeaders(headers http.Header) func(*ecr.Options) {
+	return func(options *ecr.Options) {
+		for name, values := range headers {
+			for i, value := range values {
+				if i == 0 {
+					options.APIOptions = append(options.APIOptions, smithyhttp.SetHeaderValue(name, value))
+				} else {
+					options.APIOptions = append(options.APIOptions, smithyhttp.AddHeaderValue(name, value))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+const testTraceId = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+
+func TestInitWithTraceHeaders(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-trace-headers")
+	fake := newFakeRegistry(t)
+	fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+	var mu sync.Mutex
+	var traceIds []string
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		traceIds = append(traceIds, r.Header.Get("X-Amzn-Trace-Id"))
+		return false
+	}
+
+	doTest := func(expectedTraceId string, opts ...Option) {
+		traceIds = nil
+		registry, err := Init(ctx, fake.host(), append([]Option{WithPlainHTTP()}, opts...)...)
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		if _, err := registry.GetManifest(ctx, "repo", "latest"); err != nil {
+			t.Fatalf("GetManifest failed: %v", err)
+		}
+		if len(traceIds) == 0 {
+			t.Fatalf("Expected requests to the registry")
+		}
+		for _, traceId := range traceIds {
+			if traceId != expectedTraceId {
+				t.Fatalf("Expected trace header %q but got %q", expectedTraceId, traceId)
+			}
+		}
+	}
+
+	doTest(testTraceId, WithTraceHeaders(http.Header{"x-amzn-trace-id": {testTraceId}}))
+	// no header by default
+	doTest("")
+}
+
+func TestEcrClientTraceHeaders(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-ecr-client-trace-headers")
+	var traceIds []string
+	ecrEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceIds = append(traceIds, r.Header.Get("X-Amzn-Trace-Id"))
+		token := base64.StdEncoding.EncodeToString([]byte("AWS:password"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d}]}`, token, time.Now().Add(time.Hour).Unix())
+	}))
+	t.Cleanup(ecrEndpoint.Close)
+	t.Setenv("ECR_ENDPOINT", ecrEndpoint.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	doTest := func(config registryConfig, expectedTraceId string) {
+		traceIds = nil
+		registry, err := remote.NewRegistry(testEcrRegistryUrl)
+		if err != nil {
+			t.Fatalf("Failed to create registry client: %v", err)
+		}
+		if _, err := authorizeEcr(ctx, registry, "us-west-2", config, nil); err != nil {
+			t.Fatalf("authorizeEcr failed: %v", err)
+		}
+		if len(traceIds) != 1 || traceIds[0] != expectedTraceId {
+			t.Fatalf("Expected one ECR request with trace header %q but got %q", expectedTraceId, traceIds)
+		}
+	}
+
+	doTest(newRegistryConfig([]Option{WithTraceHeaders(http.Header{"X-Amzn-Trace-Id": {testTraceId}})}), testTraceId)
+	doTest(newRegistryConfig(nil), "")
+}
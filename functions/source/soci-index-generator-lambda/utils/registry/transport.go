@@ -0,0 +1,152 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// Connection reuse settings of the transport of the requests to the registry
+type TransportTuning struct {
+	// Maximum number of idle connections kept open across all hosts
+	MaxIdleConns int
+	// Maximum number of idle connections kept open per host
+	MaxIdleConnsPerHost int
+	// How long an idle connection is kept open
+	IdleConnTimeout time.Duration
+	// Try HTTP/2 even though the transport is customized
+	ForceAttemptHTTP2 bool
+}
+
+// Transport tuning for Lambda: a batch indexes images concurrently against a single registry host, so enough
+// connections are kept per host to be reused across the batch instead of the 2 of Go's default transport. Idle
+// connections are closed sooner than Go's default, as the execution environment may be frozen between invocations.
+var DefaultTransportTuning = TransportTuning{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     30 * time.Second,
+	ForceAttemptHTTP2:   true,
+}
+
+// Build the HTTP client of the requests to the registry from the transport options. Like oras' default client,
+// the client retries throttled and failed requests; every attempt goes through the rate limiter.
+func newHTTPClient(config registryConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = config.transportTuning.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.transportTuning.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.transportTuning.IdleConnTimeout
+	transport.ForceAttemptHTTP2 = config.transportTuning.ForceAttemptHTTP2
+	if config.insecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	var roundTripper http.RoundTripper = transport
+	if config.requestsPerSecond > 0 {
+		roundTripper = &rateLimitedTransport{
+			base:    transport,
+			limiter: newRateLimiter(config.requestsPerSecond, config.requestBurst),
+		}
+	}
+	if len(config.traceHeaders) > 0 {
+		roundTripper = &headerTransport{base: roundTripper, headers: config.traceHeaders}
+	}
+	retryTransport := retry.NewTransport(roundTripper)
+	if budget := config.retryBudget; budget != nil {
+		retryTransport.Policy = func() retry.Policy {
+			return &budgetedRetryPolicy{base: retry.DefaultPolicy, budget: budget}
+		}
+	}
+	return &http.Client{Transport: retryTransport}
+}
+
+// A RoundTripper adding fixed headers to each request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (transport *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	for name, values := range transport.headers {
+		req.Header[name] = values
+	}
+	return transport.base.RoundTrip(req)
+}
+
+// A RoundTripper waiting for the rate limiter before each request
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (transport *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := transport.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return transport.base.RoundTrip(req)
+}
+
+// A token bucket refilled at rate tokens per second, holding up to burst tokens. It starts full.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take a token if one is available, without waiting
+func (limiter *rateLimiter) tryTake() bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := time.Now()
+	limiter.tokens = min(limiter.burst, limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	limiter.last = now
+	if limiter.tokens < 1 {
+		return false
+	}
+	limiter.tokens--
+	return true
+}
+
+// Take a token, waiting until one is available or the context is done
+func (limiter *rateLimiter) wait(ctx context.Context) error {
+	limiter.mu.Lock()
+	now := time.Now()
+	limiter.tokens = min(limiter.burst, limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	limiter.last = now
+	// the token is reserved right away, so that concurrent requests queue up behind each other
+	limiter.tokens--
+	delay := time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	limiter.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved token back
+		limiter.mu.Lock()
+		limiter.tokens++
+		limiter.mu.Unlock()
+		return ctx.Err()
+	}
+}
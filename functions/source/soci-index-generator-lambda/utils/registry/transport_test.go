@@ -0,0 +1,114 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// Unwrap the round trippers of a client built by newHTTPClient down to its transport
+func baseTransport(t testing.TB, client *http.Client) *http.Transport {
+	roundTripper := client.Transport
+	for {
+		switch rt := roundTripper.(type) {
+		case *http.Transport:
+			return rt
+		case *retry.Transport:
+			roundTripper = rt.Base
+		case *headerTransport:
+			roundTripper = rt.base
+		case *rateLimitedTransport:
+			roundTripper = rt.base
+		default:
+			t.Fatalf("Unexpected round tripper %T", roundTripper)
+			return nil
+		}
+	}
+}
+
+func TestInitWithTransportTuning(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-init-transport-tuning")
+	fake := newFakeRegistry(t)
+
+	doTest := func(expected TransportTuning, opts ...Option) {
+		registry, err := Init(ctx, fake.host(), append([]Option{WithPlainHTTP()}, opts...)...)
+		if err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+		transport := baseTransport(t, registry.httpClient)
+		actual := TransportTuning{
+			MaxIdleConns:        transport.MaxIdleConns,
+			MaxIdleConnsPerHost: transport.MaxIdleConnsPerHost,
+			IdleConnTimeout:     transport.IdleConnTimeout,
+			ForceAttemptHTTP2:   transport.ForceAttemptHTTP2,
+		}
+		if actual != expected {
+			t.Fatalf("Expected transport tuning %+v but got %+v", expected, actual)
+		}
+	}
+
+	doTest(DefaultTransportTuning)
+	tuned := TransportTuning{MaxIdleConns: 10, MaxIdleConnsPerHost: 5, IdleConnTimeout: time.Minute}
+	doTest(tuned, WithTransportTuning(tuned))
+	// the tuning applies under the other transport options
+	doTest(tuned, WithTransportTuning(tuned), WithRateLimit(1000, 10), WithTraceHeaders(http.Header{"X-Amzn-Trace-Id": {testTraceId}}))
+	// non positive values keep the defaults
+	doTest(TransportTuning{MaxIdleConns: 100, MaxIdleConnsPerHost: 8, IdleConnTimeout: 30 * time.Second, ForceAttemptHTTP2: true},
+		WithTransportTuning(TransportTuning{MaxIdleConnsPerHost: 8, IdleConnTimeout: -time.Second, ForceAttemptHTTP2: true}))
+}
+
+// Compare the throughput of bursts of concurrent requests, like the images of a batch, with Go's default connection
+// reuse settings and the defaults for Lambda. Besides the time per burst, conns/op reports the connections opened
+// per burst: with only 2 idle connections kept per host, each burst has to open new connections, which against
+// a real registry also costs a TLS handshake each.
+func BenchmarkTransportTuning(b *testing.B) {
+	const concurrency = 16
+	goDefaults := TransportTuning{MaxIdleConns: 100, MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost, IdleConnTimeout: 90 * time.Second}
+	doBenchmark := func(name string, tuning TransportTuning) {
+		b.Run(name, func(b *testing.B) {
+			ctx := newTestContext("abcd-1234-benchmark-transport-tuning")
+			fake := newFakeRegistry(b)
+			fake.putJSONManifest(b, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig), "latest")
+			var mu sync.Mutex
+			connections := map[string]bool{}
+			fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				mu.Lock()
+				connections[r.RemoteAddr] = true
+				mu.Unlock()
+				// the round trip latency to a registry keeps the requests of a burst in flight together
+				time.Sleep(time.Millisecond)
+				return false
+			}
+			registry, err := Init(ctx, fake.host(), WithPlainHTTP(), WithTransportTuning(tuning))
+			if err != nil {
+				b.Fatalf("Init failed: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < concurrency; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if _, err := registry.HeadManifest(ctx, "repo", "latest"); err != nil {
+							b.Errorf("HeadManifest failed: %v", err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(len(connections))/float64(b.N), "conns/op")
+		})
+	}
+
+	doBenchmark("go defaults", goDefaults)
+	doBenchmark("lambda defaults", DefaultTransportTuning)
+}
@@ -0,0 +1,80 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Reports whether an error returned by a registry while pushing means the registry doesn't support OCI artifacts
+type UnsupportedArtifactMatcher func(err error) bool
+
+// The signatures of registries rejecting OCI artifacts and indexes that are recognized out of the box
+var defaultUnsupportedArtifactMatchers = []UnsupportedArtifactMatcher{
+	isEcrInvalidJsonSyntax,
+	isUnsupportedMediaType,
+	isManifestMediaTypeInvalid,
+	isUnsupportedOperation,
+}
+
+// Check if a push error means the registry doesn't support OCI artifacts, using the default and the extra matchers
+func isUnsupportedArtifactError(err error, extraMatchers []UnsupportedArtifactMatcher) bool {
+	if err == nil {
+		return false
+	}
+	for _, matchers := range [][]UnsupportedArtifactMatcher{defaultUnsupportedArtifactMatchers, extraMatchers} {
+		for _, matcher := range matchers {
+			if matcher(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ECR rejects manifests it can't parse, e.g. OCI 1.1 artifacts on older ECR deployments, with a 405
+func isEcrInvalidJsonSyntax(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()),
+		"response status code 405: unsupported: invalid parameter at 'imagemanifest' failed to satisfy constraint: 'invalid json syntax'")
+}
+
+// Some registries (e.g. older Artifactory) reject the OCI manifest or index media type itself with a 415
+func isUnsupportedMediaType(err error) bool {
+	var errResp *errcode.ErrorResponse
+	return errors.As(err, &errResp) && errResp.StatusCode == http.StatusUnsupportedMediaType
+}
+
+// Some registries (e.g. legacy GCR) reply 400 MANIFEST_INVALID, blaming the media type, to manifests they can't store
+func isManifestMediaTypeInvalid(err error) bool {
+	var errResp *errcode.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, e := range errResp.Errors {
+		message := strings.ToLower(e.Message)
+		if e.Code == errcode.ErrorCodeManifestInvalid &&
+			(strings.Contains(message, "media type") || strings.Contains(message, "mediatype") || strings.Contains(message, "artifacttype")) {
+			return true
+		}
+	}
+	return false
+}
+
+// The distribution spec's UNSUPPORTED error code on a manifest push
+func isUnsupportedOperation(err error) bool {
+	var errResp *errcode.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Method != http.MethodPut || errResp.URL == nil || !strings.Contains(errResp.URL.Path, "/manifests/") {
+		return false
+	}
+	for _, e := range errResp.Errors {
+		if e.Code == errcode.ErrorCodeUnsupported {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+func TestIsUnsupportedArtifactError(t *testing.T) {
+	manifestUrl := &url.URL{Scheme: "https", Host: "registry.example.com", Path: "/v2/repo/manifests/sha256:abc"}
+	errorResponse := func(method string, status int, code string, message string) error {
+		return fmt.Errorf("wrapped: %w", &errcode.ErrorResponse{
+			Method:     method,
+			URL:        manifestUrl,
+			StatusCode: status,
+			Errors:     errcode.Errors{{Code: code, Message: message}},
+		})
+	}
+	customErr := errors.New("custom registry says no")
+
+	doTest := func(name string, err error, expected bool, extraMatchers ...UnsupportedArtifactMatcher) {
+		if actual := isUnsupportedArtifactError(err, extraMatchers); actual != expected {
+			t.Fatalf("%s: expected %v but got %v for error: %v", name, expected, actual, err)
+		}
+	}
+
+	doTest("ECR invalid JSON syntax", errorResponse(http.MethodPut, http.StatusMethodNotAllowed, errcode.ErrorCodeUnsupported,
+		"Invalid parameter at 'ImageManifest' failed to satisfy constraint: 'Invalid JSON syntax'"), true)
+	doTest("legacy ECR message", errors.New("PUT: Response status code 405: unsupported: Invalid parameter at 'ImageManifest' failed to satisfy constraint: 'Invalid JSON syntax'"), true)
+	doTest("unsupported media type", errorResponse(http.MethodPut, http.StatusUnsupportedMediaType, "", ""), true)
+	doTest("manifest media type invalid", errorResponse(http.MethodPut, http.StatusBadRequest, errcode.ErrorCodeManifestInvalid,
+		"manifest invalid: unknown media type application/vnd.oci.image.index.v1+json"), true)
+	doTest("unsupported manifest push", errorResponse(http.MethodPut, http.StatusMethodNotAllowed, errcode.ErrorCodeUnsupported, "not supported"), true)
+	doTest("custom matcher", customErr, true, func(err error) bool {
+		return strings.Contains(err.Error(), "says no")
+	})
+
+	doTest("nil error", nil, false)
+	doTest("unrelated manifest invalid", errorResponse(http.MethodPut, http.StatusBadRequest, errcode.ErrorCodeManifestInvalid, "manifest invalid: layer missing"), false)
+	doTest("unauthorized", errorResponse(http.MethodPut, http.StatusUnauthorized, errcode.ErrorCodeUnauthorized, "authentication required"), false)
+	doTest("unsupported on read", errorResponse(http.MethodGet, http.StatusMethodNotAllowed, errcode.ErrorCodeUnsupported, "not supported"), false)
+	doTest("custom error without matcher", customErr, false)
+}
+
+func TestPushUnsupportedRegistry(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-unsupported")
+	fake := newFakeRegistry(t)
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			writeRegistryError(w, http.StatusUnsupportedMediaType, errcode.ErrorCodeManifestInvalid, "unsupported media type")
+			return true
+		}
+		return false
+	}
+	sociStore := newTestSociStore(t, ctx)
+	indexDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+
+	_, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "")
+	if !errors.Is(err, RegistryNotSupportingOciArtifacts) {
+		t.Fatalf("Expected RegistryNotSupportingOciArtifacts, got: %v", err)
+	}
+}
+
+func TestPushDockerManifestListFallback(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-manifest-list-fallback")
+	fake := newFakeRegistry(t)
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.Header.Get("Content-Type") == MediaTypeOCIImageIndex {
+			writeRegistryError(w, http.StatusUnsupportedMediaType, errcode.ErrorCodeManifestInvalid, "unsupported media type")
+			return true
+		}
+		return false
+	}
+	sociStore := newTestSociStore(t, ctx)
+	manifestDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	index := ocispec.Index{
+		MediaType:    MediaTypeOCIImageIndex,
+		ArtifactType: "application/vnd.amazon.soci.index.v2+json",
+		Manifests:    []ocispec.Descriptor{manifestDesc},
+	}
+	index.SchemaVersion = 2
+	content, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	indexDesc := pushToStore(t, ctx, sociStore, MediaTypeOCIImageIndex, content)
+
+	_, err = fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci")
+	if !errors.Is(err, RegistryNotSupportingOciArtifacts) {
+		t.Fatalf("Expected RegistryNotSupportingOciArtifacts without the fallback, got: %v", err)
+	}
+
+	result, err := fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithDockerManifestListFallback())
+	if err != nil {
+		t.Fatalf("Expected the fallback push to succeed, got: %v", err)
+	}
+	if !result.FallbackUsed || !strings.Contains(result.FallbackReason, "unsupported media type") {
+		t.Fatalf("Expected the fallback and its reason to be reported but got %v, %q", result.FallbackUsed, result.FallbackReason)
+	}
+	tagged := fake.tagged("repo", "latest-soci")
+	if tagged == "" || tagged == indexDesc.Digest.String() {
+		t.Fatalf("Expected the tag to point to the Docker manifest list but got %q", tagged)
+	}
+	fake.mu.Lock()
+	pushed := fake.manifests["repo@"+tagged]
+	fake.mu.Unlock()
+	if pushed.mediaType != MediaTypeDockerManifestList {
+		t.Fatalf("Expected media type %s but got %s", MediaTypeDockerManifestList, pushed.mediaType)
+	}
+	var list dockerManifestList
+	if err := json.Unmarshal(pushed.content, &list); err != nil {
+		t.Fatalf("Failed to decode the pushed manifest list: %v", err)
+	}
+	if list.MediaType != MediaTypeDockerManifestList || len(list.Manifests) != 1 || list.Manifests[0].Digest != manifestDesc.Digest {
+		t.Fatalf("Expected a manifest list of %s but got %+v", manifestDesc.Digest, list)
+	}
+	if !fake.hasManifest("repo", manifestDesc.Digest) {
+		t.Fatalf("Expected child manifest %s to be pushed", manifestDesc.Digest)
+	}
+
+	// a registry accepting the OCI image index needs no fallback
+	fake.mu.Lock()
+	fake.intercept = nil
+	fake.mu.Unlock()
+	result, err = fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithDockerManifestListFallback())
+	if err != nil {
+		t.Fatalf("Expected the push to succeed, got: %v", err)
+	}
+	if result.FallbackUsed || result.FallbackReason != "" || result.Descriptor.Digest != indexDesc.Digest {
+		t.Fatalf("Expected the OCI image index to be pushed without fallback but got %+v", result)
+	}
+}
+
+func TestPushRequireOCIArtifacts(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-push-require-oci-artifacts")
+	fake := newFakeRegistry(t)
+	fake.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.Header.Get("Content-Type") == MediaTypeOCIImageIndex {
+			writeRegistryError(w, http.StatusUnsupportedMediaType, errcode.ErrorCodeManifestInvalid, "unsupported media type")
+			return true
+		}
+		return false
+	}
+	sociStore := newTestSociStore(t, ctx)
+	manifestDesc := storeTestIndex(t, ctx, sociStore, []byte("ztoc"))
+	index := ocispec.Index{
+		MediaType:    MediaTypeOCIImageIndex,
+		ArtifactType: "application/vnd.amazon.soci.index.v2+json",
+		Manifests:    []ocispec.Descriptor{manifestDesc},
+	}
+	index.SchemaVersion = 2
+	content, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %v", err)
+	}
+	indexDesc := pushToStore(t, ctx, sociStore, MediaTypeOCIImageIndex, content)
+
+	_, err = fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "latest-soci", WithRequireOCIArtifacts(), WithMaxPushRetries(2))
+	if !errors.Is(err, RegistryNotSupportingOciArtifacts) {
+		t.Fatalf("Expected RegistryNotSupportingOciArtifacts, got: %v", err)
+	}
+	if puts := fake.requestCount(http.MethodPut, "/manifests/"); puts != 2 {
+		t.Fatalf("Expected the child manifest and a single attempt at the index to be pushed, got %d manifest pushes", puts)
+	}
+	if fake.tagged("repo", "latest-soci") != "" {
+		t.Fatalf("Expected nothing to be tagged")
+	}
+
+	_, err = fake.registry(t).Push(ctx, sociStore, indexDesc, "repo", "", WithRequireOCIArtifacts(), WithDockerManifestListFallback())
+	if !errors.Is(err, ErrConflictingPushOptions) {
+		t.Fatalf("Expected ErrConflictingPushOptions, got: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrNotSociIndex = errors.New("not a SOCI index manifest")
+
+// List the descriptors of the ztocs a SOCI index manifest of the repository references, in the order of the
+// layers of its image, e.g. to verify an index or estimate its overhead. Each ztoc is annotated with the digest of
+// the layer it indexes. Manifests other than SOCI indexes, of any version, are rejected with ErrNotSociIndex.
+func (registry *Registry) ListZtocs(ctx context.Context, repositoryName string, indexDigest string) ([]ocispec.Descriptor, error) {
+	ztocs, err := registry.listZtocs(ctx, repositoryName, indexDigest)
+	return ztocs, registry.wrapError("list ztocs", repositoryName, indexDigest, registry.classifyNotFound(ctx, repositoryName, indexDigest, err))
+}
+
+func (registry *Registry) listZtocs(ctx context.Context, repositoryName string, indexDigest string) ([]ocispec.Descriptor, error) {
+	if _, err := ParseDigest(indexDigest); err != nil {
+		return nil, err
+	}
+	manifest, err := registry.getManifest(ctx, repositoryName, indexDigest)
+	if err != nil {
+		return nil, err
+	}
+	if !isSociIndexManifest(manifest) {
+		return nil, fmt.Errorf("%w: artifact type %q, config media type %q", ErrNotSociIndex, manifest.ArtifactType, manifest.Config.MediaType)
+	}
+	return ztocsOf(manifest), nil
+}
+
+// Return the layers of a SOCI index manifest that are ztocs
+func ztocsOf(manifest ocispec.Manifest) []ocispec.Descriptor {
+	ztocs := []ocispec.Descriptor{}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == soci.SociLayerMediaType {
+			ztocs = append(ztocs, layer)
+		}
+	}
+	return ztocs
+}
@@ -0,0 +1,62 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestListZtocs(t *testing.T) {
+	ctx := newTestContext("abcd-1234-test-list-ztocs")
+	fake := newFakeRegistry(t)
+	registry := fake.registry(t)
+
+	image := fake.putJSONManifest(t, "repo", MediaTypeOCIManifest, imageManifest(MediaTypeOCIImageConfig))
+	var ztocs []ocispec.Descriptor
+	for _, layer := range []string{"first layer", "second layer"} {
+		ztocs = append(ztocs, ocispec.Descriptor{
+			MediaType:   soci.SociLayerMediaType,
+			Digest:      digest.FromString("ztoc of " + layer),
+			Size:        int64(len("ztoc of " + layer)),
+			Annotations: map[string]string{soci.IndexAnnotationImageLayerDigest: digest.FromString(layer).String()},
+		})
+	}
+	putIndex := func(version soci.IndexVersion, blobs []ocispec.Descriptor) ocispec.Descriptor {
+		content, err := soci.MarshalIndex(soci.NewIndex(version, blobs, &image, nil))
+		if err != nil {
+			t.Fatalf("Failed to marshal SOCI index: %v", err)
+		}
+		return fake.putManifest("repo", MediaTypeOCIManifest, content, digest.SHA256)
+	}
+	v1Desc := putIndex(soci.V1, ztocs)
+	// other layers of an index, if any, aren't ztocs
+	v2Desc := putIndex(soci.V2, append(ztocs, ocispec.Descriptor{MediaType: "application/vnd.example.other", Digest: digest.FromString("other")}))
+
+	doTest := func(indexDigest string, expected []ocispec.Descriptor, expectedErr error) {
+		actual, err := registry.ListZtocs(ctx, "repo", indexDigest)
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("Expected %v listing the ztocs of %s but got %v", expectedErr, indexDigest, err)
+		}
+		if len(actual) != len(expected) {
+			t.Fatalf("Expected %d ztocs in %s but got %d", len(expected), indexDigest, len(actual))
+		}
+		for i := range expected {
+			if actual[i].Digest != expected[i].Digest || actual[i].Size != expected[i].Size ||
+				actual[i].Annotations[soci.IndexAnnotationImageLayerDigest] != expected[i].Annotations[soci.IndexAnnotationImageLayerDigest] {
+				t.Fatalf("Expected ztoc %v in %s but got %v", expected[i], indexDigest, actual[i])
+			}
+		}
+	}
+
+	doTest(v1Desc.Digest.String(), ztocs, nil)
+	doTest(v2Desc.Digest.String(), ztocs, nil)
+	doTest(image.Digest.String(), nil, ErrNotSociIndex)
+	doTest(digest.FromString("missing").String(), nil, ErrImageNotFound)
+	doTest("latest", nil, digest.ErrDigestInvalidFormat)
+}